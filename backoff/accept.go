@@ -0,0 +1,41 @@
+package backoff
+
+import "time"
+
+// Accept waits for the next connection with accept, such as the Accept
+// method of a net.Listener, or AcceptTCP of a net.TCPListener.  The
+// errors after which the listener remains usable (see IsRetryableAccept),
+// such as running out of file descriptors, are retried after a delay
+// growing with policy p, and reported to logf unless it is nil.  Other
+// errors, such as net.ErrClosed once the listener is closed, are
+// returned: the listener cannot accept connections anymore.
+//
+// Example:
+//
+//	for {
+//		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
+//		if err != nil {
+//			return err
+//		}
+//		go handle(conn)
+//	}
+func Accept[C any](accept func() (C, error), p Policy, logf func(format string, args ...interface{})) (C, error) {
+	var retry *Backoff
+	for {
+		conn, err := accept()
+		if err == nil || !IsRetryableAccept(err) {
+			return conn, err
+		}
+		if retry == nil {
+			retry = New(p)
+		}
+		delay, ok := retry.Next()
+		if !ok {
+			return conn, err
+		}
+		if logf != nil {
+			logf("accept failed, retrying in %v: %v", delay, err)
+		}
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,40 @@
+package backoff
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAccept(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", syscall.EMFILE)}
+	errs := []error{emfile, emfile, nil, net.ErrClosed}
+	accept := func() (int, error) {
+		err := errs[0]
+		errs = errs[1:]
+		return len(errs), err
+	}
+	var logged int
+	logf := func(string, ...interface{}) { logged++ }
+
+	p := Policy{Initial: time.Millisecond, Max: time.Millisecond}
+	conn, err := Accept(accept, p, logf)
+	if err != nil || conn != 1 {
+		t.Fatalf("got %v, %v, want the connection after the retries", conn, err)
+	}
+	if logged != 2 {
+		t.Errorf("%d retries logged, want 2", logged)
+	}
+	if _, err := Accept(accept, p, nil); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("got %v, want %v", err, net.ErrClosed)
+	}
+
+	// the policy bounds the retries
+	errs = []error{emfile, emfile, emfile}
+	if _, err := Accept(accept, Policy{Initial: time.Millisecond, MaxRetries: 2}, nil); err != emfile {
+		t.Fatalf("got %v, want the error once retries are exhausted", err)
+	}
+}
@@ -0,0 +1,167 @@
+// Package backoff implements retry delays for programs that dial
+// or accept network connections.  A Backoff computes exponentially
+// growing delays, optionally with decorrelated jitter, and gives up
+// once the max elapsed time or the max number of retries is reached.
+//
+// Errors are classified with errors.Is against syscall error codes,
+// instead of the deprecated net.Error Temporary() method, using
+// functions IsRetryableDial and IsRetryableAccept.  Accept retries the
+// errors of accept loops that leave the listener usable.
+//
+// Example:
+//
+//	retry := backoff.New(backoff.Policy{MaxElapsed: time.Minute})
+//	for {
+//		conn, err := net.Dial("tcp", addr)
+//		if err == nil {
+//			break
+//		}
+//		if !backoff.IsRetryableDial(err) {
+//			return err
+//		}
+//		if err := retry.Wait(ctx); err != nil {
+//			return err
+//		}
+//	}
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrExhausted is returned by Wait when no more retries are allowed
+var ErrExhausted = errors.New("backoff: retries exhausted")
+
+// Policy configures a Backoff.  Zero values are replaced with defaults.
+type Policy struct {
+	// Initial is the first delay, default 10ms
+	Initial time.Duration
+
+	// Max caps each delay, default 5s
+	Max time.Duration
+
+	// Multiplier grows the delay after each retry, default 2
+	Multiplier float64
+
+	// Jitter enables decorrelated jitter where each delay is picked
+	// at random in [Initial, previous delay * 3], which spreads the
+	// retries of many clients failing at the same time.
+	Jitter bool
+
+	// MaxElapsed stops the retries once this much time has passed
+	// since the first failure, zero means no limit
+	MaxElapsed time.Duration
+
+	// MaxRetries stops after that many consecutive retries,
+	// zero means no limit
+	MaxRetries int
+}
+
+// Backoff computes the delays between consecutive retries.  Call Reset
+// after a success to start over with the initial delay.  A Backoff is
+// not safe for concurrent use.
+type Backoff struct {
+	policy  Policy
+	retries int
+	delay   time.Duration
+	start   time.Time
+	rnd     *rand.Rand
+}
+
+// New returns a Backoff for policy p
+func New(p Policy) *Backoff {
+	if p.Initial <= 0 {
+		p.Initial = 10 * time.Millisecond
+	}
+	if p.Max <= 0 {
+		p.Max = 5 * time.Second
+	}
+	if p.Max < p.Initial {
+		p.Max = p.Initial
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	return &Backoff{
+		policy: p,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next returns the delay to wait before the next retry.  It returns
+// false when the policy does not allow another retry.
+func (b *Backoff) Next() (time.Duration, bool) {
+	now := time.Now()
+	if b.retries == 0 {
+		b.start = now
+	}
+	if b.policy.MaxRetries > 0 && b.retries >= b.policy.MaxRetries {
+		return 0, false
+	}
+	if b.policy.MaxElapsed > 0 && now.Sub(b.start) >= b.policy.MaxElapsed {
+		return 0, false
+	}
+
+	p := b.policy
+	switch {
+	case b.retries == 0:
+		b.delay = p.Initial
+	case p.Jitter:
+		// decorrelated jitter: random in [initial, 3 * previous delay]
+		upper := b.delay * 3
+		if upper > p.Max {
+			upper = p.Max
+		}
+		b.delay = p.Initial
+		if upper > p.Initial {
+			b.delay += time.Duration(b.rnd.Int63n(int64(upper - p.Initial)))
+		}
+	default:
+		b.delay = time.Duration(float64(b.delay) * p.Multiplier)
+	}
+	if b.delay > p.Max {
+		b.delay = p.Max
+	}
+
+	// do not sleep past the max elapsed time
+	if p.MaxElapsed > 0 {
+		if left := p.MaxElapsed - now.Sub(b.start); b.delay > left {
+			b.delay = left
+		}
+	}
+
+	b.retries++
+	return b.delay, true
+}
+
+// Wait sleeps for the next delay.  It returns ErrExhausted when no more
+// retries are allowed, or the context error if ctx is done first.
+func (b *Backoff) Wait(ctx context.Context) error {
+	delay, ok := b.Next()
+	if !ok {
+		return ErrExhausted
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reset records a success: the next failure starts over with the
+// initial delay.
+func (b *Backoff) Reset() {
+	b.retries = 0
+	b.delay = 0
+}
+
+// Retries returns the number of retries since the last Reset
+func (b *Backoff) Retries() int {
+	return b.retries
+}
@@ -0,0 +1,120 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNextExponential(t *testing.T) {
+	b := New(Policy{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond})
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		delay, ok := b.Next()
+		if !ok || delay != w*time.Millisecond {
+			t.Fatalf("retry %d: got %v, %v, want %v", i+1, delay, ok, w*time.Millisecond)
+		}
+	}
+	if n := b.Retries(); n != len(want) {
+		t.Errorf("got %d retries, want %d", n, len(want))
+	}
+
+	// a success starts over
+	b.Reset()
+	if delay, _ := b.Next(); delay != 10*time.Millisecond {
+		t.Errorf("after Reset: got %v, want the initial delay", delay)
+	}
+	if n := b.Retries(); n != 1 {
+		t.Errorf("after Reset: got %d retries, want 1", n)
+	}
+}
+
+func TestNextJitter(t *testing.T) {
+	p := Policy{Initial: 10 * time.Millisecond, Max: 200 * time.Millisecond, Jitter: true}
+	for run := 0; run < 100; run++ {
+		b := New(p)
+		prev, _ := b.Next()
+		if prev != p.Initial {
+			t.Fatalf("first delay %v, want %v", prev, p.Initial)
+		}
+		for i := 0; i < 20; i++ {
+			delay, _ := b.Next()
+			upper := 3 * prev
+			if upper > p.Max {
+				upper = p.Max
+			}
+			if delay < p.Initial || delay > upper {
+				t.Fatalf("delay %v after %v out of [%v, %v]", delay, prev, p.Initial, upper)
+			}
+			prev = delay
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	b := New(Policy{MaxRetries: 2})
+	for i := 0; i < 2; i++ {
+		if _, ok := b.Next(); !ok {
+			t.Fatalf("retry %d refused", i+1)
+		}
+	}
+	if _, ok := b.Next(); ok {
+		t.Fatal("retry over MaxRetries allowed")
+	}
+	if err := b.Wait(context.Background()); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("Wait: got %v, want %v", err, ErrExhausted)
+	}
+
+	// the delays do not go past the max elapsed time
+	b = New(Policy{Initial: time.Hour, MaxElapsed: 20 * time.Millisecond})
+	if delay, ok := b.Next(); !ok || delay > 20*time.Millisecond {
+		t.Fatalf("got %v, %v, want at most the max elapsed time", delay, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := b.Next(); ok {
+		t.Fatal("retry past MaxElapsed allowed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b = New(Policy{Initial: time.Hour})
+	if err := b.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestIsRetryableAccept(t *testing.T) {
+	wrap := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", errno)}
+	}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{wrap(syscall.EMFILE), true},
+		{wrap(syscall.ENFILE), true},
+		{wrap(syscall.ECONNABORTED), true},
+		{fmt.Errorf("serve: %w", wrap(syscall.EINTR)), true},
+		{wrap(syscall.EBADF), false},
+		{wrap(syscall.EINVAL), false},
+		{&net.OpError{Op: "accept", Net: "tcp", Err: net.ErrClosed}, false},
+		{errors.New("other"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryableAccept(tt.err); got != tt.want {
+			t.Errorf("IsRetryableAccept(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	if !IsResourceExhausted(wrap(syscall.EMFILE)) || IsResourceExhausted(wrap(syscall.ECONNABORTED)) {
+		t.Error("IsResourceExhausted misclassifies EMFILE or ECONNABORTED")
+	}
+	if !IsRetryableDial(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}) {
+		t.Error("refused dial not retryable")
+	}
+}
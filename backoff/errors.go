@@ -0,0 +1,74 @@
+package backoff
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// dial errors that are likely to go away on their own, i.e. the server
+// is restarting or the network is briefly unreachable
+var dialErrnos = []syscall.Errno{
+	syscall.ECONNREFUSED,
+	syscall.ECONNRESET,
+	syscall.ECONNABORTED,
+	syscall.ETIMEDOUT,
+	syscall.EHOSTUNREACH,
+	syscall.ENETUNREACH,
+	syscall.ENETDOWN,
+	syscall.EADDRNOTAVAIL,
+	syscall.EAGAIN,
+	syscall.EINTR,
+}
+
+// accept errors that leave the listener usable.  EMFILE and ENFILE
+// mean that the process (or system) ran out of file descriptors, which
+// clears up as existing connections are closed.
+var acceptErrnos = []syscall.Errno{
+	syscall.EMFILE,
+	syscall.ENFILE,
+	syscall.ENOBUFS,
+	syscall.ENOMEM,
+	syscall.ECONNABORTED,
+	syscall.ECONNRESET,
+	syscall.EPROTO,
+	syscall.EAGAIN,
+	syscall.EINTR,
+}
+
+// IsRetryableDial reports whether a failed dial may succeed if retried
+func IsRetryableDial(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isErrno(err, dialErrnos) || IsResourceExhausted(err) {
+		return true
+	}
+	// dial timeouts are retryable
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsRetryableAccept reports whether the listener can keep accepting
+// connections after err.  It returns false once the listener is closed.
+func IsRetryableAccept(err error) bool {
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	return isErrno(err, acceptErrnos)
+}
+
+// IsResourceExhausted reports whether err is caused by running out
+// of file descriptors (EMFILE, ENFILE) or buffer space (ENOBUFS)
+func IsResourceExhausted(err error) bool {
+	return isErrno(err, []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS})
+}
+
+func isErrno(err error, errnos []syscall.Errno) bool {
+	for _, errno := range errnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
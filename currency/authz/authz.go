@@ -0,0 +1,199 @@
+// Package authz authorizes requests from clients authenticated with
+// a TLS client certificate.  The identity of a client is taken from its
+// verified certificate (subject CN and OU, DNS and URI SANs).  A Policy
+// maps identities to roles, and roles to the commands they may run:
+//
+//	{
+//	  "rules": [
+//	    {"ou": "admin", "roles": ["admin"]},
+//	    {"uri": "spiffe://currency/*", "roles": ["reader"]},
+//	    {"cn": "*", "roles": ["reader"]}
+//	  ],
+//	  "roles": {
+//	    "admin": ["*"],
+//	    "reader": ["get"]
+//	  }
+//	}
+//
+// Rule fields are patterns (see path.Match), all non-empty fields of
+// a rule must match for the client to be granted the rule roles.  For
+// clients connected over a unix socket, rules can also match the user
+// and group ids of the client process ("uid", "gid").
+package authz
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Identity is the identity of a client taken from its certificate
+type Identity struct {
+	CommonName          string
+	OrganizationalUnits []string
+	DNSNames            []string
+	URIs                []string
+
+	// UID and GID are the user and group ids of the client process,
+	// known for unix socket connections only
+	UID, GID string
+}
+
+// IdentityFromCert returns the identity described by a
+// (verified) client certificate
+func IdentityFromCert(cert *x509.Certificate) Identity {
+	id := Identity{
+		CommonName:          cert.Subject.CommonName,
+		OrganizationalUnits: cert.Subject.OrganizationalUnit,
+		DNSNames:            cert.DNSNames,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id
+}
+
+// String returns a short description of the identity for logs
+func (id Identity) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cn=%q", id.CommonName)
+	if len(id.OrganizationalUnits) > 0 {
+		fmt.Fprintf(&b, " ou=%s", strings.Join(id.OrganizationalUnits, ","))
+	}
+	if len(id.URIs) > 0 {
+		fmt.Fprintf(&b, " uri=%s", strings.Join(id.URIs, ","))
+	}
+	if id.UID != "" {
+		fmt.Fprintf(&b, " uid=%s gid=%s", id.UID, id.GID)
+	}
+	return b.String()
+}
+
+// Rule grants roles to the identities it matches
+type Rule struct {
+	CN    string   `json:"cn,omitempty"`
+	OU    string   `json:"ou,omitempty"`
+	DNS   string   `json:"dns,omitempty"`
+	URI   string   `json:"uri,omitempty"`
+	UID   string   `json:"uid,omitempty"`
+	GID   string   `json:"gid,omitempty"`
+	Roles []string `json:"roles"`
+}
+
+// Policy maps identities to roles and roles to commands
+type Policy struct {
+	Rules []Rule `json:"rules"`
+	// Roles lists the commands allowed for each role, "*" allows all
+	Roles map[string][]string `json:"roles"`
+}
+
+// LoadPolicy reads a JSON policy file and validates it
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &p, nil
+}
+
+// Validate checks the rule patterns, and that rules only
+// grant roles that are defined
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		if r.CN == "" && r.OU == "" && r.DNS == "" && r.URI == "" && r.UID == "" && r.GID == "" {
+			return fmt.Errorf("rule %d: no cn, ou, dns, uri, uid, or gid pattern", i)
+		}
+		for _, pattern := range []string{r.CN, r.OU, r.DNS, r.URI, r.UID, r.GID} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d: pattern %q: %w", i, pattern, err)
+			}
+		}
+		for _, role := range r.Roles {
+			if _, ok := p.Roles[role]; !ok {
+				return fmt.Errorf("rule %d: undefined role %q", i, role)
+			}
+		}
+	}
+	return nil
+}
+
+// RolesOf returns the sorted roles granted to id
+func (p *Policy) RolesOf(id Identity) []string {
+	set := make(map[string]bool)
+	for _, r := range p.Rules {
+		if r.matches(id) {
+			for _, role := range r.Roles {
+				set[role] = true
+			}
+		}
+	}
+	roles := make([]string, 0, len(set))
+	for role := range set {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Authorize returns nil if one of the roles of id allows cmd,
+// a *DeniedError otherwise
+func (p *Policy) Authorize(id Identity, cmd string) error {
+	roles := p.RolesOf(id)
+	for _, role := range roles {
+		for _, allowed := range p.Roles[role] {
+			if allowed == "*" || allowed == cmd {
+				return nil
+			}
+		}
+	}
+	return &DeniedError{Identity: id, Command: cmd, Roles: roles}
+}
+
+// DeniedError reports a command that the client is not allowed to run
+type DeniedError struct {
+	Identity Identity
+	Command  string
+	Roles    []string
+}
+
+func (e *DeniedError) Error() string {
+	if len(e.Roles) == 0 {
+		return fmt.Sprintf("%s: no role granted, command %q denied", e.Identity.CommonName, e.Command)
+	}
+	return fmt.Sprintf("%s: command %q not allowed for roles %s",
+		e.Identity.CommonName, e.Command, strings.Join(e.Roles, ","))
+}
+
+// matches reports whether all the patterns of the rule match id
+func (r Rule) matches(id Identity) bool {
+	return matchOne(r.CN, []string{id.CommonName}) &&
+		matchOne(r.OU, id.OrganizationalUnits) &&
+		matchOne(r.DNS, id.DNSNames) &&
+		matchOne(r.URI, id.URIs) &&
+		matchOne(r.UID, []string{id.UID}) &&
+		matchOne(r.GID, []string{id.GID})
+}
+
+// matchOne reports whether pattern is empty or matches one of values
+func matchOne(pattern string, values []string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, v := range values {
+		if ok, _ := path.Match(pattern, v); ok {
+			return true
+		}
+	}
+	return false
+}
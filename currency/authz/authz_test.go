@@ -0,0 +1,156 @@
+package authz
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testPolicy = &Policy{
+	Rules: []Rule{
+		{OU: "admin", Roles: []string{"admin"}},
+		{URI: "spiffe://currency/*", Roles: []string{"reader"}},
+		{CN: "ops-*", DNS: "*.ops.example.com", Roles: []string{"operator"}},
+		{UID: "0", Roles: []string{"admin"}},
+	},
+	Roles: map[string][]string{
+		"admin":    {"*"},
+		"reader":   {"get"},
+		"operator": {"get", "stats"},
+	},
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      Identity
+		roles   []string
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "admin by OU",
+			id:      Identity{CommonName: "alice", OrganizationalUnits: []string{"dev", "admin"}},
+			roles:   []string{"admin"},
+			allowed: []string{"get", "stats", "reload"},
+		},
+		{
+			name:    "reader by URI",
+			id:      Identity{CommonName: "svc", URIs: []string{"spiffe://currency/web"}},
+			roles:   []string{"reader"},
+			allowed: []string{"get"},
+			denied:  []string{"stats", "reload"},
+		},
+		{
+			name:   "all patterns of a rule must match",
+			id:     Identity{CommonName: "ops-1", DNSNames: []string{"a.example.com"}},
+			roles:  []string{},
+			denied: []string{"get"},
+		},
+		{
+			name:    "operator and reader",
+			id:      Identity{CommonName: "ops-1", DNSNames: []string{"a.ops.example.com"}, URIs: []string{"spiffe://currency/ops"}},
+			roles:   []string{"operator", "reader"},
+			allowed: []string{"get", "stats"},
+			denied:  []string{"reload"},
+		},
+		{
+			name:    "unix socket peer",
+			id:      Identity{CommonName: "bob", UID: "0", GID: "0"},
+			roles:   []string{"admin"},
+			allowed: []string{"reload"},
+		},
+		{
+			name:   "a path separator does not match *",
+			id:     Identity{URIs: []string{"spiffe://currency/web/extra"}},
+			roles:  []string{},
+			denied: []string{"get"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if roles := testPolicy.RolesOf(tt.id); !reflect.DeepEqual(roles, tt.roles) {
+				t.Errorf("got roles %v, want %v", roles, tt.roles)
+			}
+			for _, cmd := range tt.allowed {
+				if err := testPolicy.Authorize(tt.id, cmd); err != nil {
+					t.Errorf("%s: %v", cmd, err)
+				}
+			}
+			for _, cmd := range tt.denied {
+				var denied *DeniedError
+				if err := testPolicy.Authorize(tt.id, cmd); !errors.As(err, &denied) || denied.Command != cmd {
+					t.Errorf("%s: got %v, want a DeniedError", cmd, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testPolicy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	roles := map[string][]string{"reader": {"get"}}
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"no pattern", Rule{Roles: []string{"reader"}}},
+		{"bad pattern", Rule{CN: "[a", Roles: []string{"reader"}}},
+		{"undefined role", Rule{CN: "*", Roles: []string{"writer"}}},
+	}
+	for _, tt := range tests {
+		p := &Policy{Rules: []Rule{tt.rule}, Roles: roles}
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: policy accepted", tt.name)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "authz.json")
+	data := `{"rules": [{"cn": "*", "roles": ["reader"]}], "roles": {"reader": ["get"]}}`
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize(Identity{CommonName: "anyone"}, "get"); err != nil {
+		t.Error(err)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"rules": [{"cn": "*", "roles": ["admin"]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPolicy(bad); err == nil {
+		t.Error("policy with an undefined role loaded")
+	}
+}
+
+func TestIdentityFromCert(t *testing.T) {
+	u, _ := url.Parse("spiffe://currency/web")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "web", OrganizationalUnit: []string{"svc"}},
+		DNSNames: []string{"web.example.com"},
+		URIs:     []*url.URL{u},
+	}
+	want := Identity{
+		CommonName:          "web",
+		OrganizationalUnits: []string{"svc"},
+		DNSNames:            []string{"web.example.com"},
+		URIs:                []string{"spiffe://currency/web"},
+	}
+	if id := IdentityFromCert(cert); !reflect.DeepEqual(id, want) {
+		t.Errorf("got %+v, want %+v", id, want)
+	}
+}
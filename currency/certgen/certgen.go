@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/pki"
+)
+
+const usage = `Usage: certgen <command> [options]
+
+Commands:
+  ca       create a self-signed root CA (<name>-cert.pem, <name>-key.pem)
+  server   create a server certificate signed by the CA
+  client   create a client certificate signed by the CA
+  crl      create or update the certificate revocation list of the CA
+  verify   verify that a key matches its certificate, and that the
+           certificate chains up to the CA
+  show     print a summary of a certificate
+
+Run 'certgen <command> -h' for the options of a command.
+`
+
+// This program creates the keys and certificates used by the TLS
+// versions of the currency service (tls-servX, tls-clientX) using
+// package pki, which relies on crypto/x509.  It replaces the manual
+// openssl steps documented in ../certs/certs.txt and adds subject
+// alternative names (DNS, IP, URI) that Go requires for hostname
+// verification.
+//
+// Files are named after the -name flag, as <name>-cert.pem and
+// <name>-key.pem, in the directory given by -dir.
+//
+// Usage: certgen <command> [options]
+//
+// Examples (from the currency directory):
+//   certgen ca -dir certs -name ca -cn "Currency Root CA"
+//   certgen server -dir certs -name localhost -dns localhost -ip 127.0.0.1,::1
+//   certgen client -dir certs -name client -cn client -ou admin -type ed25519
+//   certgen crl -dir certs -revoke certs/client-cert.pem
+//   certgen verify -cert certs/localhost-cert.pem -key certs/localhost-key.pem -ca certs/ca-cert.pem
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "ca":
+		err = cmdCreate(pki.Usage(""), args)
+	case "server":
+		err = cmdCreate(pki.ServerUsage, args)
+	case "client":
+		err = cmdCreate(pki.ClientUsage, args)
+	case "crl":
+		err = cmdCRL(args)
+	case "verify":
+		err = cmdVerify(args)
+	case "show":
+		err = cmdShow(args)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "certgen:", err)
+		os.Exit(1)
+	}
+}
+
+// cmdCreate creates a CA (usage is empty), or a server or client
+// certificate signed by the CA
+func cmdCreate(usage pki.Usage, args []string) error {
+	isCA := usage == ""
+	name := string(usage)
+	if isCA {
+		name = "ca"
+	}
+
+	fs := flag.NewFlagSet("certgen "+name, flag.ExitOnError)
+	var (
+		dir, cn, org, ou, dns, ips, uris, keyType, use, ca, caKey string
+		ocsp, crl                                                 string
+		bits, days                                                int
+	)
+	fs.StringVar(&dir, "dir", ".", "output directory")
+	fs.StringVar(&name, "name", name, "file name prefix (<name>-cert.pem, <name>-key.pem)")
+	fs.StringVar(&cn, "cn", "", "subject common name, default first DNS name or name")
+	fs.StringVar(&org, "o", "", "subject organization")
+	fs.StringVar(&ou, "ou", "", "comma-separated subject organizational units")
+	fs.StringVar(&keyType, "type", "ecdsa", "key type [rsa,ecdsa,ed25519]")
+	fs.IntVar(&bits, "bits", 0, "RSA key size (2048) or ECDSA curve (256,384,521)")
+	if isCA {
+		fs.IntVar(&days, "days", 3650, "validity in days")
+	} else {
+		fs.StringVar(&dns, "dns", "", "comma-separated DNS subject alternative names")
+		fs.StringVar(&ips, "ip", "", "comma-separated IP subject alternative names")
+		fs.StringVar(&uris, "uri", "", "comma-separated URI subject alternative names")
+		fs.StringVar(&use, "usage", string(usage), "extended key usage [server,client,peer]")
+		fs.StringVar(&ca, "ca", "ca-cert.pem", "CA certificate (relative to -dir)")
+		fs.StringVar(&caKey, "ca-key", "ca-key.pem", "CA private key (relative to -dir)")
+		fs.StringVar(&ocsp, "ocsp", "", "comma-separated OCSP responder URLs")
+		fs.StringVar(&crl, "crl-url", "", "comma-separated CRL distribution points")
+		fs.IntVar(&days, "days", 365, "validity in days")
+	}
+	fs.Parse(args)
+
+	kt, err := pki.ParseKeyType(keyType)
+	if err != nil {
+		return err
+	}
+	opts := pki.Options{
+		CommonName:            cn,
+		OrganizationalUnit:    splitList(ou),
+		DNSNames:              splitList(dns),
+		KeyType:               kt,
+		KeyBits:               bits,
+		Validity:              time.Duration(days) * 24 * time.Hour,
+		Usage:                 pki.Usage(use),
+		OCSPServer:            splitList(ocsp),
+		CRLDistributionPoints: splitList(crl),
+	}
+	if org != "" {
+		opts.Organization = []string{org}
+	}
+	for _, s := range splitList(ips) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address: %s", s)
+		}
+		opts.IPAddresses = append(opts.IPAddresses, ip)
+	}
+	for _, s := range splitList(uris) {
+		u, err := url.Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid URI %s: %w", s, err)
+		}
+		opts.URIs = append(opts.URIs, u)
+	}
+	if opts.CommonName == "" {
+		opts.CommonName = name
+		if len(opts.DNSNames) > 0 {
+			opts.CommonName = opts.DNSNames[0]
+		}
+	}
+
+	var cert *pki.Cert
+	if isCA {
+		cert, err = pki.NewCA(opts)
+	} else {
+		var issuer *pki.Cert
+		issuer, err = pki.LoadFiles(filepath.Join(dir, ca), filepath.Join(dir, caKey))
+		if err != nil {
+			return fmt.Errorf("failed to load CA: %w", err)
+		}
+		cert, err = issuer.Issue(opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	certFile := filepath.Join(dir, name+"-cert.pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	if err := cert.WriteFiles(certFile, keyFile); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s\n", certFile, keyFile)
+	fmt.Print(pki.Describe(cert.Cert))
+	return nil
+}
+
+// cmdCRL creates the CRL of the CA, or adds certificates to an
+// existing one.  Certificates are given as certificate files or
+// serial numbers (hex).
+func cmdCRL(args []string) error {
+	fs := flag.NewFlagSet("certgen crl", flag.ExitOnError)
+	var dir, ca, caKey, out, revoked string
+	var days int
+	fs.StringVar(&dir, "dir", ".", "directory of the CA and CRL files")
+	fs.StringVar(&ca, "ca", "ca-cert.pem", "CA certificate (relative to -dir)")
+	fs.StringVar(&caKey, "ca-key", "ca-key.pem", "CA private key (relative to -dir)")
+	fs.StringVar(&out, "out", "crl.pem", "CRL file (relative to -dir), updated if it exists")
+	fs.StringVar(&revoked, "revoke", "", "comma-separated certificate files or hex serial numbers to revoke")
+	fs.IntVar(&days, "days", 7, "days until the next update of the CRL")
+	fs.Parse(args)
+
+	issuer, err := pki.LoadFiles(filepath.Join(dir, ca), filepath.Join(dir, caKey))
+	if err != nil {
+		return fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	// keep the entries of the current list, and increase its number
+	out = filepath.Join(dir, out)
+	var entries []x509.RevocationListEntry
+	number := big.NewInt(1)
+	if data, err := os.ReadFile(out); err == nil {
+		list, err := pki.ParseCRL(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", out, err)
+		}
+		entries = list.RevokedCertificateEntries
+		if list.Number != nil {
+			number.Add(list.Number, big.NewInt(1))
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, e := range entries {
+		listed[e.SerialNumber.Text(16)] = true
+	}
+	for _, item := range splitList(revoked) {
+		serial, err := parseSerial(item)
+		if err != nil {
+			return err
+		}
+		if listed[serial.Text(16)] {
+			continue
+		}
+		listed[serial.Text(16)] = true
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: time.Now()})
+		fmt.Printf("revoked serial %x\n", serial)
+	}
+
+	crl, err := issuer.CreateCRL(entries, number, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, crl, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s (number %v, %d revoked)\n", out, number, len(entries))
+	return nil
+}
+
+// parseSerial returns the serial number of a certificate file,
+// or parses s as a hex serial number
+func parseSerial(s string) (*big.Int, error) {
+	if data, err := os.ReadFile(s); err == nil {
+		certs, err := pki.ParseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s, err)
+		}
+		return certs[0].SerialNumber, nil
+	}
+	serial, ok := new(big.Int).SetString(strings.ReplaceAll(s, ":", ""), 16)
+	if !ok {
+		return nil, fmt.Errorf("not a certificate file or hex serial number: %s", s)
+	}
+	return serial, nil
+}
+
+// cmdVerify checks a key/certificate pair and, optionally, its chain
+func cmdVerify(args []string) error {
+	fs := flag.NewFlagSet("certgen verify", flag.ExitOnError)
+	var certFile, keyFile, caFile, use string
+	fs.StringVar(&certFile, "cert", "", "certificate to verify")
+	fs.StringVar(&keyFile, "key", "", "private key expected to match the certificate")
+	fs.StringVar(&caFile, "ca", "", "root CA the certificate must chain up to")
+	fs.StringVar(&use, "usage", "", "expected extended key usage [server,client]")
+	fs.Parse(args)
+	if certFile == "" {
+		return fmt.Errorf("missing -cert")
+	}
+
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	certs, err := pki.ParseCertificates(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", certFile, err)
+	}
+	cert := certs[0]
+
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		key, err := pki.ParsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", keyFile, err)
+		}
+		if err := pki.VerifyKeyPair(cert, key); err != nil {
+			return err
+		}
+		fmt.Printf("key %s matches certificate %s\n", keyFile, certFile)
+	}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		roots, err := pki.ParseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", caFile, err)
+		}
+		rootPool := (&pki.Cert{Cert: roots[0]}).Pool()
+		for _, root := range roots[1:] {
+			rootPool.AddCert(root)
+		}
+		intermediates := (&pki.Cert{Cert: cert}).Pool()
+		for _, c := range certs[1:] {
+			intermediates.AddCert(c)
+		}
+		if err := pki.Verify(cert, rootPool, intermediates, pki.Usage(use), time.Now()); err != nil {
+			return err
+		}
+		fmt.Printf("certificate %s is signed by %s\n", certFile, caFile)
+	}
+
+	if left := time.Until(cert.NotAfter); left < 30*24*time.Hour {
+		fmt.Printf("warning: certificate expires in %v (%s)\n", left.Round(time.Hour), cert.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// cmdShow prints a summary of each certificate file
+func cmdShow(args []string) error {
+	fs := flag.NewFlagSet("certgen show", flag.ExitOnError)
+	fs.Parse(args)
+	for _, file := range fs.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		certs, err := pki.ParseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, cert := range certs {
+			fmt.Printf("%s:\n%s", file, pki.Describe(cert))
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
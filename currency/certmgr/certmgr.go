@@ -0,0 +1,304 @@
+// Package certmgr serves TLS certificates that can be renewed without
+// restarting the server.  A Manager loads the certificate, private key,
+// and (optional) client CA bundle, then watches the files for changes.
+// When the files change, the new material is validated and swapped in
+// atomically; if validation fails, the server keeps serving the previous
+// certificate.  The Manager also logs warnings ahead of expiry.
+//
+// The certificate is served through tls.Config GetCertificate, and the
+// client CA pool through GetConfigForClient:
+//
+//	mgr, err := certmgr.New(certmgr.Config{CertFile: cert, KeyFile: key, CAFile: ca})
+//	...
+//	defer mgr.Close()
+//	ln, err := tls.Listen("tcp", addr, mgr.ServerConfig(&tls.Config{
+//		ClientAuth: tls.RequireAndVerifyClientCert,
+//	}))
+//
+// SNIManager serves several hostnames from a directory of certificates,
+// selecting the certificate and the client authentication policy from
+// the server name (SNI) requested by the client.
+package certmgr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config configures a Manager
+type Config struct {
+	// CertFile and KeyFile are the PEM encoded certificate (chain)
+	// and private key presented by the server
+	CertFile, KeyFile string
+
+	// CAFile is the PEM bundle used to verify client certificates,
+	// it is optional
+	CAFile string
+
+	// Interval between checks for file changes, default 10s
+	Interval time.Duration
+
+	// ExpiryWarning is how long before expiry warnings are logged,
+	// default 30 days
+	ExpiryWarning time.Duration
+
+	// Logger receives reload and expiry messages, default log.Printf
+	Logger *log.Logger
+
+	// Staple, if set, is called for every handshake and returns the
+	// certificate to present with an OCSP response attached
+	// (see revoke.Stapler)
+	Staple func(*tls.Certificate) *tls.Certificate
+}
+
+// Manager provides the current certificate and client CA pool
+type Manager struct {
+	cfg Config
+
+	cert   atomic.Value // *tls.Certificate
+	caPool atomic.Value // *x509.CertPool
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+	lastWarn time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New loads the certificate files and starts watching them.  It fails
+// if the initial files cannot be loaded.
+func New(cfg Config) (*Manager, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.ExpiryWarning <= 0 {
+		cfg.ExpiryWarning = 30 * 24 * time.Hour
+	}
+	m := &Manager{
+		cfg:      cfg,
+		modTimes: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+	if err := m.reload(true); err != nil {
+		return nil, err
+	}
+
+	m.wg.Add(1)
+	go m.watch()
+	return m, nil
+}
+
+// Close stops watching the files
+func (m *Manager) Close() error {
+	close(m.done)
+	m.wg.Wait()
+	return nil
+}
+
+// GetCertificate returns the current certificate, for use as
+// tls.Config.GetCertificate
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if m.cfg.Staple != nil {
+		return m.cfg.Staple(m.Certificate()), nil
+	}
+	return m.Certificate(), nil
+}
+
+// Certificate returns the current certificate
+func (m *Manager) Certificate() *tls.Certificate {
+	return m.cert.Load().(*tls.Certificate)
+}
+
+// ClientCAs returns the current client CA pool, or nil if no
+// CA file is configured
+func (m *Manager) ClientCAs() *x509.CertPool {
+	pool, _ := m.caPool.Load().(*x509.CertPool)
+	return pool
+}
+
+// ServerConfig returns a copy of base that obtains the certificate and,
+// when a CA file is configured, the client CA pool from the manager
+// for every handshake
+func (m *Manager) ServerConfig(base *tls.Config) *tls.Config {
+	if base == nil {
+		base = &tls.Config{}
+	}
+	cfg := base.Clone()
+	cfg.Certificates = nil
+	cfg.GetCertificate = m.GetCertificate
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.Certificates = nil
+		c.GetCertificate = m.GetCertificate
+		if pool := m.ClientCAs(); pool != nil {
+			c.ClientCAs = pool
+		}
+		return c, nil
+	}
+	return cfg
+}
+
+// Reload loads and validates the files, then swaps them in.  On error
+// the previous certificate and CA pool remain in use.
+func (m *Manager) Reload() error {
+	return m.reload(false)
+}
+
+// reload implements Reload.  The initial load accepts a certificate that
+// is outside its validity period (with a warning) since there is nothing
+// else to serve, later reloads reject it.
+func (m *Manager) reload(initial bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// record the file times first so that a failed reload is only
+	// retried once the files change again
+	for _, file := range m.files() {
+		if info, err := os.Stat(file); err == nil {
+			m.modTimes[file] = info.ModTime()
+		}
+	}
+
+	cert, err := loadCertificate(m.cfg.CertFile, m.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	if err := checkValidity(cert.Leaf, time.Now()); err != nil {
+		if !initial {
+			return fmt.Errorf("%s: %w", m.cfg.CertFile, err)
+		}
+		m.logf("certmgr: WARNING %s: %v", m.cfg.CertFile, err)
+	}
+
+	var pool *x509.CertPool
+	if m.cfg.CAFile != "" {
+		if pool, err = loadPool(m.cfg.CAFile); err != nil {
+			return err
+		}
+	}
+
+	m.cert.Store(cert)
+	if pool != nil {
+		m.caPool.Store(pool)
+	}
+	m.lastWarn = time.Time{}
+	m.logf("certmgr: loaded %s (%s, expires %s)", m.cfg.CertFile,
+		cert.Leaf.Subject, cert.Leaf.NotAfter.Format(time.RFC3339))
+	m.checkExpiry(time.Now())
+	return nil
+}
+
+// watch polls the files and reloads them when they change
+func (m *Manager) watch() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			if m.changed() {
+				if err := m.Reload(); err != nil {
+					m.logf("certmgr: reload failed, keeping current certificate: %v", err)
+				}
+			}
+			m.mu.Lock()
+			m.checkExpiry(time.Now())
+			m.mu.Unlock()
+		}
+	}
+}
+
+// changed reports whether any file was modified since the last reload
+func (m *Manager) changed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, file := range m.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue // file being replaced, check again later
+		}
+		if !info.ModTime().Equal(m.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExpiry logs a warning, at most once a day, when the
+// certificate is about to expire
+func (m *Manager) checkExpiry(now time.Time) {
+	leaf := m.Certificate().Leaf
+	left := leaf.NotAfter.Sub(now)
+	if left > m.cfg.ExpiryWarning || now.Sub(m.lastWarn) < 24*time.Hour {
+		return
+	}
+	m.lastWarn = now
+	m.logf("certmgr: WARNING certificate %s expires in %v (%s)", leaf.Subject,
+		left.Round(time.Minute), leaf.NotAfter.Format(time.RFC3339))
+}
+
+func (m *Manager) files() []string {
+	files := []string{m.cfg.CertFile, m.cfg.KeyFile}
+	if m.cfg.CAFile != "" {
+		files = append(files, m.cfg.CAFile)
+	}
+	return files
+}
+
+func (m *Manager) logf(format string, args ...interface{}) {
+	if m.cfg.Logger != nil {
+		m.cfg.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// loadCertificate loads the key pair and parses the leaf certificate.
+// tls.LoadX509KeyPair verifies that the private key matches the
+// certificate public key.
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// checkValidity checks that now is within the certificate validity period
+func checkValidity(leaf *x509.Certificate, now time.Time) error {
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadPool reads a PEM bundle of CA certificates
+func loadPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New(caFile + ": no CA certificate found")
+	}
+	return pool, nil
+}
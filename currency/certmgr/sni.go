@@ -0,0 +1,472 @@
+package certmgr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SNIConfig describes a multi-tenant server: a directory of certificates
+// and the client authentication policy of each served hostname.  It is
+// usually read from a JSON file with LoadSNIConfig:
+//
+//	{
+//	  "cert_dir": "../certs/tenants",
+//	  "default": "localhost",
+//	  "hosts": [
+//	    {"name": "currency.example.com", "client_auth": "none"},
+//	    {"name": "*.internal", "client_auth": "require-and-verify", "client_ca": "../certs/ca-cert.pem"}
+//	  ]
+//	}
+type SNIConfig struct {
+	// CertDir contains certificate and key pairs named
+	// <name>-cert.pem and <name>-key.pem.  Certificates are indexed
+	// by their DNS subject alternative names (or common name if the
+	// certificate has no SAN).
+	CertDir string `json:"cert_dir"`
+
+	// Default is the hostname whose certificate is presented when the
+	// client sends no SNI or an unknown name
+	Default string `json:"default"`
+
+	// Hosts lists per-hostname policies.  Names can use a leading
+	// wildcard label (*.example.com); name "*" sets the default policy.
+	Hosts []HostPolicy `json:"hosts"`
+
+	// Interval between checks for file changes, default 10s
+	Interval time.Duration `json:"-"`
+
+	// ExpiryWarning is how long before expiry warnings are logged,
+	// default 30 days
+	ExpiryWarning time.Duration `json:"-"`
+
+	// Logger receives reload and expiry messages, default log.Printf
+	Logger *log.Logger `json:"-"`
+}
+
+// HostPolicy is the client authentication policy of a hostname
+type HostPolicy struct {
+	Name string `json:"name"`
+
+	// ClientAuth is one of none, request, require, verify-if-given,
+	// or require-and-verify (see tls.ClientAuthType)
+	ClientAuth string `json:"client_auth"`
+
+	// ClientCA is the PEM bundle used to verify client certificates
+	ClientCA string `json:"client_ca"`
+}
+
+// LoadSNIConfig reads a JSON configuration file.  Relative paths in the
+// file are resolved against the directory of the file.
+func LoadSNIConfig(path string) (*SNIConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg SNIConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	base := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+	cfg.CertDir = resolve(cfg.CertDir)
+	for i := range cfg.Hosts {
+		cfg.Hosts[i].ClientCA = resolve(cfg.Hosts[i].ClientCA)
+	}
+	return &cfg, nil
+}
+
+// ParseClientAuth returns the tls.ClientAuthType named by s
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require-and-verify":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return 0, fmt.Errorf("unsupported client auth: %s", s)
+}
+
+// SNIManager selects the certificate and client authentication policy
+// of each connection from the server name (SNI) sent by the client.
+// Like Manager, it watches the certificate directory and CA files, and
+// reloads them when they change.  A certificate that fails to load or
+// validate is skipped and the previously loaded version, if any, is
+// kept in service.
+type SNIManager struct {
+	cfg   SNIConfig
+	state atomic.Value // *sniState
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+	lastWarn map[string]time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// sniState is the immutable material used for handshakes,
+// swapped atomically on reload
+type sniState struct {
+	certs    map[string]*tls.Certificate // by lowercase DNS name
+	byFile   map[string]*tls.Certificate // by cert file, to keep on failure
+	def      *tls.Certificate
+	policies map[string]*hostPolicy
+}
+
+type hostPolicy struct {
+	HostPolicy
+	auth tls.ClientAuthType
+	pool *x509.CertPool
+}
+
+// NewSNI loads the certificates and policies of cfg and starts
+// watching the files
+func NewSNI(cfg SNIConfig) (*SNIManager, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.ExpiryWarning <= 0 {
+		cfg.ExpiryWarning = 30 * 24 * time.Hour
+	}
+	m := &SNIManager{
+		cfg:      cfg,
+		modTimes: make(map[string]time.Time),
+		lastWarn: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	m.wg.Add(1)
+	go m.watch()
+	return m, nil
+}
+
+// Close stops watching the files
+func (m *SNIManager) Close() error {
+	close(m.done)
+	m.wg.Wait()
+	return nil
+}
+
+// GetCertificate returns the certificate for the requested server name,
+// or the default certificate, for use as tls.Config.GetCertificate
+func (m *SNIManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	st := m.current()
+	if cert := lookup(st.certs, hello.ServerName); cert != nil {
+		return cert, nil
+	}
+	if st.def == nil {
+		return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+	}
+	return st.def, nil
+}
+
+// Policy returns the policy that applies to serverName
+func (m *SNIManager) Policy(serverName string) HostPolicy {
+	if p := m.policy(serverName); p != nil {
+		return p.HostPolicy
+	}
+	return HostPolicy{Name: "*", ClientAuth: "none"}
+}
+
+// ServerConfig returns a copy of base that selects, for each client,
+// the certificate and client authentication settings of the server
+// name it requested
+func (m *SNIManager) ServerConfig(base *tls.Config) *tls.Config {
+	if base == nil {
+		base = &tls.Config{}
+	}
+	cfg := base.Clone()
+	cfg.Certificates = nil
+	cfg.GetCertificate = m.GetCertificate
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.Certificates = nil
+		c.GetCertificate = m.GetCertificate
+		c.ClientAuth = tls.NoClientCert
+		if p := m.policy(hello.ServerName); p != nil {
+			c.ClientAuth = p.auth
+			c.ClientCAs = p.pool
+		}
+		return c, nil
+	}
+	return cfg
+}
+
+func (m *SNIManager) current() *sniState {
+	return m.state.Load().(*sniState)
+}
+
+func (m *SNIManager) policy(serverName string) *hostPolicy {
+	policies := m.current().policies
+	if p := lookup(policies, serverName); p != nil {
+		return p
+	}
+	return policies["*"]
+}
+
+// lookup finds name in table using an exact match first, then
+// a wildcard match on the parent domain (*.example.com)
+func lookup[T any](table map[string]T, name string) T {
+	var zero T
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return zero
+	}
+	if v, ok := table[name]; ok {
+		return v
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if v, ok := table["*"+name[i:]]; ok {
+			return v
+		}
+	}
+	return zero
+}
+
+// Reload loads the certificate directory and the client CA bundles.
+// The new state is swapped in atomically.
+func (m *SNIManager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var prev *sniState
+	if st, ok := m.state.Load().(*sniState); ok {
+		prev = st
+	}
+
+	files, err := m.files()
+	if err != nil {
+		return err
+	}
+	times := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			times[file] = info.ModTime()
+		}
+	}
+	m.modTimes = times // the times of removed files are dropped
+
+	st := &sniState{
+		certs:    make(map[string]*tls.Certificate),
+		byFile:   make(map[string]*tls.Certificate),
+		policies: make(map[string]*hostPolicy),
+	}
+
+	// policies are loaded first, a bad policy fails the whole reload
+	for _, h := range m.cfg.Hosts {
+		auth, err := ParseClientAuth(h.ClientAuth)
+		if err != nil {
+			return fmt.Errorf("host %s: %w", h.Name, err)
+		}
+		p := &hostPolicy{HostPolicy: h, auth: auth}
+		if h.ClientCA != "" {
+			if p.pool, err = loadPool(h.ClientCA); err != nil {
+				return fmt.Errorf("host %s: %w", h.Name, err)
+			}
+		} else if auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert {
+			return fmt.Errorf("host %s: client_auth %s requires client_ca", h.Name, h.ClientAuth)
+		}
+		st.policies[strings.ToLower(h.Name)] = p
+	}
+
+	certFiles, _ := filepath.Glob(filepath.Join(m.cfg.CertDir, "*-cert.pem"))
+	sort.Strings(certFiles)
+	now := time.Now()
+	for _, certFile := range certFiles {
+		keyFile := strings.TrimSuffix(certFile, "-cert.pem") + "-key.pem"
+		if _, err := os.Stat(keyFile); err != nil {
+			continue // no private key, not a server certificate
+		}
+
+		cert, err := loadCertificate(certFile, keyFile)
+		if err == nil {
+			if err = checkValidity(cert.Leaf, now); err != nil {
+				err = fmt.Errorf("%s: %w", certFile, err)
+			}
+		}
+		if err != nil {
+			var old *tls.Certificate
+			if prev != nil {
+				old = prev.byFile[certFile]
+			}
+			if old == nil {
+				m.logf("certmgr: skipping %s: %v", certFile, err)
+				continue
+			}
+			m.logf("certmgr: reload of %s failed, keeping current certificate: %v", certFile, err)
+			cert = old
+		}
+
+		if !serverCert(cert.Leaf) {
+			continue // CA or client certificate kept next to the server certificates
+		}
+
+		if st.def == nil {
+			st.def = cert // first certificate, unless Default is set
+		}
+		st.byFile[certFile] = cert
+		for _, name := range certNames(cert.Leaf) {
+			if other, ok := st.certs[name]; ok && other != cert {
+				m.logf("certmgr: %s: name %s already served by another certificate", certFile, name)
+				continue
+			}
+			st.certs[name] = cert
+		}
+	}
+
+	if len(st.byFile) == 0 {
+		return fmt.Errorf("no certificate found in %s", m.cfg.CertDir)
+	}
+	if m.cfg.Default != "" {
+		if st.def = lookup(st.certs, m.cfg.Default); st.def == nil {
+			return fmt.Errorf("no certificate for default host %s", m.cfg.Default)
+		}
+	}
+
+	m.state.Store(st)
+	names := make([]string, 0, len(st.certs))
+	for name := range st.certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	m.logf("certmgr: serving certificates for %s", strings.Join(names, ", "))
+	m.checkExpiry(now)
+	return nil
+}
+
+// serverCert reports whether leaf can be presented by a server
+func serverCert(leaf *x509.Certificate) bool {
+	if leaf.IsCA {
+		return false
+	}
+	if len(leaf.ExtKeyUsage) == 0 {
+		return true
+	}
+	for _, u := range leaf.ExtKeyUsage {
+		if u == x509.ExtKeyUsageServerAuth || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+// certNames returns the lowercase DNS names of a certificate
+func certNames(leaf *x509.Certificate) []string {
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	result := make([]string, len(names))
+	for i, n := range names {
+		result[i] = strings.ToLower(n)
+	}
+	return result
+}
+
+// watch polls the files and reloads them when they change
+func (m *SNIManager) watch() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			if m.changed() {
+				if err := m.Reload(); err != nil {
+					m.logf("certmgr: reload failed, keeping current certificates: %v", err)
+				}
+			}
+			m.mu.Lock()
+			m.checkExpiry(time.Now())
+			m.mu.Unlock()
+		}
+	}
+}
+
+// changed reports whether files were added, removed, or modified
+func (m *SNIManager) changed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files, err := m.files()
+	if err != nil {
+		return false
+	}
+	if len(files) != len(m.modTimes) {
+		return true
+	}
+	for _, file := range files {
+		t, ok := m.modTimes[file]
+		if !ok {
+			return true
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// files lists the watched files: the PEM files of the
+// certificate directory and the client CA bundles
+func (m *SNIManager) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(m.cfg.CertDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range m.cfg.Hosts {
+		if h.ClientCA != "" {
+			files = append(files, h.ClientCA)
+		}
+	}
+	return files, nil
+}
+
+// checkExpiry logs a warning, at most once a day per certificate,
+// for certificates about to expire
+func (m *SNIManager) checkExpiry(now time.Time) {
+	for file, cert := range m.current().byFile {
+		left := cert.Leaf.NotAfter.Sub(now)
+		if left > m.cfg.ExpiryWarning || now.Sub(m.lastWarn[file]) < 24*time.Hour {
+			continue
+		}
+		m.lastWarn[file] = now
+		m.logf("certmgr: WARNING certificate %s expires in %v (%s)", file,
+			left.Round(time.Minute), cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func (m *SNIManager) logf(format string, args ...interface{}) {
+	if m.cfg.Logger != nil {
+		m.cfg.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,74 @@
+package certmgr
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/pki"
+)
+
+// writeTenants issues a server certificate for each name with a
+// throwaway CA, written to dir as <name>-cert.pem and <name>-key.pem
+func writeTenants(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	ca, err := pki.NewCA(pki.Options{CommonName: "Test CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		cert, err := ca.Issue(pki.Options{CommonName: name, DNSNames: []string{name}, Usage: pki.ServerUsage})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cert.WriteFiles(filepath.Join(dir, name+"-cert.pem"), filepath.Join(dir, name+"-key.pem")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// served returns the common name of the certificate served to name
+func served(t *testing.T, m *SNIManager, name string) string {
+	t.Helper()
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestSNIRemove(t *testing.T) {
+	dir := t.TempDir()
+	writeTenants(t, dir, "a.example.com", "b.example.com")
+	m, err := NewSNI(SNIConfig{
+		CertDir:  dir,
+		Default:  "a.example.com",
+		Interval: 10 * time.Millisecond,
+		Logger:   log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if name := served(t, m, "b.example.com"); name != "b.example.com" {
+		t.Fatalf("got %s, want b.example.com", name)
+	}
+
+	// once its files are removed, the host gets the default certificate
+	for _, f := range []string{"b.example.com-cert.pem", "b.example.com-key.pem"} {
+		if err := os.Remove(filepath.Join(dir, f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for served(t, m, "b.example.com") != "a.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("removed certificate still served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,354 @@
+// Package client implements a reusable client for the JSON currency
+// service (see programs serverjsonX and tls-servX).  A Client holds a
+// single connection to the service and exchanges requests encoded as
+// curr.CurrencyRequest for responses encoded as []curr.Currency, or as
+// curr.CurrencyError when the server rejects the request.  Servers that
+// support commands other than searches (see tls-serv1) run them with
+// Command.
+//
+// Example:
+//
+//	c, err := client.Dial(ctx, "tcp", "localhost:4040")
+//	if err != nil {
+//		...
+//	}
+//	defer c.Close()
+//	currencies, err := c.Get(ctx, "USD")
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+// ErrClosed is returned when using a client that has been closed
+var ErrClosed = errors.New("client: connection closed")
+
+// ServerError is the error reported by the server with a
+// curr.CurrencyError response.  The connection remains usable.
+type ServerError struct {
+	Message string
+	// Code classifies the error when the server provides it,
+	// i.e. curr.ErrCodeForbidden
+	Code string
+}
+
+func (e *ServerError) Error() string {
+	return "currency server: " + e.Message
+}
+
+// Option configures how a client dials the service
+type Option func(*options)
+
+type options struct {
+	dialer    *net.Dialer
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	retry     *backoff.Policy
+}
+
+// WithDialer sets the dialer used to create the connection.
+// This can be used to set timeouts, keep-alive, or a local address.
+func WithDialer(d *net.Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithTimeout sets the maximum amount of time spent dialing
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithBackoff retries failed dials using policy p.  Only errors
+// classified by backoff.IsRetryableDial are retried.
+func WithBackoff(p backoff.Policy) Option {
+	return func(o *options) {
+		o.retry = &p
+	}
+}
+
+// WithTLS secures the connection using the provided TLS configuration.
+// Use LoadTLSConfig to build a configuration for TLS or mutual TLS.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = cfg
+	}
+}
+
+// LoadTLSConfig returns a TLS configuration that trusts the root CA
+// stored in caFile (or the system roots when caFile is empty).  When
+// both certFile and keyFile are provided, the client certificate is
+// presented to the server for mutual TLS authentication (see tls-serv1).
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cer, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client cert: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cer}
+	}
+
+	return cfg, nil
+}
+
+// Client is a connection to the currency service.  A client can be
+// used from multiple goroutines, requests are sent one at a time.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	err  error // sticky connection error
+}
+
+// Dial connects to the currency service at addr over network
+// (tcp, tcp4, tcp6, or unix).  The context bounds the time spent
+// connecting, including the TLS handshake when WithTLS is used.
+func Dial(ctx context.Context, network, addr string, opts ...Option) (*Client, error) {
+	o := &options{dialer: &net.Dialer{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	var retry *backoff.Backoff
+	if o.retry != nil {
+		retry = backoff.New(*o.retry)
+	}
+
+	for {
+		conn, err := o.dial(ctx, network, addr)
+		if err == nil {
+			return NewClient(conn), nil
+		}
+		if retry == nil || !backoff.IsRetryableDial(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		if werr := retry.Wait(ctx); werr != nil {
+			return nil, fmt.Errorf("%w (%d retries)", err, retry.Retries())
+		}
+	}
+}
+
+func (o *options) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if o.tlsConfig != nil {
+		if strings.HasPrefix(network, "unix") && o.tlsConfig.ServerName == "" && !o.tlsConfig.InsecureSkipVerify {
+			// the server name cannot be inferred from a socket path
+			return nil, errors.New("client: TLS over unix socket requires a server name")
+		}
+		dialer := &tls.Dialer{NetDialer: o.dialer, Config: o.tlsConfig}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return o.dialer.DialContext(ctx, network, addr)
+}
+
+// NewClient returns a client that uses an already established connection
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(conn),
+	}
+}
+
+// Get sends query (a currency name, code, number, country, or *)
+// and returns the currencies found by the server.  If the server
+// rejects the request, the returned error is a *ServerError.
+//
+// The context deadline is applied to the connection.  If the context
+// is canceled while waiting, the pending IO is interrupted and the
+// connection can no longer be used.
+func (c *Client) Get(ctx context.Context, query string) ([]curr.Currency, error) {
+	raw, err := c.roundTrip(ctx, &curr.CurrencyRequest{Get: query})
+	if err != nil {
+		return nil, err
+	}
+	var currencies []curr.Currency
+	if err := json.Unmarshal(raw, &currencies); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return currencies, nil
+}
+
+// Command runs the server command cmd, such as "stats" or "reload" on
+// tls-serv1, and decodes its reply into result, unless result is nil.
+// The reply of a command is a JSON object specific to the command.  If
+// the server rejects the command, i.e. because the client is not
+// allowed to run it, the returned error is a *ServerError.
+//
+// The context is applied to the connection like with Get.
+func (c *Client) Command(ctx context.Context, cmd string, result interface{}) error {
+	if cmd == "" || cmd == "get" {
+		return errors.New("client: use Get to search currencies")
+	}
+	raw, err := c.roundTrip(ctx, &curr.CurrencyRequest{Cmd: cmd})
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("failed to decode %s reply: %w", cmd, err)
+	}
+	return nil
+}
+
+// roundTrip sends req and returns the response, or a *ServerError if
+// the response is a curr.CurrencyError
+func (c *Client) roundTrip(ctx context.Context, req *curr.CurrencyRequest) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	stop, err := c.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	// send request
+	if err := c.enc.Encode(req); err != nil {
+		return nil, c.fail(ctx, fmt.Errorf("failed to send request: %w", err))
+	}
+
+	// receive response, a curr.CurrencyError or the result of the request
+	var raw json.RawMessage
+	if err := c.dec.Decode(&raw); err != nil {
+		return nil, c.fail(ctx, fmt.Errorf("failed to receive response: %w", err))
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '{' {
+		// only objects with a currency_error field are errors, the
+		// replies of commands are objects too
+		var srvErr struct {
+			Error *string `json:"currency_error"`
+			Code  string  `json:"code"`
+		}
+		if err := json.Unmarshal(raw, &srvErr); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if srvErr.Error != nil {
+			return nil, &ServerError{Message: *srvErr.Error, Code: srvErr.Code}
+		}
+	}
+	return raw, nil
+}
+
+// Close closes the connection to the server
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == ErrClosed {
+		return nil
+	}
+	c.err = ErrClosed
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local network address of the client
+func (c *Client) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the network address of the server
+func (c *Client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ConnectionState returns TLS details about the connection.
+// The boolean is false if the connection is not secured with TLS.
+func (c *Client) ConnectionState() (tls.ConnectionState, bool) {
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		return tc.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// bind ties the context to the connection: the context deadline becomes
+// the connection deadline, and a canceled context forces pending IO to
+// return immediately. The returned func must be called once IO is done.
+func (c *Client) bind(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline() // zero value means no deadline
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// unblock reads/writes by moving the deadline to the past
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}, nil
+}
+
+// fail records a connection error.  Once the request stream is broken,
+// the client cannot resynchronize with the server so the error is kept
+// and returned by subsequent calls.
+func (c *Client) fail(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	var netErr net.Error
+	if _, ok := ctx.Deadline(); ok && ctxErr == nil && errors.As(err, &netErr) && netErr.Timeout() {
+		// the connection deadline is the context deadline, which the
+		// connection may see expire a moment before the context
+		ctxErr = context.DeadlineExceeded
+	}
+	if ctxErr != nil {
+		err = fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	c.err = err
+	return err
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+// pipeServer returns a client connected over net.Pipe to a server
+// answering each request with the value returned by handle, until
+// handle returns nil
+func pipeServer(t *testing.T, handle func(curr.CurrencyRequest) interface{}) *Client {
+	t.Helper()
+	cc, sc := net.Pipe()
+	go func() {
+		defer sc.Close()
+		dec, enc := json.NewDecoder(sc), json.NewEncoder(sc)
+		for {
+			var req curr.CurrencyRequest
+			if err := dec.Decode(&req); err != nil {
+				return
+			}
+			rsp := handle(req)
+			if rsp == nil || enc.Encode(rsp) != nil {
+				return
+			}
+		}
+	}()
+	c := NewClient(cc)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCommand(t *testing.T) {
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := pipeServer(t, func(req curr.CurrencyRequest) interface{} {
+		switch req.Cmd {
+		case "stats":
+			return map[string]interface{}{"started": started, "requests": 42}
+		case "reload":
+			return &curr.CurrencyError{Error: "reload failed: no such file", Code: curr.ErrCodeReloadFailed}
+		}
+		return &curr.CurrencyError{Error: "unknown command: " + req.Cmd, Code: curr.ErrCodeBadRequest}
+	})
+	ctx := context.Background()
+
+	// the reply of a command is an object, but not an error
+	var stats struct {
+		Started  time.Time `json:"started"`
+		Requests int64     `json:"requests"`
+	}
+	if err := c.Command(ctx, "stats", &stats); err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Started.Equal(started) || stats.Requests != 42 {
+		t.Errorf("got %+v", stats)
+	}
+
+	var srvErr *ServerError
+	if err := c.Command(ctx, "reload", nil); !errors.As(err, &srvErr) || srvErr.Code != curr.ErrCodeReloadFailed {
+		t.Errorf("reload: got %v, want a ServerError with code %s", err, curr.ErrCodeReloadFailed)
+	}
+	if err := c.Command(ctx, "get", nil); err == nil {
+		t.Error("get accepted as a command")
+	}
+
+	// a command reply is not a list of currencies
+	c = pipeServer(t, func(curr.CurrencyRequest) interface{} {
+		return map[string]int{"requests": 1}
+	})
+	if _, err := c.Get(ctx, "USD"); err == nil || errors.As(err, &srvErr) {
+		t.Errorf("get: got %v, want a decoding error", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	c := pipeServer(t, func(req curr.CurrencyRequest) interface{} {
+		switch req.Get {
+		case "":
+			return &curr.CurrencyError{Error: "empty search", Code: curr.ErrCodeBadRequest}
+		case "legacy":
+			// servers before error codes
+			return &curr.CurrencyError{Error: "legacy error"}
+		case "none":
+			return []curr.Currency{}
+		}
+		return []curr.Currency{{Code: req.Get, Name: "Currency " + req.Get}}
+	})
+	ctx := context.Background()
+
+	result, err := c.Get(ctx, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].Code != "USD" || result[0].Name != "Currency USD" {
+		t.Errorf("got %+v", result)
+	}
+	if result, err := c.Get(ctx, "none"); err != nil || len(result) != 0 {
+		t.Errorf("none: got %+v, %v", result, err)
+	}
+
+	tests := []struct {
+		query, message, code string
+	}{
+		{"", "empty search", curr.ErrCodeBadRequest},
+		{"legacy", "legacy error", ""},
+	}
+	for _, tt := range tests {
+		var srvErr *ServerError
+		_, err := c.Get(ctx, tt.query)
+		if !errors.As(err, &srvErr) || srvErr.Message != tt.message || srvErr.Code != tt.code {
+			t.Errorf("%q: got %#v, want a ServerError %q with code %q", tt.query, err, tt.message, tt.code)
+		}
+	}
+
+	// the connection remains usable after a server error
+	if _, err := c.Get(ctx, "EUR"); err != nil {
+		t.Errorf("after a server error: %v", err)
+	}
+
+	c.Close()
+	if _, err := c.Get(ctx, "USD"); err != ErrClosed {
+		t.Errorf("after Close: got %v, want %v", err, ErrClosed)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestGetBrokenConnection(t *testing.T) {
+	// the server closes the connection instead of answering
+	c := pipeServer(t, func(curr.CurrencyRequest) interface{} { return nil })
+	ctx := context.Background()
+	_, err := c.Get(ctx, "USD")
+	var srvErr *ServerError
+	if err == nil || errors.As(err, &srvErr) {
+		t.Fatalf("got %v, want a connection error", err)
+	}
+	// the error sticks, the request stream cannot be resynchronized
+	if _, err2 := c.Get(ctx, "USD"); err2 != err {
+		t.Errorf("got %v, want %v again", err2, err)
+	}
+}
+
+func TestGetDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := pipeServer(t, func(req curr.CurrencyRequest) interface{} {
+		if req.Get == "slow" {
+			<-release
+		}
+		return []curr.Currency{{Code: req.Get}}
+	})
+
+	// a context already done fails without touching the connection
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(canceled, "USD"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if _, err := c.Get(context.Background(), "USD"); err != nil {
+		t.Fatalf("after a canceled context: %v", err)
+	}
+
+	// the deadline of the context is the deadline of the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Get(ctx, "slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned after %v", elapsed)
+	}
+}
+
+func TestGetCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := pipeServer(t, func(curr.CurrencyRequest) interface{} {
+		<-release
+		return []curr.Currency{}
+	})
+
+	// canceling the context interrupts the pending read
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := c.Get(ctx, "USD"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if _, err := c.Get(context.Background(), "USD"); err == nil {
+		t.Error("connection usable after an interrupted request")
+	}
+}
+
+func TestDial(t *testing.T) {
+	srv := startServer(t, "A", "")
+	ctx := context.Background()
+	c, err := Dial(ctx, "tcp", srv.addr, WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if result, err := c.Get(ctx, "USD"); err != nil || len(result) != 1 || result[0].Code != "A" {
+		t.Errorf("got %+v, %v", result, err)
+	}
+	if c.RemoteAddr().String() != srv.addr {
+		t.Errorf("got remote address %s, want %s", c.RemoteAddr(), srv.addr)
+	}
+	if _, ok := c.ConnectionState(); ok {
+		t.Error("got a TLS connection state")
+	}
+
+	// a refused connection is not retried without WithBackoff
+	srv.stop()
+	if _, err := Dial(ctx, "tcp", srv.addr); err == nil {
+		t.Error("dialed a stopped server")
+	}
+	if _, err := Dial(ctx, "unix", "/nonexistent/currency.sock", WithTLS(&tls.Config{})); err == nil {
+		t.Error("dialed TLS over a unix socket without a server name")
+	}
+}
@@ -0,0 +1,422 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+// ErrNoEndpoints is returned when a pool is created without endpoints
+var ErrNoEndpoints = errors.New("client: no endpoints")
+
+// Endpoint is the network address of a currency server
+type Endpoint struct {
+	Network string
+	Addr    string
+}
+
+func (e Endpoint) String() string {
+	return e.Network + "://" + e.Addr
+}
+
+// ParseEndpoint parses an endpoint in the form network://addr such
+// as tcp://localhost:4040 or unix:///tmp/currency.sock.  When the
+// network is omitted, tcp is assumed.
+func ParseEndpoint(s string) (Endpoint, error) {
+	network, addr := "tcp", s
+	if i := strings.Index(s, "://"); i >= 0 {
+		network, addr = s[:i], s[i+3:]
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return Endpoint{}, fmt.Errorf("unsupported network protocol: %s", network)
+	}
+	if addr == "" {
+		return Endpoint{}, fmt.Errorf("missing address: %s", s)
+	}
+	return Endpoint{Network: network, Addr: addr}, nil
+}
+
+// Strategy selects the endpoint that serves the next request
+type Strategy int
+
+const (
+	// RoundRobin cycles through the healthy endpoints
+	RoundRobin Strategy = iota
+	// LeastOutstanding picks the endpoint with the fewest requests in flight
+	LeastOutstanding
+	// PowerOfTwo picks two endpoints at random and uses the least loaded one
+	PowerOfTwo
+)
+
+// ParseStrategy returns the strategy named by s
+// [round-robin, least-outstanding, p2c]
+func ParseStrategy(s string) (Strategy, error) {
+	switch s {
+	case "round-robin", "rr":
+		return RoundRobin, nil
+	case "least-outstanding", "least":
+		return LeastOutstanding, nil
+	case "p2c", "power-of-two":
+		return PowerOfTwo, nil
+	}
+	return 0, fmt.Errorf("unsupported balancing strategy: %s", s)
+}
+
+// PoolConfig configures a connection pool
+type PoolConfig struct {
+	// Endpoints lists the currency servers (tcp and unix can be mixed)
+	Endpoints []Endpoint
+
+	// Strategy selects the endpoint for each request
+	Strategy Strategy
+
+	// MaxIdle is the max number of idle connections kept per endpoint,
+	// default 2
+	MaxIdle int
+
+	// MaxAttempts bounds how many endpoints are tried for a request
+	// after connection failures, default is the number of endpoints
+	MaxAttempts int
+
+	// HealthInterval is the time between background health checks,
+	// default 10s
+	HealthInterval time.Duration
+
+	// HealthTimeout bounds each health check, default 2s
+	HealthTimeout time.Duration
+
+	// HealthQuery is the lookup used to probe a server, default "USD"
+	HealthQuery string
+
+	// Options are applied when dialing new connections
+	Options []Option
+}
+
+// Pool distributes currency lookups across several servers.  It keeps
+// a bounded set of idle connections for each endpoint, checks their
+// health in the background, and retries a lookup on another endpoint
+// when a connection fails.  Lookups are idempotent, so retrying them
+// is always safe.
+type Pool struct {
+	cfg       PoolConfig
+	endpoints []*endpoint
+	next      uint64 // round-robin counter
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// endpoint tracks the connections and state of one server
+type endpoint struct {
+	Endpoint
+	outstanding int64 // requests in flight (atomic)
+
+	mu      sync.Mutex
+	idle    []*Client
+	healthy bool
+	closed  bool
+}
+
+// NewPool creates a pool for the configured endpoints and starts
+// the background health checks.  Connections are dialed lazily.
+func NewPool(cfg PoolConfig) (*Pool, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = 2
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = len(cfg.Endpoints)
+	}
+	if cfg.HealthInterval <= 0 {
+		cfg.HealthInterval = 10 * time.Second
+	}
+	if cfg.HealthTimeout <= 0 {
+		cfg.HealthTimeout = 2 * time.Second
+	}
+	if cfg.HealthQuery == "" {
+		cfg.HealthQuery = "USD"
+	}
+
+	p := &Pool{cfg: cfg, done: make(chan struct{})}
+	for _, e := range cfg.Endpoints {
+		p.endpoints = append(p.endpoints, &endpoint{Endpoint: e, healthy: true})
+	}
+
+	p.wg.Add(1)
+	go p.healthLoop()
+	return p, nil
+}
+
+// Get looks up query on one of the endpoints.  If the connection
+// fails, the endpoint is marked unhealthy and the lookup is retried
+// on another endpoint, up to MaxAttempts.  Errors reported by the
+// server (*ServerError) and context errors are not retried.
+func (p *Pool) Get(ctx context.Context, query string) ([]curr.Currency, error) {
+	tried := make(map[*endpoint]bool)
+	var lastErr error
+
+	for attempt := 0; attempt < p.cfg.MaxAttempts; attempt++ {
+		ep := p.pick(tried)
+		if ep == nil {
+			break
+		}
+		tried[ep] = true
+
+		result, err := p.getFrom(ctx, ep, query)
+		if err == nil {
+			return result, nil
+		}
+
+		var srvErr *ServerError
+		if errors.As(err, &srvErr) {
+			return nil, err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, err
+		}
+
+		log.Printf("currency pool: %s failed: %v", ep, err)
+		ep.setHealthy(false)
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no endpoint available")
+	}
+	return nil, fmt.Errorf("client: lookup failed: %w", lastErr)
+}
+
+// Close stops the health checks and closes all idle connections.  It
+// can be called more than once.
+func (p *Pool) Close() error {
+	p.once.Do(func() { close(p.done) })
+	p.wg.Wait()
+	for _, ep := range p.endpoints {
+		ep.mu.Lock()
+		ep.closed = true
+		idle := ep.idle
+		ep.idle = nil
+		ep.mu.Unlock()
+		for _, c := range idle {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// getFrom sends the lookup on an idle or new connection to ep
+func (p *Pool) getFrom(ctx context.Context, ep *endpoint, query string) ([]curr.Currency, error) {
+	atomic.AddInt64(&ep.outstanding, 1)
+	defer atomic.AddInt64(&ep.outstanding, -1)
+
+	// an idle connection may have been closed by the server in the
+	// meantime, so its failure is retried once on a new connection.
+	if c := ep.takeIdle(); c != nil {
+		result, err := p.send(ctx, ep, c, query)
+		if err == nil || ctx.Err() != nil {
+			return result, err
+		}
+		var srvErr *ServerError
+		if errors.As(err, &srvErr) {
+			return nil, err
+		}
+	}
+
+	c, err := Dial(ctx, ep.Network, ep.Addr, p.cfg.Options...)
+	if err != nil {
+		return nil, err
+	}
+	return p.send(ctx, ep, c, query)
+}
+
+// send issues the lookup on c, then returns c to the idle list unless
+// the connection failed
+func (p *Pool) send(ctx context.Context, ep *endpoint, c *Client, query string) ([]curr.Currency, error) {
+	result, err := c.Get(ctx, query)
+	var srvErr *ServerError
+	if err != nil && !errors.As(err, &srvErr) {
+		c.Close()
+		return nil, err
+	}
+	ep.putIdle(c, p.cfg.MaxIdle)
+	return result, err
+}
+
+// pick selects an endpoint that has not been tried yet using the
+// configured strategy.  Healthy endpoints are preferred, but when
+// none are left the unhealthy ones are tried as a last resort.
+func (p *Pool) pick(tried map[*endpoint]bool) *endpoint {
+	var healthy, unhealthy []*endpoint
+	for _, ep := range p.endpoints {
+		if tried[ep] {
+			continue
+		}
+		if ep.isHealthy() {
+			healthy = append(healthy, ep)
+		} else {
+			unhealthy = append(unhealthy, ep)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = unhealthy
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch p.cfg.Strategy {
+	case LeastOutstanding:
+		best := candidates[0]
+		for _, ep := range candidates[1:] {
+			if ep.load() < best.load() {
+				best = ep
+			}
+		}
+		return best
+	case PowerOfTwo:
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		i := rand.Intn(len(candidates))
+		j := rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		if candidates[j].load() < candidates[i].load() {
+			return candidates[j]
+		}
+		return candidates[i]
+	default:
+		n := atomic.AddUint64(&p.next, 1)
+		return candidates[int((n-1)%uint64(len(candidates)))]
+	}
+}
+
+// healthLoop periodically checks every endpoint
+func (p *Pool) healthLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			var wg sync.WaitGroup
+			for _, ep := range p.endpoints {
+				wg.Add(1)
+				go func(ep *endpoint) {
+					defer wg.Done()
+					p.check(ep)
+				}(ep)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// check probes the idle connections of ep, dropping the broken ones.
+// When there are no idle connections, a new one is dialed, which also
+// warms up the pool.  The endpoint is healthy if any probe succeeds.
+func (p *Pool) check(ep *endpoint) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.HealthTimeout)
+	defer cancel()
+
+	ep.mu.Lock()
+	idle := ep.idle
+	ep.idle = nil
+	ep.mu.Unlock()
+
+	healthy := false
+	for _, c := range idle {
+		if _, err := c.Get(ctx, p.cfg.HealthQuery); err != nil {
+			c.Close()
+			continue
+		}
+		healthy = true
+		ep.putIdle(c, p.cfg.MaxIdle)
+	}
+
+	if !healthy {
+		c, err := Dial(ctx, ep.Network, ep.Addr, p.cfg.Options...)
+		if err == nil {
+			if _, err = c.Get(ctx, p.cfg.HealthQuery); err == nil {
+				healthy = true
+				ep.putIdle(c, p.cfg.MaxIdle)
+			} else {
+				c.Close()
+			}
+		}
+		if err != nil && ep.isHealthy() {
+			log.Printf("currency pool: health check %s failed: %v", ep, err)
+		}
+	}
+
+	if healthy && !ep.isHealthy() {
+		log.Printf("currency pool: %s is healthy again", ep)
+	}
+	ep.setHealthy(healthy)
+}
+
+func (ep *endpoint) load() int64 {
+	return atomic.LoadInt64(&ep.outstanding)
+}
+
+func (ep *endpoint) isHealthy() bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.healthy
+}
+
+// setHealthy updates the state of ep.  Idle connections of an
+// unhealthy endpoint are closed since they are likely broken.
+func (ep *endpoint) setHealthy(healthy bool) {
+	ep.mu.Lock()
+	ep.healthy = healthy
+	var idle []*Client
+	if !healthy {
+		idle, ep.idle = ep.idle, nil
+	}
+	ep.mu.Unlock()
+	for _, c := range idle {
+		c.Close()
+	}
+}
+
+func (ep *endpoint) takeIdle() *Client {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if n := len(ep.idle); n > 0 {
+		c := ep.idle[n-1]
+		ep.idle = ep.idle[:n-1]
+		return c
+	}
+	return nil
+}
+
+// putIdle returns c to the idle list, or closes it when the list is full
+func (ep *endpoint) putIdle(c *Client, max int) {
+	ep.mu.Lock()
+	if !ep.closed && len(ep.idle) < max {
+		ep.idle = append(ep.idle, c)
+		c = nil
+	}
+	ep.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+}
@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+// testServer is a currency server on a local listener that answers
+// every lookup with a currency coded after its name, and "bad" with an
+// error
+type testServer struct {
+	name     string
+	addr     string
+	requests atomic.Int64
+
+	// hold, if not nil, delays the first lookup it receives
+	hold *hold
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]bool
+}
+
+// startServer starts a server named name listening on addr, a random
+// local port if empty
+func startServer(t *testing.T, name, addr string) *testServer {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	s := &testServer{name: name}
+	s.listen(t, addr)
+	t.Cleanup(s.stop)
+	return s
+}
+
+func (s *testServer) listen(t *testing.T, addr string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	s.mu.Lock()
+	s.ln, s.addr, s.conns = ln, ln.Addr().String(), make(map[net.Conn]bool)
+	s.mu.Unlock()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = true
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+	for {
+		var req curr.CurrencyRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		s.requests.Add(1)
+		if h := s.hold; h != nil && h.taken.CompareAndSwap(false, true) {
+			h.held <- s.name
+			<-h.release
+		}
+		var rsp interface{} = []curr.Currency{{Code: s.name}}
+		if req.Get == "bad" {
+			rsp = &curr.CurrencyError{Error: "bad request", Code: curr.ErrCodeBadRequest}
+		}
+		if enc.Encode(rsp) != nil {
+			return
+		}
+	}
+}
+
+// stop closes the listener and the connections of the server
+func (s *testServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ln.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// newTestPool returns a pool of the servers
+func newTestPool(t *testing.T, cfg PoolConfig, servers ...*testServer) *Pool {
+	t.Helper()
+	for _, s := range servers {
+		cfg.Endpoints = append(cfg.Endpoints, Endpoint{Network: "tcp", Addr: s.addr})
+	}
+	p, err := NewPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// served returns the name of the server that answered a lookup
+func served(t *testing.T, p *Pool) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := p.Get(ctx, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("got %v", result)
+	}
+	return result[0].Code
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	a, b, c := startServer(t, "A", ""), startServer(t, "B", ""), startServer(t, "C", "")
+	p := newTestPool(t, PoolConfig{Strategy: RoundRobin}, a, b, c)
+
+	var got string
+	for i := 0; i < 6; i++ {
+		got += served(t, p)
+	}
+	if got != "ABCABC" {
+		t.Errorf("got servers %s, want ABCABC", got)
+	}
+	// connections are reused
+	for _, s := range []*testServer{a, b, c} {
+		s.mu.Lock()
+		if len(s.conns) != 1 {
+			t.Errorf("%s: got %d connections, want 1", s.name, len(s.conns))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// hold delays the first lookup received by any of the servers it is
+// set on, until release is closed
+type hold struct {
+	taken   atomic.Bool
+	held    chan string // the name of the server holding the lookup
+	release chan struct{}
+}
+
+// holdOne starts a lookup held by one of the servers, and returns the
+// name of that server once it received it
+func holdOne(t *testing.T, p *Pool, servers ...*testServer) (busy string, release func()) {
+	t.Helper()
+	h := &hold{held: make(chan string, 1), release: make(chan struct{})}
+	for _, s := range servers {
+		s.hold = h
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Get(context.Background(), "USD")
+	}()
+	select {
+	case busy = <-h.held:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no server received the lookup")
+	}
+	return busy, func() {
+		close(h.release)
+		<-done
+	}
+}
+
+func TestPoolLeastOutstanding(t *testing.T) {
+	a, b := startServer(t, "A", ""), startServer(t, "B", "")
+	p := newTestPool(t, PoolConfig{Strategy: LeastOutstanding}, a, b)
+
+	// the first endpoint wins a tie, then is busy
+	busy, release := holdOne(t, p, a, b)
+	defer release()
+	if busy != "A" {
+		t.Fatalf("got server %s, want A", busy)
+	}
+	for i := 0; i < 3; i++ {
+		if got := served(t, p); got != "B" {
+			t.Errorf("lookup %d: got server %s, want B", i, got)
+		}
+	}
+}
+
+func TestPoolPowerOfTwo(t *testing.T) {
+	a, b := startServer(t, "A", ""), startServer(t, "B", "")
+	p := newTestPool(t, PoolConfig{Strategy: PowerOfTwo}, a, b)
+
+	// of two endpoints, both are picked, and the least loaded is used
+	busy, release := holdOne(t, p, a, b)
+	defer release()
+	for i := 0; i < 10; i++ {
+		if got := served(t, p); got == busy {
+			t.Errorf("lookup %d: got the busy server %s", i, got)
+		}
+	}
+}
+
+func TestPoolServerError(t *testing.T) {
+	a, b := startServer(t, "A", ""), startServer(t, "B", "")
+	p := newTestPool(t, PoolConfig{}, a, b)
+
+	// errors of the server are not retried on another endpoint
+	var srvErr *ServerError
+	if _, err := p.Get(context.Background(), "bad"); !errors.As(err, &srvErr) {
+		t.Fatalf("got %v, want a ServerError", err)
+	}
+	if a.requests.Load()+b.requests.Load() != 1 {
+		t.Errorf("got %d and %d requests, want 1", a.requests.Load(), b.requests.Load())
+	}
+	if !p.endpoints[0].isHealthy() {
+		t.Error("endpoint unhealthy after a server error")
+	}
+}
+
+func TestPoolHealth(t *testing.T) {
+	a, b := startServer(t, "A", ""), startServer(t, "B", "")
+	p := newTestPool(t, PoolConfig{HealthInterval: 20 * time.Millisecond, HealthTimeout: time.Second}, a, b)
+	waitHealthy := func(ep *endpoint, healthy bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ep.isHealthy() != healthy; {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: still healthy=%v", ep, !healthy)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// a lookup on a stopped server is retried on the other one, and
+	// the stopped server is ejected
+	if got := served(t, p); got != "A" {
+		t.Fatalf("got server %s, want A", got)
+	}
+	a.stop()
+	for i := 0; i < 4; i++ {
+		if got := served(t, p); got != "B" {
+			t.Errorf("lookup %d: got server %s, want B", i, got)
+		}
+	}
+	waitHealthy(p.endpoints[0], false)
+
+	// the health checks readmit it once it is back
+	a.listen(t, a.addr)
+	waitHealthy(p.endpoints[0], true)
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		seen[served(t, p)] = true
+	}
+	if !seen["A"] || !seen["B"] {
+		t.Errorf("got servers %v, want both", seen)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	a := startServer(t, "A", "")
+	p := newTestPool(t, PoolConfig{}, a)
+	served(t, p)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a second Close, here from the cleanup of newTestPool, is a no-op
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"learning-go/ch11/curr1"
 	"net"
 	"os"
 	"time"
 
-	curr "github.com/vladimirvivien/go-networking/tcp/curlib"
+	"github.com/vladimirvivien/go-networking/backoff"
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
 )
 
 const prompt = "currency"
@@ -23,7 +23,8 @@ const prompt = "currency"
 // This program highlights the use of IO streaming, data serialization,
 // and client-side error handling. It also shows how to configure the dialer
 // to setup settings such as timeout and KeepAlive values.  Futher,
-// the code also implements a simple connection-retry strategy when connecting.
+// the code also implements a connection-retry strategy, using exponential
+// backoff with jitter (see package backoff), when connecting.
 //
 // Usage: client [options]
 // options:
@@ -46,41 +47,37 @@ func main() {
 		KeepAlive: time.Minute * 5,
 	}
 
-	// simple dialing strategy with retry with a simple backoff.
-	// More sophisticated retry strategies
-	// follow similar pattern but may include
-	// features such as exponential backoff delay, etc
+	// dialing strategy with exponential backoff and jitter.  Only
+	// errors that may go away on their own (i.e. ECONNREFUSED while
+	// the server restarts) are retried, for at most one minute.
+	retry := backoff.New(backoff.Policy{
+		Initial:    time.Millisecond * 250,
+		Max:        time.Second * 10,
+		Jitter:     true,
+		MaxElapsed: time.Minute,
+	})
 	var (
-		conn           net.Conn
-		err            error
-		connTries      = 0
-		connMaxRetries = 3
-		connSleepRetry = time.Second * 1
+		conn net.Conn
+		err  error
 	)
-	for connTries < connMaxRetries {
+	for {
 		fmt.Println("creating connection socket to", addr)
 		conn, err = dialer.Dial(network, addr)
-		if err != nil {
-			fmt.Println("failed to create socket:", err)
-			switch nerr := err.(type) {
-			case net.Error:
-				// attempt to retry
-				if nerr.Temporary() {
-					connTries++
-					fmt.Println("trying again in:", connSleepRetry)
-					time.Sleep(connSleepRetry)
-					continue
-				}
-				// non-temporary error
-				fmt.Println("unable to recover")
-				os.Exit(1)
-
-			default: // non networking error
-				os.Exit(1)
-			}
+		if err == nil {
+			break
+		}
+		fmt.Println("failed to create socket:", err)
+		if !backoff.IsRetryableDial(err) {
+			fmt.Println("unable to recover")
+			os.Exit(1)
+		}
+		delay, ok := retry.Next()
+		if !ok {
+			fmt.Printf("giving up after %d retries\n", retry.Retries())
+			os.Exit(1)
 		}
-		// no error break
-		break
+		fmt.Println("trying again in:", delay)
+		time.Sleep(delay)
 	}
 
 	// did we get a connection
@@ -119,7 +116,7 @@ func main() {
 		}
 
 		// Display response
-		var currencies []curr1.Currency
+		var currencies []curr.Currency
 		err = json.NewDecoder(conn).Decode(&currencies)
 		if err != nil {
 			switch err := err.(type) {
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/client"
+)
+
+const prompt = "currency"
+
+// This porgram is a client implementation for the currency service
+// program.  It sends JSON-encoded requests, i.e. {"Get":"USD"}
+// and receives JSON-encoded array of currency information directly
+// over TCP or unix domain socket.
+//
+// Focus:
+// This version of the client uses a pool of connections (see package
+// client) spread across several currency servers.  The pool keeps idle
+// connections to each server, checks their health in the background,
+// and retries a lookup on another server when a connection fails.
+// Servers are selected using one of the balancing strategies:
+// round-robin, least-outstanding, or p2c (power of two choices).
+//
+// Usage: client [options]
+// options:
+//  - e comma-separated list of endpoints, default tcp://localhost:4040
+//      endpoints use the form tcp://host:port or unix:///path/to/socket
+//  - lb balancing strategy [round-robin,least-outstanding,p2c], default round-robin
+//  - idle max idle connections per endpoint, default 2
+//  - health interval between health checks, default 10s
+//
+// Once started a prompt is provided to interact with service.
+func main() {
+	// setup flags
+	var addrs, lb string
+	var idle int
+	var health time.Duration
+	flag.StringVar(&addrs, "e", "tcp://localhost:4040", "comma-separated service endpoints [tcp://addr or unix://path]")
+	flag.StringVar(&lb, "lb", "round-robin", "balancing strategy [round-robin,least-outstanding,p2c]")
+	flag.IntVar(&idle, "idle", 2, "max idle connections per endpoint")
+	flag.DurationVar(&health, "health", 10*time.Second, "health check interval")
+	flag.Parse()
+
+	var endpoints []client.Endpoint
+	for _, addr := range strings.Split(addrs, ",") {
+		ep, err := client.ParseEndpoint(strings.TrimSpace(addr))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	strategy, err := client.ParseStrategy(lb)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	pool, err := client.NewPool(client.PoolConfig{
+		Endpoints:      endpoints,
+		Strategy:       strategy,
+		MaxIdle:        idle,
+		HealthInterval: health,
+		Options:        []client.Option{client.WithTimeout(5 * time.Second)},
+	})
+	if err != nil {
+		fmt.Println("failed to create pool:", err)
+		os.Exit(1)
+	}
+	defer pool.Close()
+	fmt.Println("using currency service endpoints: ", addrs)
+
+	// start REPL
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Println("Enter search string or *")
+		fmt.Print(prompt, "> ")
+		if !scanner.Scan() {
+			return
+		}
+		param := strings.TrimSpace(scanner.Text())
+		if param == "" {
+			fmt.Println("Usage: <search string or *>")
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		currencies, err := pool.Get(ctx, param)
+		cancel()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		// print currencies
+		for i, c := range currencies {
+			fmt.Printf("%2d. %s[%s]\t%s, %s\n", i, c.Code, c.Number, c.Name, c.Country)
+		}
+	}
+}
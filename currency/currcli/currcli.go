@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/client"
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+// exit codes returned by the program
+const (
+	exitOK         = 0
+	exitError      = 1 // general failure (i.e. bad output)
+	exitUsage      = 2 // invalid command or flags
+	exitNotFound   = 3 // the query matched nothing
+	exitConnection = 4 // unable to reach the server
+	exitServer     = 5 // server rejected the request
+	exitTLS        = 6 // TLS handshake or certificate failure
+)
+
+const usage = `Usage: currcli <command> [options] [args]
+
+Commands:
+  get [query...]     look up currencies by name, code, number, or country
+                     (reads one query per line from stdin when query is - or missing)
+  list               list all currencies, one entry per currency code
+  convert <code>...  convert between alphabetic (USD) and numeric (840) codes
+  watch <query>      repeat a query and print the result whenever it changes
+  stats              print the counters of the server (tls-serv1)
+  reload             make the server reload its certificates and policy (tls-serv1)
+
+Run 'currcli <command> -h' for the options of a command.
+`
+
+// This program is a non-interactive client for the currency service
+// meant for scripts and batch use.  It uses package client to send
+// JSON-encoded requests to any of the serverjsonX or tls-servX programs
+// over TCP or unix domain socket, optionally secured with TLS or mTLS.
+//
+// Results are printed as a table (default), JSON, CSV, or YAML and the
+// exit code reports the outcome: 0 success, 2 usage, 3 nothing found,
+// 4 connection failure, 5 server error, 6 TLS failure, 1 otherwise.
+//
+// Usage: currcli <command> [options] [args]
+// options:
+//   -e service endpoint or socket path, default localhost:4040
+//   -n network protocol name [tcp,unix], default tcp
+//   -o output format [table,json,csv,yaml], default table
+//   -tls secure the connection with TLS
+//   -ca root CA certificate (implies -tls)
+//   -cert, -key client certificate and key for mTLS (implies -tls)
+//   -servername server name to verify, default host from -e
+//   -timeout time allowed for each request, default 10s
+//
+// Examples:
+//   currcli get united states
+//   currcli get -o json -n unix -e /tmp/currency.sock EUR
+//   cat queries.txt | currcli get -o csv
+//   currcli convert USD 978
+//   currcli watch -i 5s -ca ../certs/ca-cert.pem -e localhost:4443 GBP
+//   currcli stats -cert ../certs/client-cert.pem -key ../certs/client-key.pem -e localhost:4443
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// config holds the options shared by all commands
+type config struct {
+	addr, network, output     string
+	useTLS                    bool
+	ca, cert, key, serverName string
+	timeout, interval         time.Duration
+	stdin                     io.Reader
+	stdout, stderr            io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	cmd := args[0]
+	cfg := &config{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("currcli "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.addr, "e", "localhost:4040", "service endpoint [ip addr or socket path]")
+	fs.StringVar(&cfg.network, "n", "tcp", "network protocol [tcp,unix]")
+	fs.StringVar(&cfg.output, "o", "table", "output format [table,json,csv,yaml]")
+	fs.BoolVar(&cfg.useTLS, "tls", false, "secure connection with TLS")
+	fs.StringVar(&cfg.ca, "ca", "", "root CA certificate (implies -tls)")
+	fs.StringVar(&cfg.cert, "cert", "", "client certificate for mTLS (implies -tls)")
+	fs.StringVar(&cfg.key, "key", "", "client private key for mTLS (implies -tls)")
+	fs.StringVar(&cfg.serverName, "servername", "", "server name to verify")
+	fs.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "time allowed for each request")
+	if cmd == "watch" {
+		fs.DurationVar(&cfg.interval, "i", 10*time.Second, "interval between queries")
+	}
+
+	var handler command
+	switch cmd {
+	case "get":
+		handler = cmdGet
+	case "list":
+		handler = cmdList
+	case "convert":
+		handler = cmdConvert
+	case "watch":
+		handler = cmdWatch
+	case "stats", "reload":
+		handler = cmdServer(cmd)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", cmd, usage)
+		return exitUsage
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if _, ok := formatters[cfg.output]; !ok {
+		fmt.Fprintln(stderr, "unsupported output format:", cfg.output)
+		return exitUsage
+	}
+	switch cfg.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		fmt.Fprintln(stderr, "unsupported network protocol:", cfg.network)
+		return exitUsage
+	}
+
+	// the arguments are checked before connecting
+	exec, code := handler(cfg, fs.Args())
+	if exec == nil {
+		return code
+	}
+	c, err := dial(cfg)
+	if err != nil {
+		return report(cfg, err)
+	}
+	defer c.Close()
+
+	return exec(c)
+}
+
+// command checks the arguments of a command, and returns the function
+// running it once connected, or nil and the exit code of a usage error
+type command func(cfg *config, args []string) (exec func(*client.Client) int, code int)
+
+// dial connects to the service using the transport options
+func dial(cfg *config) (*client.Client, error) {
+	opts := []client.Option{client.WithTimeout(cfg.timeout)}
+	if cfg.useTLS || cfg.ca != "" || cfg.cert != "" || cfg.key != "" {
+		tlsConfig, err := client.LoadTLSConfig(cfg.ca, cfg.cert, cfg.key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = cfg.serverName
+		opts = append(opts, client.WithTLS(tlsConfig))
+	}
+	return client.Dial(context.Background(), cfg.network, cfg.addr, opts...)
+}
+
+// get sends a single query bounded by the request timeout
+func get(c *client.Client, cfg *config, query string) ([]curr.Currency, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	return c.Get(ctx, query)
+}
+
+// cmdGet looks up each query given as argument, or read from stdin
+func cmdGet(cfg *config, args []string) (func(*client.Client) int, int) {
+	var queries []string
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		scanner := bufio.NewScanner(cfg.stdin)
+		for scanner.Scan() {
+			if q := strings.TrimSpace(scanner.Text()); q != "" {
+				queries = append(queries, q)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(cfg.stderr, "failed to read queries:", err)
+			return nil, exitError
+		}
+	} else {
+		// multi-word queries, i.e. get united states
+		queries = []string{strings.Join(args, " ")}
+	}
+	if len(queries) == 0 {
+		fmt.Fprintln(cfg.stderr, "missing query")
+		return nil, exitUsage
+	}
+
+	return func(c *client.Client) int {
+		var result []curr.Currency
+		for _, q := range queries {
+			found, err := get(c, cfg, q)
+			if err != nil {
+				return report(cfg, err)
+			}
+			result = append(result, found...)
+		}
+		return output(cfg, result)
+	}, exitOK
+}
+
+// cmdList prints one entry per currency code
+func cmdList(cfg *config, args []string) (func(*client.Client) int, int) {
+	if len(args) > 0 {
+		fmt.Fprintln(cfg.stderr, "list takes no arguments")
+		return nil, exitUsage
+	}
+	return func(c *client.Client) int {
+		all, err := get(c, cfg, "*")
+		if err != nil {
+			return report(cfg, err)
+		}
+		return output(cfg, uniqueCodes(all))
+	}, exitOK
+}
+
+// cmdConvert maps alphabetic codes to numeric codes and vice versa
+func cmdConvert(cfg *config, args []string) (func(*client.Client) int, int) {
+	if len(args) == 0 {
+		fmt.Fprintln(cfg.stderr, "missing currency code")
+		return nil, exitUsage
+	}
+	return func(c *client.Client) int {
+		var result []curr.Currency
+		for _, code := range args {
+			found, err := get(c, cfg, code)
+			if err != nil {
+				return report(cfg, err)
+			}
+			// the server also matches names and countries, keep exact codes only
+			for _, cur := range uniqueCodes(found) {
+				if strings.EqualFold(cur.Code, code) || cur.Number == code {
+					result = append(result, cur)
+					break
+				}
+			}
+		}
+		return output(cfg, result)
+	}, exitOK
+}
+
+// cmdWatch repeats a query until interrupted and prints the result
+// each time it differs from the previous one
+func cmdWatch(cfg *config, args []string) (func(*client.Client) int, int) {
+	if len(args) == 0 {
+		fmt.Fprintln(cfg.stderr, "missing query")
+		return nil, exitUsage
+	}
+	if cfg.interval <= 0 {
+		fmt.Fprintln(cfg.stderr, "invalid interval:", cfg.interval)
+		return nil, exitUsage
+	}
+	query := strings.Join(args, " ")
+	return func(c *client.Client) int {
+		return watch(c, cfg, query)
+	}, exitOK
+}
+
+// watch runs the query of cmdWatch until interrupted
+func watch(c *client.Client, cfg *config, query string) int {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	var last []curr.Currency
+	first := true
+	for {
+		result, err := get(c, cfg, query)
+		if err != nil {
+			return report(cfg, err)
+		}
+		if first || !reflect.DeepEqual(result, last) {
+			if cfg.output == "table" {
+				fmt.Fprintf(cfg.stdout, "# %s\n", time.Now().Format(time.RFC3339))
+			}
+			if err := formatters[cfg.output](cfg.stdout, result); err != nil {
+				fmt.Fprintln(cfg.stderr, "failed to write output:", err)
+				return exitError
+			}
+			last, first = result, false
+		}
+
+		select {
+		case <-interrupt:
+			return exitOK
+		case <-ticker.C:
+		}
+	}
+}
+
+// cmdServer returns the handler of a server command, which prints the
+// fields of the reply of the server
+func cmdServer(name string) command {
+	return func(cfg *config, args []string) (func(*client.Client) int, int) {
+		if len(args) > 0 {
+			fmt.Fprintln(cfg.stderr, name, "takes no arguments")
+			return nil, exitUsage
+		}
+		return func(c *client.Client) int {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+			defer cancel()
+			var reply map[string]json.RawMessage
+			if err := c.Command(ctx, name, &reply); err != nil {
+				return report(cfg, err)
+			}
+			if err := writeFields(cfg.stdout, cfg.output, reply); err != nil {
+				fmt.Fprintln(cfg.stderr, "failed to write output:", err)
+				return exitError
+			}
+			return exitOK
+		}, exitOK
+	}
+}
+
+// writeFields writes the fields of a command reply, sorted by name,
+// in format
+func writeFields(w io.Writer, format string, fields map[string]json.RawMessage) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(fields)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// strings are printed unquoted, other values as JSON
+	value := func(raw json.RawMessage) string {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+		return string(raw)
+	}
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"field", "value"})
+		for _, name := range names {
+			cw.Write([]string{name, value(fields[name])})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "yaml":
+		bw := bufio.NewWriter(w)
+		for _, name := range names {
+			fmt.Fprintf(bw, "%s: %s\n", name, yamlQuote(value(fields[name])))
+		}
+		return bw.Flush()
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tVALUE")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t%s\n", name, value(fields[name]))
+	}
+	return tw.Flush()
+}
+
+// uniqueCodes keeps the first currency found for each code, sorted by code
+func uniqueCodes(currencies []curr.Currency) []curr.Currency {
+	seen := make(map[string]bool)
+	var result []curr.Currency
+	for _, cur := range currencies {
+		if cur.Code == "" || seen[cur.Code] {
+			continue
+		}
+		seen[cur.Code] = true
+		cur.Country = ""
+		result = append(result, cur)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Code < result[j].Code })
+	return result
+}
+
+// output writes the result in the configured format
+func output(cfg *config, result []curr.Currency) int {
+	if err := formatters[cfg.output](cfg.stdout, result); err != nil {
+		fmt.Fprintln(cfg.stderr, "failed to write output:", err)
+		return exitError
+	}
+	if len(result) == 0 {
+		return exitNotFound
+	}
+	return exitOK
+}
+
+// report prints err and maps it to an exit code
+func report(cfg *config, err error) int {
+	fmt.Fprintln(cfg.stderr, "error:", err)
+
+	var (
+		srvErr     *client.ServerError
+		netErr     net.Error
+		opErr      *net.OpError
+		recordErr  tls.RecordHeaderError
+		unknownCA  x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+		alertErr   tls.AlertError
+		verifyErr  *tls.CertificateVerificationError
+	)
+	switch {
+	case errors.As(err, &srvErr):
+		return exitServer
+	case errors.As(err, &unknownCA), errors.As(err, &hostErr),
+		errors.As(err, &invalidErr), errors.As(err, &recordErr),
+		errors.As(err, &alertErr), errors.As(err, &verifyErr):
+		return exitTLS
+	case errors.As(err, &opErr), errors.As(err, &netErr),
+		errors.Is(err, io.EOF), errors.Is(err, context.DeadlineExceeded):
+		return exitConnection
+	}
+	return exitError
+}
+
+// formatters write currencies in each supported output format
+var formatters = map[string]func(io.Writer, []curr.Currency) error{
+	"table": writeTable,
+	"json":  writeJSON,
+	"csv":   writeCSV,
+	"yaml":  writeYAML,
+}
+
+func writeTable(w io.Writer, currencies []curr.Currency) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tNUMBER\tNAME\tCOUNTRY")
+	for _, c := range currencies {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Code, c.Number, c.Name, c.Country)
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, currencies []curr.Currency) error {
+	if currencies == nil {
+		currencies = []curr.Currency{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(currencies)
+}
+
+func writeCSV(w io.Writer, currencies []curr.Currency) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"code", "number", "name", "country"})
+	for _, c := range currencies {
+		cw.Write([]string{c.Code, c.Number, c.Name, c.Country})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeYAML writes a YAML sequence of mappings using the JSON field
+// names.  Values are always quoted so that codes such as 036 remain
+// strings.
+func writeYAML(w io.Writer, currencies []curr.Currency) error {
+	if len(currencies) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, c := range currencies {
+		fmt.Fprintf(bw, "- currency_code: %s\n", yamlQuote(c.Code))
+		fmt.Fprintf(bw, "  currency_name: %s\n", yamlQuote(c.Name))
+		fmt.Fprintf(bw, "  currency_number: %s\n", yamlQuote(c.Number))
+		fmt.Fprintf(bw, "  currency_country: %s\n", yamlQuote(c.Country))
+	}
+	return bw.Flush()
+}
+
+// yamlQuote returns s as a YAML double-quoted scalar.  JSON string
+// escaping is a valid subset of YAML double-quoted escaping.
+func yamlQuote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+var testCurrencies = []curr.Currency{
+	{Code: "USD", Number: "840", Name: "US Dollar", Country: "UNITED STATES"},
+	{Code: "USD", Number: "840", Name: "US Dollar", Country: "ECUADOR"},
+	{Code: "EUR", Number: "978", Name: "Euro", Country: "FRANCE"},
+}
+
+// startServer serves the currency protocol on a local listener, and
+// returns its address and the number of connections accepted
+func startServer(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var conns atomic.Int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String(), &conns
+}
+
+func serve(conn net.Conn) {
+	defer conn.Close()
+	dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+	for {
+		var req curr.CurrencyRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		var rsp interface{}
+		switch {
+		case req.Cmd == "stats":
+			rsp = map[string]interface{}{"requests": 42, "started": "2024-01-02T03:04:05Z"}
+		case req.Cmd != "":
+			rsp = &curr.CurrencyError{Error: "forbidden: " + req.Cmd, Code: curr.ErrCodeForbidden}
+		default:
+			found := []curr.Currency{}
+			for _, c := range testCurrencies {
+				if req.Get == "*" || strings.EqualFold(req.Get, c.Code) || req.Get == c.Number ||
+					strings.EqualFold(req.Get, c.Country) {
+					found = append(found, c)
+				}
+			}
+			rsp = found
+		}
+		if enc.Encode(rsp) != nil {
+			return
+		}
+	}
+}
+
+// runCLI runs currcli with args and stdin, and returns its exit code and
+// output
+func runCLI(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestUsage(t *testing.T) {
+	addr, conns := startServer(t)
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		code  int
+	}{
+		{"no command", "", nil, exitUsage},
+		{"unknown command", "", []string{"delete"}, exitUsage},
+		{"help", "", []string{"help"}, exitOK},
+		{"bad flag", "", []string{"get", "-x", "USD"}, exitUsage},
+		{"bad output", "", []string{"get", "-o", "xml", "USD"}, exitUsage},
+		{"bad network", "", []string{"get", "-n", "udp", "USD"}, exitUsage},
+		{"get without query", "\n  \n", []string{"get"}, exitUsage},
+		{"list with arguments", "", []string{"list", "USD"}, exitUsage},
+		{"convert without code", "", []string{"convert"}, exitUsage},
+		{"watch without query", "", []string{"watch"}, exitUsage},
+		{"watch without interval", "", []string{"watch", "-i", "0", "USD"}, exitUsage},
+		{"stats with arguments", "", []string{"stats", "now"}, exitUsage},
+	}
+	for _, tt := range tests {
+		args := tt.args
+		if len(args) > 0 && args[0] != "help" && args[0] != "delete" {
+			// flags follow the command
+			args = append([]string{args[0], "-e", addr}, args[1:]...)
+		}
+		if code, _, stderr := runCLI(tt.stdin, args...); code != tt.code {
+			t.Errorf("%s: got exit code %d, want %d (%s)", tt.name, code, tt.code, stderr)
+		}
+	}
+	// usage errors are reported without connecting
+	if n := conns.Load(); n != 0 {
+		t.Errorf("got %d connections, want none", n)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	addr, _ := startServer(t)
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		code  int
+		want  string
+	}{
+		{"get", "", []string{"get", "-o", "csv", "united", "states"}, exitOK,
+			"code,number,name,country\nUSD,840,US Dollar,UNITED STATES\n"},
+		{"get from stdin", "EUR\nECUADOR\n", []string{"get", "-o", "csv"}, exitOK,
+			"code,number,name,country\nEUR,978,Euro,FRANCE\nUSD,840,US Dollar,ECUADOR\n"},
+		{"get nothing", "", []string{"get", "-o", "json", "XXX"}, exitNotFound, "[]\n"},
+		{"list", "", []string{"list", "-o", "csv"}, exitOK,
+			"code,number,name,country\nEUR,978,Euro,\nUSD,840,US Dollar,\n"},
+		{"convert", "", []string{"convert", "-o", "yaml", "978"}, exitOK,
+			"- currency_code: \"EUR\"\n  currency_name: \"Euro\"\n  currency_number: \"978\"\n  currency_country: \"\"\n"},
+		{"stats", "", []string{"stats", "-o", "csv"}, exitOK,
+			"field,value\nrequests,42\nstarted,2024-01-02T03:04:05Z\n"},
+		{"reload denied", "", []string{"reload"}, exitServer, ""},
+	}
+	for _, tt := range tests {
+		args := append([]string{tt.args[0], "-e", addr}, tt.args[1:]...)
+		code, stdout, stderr := runCLI(tt.stdin, args...)
+		if code != tt.code {
+			t.Errorf("%s: got exit code %d, want %d (%s)", tt.name, code, tt.code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%s: got output\n%s\nwant\n%s", tt.name, stdout, tt.want)
+		}
+	}
+}
+
+func TestConnectionFailure(t *testing.T) {
+	addr, _ := startServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	if code, _, _ := runCLI("", "get", "-e", closed, "USD"); code != exitConnection {
+		t.Errorf("closed port: got exit code %d, want %d", code, exitConnection)
+	}
+	// a plaintext server fails the TLS handshake
+	if code, _, _ := runCLI("", "get", "-tls", "-servername", "localhost", "-timeout", "2s", "-e", addr, "USD"); code == exitOK {
+		t.Error("TLS to a plaintext server succeeded")
+	}
+}
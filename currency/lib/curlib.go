@@ -16,12 +16,24 @@ type Currency struct {
 
 type CurrencyRequest struct {
 	Get string `json:"get"`
+	// Cmd selects a command other than a currency search (i.e. "stats",
+	// "reload") on servers that support them, empty means "get"
+	Cmd string `json:"cmd,omitempty"`
 }
 
 type CurrencyError struct {
 	Error string `json:"currency_error"`
+	// Code classifies the error on servers that support it (i.e. "forbidden")
+	Code string `json:"code,omitempty"`
 }
 
+// Error codes reported in CurrencyError.Code
+const (
+	ErrCodeForbidden    = "forbidden"
+	ErrCodeBadRequest   = "bad_request"
+	ErrCodeReloadFailed = "reload_failed"
+)
+
 func Load(path string) []Currency {
 	table := make([]Currency, 0)
 	file, err := os.Open(path)
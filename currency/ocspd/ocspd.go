@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/pki"
+	"github.com/vladimirvivien/go-networking/currency/revoke"
+)
+
+// This program is a tiny OCSP responder for testing certificate
+// revocation with the TLS versions of the currency service.  It answers
+// OCSP requests (RFC 6960) over HTTP for certificates signed by the CA:
+// a certificate listed in the CRL file is reported as revoked, any other
+// as good.  Responses are signed with the CA key.  The CRL is reloaded
+// when it changes, so revoking a certificate only requires a new CRL
+// (see certgen crl).
+//
+// Certificates point to the responder with certgen -ocsp, or servers
+// can be given its URL directly (tls-serv1 -ocsp).
+//
+// Usage: ocspd [options]
+// options:
+//   -e host endpoint, default "localhost:8889"
+//   -ca, -ca-key CA certificate and private key
+//   -crl certificate revocation list signed by the CA
+//   -validity validity of the responses, default 1h
+func main() {
+	var addr, ca, caKey, crlFile string
+	var validity time.Duration
+	flag.StringVar(&addr, "e", "localhost:8889", "service endpoint")
+	flag.StringVar(&ca, "ca", "../certs/ca-cert.pem", "CA certificate")
+	flag.StringVar(&caKey, "ca-key", "../certs/ca-key.pem", "CA private key")
+	flag.StringVar(&crlFile, "crl", "../certs/crl.pem", "certificate revocation list")
+	flag.DurationVar(&validity, "validity", time.Hour, "validity of the responses")
+	flag.Parse()
+
+	issuer, err := pki.LoadFiles(ca, caKey)
+	if err != nil {
+		log.Fatal("failed to load CA: ", err)
+	}
+	crl, err := revoke.LoadCRL(crlFile, issuer.Cert, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer crl.Close()
+
+	log.Printf("OCSP responder started: %s; CA %s\n", addr, issuer.Cert.Subject)
+	responder := &revoke.Responder{
+		Issuer:   issuer.Cert,
+		Key:      issuer.Key,
+		CRL:      crl,
+		Validity: validity,
+	}
+	if err := http.ListenAndServe(addr, responder); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,455 @@
+// Package pki creates the keys and X.509 certificates used by the
+// TLS versions of the currency service: a root CA, server certificates
+// with subject alternative names (DNS and IP), and client certificates
+// for mutual TLS.  It replaces the manual openssl steps listed in
+// ../certs/certs.txt and can also be used to create throwaway PKI
+// on the fly, i.e. when testing TLS servers.
+//
+// Example:
+//
+//	ca, _ := pki.NewCA(pki.Options{CommonName: "Currency CA"})
+//	srv, _ := ca.Issue(pki.Options{
+//		CommonName: "localhost",
+//		DNSNames:   []string{"localhost"},
+//		Usage:      pki.ServerUsage,
+//	})
+//	tlsCert, _ := srv.TLSCertificate()
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// KeyType selects the public key algorithm
+type KeyType string
+
+const (
+	RSA     KeyType = "rsa"
+	ECDSA   KeyType = "ecdsa"
+	Ed25519 KeyType = "ed25519"
+)
+
+// Usage selects the extended key usage of a certificate
+type Usage string
+
+const (
+	ServerUsage Usage = "server"
+	ClientUsage Usage = "client"
+	// PeerUsage allows the certificate for both server and client auth
+	PeerUsage Usage = "peer"
+)
+
+// Options describes the certificate to create.  Zero values are
+// replaced with defaults: ECDSA P-256 keys and a validity of one year
+// (ten years for a CA).
+type Options struct {
+	CommonName         string
+	Organization       []string
+	OrganizationalUnit []string
+
+	// subject alternative names
+	DNSNames    []string
+	IPAddresses []net.IP
+	URIs        []*url.URL
+	Emails      []string
+
+	KeyType KeyType
+	// KeyBits is the RSA modulus size (default 2048) or the ECDSA
+	// curve size [256,384,521] (default 256)
+	KeyBits int
+
+	NotBefore time.Time
+	Validity  time.Duration
+
+	Usage Usage
+
+	// OCSPServer and CRLDistributionPoints are embedded in
+	// certificates issued by a CA to locate revocation information
+	OCSPServer            []string
+	CRLDistributionPoints []string
+}
+
+// Cert is a certificate along with its private key
+type Cert struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// ParseKeyType returns the key type named by s
+func ParseKeyType(s string) (KeyType, error) {
+	switch kt := KeyType(s); kt {
+	case RSA, ECDSA, Ed25519:
+		return kt, nil
+	}
+	return "", fmt.Errorf("unsupported key type: %s", s)
+}
+
+// GenerateKey creates a private key of type kt.  For RSA, bits is the
+// modulus size, for ECDSA it is the curve size.  Zero selects the default.
+func GenerateKey(kt KeyType, bits int) (crypto.Signer, error) {
+	switch kt {
+	case RSA:
+		if bits == 0 {
+			bits = 2048
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA key size %d is too small", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case ECDSA, "":
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve size: %d", bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", kt)
+}
+
+// NewCA creates a self-signed root CA
+func NewCA(opts Options) (*Cert, error) {
+	if opts.Validity == 0 {
+		opts.Validity = 10 * 365 * 24 * time.Hour
+	}
+	key, err := GenerateKey(opts.KeyType, opts.KeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template(opts, key.Public())
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = nil
+
+	return create(tmpl, tmpl, key.Public(), key, key)
+}
+
+// Issue creates a new key and a certificate for it signed by ca
+func (ca *Cert) Issue(opts Options) (*Cert, error) {
+	key, err := GenerateKey(opts.KeyType, opts.KeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return ca.Sign(opts, key)
+}
+
+// Sign creates a certificate, signed by ca, for an existing key
+func (ca *Cert) Sign(opts Options, key crypto.Signer) (*Cert, error) {
+	if !ca.Cert.IsCA {
+		return nil, errors.New("issuer is not a CA")
+	}
+	tmpl, err := template(opts, key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return create(tmpl, ca.Cert, key.Public(), key, ca.Key)
+}
+
+// CreateCRL creates a PEM encoded certificate revocation list, signed by
+// ca, listing the revoked certificates.  The CRL number must increase
+// with every new list; the list should be replaced before validity ends.
+func (ca *Cert) CreateCRL(revoked []x509.RevocationListEntry, number *big.Int, validity time.Duration) ([]byte, error) {
+	if validity == 0 {
+		validity = 7 * 24 * time.Hour
+	}
+	now := time.Now()
+	tmpl := &x509.RevocationList{
+		RevokedCertificateEntries: revoked,
+		Number:                    number,
+		ThisUpdate:                now.Add(-5 * time.Minute),
+		NextUpdate:                now.Add(validity),
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.Cert, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// template fills in the fields shared by all certificates
+func template(opts Options, pub crypto.PublicKey) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	if opts.NotBefore.IsZero() {
+		// backdate a little to tolerate clock skew
+		opts.NotBefore = time.Now().Add(-5 * time.Minute)
+	}
+	if opts.Validity == 0 {
+		opts.Validity = 365 * 24 * time.Hour
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		// RSA key exchange (TLS 1.2) encrypts with the public key
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	var extUsage []x509.ExtKeyUsage
+	switch opts.Usage {
+	case ServerUsage:
+		extUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case ClientUsage:
+		extUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case PeerUsage, "":
+		extUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	default:
+		return nil, fmt.Errorf("unsupported usage: %s", opts.Usage)
+	}
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         opts.CommonName,
+			Organization:       opts.Organization,
+			OrganizationalUnit: opts.OrganizationalUnit,
+		},
+		NotBefore:             opts.NotBefore,
+		NotAfter:              opts.NotBefore.Add(opts.Validity),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           extUsage,
+		BasicConstraintsValid: true,
+		DNSNames:              opts.DNSNames,
+		IPAddresses:           opts.IPAddresses,
+		URIs:                  opts.URIs,
+		EmailAddresses:        opts.Emails,
+		OCSPServer:            opts.OCSPServer,
+		CRLDistributionPoints: opts.CRLDistributionPoints,
+	}, nil
+}
+
+func create(tmpl, parent *x509.Certificate, pub crypto.PublicKey, key, signer crypto.Signer) (*Cert, error) {
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Cert{Cert: cert, Key: key}, nil
+}
+
+// CertPEM returns the PEM encoded certificate
+func (c *Cert) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM returns the PEM encoded private key (PKCS #8)
+func (c *Cert) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// TLSCertificate returns the certificate and key for use in a tls.Config
+func (c *Cert) TLSCertificate() (tls.Certificate, error) {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(c.CertPEM(), keyPEM)
+}
+
+// Pool returns a cert pool containing only this certificate, which is
+// used to trust a CA in tls.Config RootCAs or ClientCAs
+func (c *Cert) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert)
+	return pool
+}
+
+// WriteFiles saves the certificate and private key as PEM files.
+// The key file is only readable by its owner.
+func (c *Cert) WriteFiles(certFile, keyFile string) error {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, c.CertPEM(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, keyPEM, 0600)
+}
+
+// LoadFiles reads a PEM certificate and its private key, for instance
+// to issue certificates with a CA saved by WriteFiles
+func LoadFiles(certFile, keyFile string) (*Cert, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+	return &Cert{Cert: cert, Key: signer}, nil
+}
+
+// ParseCRL decodes a PEM or DER certificate revocation list.  It does
+// not check the signature (see x509.RevocationList.CheckSignatureFrom).
+func ParseCRL(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block %s", block.Type)
+		}
+		data = block.Bytes
+	}
+	return x509.ParseRevocationList(data)
+}
+
+// ParseCertificates decodes all PEM certificates found in data
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+// ParsePrivateKey decodes a PEM private key in PKCS #8, PKCS #1 (RSA),
+// or SEC 1 (EC) format
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+		var (
+			key interface{}
+			err error
+		)
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("private key cannot sign")
+		}
+		return signer, nil
+	}
+}
+
+// VerifyKeyPair checks that key is the private key of cert by comparing
+// the public keys.  This is the equivalent of comparing the md5 digest of
+// the modulus printed by openssl for the key and the certificate.
+func VerifyKeyPair(cert *x509.Certificate, key crypto.Signer) error {
+	type equaler interface {
+		Equal(crypto.PublicKey) bool
+	}
+	pub, ok := key.Public().(equaler)
+	if !ok {
+		return errors.New("unsupported public key type")
+	}
+	if !pub.Equal(cert.PublicKey) {
+		return errors.New("private key does not match certificate public key")
+	}
+	return nil
+}
+
+// Verify checks that cert chains up to one of the roots (and optional
+// intermediates) for the given usage at time now
+func Verify(cert *x509.Certificate, roots, intermediates *x509.CertPool, usage Usage, now time.Time) error {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	}
+	switch usage {
+	case ServerUsage:
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case ClientUsage:
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+	_, err := cert.Verify(opts)
+	return err
+}
+
+// Describe returns a short, human readable summary of cert
+func Describe(cert *x509.Certificate) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "subject:   %s\n", cert.Subject)
+	fmt.Fprintf(&buf, "issuer:    %s\n", cert.Issuer)
+	fmt.Fprintf(&buf, "serial:    %x\n", cert.SerialNumber)
+	fmt.Fprintf(&buf, "validity:  %s - %s\n", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+	fmt.Fprintf(&buf, "key:       %s\n", cert.PublicKeyAlgorithm)
+	if cert.IsCA {
+		fmt.Fprintf(&buf, "ca:        true\n")
+	}
+	if len(cert.DNSNames) > 0 {
+		fmt.Fprintf(&buf, "dns:       %v\n", cert.DNSNames)
+	}
+	if len(cert.IPAddresses) > 0 {
+		fmt.Fprintf(&buf, "ip:        %v\n", cert.IPAddresses)
+	}
+	if len(cert.URIs) > 0 {
+		fmt.Fprintf(&buf, "uri:       %v\n", cert.URIs)
+	}
+	return buf.String()
+}
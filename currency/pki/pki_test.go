@@ -0,0 +1,221 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI returns a CA with a server certificate for localhost and a
+// client certificate
+func testPKI(t *testing.T, kt KeyType) (ca, srv, client *Cert) {
+	t.Helper()
+	ca, err := NewCA(Options{CommonName: "Test CA", KeyType: kt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err = ca.Issue(Options{
+		CommonName:  "localhost",
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyType:     kt,
+		Usage:       ServerUsage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = ca.Issue(Options{CommonName: "client", KeyType: kt, Usage: ClientUsage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, srv, client
+}
+
+// queuedConn queues the writes to one end of a net.Pipe, which would
+// block until the other end reads: a TLS peer sending an alert while the
+// other is still writing its flight would deadlock
+type queuedConn struct {
+	net.Conn
+	queue chan []byte
+}
+
+func newQueuedConn(conn net.Conn) *queuedConn {
+	q := &queuedConn{Conn: conn, queue: make(chan []byte, 64)}
+	go func() {
+		for b := range q.queue {
+			if _, err := conn.Write(b); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+	return q
+}
+
+func (q *queuedConn) Write(b []byte) (int, error) {
+	q.queue <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+// handshake runs a TLS handshake over net.Pipe and returns the errors
+// of the client and the server, and the connection state of the server
+func handshake(t *testing.T, clientConf, serverConf *tls.Config) (clientErr, serverErr error, cs tls.ConnectionState) {
+	t.Helper()
+	pc, ps := net.Pipe()
+	c, s := newQueuedConn(pc), newQueuedConn(ps)
+	defer close(c.queue)
+	defer close(s.queue)
+	defer c.Close()
+	defer s.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	s.SetDeadline(time.Now().Add(10 * time.Second))
+
+	done := make(chan error, 1)
+	server := tls.Server(s, serverConf)
+	go func() {
+		err := server.Handshake()
+		if err == nil {
+			_, err = server.Write([]byte("pong"))
+		}
+		if err != nil {
+			s.Close() // unblock the client
+		}
+		done <- err
+	}()
+	client := tls.Client(c, clientConf)
+	clientErr = client.Handshake()
+	if clientErr == nil {
+		// in TLS 1.3, the client is done before the server checked
+		// its certificate, the server answers once it did
+		_, clientErr = io.ReadFull(client, make([]byte, 4))
+	}
+	if clientErr != nil {
+		c.Close()
+	}
+	serverErr = <-done
+	return clientErr, serverErr, server.ConnectionState()
+}
+
+func TestMutualTLS(t *testing.T) {
+	for _, kt := range []KeyType{ECDSA, Ed25519, RSA} {
+		t.Run(string(kt), func(t *testing.T) {
+			ca, srv, client := testPKI(t, kt)
+			srvCert, err := srv.TLSCertificate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientCert, err := client.TLSCertificate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			serverConf := &tls.Config{
+				Certificates: []tls.Certificate{srvCert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    ca.Pool(),
+			}
+			clientConf := &tls.Config{
+				Certificates: []tls.Certificate{clientCert},
+				RootCAs:      ca.Pool(),
+				ServerName:   "localhost",
+			}
+
+			clientErr, serverErr, cs := handshake(t, clientConf, serverConf)
+			if clientErr != nil || serverErr != nil {
+				t.Fatalf("handshake failed: client %v, server %v", clientErr, serverErr)
+			}
+			if len(cs.PeerCertificates) == 0 || cs.PeerCertificates[0].Subject.CommonName != "client" {
+				t.Fatalf("server got peer certificates %v, want the client", cs.PeerCertificates)
+			}
+		})
+	}
+}
+
+func TestMutualTLSRejected(t *testing.T) {
+	ca, srv, client := testPKI(t, ECDSA)
+	other, _, stranger := testPKI(t, ECDSA)
+	srvCert, _ := srv.TLSCertificate()
+	clientCert, _ := client.TLSCertificate()
+	strangerCert, _ := stranger.TLSCertificate()
+	serverConf := &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.Pool(),
+	}
+
+	tests := []struct {
+		name string
+		conf *tls.Config
+	}{
+		{"no client certificate", &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"}},
+		{"client of another CA", &tls.Config{Certificates: []tls.Certificate{strangerCert}, RootCAs: ca.Pool(), ServerName: "localhost"}},
+		{"server of another CA", &tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: other.Pool(), ServerName: "localhost"}},
+		{"wrong server name", &tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: ca.Pool(), ServerName: "example.com"}},
+	}
+	for _, tt := range tests {
+		clientErr, serverErr, _ := handshake(t, tt.conf, serverConf)
+		if clientErr == nil && serverErr == nil {
+			t.Errorf("%s: handshake succeeded", tt.name)
+		}
+	}
+
+	// a server certificate does not authenticate a client
+	srvAsClient := &tls.Config{Certificates: []tls.Certificate{srvCert}, RootCAs: ca.Pool(), ServerName: "localhost"}
+	if _, serverErr, _ := handshake(t, srvAsClient, serverConf); serverErr == nil {
+		t.Error("server certificate accepted as a client certificate")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ca, srv, client := testPKI(t, ECDSA)
+	now := time.Now()
+	if err := Verify(srv.Cert, ca.Pool(), nil, ServerUsage, now); err != nil {
+		t.Errorf("server certificate: %v", err)
+	}
+	if err := Verify(client.Cert, ca.Pool(), nil, ClientUsage, now); err != nil {
+		t.Errorf("client certificate: %v", err)
+	}
+	if err := Verify(client.Cert, ca.Pool(), nil, ServerUsage, now); err == nil {
+		t.Error("client certificate valid for server auth")
+	}
+	var invalid x509.CertificateInvalidError
+	if err := Verify(srv.Cert, ca.Pool(), nil, ServerUsage, now.Add(2*365*24*time.Hour)); !errors.As(err, &invalid) || invalid.Reason != x509.Expired {
+		t.Errorf("expired certificate: got %v", err)
+	}
+	if _, err := client.Issue(Options{CommonName: "leaf"}); err == nil {
+		t.Error("a certificate that is not a CA issued a certificate")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	ca, _, _ := testPKI(t, RSA)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	if err := ca.WriteFiles(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFiles(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(ca.Cert) {
+		t.Fatal("loaded certificate differs")
+	}
+	if err := VerifyKeyPair(loaded.Cert, loaded.Key); err != nil {
+		t.Fatal(err)
+	}
+	// the loaded CA still issues certificates
+	leaf, err := loaded.Issue(Options{CommonName: "leaf", Usage: ServerUsage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(leaf.Cert, ca.Pool(), nil, ServerUsage, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyKeyPair(leaf.Cert, loaded.Key); err == nil {
+		t.Error("key of the CA matches the leaf certificate")
+	}
+}
@@ -0,0 +1,234 @@
+// Package protomux serves several protocols on one endpoint.  On TLS
+// connections the protocol is the one negotiated with ALPN (the client
+// and server agree on a protocol ID during the handshake); on plaintext
+// connections, and TLS clients that do not use ALPN, the protocol is
+// guessed by sniffing the first bytes sent by the client:
+//
+//	{...                     currency-json/1
+//	GET /path HTTP/1.1       http/1.1
+//	anything else, or silence currency-txt/1
+//
+// HTTP/2 (h2) is only negotiated with ALPN: the clients of cleartext
+// HTTP/2 with prior knowledge are not recognized, since net/http does
+// not serve it by default.
+//
+// Example:
+//
+//	mux := protomux.New()
+//	mux.Handle(protomux.JSON, protomux.HandlerFunc(serveJSON))
+//	mux.Handle(protomux.Text, protomux.HandlerFunc(serveText))
+//	ln, _ := tls.Listen("tcp", ":4443", &tls.Config{NextProtos: mux.NextProtos(), ...})
+//	for {
+//		conn, _ := ln.Accept()
+//		go mux.ServeConn(conn)
+//	}
+package protomux
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Protocol IDs advertised with ALPN
+const (
+	JSON  = "currency-json/1"
+	Text  = "currency-txt/1"
+	HTTP2 = "h2"
+	HTTP1 = "http/1.1"
+)
+
+// Handler serves a connection once its protocol is known.  The
+// handler owns the connection and must close it.
+type Handler interface {
+	ServeConn(conn net.Conn)
+}
+
+// HandlerFunc adapts a function to the Handler interface
+type HandlerFunc func(conn net.Conn)
+
+func (f HandlerFunc) ServeConn(conn net.Conn) {
+	f(conn)
+}
+
+// Mux routes connections to the handler of their protocol
+type Mux struct {
+	// SniffTimeout is how long to wait for the first bytes of a client
+	// before assuming the text protocol, whose clients wait for the
+	// server greeting, default 500ms
+	SniffTimeout time.Duration
+
+	// HandshakeTimeout bounds the TLS handshake, default 10s
+	HandshakeTimeout time.Duration
+
+	handlers map[string]Handler
+	protos   []string
+}
+
+// New returns an empty Mux
+func New() *Mux {
+	return &Mux{
+		SniffTimeout:     500 * time.Millisecond,
+		HandshakeTimeout: 10 * time.Second,
+		handlers:         make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler of a protocol.  Protocols are advertised
+// in their order of registration, the preferred one first.
+func (m *Mux) Handle(proto string, h Handler) {
+	if _, ok := m.handlers[proto]; !ok {
+		m.protos = append(m.protos, proto)
+	}
+	m.handlers[proto] = h
+}
+
+// NextProtos returns the protocol IDs to advertise, for tls.Config.NextProtos
+func (m *Mux) NextProtos() []string {
+	return append([]string(nil), m.protos...)
+}
+
+// ServeConn finds the protocol of conn and passes it to its handler.
+// TLS connections are handed over as *tls.Conn when the protocol was
+// negotiated with ALPN so that handlers, such as net/http, can inspect
+// the connection state.
+func (m *Mux) ServeConn(conn net.Conn) {
+	proto, conn, err := m.detect(conn)
+	if err != nil {
+		log.Printf("protomux: %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	h, ok := m.handlers[proto]
+	if !ok {
+		log.Printf("protomux: %s: no handler for protocol %s", conn.RemoteAddr(), proto)
+		conn.Close()
+		return
+	}
+	log.Printf("protomux: %s: protocol %s", conn.RemoteAddr(), proto)
+	h.ServeConn(conn)
+}
+
+// detect returns the protocol of conn, and the connection to use
+// to read from it (which replays the sniffed bytes)
+func (m *Mux) detect(conn net.Conn) (string, net.Conn, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(m.HandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return "", conn, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != "" {
+			return proto, conn, nil
+		}
+	}
+	return Sniff(conn, m.SniffTimeout)
+}
+
+// maxSniff is the longest request line looked at to recognize HTTP/1
+const maxSniff = 4096
+
+// Sniff guesses the protocol of a connection from the first bytes sent
+// by the client.  The returned connection replays the sniffed bytes.
+func Sniff(conn net.Conn, timeout time.Duration) (string, net.Conn, error) {
+	r := bufio.NewReaderSize(conn, maxSniff)
+	pc := &peekedConn{Conn: conn, r: r}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", pc, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	first, err := r.Peek(1)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return Text, pc, nil // client waits for the greeting
+		}
+		return "", pc, err
+	}
+	if first[0] == '{' {
+		return JSON, pc, nil
+	}
+
+	// read the first line, as much of it as arrives in time, only
+	// waiting for more bytes when those buffered lack a newline
+	for {
+		buf, _ := r.Peek(r.Buffered())
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			if bytes.Contains(buf[:i+1], []byte(" HTTP/1.")) {
+				return HTTP1, pc, nil
+			}
+			return Text, pc, nil
+		}
+		if len(buf) >= maxSniff {
+			return Text, pc, nil
+		}
+		if _, err := r.Peek(len(buf) + 1); err != nil {
+			return Text, pc, nil
+		}
+	}
+}
+
+// peekedConn reads through the buffer used to sniff the protocol
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Listener is a net.Listener, and a Handler, that hands over the
+// connections it serves to Accept.  It connects the mux to servers
+// that own their accept loop, such as http.Server.Serve.
+type Listener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+// NewListener returns a Listener reporting addr as its address
+func NewListener(addr net.Addr) *Listener {
+	return &Listener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// ServeConn queues conn for Accept
+func (l *Listener) ServeConn(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept waits for the next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops Accept, connections served afterwards are closed
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address of the listener
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,117 @@
+package protomux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSniff(t *testing.T) {
+	longLine := strings.Repeat("x", maxSniff+100) + "\n"
+	tests := []struct {
+		name  string
+		input string
+		proto string
+		bytes bool // the client sends input a byte at a time
+		close bool // the client closes after sending input
+		waits bool // the line is incomplete, Sniff times out
+	}{
+		{name: "JSON", input: `{"Get":"USD"}`, proto: JSON},
+		{name: "JSON after a byte", input: "{", proto: JSON},
+		{name: "HTTP/1.1", input: "GET /currency/usd HTTP/1.1\r\nHost: localhost\r\n\r\n", proto: HTTP1},
+		{name: "HTTP/1.0", input: "HEAD / HTTP/1.0\n", proto: HTTP1},
+		{name: "request line in pieces", input: "GET /currency/usd HTTP/1.1\r\n", proto: HTTP1, bytes: true},
+		{name: "text", input: "USD\r\n", proto: Text},
+		{name: "silence", proto: Text, waits: true},
+		{name: "partial line", input: "GET /currency", proto: Text, waits: true},
+		{name: "partial line then close", input: "GET / HTTP/1.1", proto: Text, close: true},
+		{name: "line longer than the sniff buffer", input: longLine, proto: Text},
+		// cleartext HTTP/2 is only served over TLS, with ALPN
+		{name: "HTTP/2 preface", input: "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", proto: Text},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				if tt.bytes {
+					for _, b := range []byte(tt.input) {
+						client.Write([]byte{b})
+					}
+				} else {
+					client.Write([]byte(tt.input))
+				}
+				if tt.close {
+					client.Close()
+				}
+			}()
+
+			// a complete line is routed without waiting for the timeout
+			timeout := 5 * time.Second
+			if tt.waits {
+				timeout = 100 * time.Millisecond
+			}
+			start := time.Now()
+			proto, conn, err := Sniff(server, timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proto != tt.proto {
+				t.Errorf("got %s, want %s", proto, tt.proto)
+			}
+			if !tt.waits && time.Since(start) >= timeout {
+				t.Errorf("sniffed after the timeout of %v", timeout)
+			}
+
+			// the sniffed bytes are replayed
+			if tt.input == "" {
+				return
+			}
+			got := make([]byte, len(tt.input))
+			if tt.close {
+				got, _ = io.ReadAll(conn)
+			} else if _, err := io.ReadFull(conn, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, []byte(tt.input)) {
+				t.Errorf("read %q after sniffing, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestSniffClosed(t *testing.T) {
+	client, server := net.Pipe()
+	client.Close()
+	if _, _, err := Sniff(server, time.Second); err == nil {
+		t.Error("got a protocol from a closed connection")
+	}
+}
+
+func TestListener(t *testing.T) {
+	l := NewListener(&net.TCPAddr{})
+	client, server := net.Pipe()
+	defer client.Close()
+	go l.ServeConn(server)
+	conn, err := l.Accept()
+	if err != nil || conn != server {
+		t.Fatalf("got %v, %v", conn, err)
+	}
+
+	l.Close()
+	l.Close()
+	if _, err := l.Accept(); err != net.ErrClosed {
+		t.Errorf("got %v after Close, want %v", err, net.ErrClosed)
+	}
+	// a connection served after Close is closed
+	client2, server2 := net.Pipe()
+	l.ServeConn(server2)
+	if _, err := client2.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want the connection closed", err)
+	}
+}
@@ -0,0 +1,208 @@
+// Package revoke checks whether certificates of the currency service
+// were revoked before they expire.  It supports two mechanisms:
+//
+// Certificate revocation lists (CRL), loaded from a local file signed
+// by the CA and refreshed when the file changes.  A server uses
+// CRL.VerifyConnection to reject revoked client certificates, also on
+// resumed sessions, where tls.Config.VerifyPeerCertificate is not
+// called.
+//
+// OCSP (RFC 6960), where a responder reports the status of a single
+// certificate.  A server staples the response for its own certificate
+// to the handshake (see Stapler), and clients can require a valid
+// stapled response with VerifyStaple.  Responder implements a small
+// OCSP responder that reports the status of certificates from a CRL.
+package revoke
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/pki"
+)
+
+// RevokedError is returned for a revoked certificate
+type RevokedError struct {
+	Serial    *big.Int
+	Subject   string
+	RevokedAt time.Time
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("certificate %s (serial %x) revoked on %s",
+		e.Subject, e.Serial, e.RevokedAt.Format(time.RFC3339))
+}
+
+// CRL is a certificate revocation list loaded from a file.  The file
+// is checked for changes periodically and reloaded; a list that fails
+// to load or is not signed by the issuer is ignored and the previous
+// list remains in use.
+type CRL struct {
+	file     string
+	issuer   *x509.Certificate
+	interval time.Duration
+
+	mu      sync.RWMutex
+	list    *x509.RevocationList
+	revoked map[string]time.Time // by serial number (hex)
+	modTime time.Time
+	stale   bool // past its next update, warned
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// LoadCRL loads the CRL in file (PEM or DER), verifies that it is signed
+// by issuer, and checks the file for changes every interval (default 1m)
+func LoadCRL(file string, issuer *x509.Certificate, interval time.Duration) (*CRL, error) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	c := &CRL{
+		file:     file,
+		issuer:   issuer,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go c.watch()
+	return c, nil
+}
+
+// Close stops watching the file
+func (c *CRL) Close() error {
+	close(c.done)
+	c.wg.Wait()
+	return nil
+}
+
+// Reload reads the file and, if it is a valid list signed by
+// the issuer, swaps it in
+func (c *CRL) Reload() error {
+	info, err := os.Stat(c.file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return err
+	}
+	list, err := pki.ParseCRL(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.file, err)
+	}
+	if err := list.CheckSignatureFrom(c.issuer); err != nil {
+		return fmt.Errorf("%s: %w", c.file, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.list != nil && c.list.Number != nil && list.Number != nil && list.Number.Cmp(c.list.Number) < 0 {
+		return fmt.Errorf("%s: CRL number %v older than current %v", c.file, list.Number, c.list.Number)
+	}
+	revoked := make(map[string]time.Time, len(list.RevokedCertificateEntries))
+	for _, entry := range list.RevokedCertificateEntries {
+		revoked[entry.SerialNumber.Text(16)] = entry.RevocationTime
+	}
+	c.list, c.revoked, c.modTime = list, revoked, info.ModTime()
+	log.Printf("revoke: loaded CRL %s (%d revoked, next update %s)",
+		c.file, len(revoked), list.NextUpdate.Format(time.RFC3339))
+	c.checkStale(time.Now())
+	return nil
+}
+
+// Revoked reports whether the certificate with serial number is
+// listed, and when it was revoked
+func (c *CRL) Revoked(serial *big.Int) (time.Time, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	at, ok := c.revoked[serial.Text(16)]
+	return at, ok
+}
+
+// Check returns a *RevokedError if cert is listed
+func (c *CRL) Check(cert *x509.Certificate) error {
+	if at, ok := c.Revoked(cert.SerialNumber); ok {
+		return &RevokedError{Serial: cert.SerialNumber, Subject: cert.Subject.String(), RevokedAt: at}
+	}
+	return nil
+}
+
+// VerifyPeerCertificate rejects a handshake if the peer certificate,
+// or an intermediate, is listed.  It is meant for
+// tls.Config.VerifyPeerCertificate and runs after chain verification,
+// but not on resumed sessions: servers with session tickets should use
+// VerifyConnection.
+func (c *CRL) VerifyPeerCertificate(_ [][]byte, chains [][]*x509.Certificate) error {
+	for _, chain := range chains {
+		// the root is the last certificate of the chain
+		for _, cert := range chain[:len(chain)-1] {
+			if err := c.Check(cert); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyConnection rejects a connection if the verified peer
+// certificate, or an intermediate, is listed.  It is meant for
+// tls.Config.VerifyConnection, which runs on every handshake, including
+// resumed sessions that skip VerifyPeerCertificate: a client whose
+// certificate is revoked cannot keep connecting with a session ticket.
+func (c *CRL) VerifyConnection(cs tls.ConnectionState) error {
+	return c.VerifyPeerCertificate(nil, cs.VerifiedChains)
+}
+
+// watch polls the file and reloads it when it changes
+func (c *CRL) watch() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(c.file)
+			c.mu.RLock()
+			changed := err == nil && !info.ModTime().Equal(c.modTime)
+			c.mu.RUnlock()
+			if changed {
+				if err := c.Reload(); err != nil {
+					log.Printf("revoke: CRL reload failed, keeping current list: %v", err)
+					c.mu.Lock()
+					c.modTime = info.ModTime() // retry once the file changes again
+					c.mu.Unlock()
+				}
+			}
+			c.mu.Lock()
+			c.checkStale(time.Now())
+			c.mu.Unlock()
+		}
+	}
+}
+
+// checkStale logs a warning when the list becomes past its next update,
+// the CA should have published a new one.  The warning is logged once,
+// and again only after a fresh list was loaded.  It reports whether it
+// logged.  c.mu must be held for writing.
+func (c *CRL) checkStale(now time.Time) bool {
+	stale := !c.list.NextUpdate.IsZero() && now.After(c.list.NextUpdate)
+	warn := stale && !c.stale
+	c.stale = stale
+	if warn {
+		log.Printf("revoke: WARNING CRL %s is stale since %s", c.file, c.list.NextUpdate.Format(time.RFC3339))
+	}
+	return warn
+}
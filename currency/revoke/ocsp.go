@@ -0,0 +1,399 @@
+package revoke
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// The subset of the OCSP protocol (RFC 6960) used by the currency
+// service: requests for a single certificate identified with SHA-1
+// hashes, and basic responses signed directly by the issuing CA.
+
+// Status is the revocation status of a certificate
+type Status int
+
+const (
+	Good Status = iota
+	Revoked
+	Unknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case Good:
+		return "good"
+	case Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// ResponseStatus is the status of an OCSP response, anything other
+// than Successful means the response carries no certificate status
+type ResponseStatus int
+
+const (
+	Successful       ResponseStatus = 0
+	MalformedRequest ResponseStatus = 1
+	InternalError    ResponseStatus = 2
+	TryLater         ResponseStatus = 3
+	Unauthorized     ResponseStatus = 6
+)
+
+var (
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	// signature algorithms of the keys created by package pki
+	signatureOIDs = map[x509.SignatureAlgorithm]asn1.ObjectIdentifier{
+		x509.SHA256WithRSA:   {1, 2, 840, 113549, 1, 1, 11},
+		x509.ECDSAWithSHA256: {1, 2, 840, 10045, 4, 3, 2},
+		x509.PureEd25519:     {1, 3, 101, 112},
+	}
+)
+
+type certID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type singleRequest struct {
+	Cert certID
+}
+
+type tbsRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []singleRequest
+}
+
+type ocspRequest struct {
+	TBSRequest tbsRequest
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag   `asn1:"tag:0,optional"`
+	Revoked    revokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag   `asn1:"tag:2,optional"`
+	ThisUpdate time.Time   `asn1:"generalized"`
+	NextUpdate time.Time   `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Raw         asn1.RawContent
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+}
+
+type basicResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+// Request is an OCSP request for the status of one certificate
+type Request struct {
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+// CreateRequest returns the DER encoded OCSP request for cert
+func CreateRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newCertID(issuer, cert.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{tbsRequest{RequestList: []singleRequest{{id}}}})
+}
+
+// ParseRequest decodes a DER encoded OCSP request
+func ParseRequest(der []byte) (*Request, error) {
+	var req ocspRequest
+	rest, err := asn1.Unmarshal(der, &req)
+	if err != nil {
+		return nil, fmt.Errorf("malformed OCSP request: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("malformed OCSP request: trailing data")
+	}
+	if len(req.TBSRequest.RequestList) != 1 {
+		return nil, errors.New("OCSP request must query one certificate")
+	}
+	id := req.TBSRequest.RequestList[0].Cert
+	if !id.HashAlgorithm.Algorithm.Equal(oidSHA1) {
+		return nil, errors.New("unsupported OCSP hash algorithm")
+	}
+	return &Request{
+		IssuerNameHash: id.IssuerNameHash,
+		IssuerKeyHash:  id.IssuerKeyHash,
+		SerialNumber:   id.SerialNumber,
+	}, nil
+}
+
+// IssuedBy reports whether the request is about a certificate of issuer
+func (r *Request) IssuedBy(issuer *x509.Certificate) bool {
+	id, err := newCertID(issuer, r.SerialNumber)
+	return err == nil && bytes.Equal(id.IssuerNameHash, r.IssuerNameHash) &&
+		bytes.Equal(id.IssuerKeyHash, r.IssuerKeyHash)
+}
+
+// Response is the status of one certificate reported by an OCSP responder
+type Response struct {
+	Status       Status
+	SerialNumber *big.Int
+	ProducedAt   time.Time
+	ThisUpdate   time.Time
+	NextUpdate   time.Time
+	RevokedAt    time.Time
+}
+
+// CreateResponse returns a DER encoded OCSP response reporting r,
+// signed by the issuer of the certificate
+func CreateResponse(issuer *x509.Certificate, key crypto.Signer, r Response) ([]byte, error) {
+	id, err := newCertID(issuer, r.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	single := singleResponse{
+		CertID:     id,
+		ThisUpdate: r.ThisUpdate.UTC(),
+		NextUpdate: r.NextUpdate.UTC(),
+	}
+	switch r.Status {
+	case Good:
+		single.Good = true
+	case Revoked:
+		single.Revoked = revokedInfo{RevocationTime: r.RevokedAt.UTC()}
+	default:
+		single.Unknown = true
+	}
+
+	// the responder is identified by the hash of the issuer key
+	keyHash, err := asn1.Marshal(id.IssuerKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	producedAt := r.ProducedAt
+	if producedAt.IsZero() {
+		producedAt = time.Now()
+	}
+	tbs, err := asn1.Marshal(responseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:  producedAt.UTC().Truncate(time.Second),
+		Responses:   []singleResponse{single},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sigAlg, sig, err := sign(key, tbs)
+	if err != nil {
+		return nil, err
+	}
+	basic, err := asn1.Marshal(basicResponse{
+		TBSResponseData:    responseData{Raw: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: sigAlg},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspResponse{
+		Status:   asn1.Enumerated(Successful),
+		Response: responseBytes{ResponseType: oidBasicResponse, Response: basic},
+	})
+}
+
+// ErrorResponse returns a DER encoded OCSP response without certificate
+// status, i.e. for a malformed request
+func ErrorResponse(status ResponseStatus) []byte {
+	der, _ := asn1.Marshal(ocspResponse{Status: asn1.Enumerated(status)})
+	return der
+}
+
+// ParseResponse decodes a DER encoded OCSP response and verifies that
+// it is signed by issuer
+func ParseResponse(der []byte, issuer *x509.Certificate) (*Response, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, fmt.Errorf("malformed OCSP response: %w", err)
+	}
+	if resp.Status != asn1.Enumerated(Successful) {
+		return nil, fmt.Errorf("OCSP responder error, status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidBasicResponse) {
+		return nil, errors.New("unsupported OCSP response type")
+	}
+
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, fmt.Errorf("malformed OCSP response: %w", err)
+	}
+	if err := verify(issuer, basic); err != nil {
+		return nil, err
+	}
+
+	data := basic.TBSResponseData
+	if len(data.Responses) != 1 {
+		return nil, errors.New("OCSP response must report one certificate")
+	}
+	single := data.Responses[0]
+	r := &Response{
+		SerialNumber: single.CertID.SerialNumber,
+		ProducedAt:   data.ProducedAt,
+		ThisUpdate:   single.ThisUpdate,
+		NextUpdate:   single.NextUpdate,
+		Status:       Unknown,
+	}
+	switch {
+	case bool(single.Good):
+		r.Status = Good
+	case !single.Revoked.RevocationTime.IsZero():
+		r.Status = Revoked
+		r.RevokedAt = single.Revoked.RevocationTime
+	}
+	return r, nil
+}
+
+// Check returns an error unless the response reports cert as good
+// at time now
+func (r *Response) Check(cert *x509.Certificate, now time.Time) error {
+	if r.SerialNumber == nil || r.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return errors.New("OCSP response is for another certificate")
+	}
+	if now.Before(r.ThisUpdate.Add(-5 * time.Minute)) {
+		return errors.New("OCSP response is not yet valid")
+	}
+	if !r.NextUpdate.IsZero() && now.After(r.NextUpdate) {
+		return fmt.Errorf("OCSP response expired on %s", r.NextUpdate.Format(time.RFC3339))
+	}
+	switch r.Status {
+	case Good:
+		return nil
+	case Revoked:
+		return &RevokedError{Serial: cert.SerialNumber, Subject: cert.Subject.String(), RevokedAt: r.RevokedAt}
+	}
+	return errors.New("OCSP status of certificate is unknown")
+}
+
+// Fetch queries the OCSP responder at url for the status of cert and
+// returns the raw response, suitable for stapling, and its decoded form
+func Fetch(ctx context.Context, url string, cert, issuer *x509.Certificate) ([]byte, *Response, error) {
+	reqDER, err := CreateRequest(cert, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqDER))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder %s: %s", url, httpResp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ParseResponse(raw, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, resp, nil
+}
+
+// newCertID identifies a certificate by the SHA-1 hashes of its issuer
+// name and public key, and its serial number
+func newCertID(issuer *x509.Certificate, serial *big.Int) (certID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return certID{}, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return certID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   serial,
+	}, nil
+}
+
+// sign signs the response data with the issuer key
+func sign(key crypto.Signer, tbs []byte) (asn1.ObjectIdentifier, []byte, error) {
+	var alg x509.SignatureAlgorithm
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err := key.Sign(rand.Reader, tbs, crypto.Hash(0))
+		return signatureOIDs[x509.PureEd25519], sig, err
+	case *rsa.PublicKey:
+		alg = x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		alg = x509.ECDSAWithSHA256
+	default:
+		return nil, nil, errors.New("unsupported key type for OCSP signature")
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	return signatureOIDs[alg], sig, err
+}
+
+// verify checks the response signature with the issuer public key
+func verify(issuer *x509.Certificate, basic basicResponse) error {
+	for alg, oid := range signatureOIDs {
+		if oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			err := issuer.CheckSignature(alg, basic.TBSResponseData.Raw, basic.Signature.RightAlign())
+			if err != nil {
+				return fmt.Errorf("bad OCSP response signature: %w", err)
+			}
+			return nil
+		}
+	}
+	return errors.New("unsupported OCSP signature algorithm")
+}
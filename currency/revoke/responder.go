@@ -0,0 +1,82 @@
+package revoke
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Responder is an OCSP responder (http.Handler) reporting the status of
+// certificates signed by Issuer: revoked when listed in CRL, good
+// otherwise.  Responses are signed with the issuer key and valid for
+// Validity (default 1h).  Requests are accepted as POST bodies or
+// base64 GET paths (RFC 6960 appendix A).
+type Responder struct {
+	Issuer   *x509.Certificate
+	Key      crypto.Signer
+	CRL      *CRL
+	Validity time.Duration
+}
+
+func (rs *Responder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		der, err = io.ReadAll(io.LimitReader(r.Body, 10<<10))
+	case http.MethodGet:
+		der, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		rs.reply(w, ErrorResponse(MalformedRequest))
+		return
+	}
+
+	req, err := ParseRequest(der)
+	if err != nil {
+		log.Printf("ocsp: %s: %v", r.RemoteAddr, err)
+		rs.reply(w, ErrorResponse(MalformedRequest))
+		return
+	}
+	if !req.IssuedBy(rs.Issuer) {
+		log.Printf("ocsp: %s: serial %x: unknown issuer", r.RemoteAddr, req.SerialNumber)
+		rs.reply(w, ErrorResponse(Unauthorized))
+		return
+	}
+
+	validity := rs.Validity
+	if validity <= 0 {
+		validity = time.Hour
+	}
+	now := time.Now()
+	status := Response{
+		Status:       Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now.Truncate(time.Second),
+		NextUpdate:   now.Add(validity).Truncate(time.Second),
+	}
+	if at, ok := rs.CRL.Revoked(req.SerialNumber); ok {
+		status.Status, status.RevokedAt = Revoked, at
+	}
+	resp, err := CreateResponse(rs.Issuer, rs.Key, status)
+	if err != nil {
+		log.Printf("ocsp: failed to sign response: %v", err)
+		rs.reply(w, ErrorResponse(InternalError))
+		return
+	}
+	log.Printf("ocsp: %s: serial %x: %s", r.RemoteAddr, req.SerialNumber, status.Status)
+	rs.reply(w, resp)
+}
+
+func (rs *Responder) reply(w http.ResponseWriter, der []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(der)
+}
@@ -0,0 +1,341 @@
+package revoke
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/pki"
+)
+
+// testCA returns a throwaway CA with a server certificate for 127.0.0.1
+// and two client certificates
+func testCA(t *testing.T) (ca, srv, alice, bob *pki.Cert) {
+	t.Helper()
+	ca, err := pki.NewCA(pki.Options{CommonName: "Test CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err = ca.Issue(pki.Options{
+		CommonName:  "localhost",
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		Usage:       pki.ServerUsage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice, err = ca.Issue(pki.Options{CommonName: "alice", Usage: pki.ClientUsage}); err != nil {
+		t.Fatal(err)
+	}
+	if bob, err = ca.Issue(pki.Options{CommonName: "bob", Usage: pki.ClientUsage}); err != nil {
+		t.Fatal(err)
+	}
+	return ca, srv, alice, bob
+}
+
+// writeCRL writes the CRL number of ca revoking certs to file
+func writeCRL(t *testing.T, ca *pki.Cert, file string, number int64, certs ...*pki.Cert) {
+	t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, c := range certs {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   c.Cert.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	data, err := ca.CreateCRL(entries, big.NewInt(number), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadCRL writes the CRL number 1 of ca revoking certs and loads it
+func loadCRL(t *testing.T, ca *pki.Cert, certs ...*pki.Cert) (*CRL, string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(t, ca, file, 1, certs...)
+	crl, err := LoadCRL(file, ca.Cert, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { crl.Close() })
+	return crl, file
+}
+
+// tlsCert returns the TLS certificate of c
+func tlsCert(t *testing.T, c *pki.Cert) tls.Certificate {
+	t.Helper()
+	cert, err := c.TLSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Leaf = c.Cert
+	return cert
+}
+
+// handshake connects a client to a server over the loopback, and
+// returns the state of the client once the server answered, or the
+// error of either side
+func handshake(t *testing.T, serverConf, clientConf *tls.Config) (tls.ConnectionState, error) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err = conn.(*tls.Conn).Handshake(); err == nil {
+			_, err = conn.Write([]byte("ok"))
+		}
+		done <- err
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConf)
+	if err != nil {
+		<-done
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// in TLS 1.3, the server checks the client after the client is
+	// done, its answer tells the outcome
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		<-done
+		return tls.ConnectionState{}, err
+	}
+	if err := <-done; err != nil {
+		return tls.ConnectionState{}, err
+	}
+	return conn.ConnectionState(), nil
+}
+
+func TestCRLRevoked(t *testing.T) {
+	ca, _, alice, bob := testCA(t)
+	crl, _ := loadCRL(t, ca, alice)
+
+	var revoked *RevokedError
+	if err := crl.Check(alice.Cert); !errors.As(err, &revoked) {
+		t.Fatalf("revoked certificate: got %v, want a RevokedError", err)
+	}
+	if revoked.Serial.Cmp(alice.Cert.SerialNumber) != 0 {
+		t.Errorf("got serial %x, want %x", revoked.Serial, alice.Cert.SerialNumber)
+	}
+	if err := crl.Check(bob.Cert); err != nil {
+		t.Errorf("valid certificate: %v", err)
+	}
+}
+
+func TestCRLReload(t *testing.T) {
+	ca, _, alice, bob := testCA(t)
+	crl, file := loadCRL(t, ca)
+
+	writeCRL(t, ca, file, 3, alice)
+	if err := crl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if crl.Check(alice.Cert) == nil {
+		t.Fatal("certificate of the new list not revoked")
+	}
+
+	// a replayed older list cannot unrevoke a certificate
+	writeCRL(t, ca, file, 2, bob)
+	if err := crl.Reload(); err == nil {
+		t.Fatal("CRL with a lower number loaded")
+	}
+	if crl.Check(alice.Cert) == nil || crl.Check(bob.Cert) != nil {
+		t.Error("the current list was not kept")
+	}
+
+	// nor a list signed by another CA
+	other, _, _, _ := testCA(t)
+	writeCRL(t, other, file, 4)
+	if err := crl.Reload(); err == nil {
+		t.Fatal("CRL of another CA loaded")
+	}
+	if crl.Check(alice.Cert) == nil {
+		t.Error("the current list was not kept")
+	}
+}
+
+func TestCRLStale(t *testing.T) {
+	ca, _, _, _ := testCA(t)
+	crl, file := loadCRL(t, ca)
+	checkStale := func(now time.Time) bool {
+		crl.mu.Lock()
+		defer crl.mu.Unlock()
+		return crl.checkStale(now)
+	}
+
+	// the list is valid for an hour, then warned about once
+	now := time.Now()
+	if checkStale(now) {
+		t.Error("fresh list reported stale")
+	}
+	if !checkStale(now.Add(2 * time.Hour)) {
+		t.Error("stale list not reported")
+	}
+	if checkStale(now.Add(3 * time.Hour)) {
+		t.Error("stale list reported twice")
+	}
+
+	// and again once a fresh list went stale
+	writeCRL(t, ca, file, 2)
+	if err := crl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !checkStale(time.Now().Add(2 * time.Hour)) {
+		t.Error("new stale list not reported")
+	}
+}
+
+func TestCRLHandshake(t *testing.T) {
+	ca, srv, alice, bob := testCA(t)
+	crl, file := loadCRL(t, ca)
+
+	serverConf := &tls.Config{
+		Certificates:     []tls.Certificate{tlsCert(t, srv)},
+		ClientAuth:       tls.RequireAndVerifyClientCert,
+		ClientCAs:        ca.Pool(),
+		VerifyConnection: crl.VerifyConnection,
+	}
+	client := func(c *pki.Cert) *tls.Config {
+		return &tls.Config{
+			Certificates:       []tls.Certificate{tlsCert(t, c)},
+			RootCAs:            ca.Pool(),
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		}
+	}
+	aliceConf, bobConf := client(alice), client(bob)
+
+	// both connect, and get a session ticket
+	for _, conf := range []*tls.Config{aliceConf, bobConf} {
+		if _, err := handshake(t, serverConf, conf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// once revoked, alice cannot resume her session
+	writeCRL(t, ca, file, 2, alice)
+	if err := crl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, serverConf, aliceConf); err == nil {
+		t.Fatal("revoked client resumed its session")
+	}
+	cs, err := handshake(t, serverConf, bobConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.DidResume {
+		t.Error("session not resumed, resumption not tested")
+	}
+
+	// nor start a new one
+	aliceConf.ClientSessionCache = nil
+	if _, err := handshake(t, serverConf, aliceConf); err == nil {
+		t.Fatal("revoked client connected")
+	}
+}
+
+func TestResponder(t *testing.T) {
+	ca, _, alice, bob := testCA(t)
+	crl, _ := loadCRL(t, ca, alice)
+	rs := httptest.NewServer(&Responder{Issuer: ca.Cert, Key: ca.Key, CRL: crl})
+	defer rs.Close()
+	ctx := context.Background()
+
+	_, resp, err := Fetch(ctx, rs.URL, alice.Cert, ca.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var revoked *RevokedError
+	if resp.Status != Revoked || !errors.As(resp.Check(alice.Cert, time.Now()), &revoked) {
+		t.Errorf("revoked certificate reported %s", resp.Status)
+	}
+
+	_, resp, err = Fetch(ctx, rs.URL, bob.Cert, ca.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Check(bob.Cert, time.Now()); err != nil {
+		t.Errorf("valid certificate: %v", err)
+	}
+	if err := resp.Check(alice.Cert, time.Now()); err == nil {
+		t.Error("response accepted for another certificate")
+	}
+	if err := resp.Check(bob.Cert, time.Now().Add(2*time.Hour)); err == nil {
+		t.Error("expired response accepted")
+	}
+
+	// the responder only answers for its CA
+	other, _, stranger, _ := testCA(t)
+	if _, _, err := Fetch(ctx, rs.URL, stranger.Cert, other.Cert); err == nil {
+		t.Error("responder answered for another CA")
+	}
+}
+
+func TestVerifyStaple(t *testing.T) {
+	ca, srv, _, _ := testCA(t)
+	crl, file := loadCRL(t, ca)
+	rs := httptest.NewServer(&Responder{Issuer: ca.Cert, Key: ca.Key, CRL: crl})
+	defer rs.Close()
+
+	cert := tlsCert(t, srv)
+	plain := &tls.Config{Certificates: []tls.Certificate{cert}}
+	stapler := NewStapler(ca.Cert, rs.URL)
+	stapled := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return stapler.Staple(&cert), nil
+		},
+	}
+	client := func(require bool) *tls.Config {
+		return &tls.Config{RootCAs: ca.Pool(), VerifyConnection: VerifyStaple(require)}
+	}
+
+	// without a staple, the handshake fails only when one is required
+	if _, err := handshake(t, plain, client(true)); err == nil {
+		t.Fatal("handshake without a required staple succeeded")
+	}
+	if _, err := handshake(t, plain, client(false)); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := handshake(t, stapled, client(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.OCSPResponse) == 0 {
+		t.Fatal("no staple")
+	}
+
+	// a staple reporting the server revoked always fails
+	writeCRL(t, ca, file, 2, srv)
+	if err := crl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	stapler = NewStapler(ca.Cert, rs.URL)
+	var revoked *RevokedError
+	if _, err := handshake(t, stapled, client(false)); !errors.As(err, &revoked) {
+		t.Fatalf("revoked server: got %v, want a RevokedError", err)
+	}
+}
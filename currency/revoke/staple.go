@@ -0,0 +1,172 @@
+package revoke
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Stapler obtains OCSP responses for server certificates and attaches
+// them to the certificate presented in the handshake.  Responses are
+// cached and refreshed halfway through their validity; while a refresh
+// is in progress or failing, the cached response is stapled as long
+// as it is valid.
+type Stapler struct {
+	issuer *x509.Certificate
+	url    string
+
+	mu    sync.Mutex
+	cache map[string]*staple // by serial number (hex)
+}
+
+type staple struct {
+	raw       []byte
+	resp      *Response
+	refreshAt time.Time
+	fetching  bool
+}
+
+// NewStapler returns a stapler for certificates signed by issuer.
+// The responder is url, or the OCSP server listed in the certificate
+// when url is empty.
+func NewStapler(issuer *x509.Certificate, url string) *Stapler {
+	return &Stapler{
+		issuer: issuer,
+		url:    url,
+		cache:  make(map[string]*staple),
+	}
+}
+
+// Staple returns a copy of cert with its OCSP response attached, or cert
+// itself when no valid response is available.  It can be used as
+// certmgr.Config.Staple.
+func (s *Stapler) Staple(cert *tls.Certificate) *tls.Certificate {
+	leaf := cert.Leaf
+	if leaf == nil {
+		return cert
+	}
+	key := leaf.SerialNumber.Text(16)
+	now := time.Now()
+
+	s.mu.Lock()
+	st, ok := s.cache[key]
+	if !ok {
+		st = &staple{}
+		s.cache[key] = st
+	}
+	refresh := now.After(st.refreshAt) && !st.fetching
+	if refresh {
+		st.fetching = true
+	}
+	valid := st.usable(leaf, now)
+	s.mu.Unlock()
+
+	if refresh {
+		if valid {
+			go s.fetch(leaf, st)
+		} else {
+			// nothing to staple yet, wait for the responder
+			s.fetch(leaf, st)
+			s.mu.Lock()
+			valid = st.usable(leaf, now)
+			s.mu.Unlock()
+		}
+	}
+	if !valid {
+		return cert
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stapled := *cert
+	stapled.OCSPStaple = st.raw
+	return &stapled
+}
+
+// fetch queries the responder and updates the cache entry
+func (s *Stapler) fetch(leaf *x509.Certificate, st *staple) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	raw, resp, err := s.query(ctx, leaf)
+	if err == nil {
+		// a revoked status is stapled too, so that clients learn about it
+		var revoked *RevokedError
+		if err = resp.Check(leaf, time.Now()); errors.As(err, &revoked) {
+			log.Printf("revoke: WARNING server %v", err)
+			err = nil
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.fetching = false
+	if err != nil {
+		log.Printf("revoke: OCSP staple for %s: %v", leaf.Subject, err)
+		st.refreshAt = time.Now().Add(time.Minute)
+		return
+	}
+	st.raw, st.resp = raw, resp
+	st.refreshAt = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	if resp.NextUpdate.IsZero() {
+		st.refreshAt = time.Now().Add(time.Hour)
+	}
+}
+
+// usable reports whether the cached response is about leaf and current
+func (st *staple) usable(leaf *x509.Certificate, now time.Time) bool {
+	if st.resp == nil {
+		return false
+	}
+	var revoked *RevokedError
+	err := st.resp.Check(leaf, now)
+	return err == nil || errors.As(err, &revoked)
+}
+
+func (s *Stapler) query(ctx context.Context, leaf *x509.Certificate) ([]byte, *Response, error) {
+	url := s.url
+	if url == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return nil, nil, errors.New("no OCSP responder for certificate")
+		}
+		url = leaf.OCSPServer[0]
+	}
+	return Fetch(ctx, url, leaf, s.issuer)
+}
+
+// VerifyStaple returns a function, for tls.Config.VerifyConnection, that
+// checks the OCSP response stapled by the server.  A response reporting
+// the certificate as revoked always fails the handshake; when require is
+// set, a missing or invalid response fails it too.
+func VerifyStaple(require bool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+			if require {
+				return errors.New("cannot check OCSP staple: no verified chain")
+			}
+			return nil
+		}
+		leaf, issuer := cs.VerifiedChains[0][0], cs.VerifiedChains[0][1]
+
+		if len(cs.OCSPResponse) == 0 {
+			if require {
+				return errors.New("server did not staple an OCSP response")
+			}
+			return nil
+		}
+		resp, err := ParseResponse(cs.OCSPResponse, issuer)
+		if err == nil {
+			err = resp.Check(leaf, time.Now())
+		}
+		var revoked *RevokedError
+		if err != nil && (require || errors.As(err, &revoked)) {
+			return fmt.Errorf("stapled OCSP response: %w", err)
+		}
+		return nil
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"os"
 	"time"
 
+	"github.com/vladimirvivien/go-networking/backoff"
 	curr "github.com/vladimirvivien/go-networking/tcp/curlib"
 )
 
@@ -67,11 +69,10 @@ func main() {
 
 	// connection loop
 	for {
-		conn, err := ln.Accept()
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			fmt.Println(err)
-			conn.Close()
-			continue
+			fmt.Println("failed to accept connection:", err)
+			return
 		}
 		fmt.Println("Connected to ", conn.RemoteAddr())
 		go handleConnection(conn)
@@ -9,7 +9,9 @@ import (
 	"log"
 	"net"
 	"os"
+	"time"
 
+	"github.com/vladimirvivien/go-networking/backoff"
 	curr "github.com/vladimirvivien/go-networking/currency/lib"
 )
 
@@ -75,11 +77,10 @@ func main() {
 
 	// connection loop
 	for {
-		conn, err := ln.Accept()
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			log.Println(err)
-			conn.Close()
-			continue
+			log.Println("failed to accept connection:", err)
+			return
 		}
 		log.Println("Connected to ", conn.RemoteAddr())
 		go handleConnection(conn)
@@ -10,6 +10,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/vladimirvivien/go-networking/backoff"
 	curr "github.com/vladimirvivien/go-networking/currency/lib"
 )
 
@@ -72,34 +73,12 @@ func main() {
 	log.Println("**** Global Currency Service ***")
 	log.Printf("Service started: (%s) %s\n", network, addr)
 
-	// delay to sleep when accept fails with a temporary error
-	acceptDelay := time.Millisecond * 10
-	acceptCount := 0
-
 	// connection loop
 	for {
-		conn, err := ln.Accept()
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			switch e := err.(type) {
-			case net.Error:
-				// if temporary error, attempt to connect again
-				if e.Temporary() {
-					if acceptCount > 5 {
-						log.Printf("unable to connect after %d retries: %v", err)
-						return
-					}
-					acceptDelay *= 2
-					acceptCount++
-					time.Sleep(acceptDelay)
-					continue
-				}
-			default:
-				log.Println(err)
-				conn.Close()
-				continue
-			}
-			acceptDelay = time.Millisecond * 10
-			acceptCount = 0
+			log.Println("failed to accept connection:", err)
+			return
 		}
 		log.Println("Connected to ", conn.RemoteAddr())
 		go handleConnection(conn)
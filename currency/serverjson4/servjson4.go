@@ -10,7 +10,9 @@ import (
 	"os"
 	"time"
 
+	"github.com/vladimirvivien/go-networking/backoff"
 	curr "github.com/vladimirvivien/go-networking/currency/lib"
+	"github.com/vladimirvivien/go-networking/currency/unixsock"
 )
 
 var (
@@ -41,16 +43,27 @@ var (
 // Netcat can be used for rudimentary testing.  However, use clientjsonX
 // programs functional tests.
 //
+// Over unix sockets, the server knows which local user runs the client
+// (SO_PEERCRED): it can restrict the clients to some users or groups,
+// and logs the uid and gid of the client with each request.  On Linux,
+// an endpoint starting with "@" is an abstract socket, which leaves no
+// file behind.
+//
 // Usage: server [options]
 // options:
 //   -e host endpoint, default ":4040"
 //   -n network protocol [tcp,unix], default "tcp"
+//   -peer-uid, -peer-gid comma-separated users or groups allowed to
+//                        connect over unix sockets, default any
 func main() {
 	// setup flags
 	var addr string
 	var network string
+	var peerUIDs, peerGIDs string
 	flag.StringVar(&addr, "e", ":4040", "service endpoint [ip addr or socket path]")
 	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
+	flag.StringVar(&peerUIDs, "peer-uid", "", "users allowed to connect to the unix socket")
+	flag.StringVar(&peerGIDs, "peer-gid", "", "groups allowed to connect to the unix socket")
 	flag.Parse()
 
 	// validate supported network protocols
@@ -61,8 +74,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	// users and groups allowed to connect over unix sockets
+	var sockOpts unixsock.Options
+	var err error
+	if sockOpts.AllowUIDs, err = unixsock.ParseIDs(peerUIDs, true); err != nil {
+		log.Fatal(err)
+	}
+	if sockOpts.AllowGIDs, err = unixsock.ParseIDs(peerGIDs, false); err != nil {
+		log.Fatal(err)
+	}
+
 	// create a listener for provided network and host address
-	ln, err := net.Listen(network, addr)
+	ln, err := listen(network, addr, sockOpts)
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
@@ -71,42 +94,33 @@ func main() {
 	log.Println("**** Global Currency Service ***")
 	log.Printf("Service started: (%s) %s\n", network, addr)
 
-	// delay to sleep when accept fails with a temporary error
-	acceptDelay := time.Millisecond * 10
-	acceptCount := 0
-
 	// connection loop
 	for {
-		conn, err := ln.Accept()
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			switch e := err.(type) {
-			case net.Error:
-				// if temporary error, attempt to connect again
-				if e.Temporary() {
-					if acceptCount > 5 {
-						log.Printf("unable to connect after %d retries: %v", err)
-						return
-					}
-					acceptDelay *= 2
-					acceptCount++
-					time.Sleep(acceptDelay)
-					continue
-				}
-			default:
-				log.Println(err)
-				conn.Close()
-				continue
-			}
-			acceptDelay = time.Millisecond * 10
-			acceptCount = 0
+			log.Println("failed to accept connection:", err)
+			return
 		}
-		log.Println("Connected to ", conn.RemoteAddr())
+		log.Println("Connected to ", unixsock.Peer(conn))
 		go handleConnection(conn)
 	}
 }
 
+// listen starts the listener of the service, unix sockets check the
+// credentials of their peers
+func listen(network, addr string, opts unixsock.Options) (net.Listener, error) {
+	if network == "unix" {
+		return unixsock.Listen(addr, opts)
+	}
+	return net.Listen(network, addr)
+}
+
 // handle client connection
 func handleConnection(conn net.Conn) {
+	// identity of the client for the request log: the uid and gid of
+	// the client process over unix sockets
+	peer := unixsock.Peer(conn)
+
 	defer func() {
 		if err := conn.Close(); err != nil {
 			log.Println("error closing connection:", err)
@@ -154,6 +168,7 @@ func handleConnection(conn net.Conn) {
 		}
 
 		// search currencies, result is []curr.Currency
+		log.Printf("%s: get %q", peer, req.Get)
 		result := curr.Find(currencies, req.Get)
 
 		// send result
@@ -8,7 +8,9 @@ import (
 	"log"
 	"net"
 	"strings"
+	"time"
 
+	"github.com/vladimirvivien/go-networking/backoff"
 	curr "github.com/vladimirvivien/go-networking/currency/lib0"
 )
 
@@ -67,13 +69,10 @@ func main() {
 
 	// connection-loop - handle incoming requests
 	for {
-		conn, err := ln.Accept()
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			fmt.Println(err)
-			if err := conn.Close(); err != nil {
-				log.Println("failed to close listener:", err)
-			}
-			continue
+			log.Println("failed to accept connection:", err)
+			return
 		}
 		log.Println("Connected to", conn.RemoteAddr())
 
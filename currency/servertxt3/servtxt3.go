@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
+	curr "github.com/vladimirvivien/go-networking/currency/lib0"
+)
+
+var currencies = curr.Load("../data.csv")
+
+// This program implements a simple currency lookup service
+// over TCP or Unix Data Socket. It loads ISO currency
+// information using package lib (see above) and uses a simple
+// text-based protocol to interact with the client and send
+// the data.
+//
+// Clients send currency search requests as a textual command in the form:
+//
+// GET <currency, country, or code>
+//
+// When the server receives the request, it is parsed and is then used
+// to search the list of currencies. The search result is then printed
+// line-by-line back to the client.
+//
+// Focus:
+// This version of the currency server focuses on interactive clients.
+// When a connection is accepted, the server probes for a telnet client
+// by requesting window size negotiation (NAWS).  If the client answers,
+// the session switches to character mode where the server handles
+// option negotiation (IAC), echoes input, and provides line editing,
+// command history (up/down arrows), tab completion of currency codes,
+// and paging of long results sized to the client window.  Raw clients,
+// such as netcat, get a line-based session where CRLF line endings and
+// backspace characters are handled before the command is parsed.
+//
+// Testing:
+// Netcat or telnet can be used to test this server by connecting and
+// sending command using the format described above.
+//
+// Usage: server3 [options]
+// options:
+//   -e host endpoint, default ":4040"
+//   -n network protocol [tcp,unix], default "tcp"
+//   -telnet telnet mode [auto,on,off], default "auto"
+func main() {
+	var addr, network, telnet string
+	flag.StringVar(&addr, "e", ":4040", "service endpoint [ip addr or socket path]")
+	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
+	flag.StringVar(&telnet, "telnet", "auto", "telnet mode [auto,on,off]")
+	flag.Parse()
+
+	// validate supported network protocols
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		log.Fatalln("unsupported network protocol:", network)
+	}
+
+	switch telnet {
+	case "auto", "on", "off":
+	default:
+		log.Fatalln("unsupported telnet mode:", telnet)
+	}
+
+	// create a listener for provided network and host address
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		log.Fatal("failed to create listener:", err)
+	}
+	defer ln.Close()
+	log.Println("**** Global Currency Service ***")
+	log.Printf("Service started: (%s) %s\n", network, addr)
+
+	// connection-loop - handle incoming requests
+	for {
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
+		if err != nil {
+			log.Println("failed to accept connection:", err)
+			return
+		}
+		log.Println("Connected to", conn.RemoteAddr())
+
+		go handleConnection(conn, telnet)
+	}
+}
+
+func handleConnection(conn net.Conn, telnet string) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Println("error closing connection:", err)
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+
+	// decide which kind of client is connected
+	interactive := false
+	switch telnet {
+	case "on":
+		interactive = true
+	case "auto":
+		interactive = probeTelnet(conn, reader, 500*time.Millisecond)
+	}
+
+	if interactive {
+		log.Println("telnet session with", conn.RemoteAddr())
+		handleTelnet(conn, reader)
+		return
+	}
+	handleRaw(conn, reader)
+}
+
+// handleRaw serves line-oriented clients such as netcat
+func handleRaw(conn net.Conn, reader *bufio.Reader) {
+	if _, err := fmt.Fprint(conn, "Connected...\nUsage: GET <currency, country, or code>\n"); err != nil {
+		log.Println("error writing:", err)
+		return
+	}
+
+	// command-loop
+	for {
+		cmdLine, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || cmdLine == "") {
+			if err != io.EOF {
+				log.Println("connection read error:", err)
+			}
+			return
+		}
+
+		result, ok := execute(cleanLine(cmdLine))
+		if !ok {
+			if _, err := fmt.Fprint(conn, "Invalid command\n"); err != nil {
+				log.Println("failed to write:", err)
+				return
+			}
+			continue
+		}
+		for _, line := range result {
+			if _, err := fmt.Fprintln(conn, line); err != nil {
+				log.Println("failed to write response:", err)
+				return
+			}
+		}
+	}
+}
+
+// handleTelnet serves telnet clients with line editing, history,
+// completion, and paging
+func handleTelnet(conn net.Conn, reader *bufio.Reader) {
+	term := newTerminal(conn, reader, completeCommand)
+	if err := term.negotiate(); err != nil {
+		log.Println("telnet negotiation failed:", err)
+		return
+	}
+
+	if _, err := fmt.Fprint(term, "Connected...\nUsage: GET <currency, country, or code>\n"+
+		"Keys: TAB completes codes, UP/DOWN recalls history, Ctrl-D quits\n"); err != nil {
+		log.Println("error writing:", err)
+		return
+	}
+
+	// command-loop
+	for {
+		cmdLine, err := term.readLine("currency> ")
+		if err != nil {
+			if err == errInterrupt {
+				continue
+			}
+			if err != io.EOF {
+				log.Println("connection read error:", err)
+			}
+			return
+		}
+		if strings.TrimSpace(cmdLine) == "" {
+			continue
+		}
+
+		result, ok := execute(cmdLine)
+		if !ok {
+			if _, err := fmt.Fprint(term, "Invalid command\n"); err != nil {
+				log.Println("failed to write:", err)
+				return
+			}
+			continue
+		}
+		if err := term.page(result); err != nil {
+			log.Println("failed to write response:", err)
+			return
+		}
+	}
+}
+
+// execute runs the command and returns the lines to send back to the
+// client.  It returns false if the command is not valid.
+func execute(cmdLine string) ([]string, bool) {
+	cmd, param := parseCommand(cmdLine)
+	switch strings.ToUpper(cmd) {
+	case "GET":
+		result := curr.Find(currencies, param)
+		if len(result) == 0 {
+			return []string{"Nothing found"}, true
+		}
+		lines := make([]string, 0, len(result))
+		for _, cur := range result {
+			lines = append(lines, fmt.Sprintf("%s %s %s %s", cur.Name, cur.Code, cur.Number, cur.Country))
+		}
+		return lines, true
+	default:
+		return nil, false
+	}
+}
+
+// parseCommand splits the command line into the command and its
+// parameter.  The parameter may contain spaces (i.e. GET united states).
+func parseCommand(cmdLine string) (cmd, param string) {
+	parts := strings.SplitN(strings.TrimSpace(cmdLine), " ", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	cmd = strings.TrimSpace(parts[0])
+	param = strings.TrimSpace(parts[1])
+	return
+}
+
+// cleanLine removes the line terminator (LF or CRLF) and applies any
+// backspace (BS or DEL) characters sent by raw clients.
+func cleanLine(cmdLine string) string {
+	cmdLine = strings.TrimRight(cmdLine, "\r\n")
+	line := make([]rune, 0, len(cmdLine))
+	for _, r := range cmdLine {
+		switch r {
+		case keyBackspace, keyDel:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			line = append(line, r)
+		}
+	}
+	return string(line)
+}
+
+// completeCommand returns completion candidates for the line being typed:
+// the GET command for the first word, currency codes after it.
+func completeCommand(line string) []string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(line, " ")) {
+		prefix := ""
+		if len(fields) == 1 {
+			prefix = strings.ToUpper(fields[0])
+		}
+		if strings.HasPrefix("GET", prefix) {
+			return []string{"GET"}
+		}
+		return nil
+	}
+	if strings.ToUpper(fields[0]) != "GET" {
+		return nil
+	}
+
+	prefix := ""
+	if !strings.HasSuffix(line, " ") {
+		prefix = strings.ToUpper(fields[len(fields)-1])
+	}
+	seen := make(map[string]bool)
+	var codes []string
+	for _, cur := range currencies {
+		if cur.Code != "" && !seen[cur.Code] && strings.HasPrefix(cur.Code, prefix) {
+			seen[cur.Code] = true
+			codes = append(codes, cur.Code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
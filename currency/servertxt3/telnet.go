@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Telnet commands and options (RFC 854, 857, 858, 1073)
+const (
+	cmdSE   = 240
+	cmdSB   = 250
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255
+
+	optEcho = 1
+	optSGA  = 3
+	optNAWS = 31
+
+	// maxSubnegotiation bounds the data of a subnegotiation kept by
+	// the server, the rest is discarded.  NAWS needs 5 bytes.
+	maxSubnegotiation = 64
+)
+
+// control keys handled by the line editor
+const (
+	keyCtrlA     = 0x01
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyBackspace = 0x08
+	keyTab       = 0x09
+	keyLF        = 0x0a
+	keyCR        = 0x0d
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEsc       = 0x1b
+	keyDel       = 0x7f
+)
+
+// errInterrupt is returned by readLine when the user presses Ctrl-C
+var errInterrupt = errors.New("interrupt")
+
+// probeTelnet asks the client to negotiate window size (NAWS) and
+// waits a short while for an IAC reply. Telnet clients answer the
+// request while netcat and similar raw clients do not, which lets
+// the server pick the interaction mode without any configuration.
+// Bytes that arrive during the probe remain buffered in reader.
+func probeTelnet(conn net.Conn, reader *bufio.Reader, wait time.Duration) bool {
+	if _, err := conn.Write([]byte{cmdIAC, cmdDO, optNAWS}); err != nil {
+		return false
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return false
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	b, err := reader.Peek(1)
+	if err != nil {
+		return false
+	}
+	return b[0] == cmdIAC
+}
+
+// terminal implements a telnet session on top of a connection.  It strips
+// and answers option negotiations from the input stream, escapes the output
+// stream, and provides server-side line editing with history and completion.
+type terminal struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	width   int
+	height  int
+	history []string
+	lastCR  bool
+
+	// requested tracks options the server asked for so that
+	// the client's acknowledgement is not answered again.
+	requested map[byte]bool
+
+	// complete returns the candidates for the word being typed
+	complete func(line string) []string
+}
+
+func newTerminal(conn net.Conn, reader *bufio.Reader, complete func(string) []string) *terminal {
+	return &terminal{
+		conn:      conn,
+		reader:    reader,
+		width:     80,
+		height:    24,
+		requested: map[byte]bool{optNAWS: true},
+		complete:  complete,
+	}
+}
+
+// negotiate puts the client in character-at-a-time mode with the
+// server echoing input, which is required for server-side editing.
+func (t *terminal) negotiate() error {
+	t.requested[optEcho] = true
+	t.requested[optSGA] = true
+	_, err := t.conn.Write([]byte{
+		cmdIAC, cmdWILL, optEcho,
+		cmdIAC, cmdWILL, optSGA,
+		cmdIAC, cmdDO, optSGA,
+	})
+	return err
+}
+
+// Write escapes IAC bytes and translates "\n" to "\r\n" as
+// required by the telnet network virtual terminal.
+func (t *terminal) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, b := range p {
+		switch b {
+		case cmdIAC:
+			buf.Write([]byte{cmdIAC, cmdIAC})
+		case '\n':
+			buf.WriteString("\r\n")
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	if _, err := t.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readByte returns the next data byte from the client, handling any
+// telnet command sequences found along the way.
+func (t *terminal) readByte() (byte, error) {
+	for {
+		b, err := t.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != cmdIAC {
+			return b, nil
+		}
+
+		cmd, err := t.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch cmd {
+		case cmdIAC: // escaped 255 data byte
+			return cmdIAC, nil
+		case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+			opt, err := t.reader.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if err := t.answer(cmd, opt); err != nil {
+				return 0, err
+			}
+		case cmdSB:
+			if err := t.subnegotiation(); err != nil {
+				return 0, err
+			}
+		default:
+			// NOP, GA, AYT and friends carry no data
+		}
+	}
+}
+
+// readKey returns the next key pressed by the client.  Telnet sends the
+// enter key as CR followed by LF or NUL, the trailer is swallowed so
+// that enter is read as a single CR.
+func (t *terminal) readKey() (byte, error) {
+	for {
+		b, err := t.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if t.lastCR && (b == keyLF || b == 0) {
+			t.lastCR = false
+			continue
+		}
+		t.lastCR = b == keyCR
+		return b, nil
+	}
+}
+
+// answer replies to an option negotiation. Options requested by the
+// server are acknowledged silently, everything else is refused.
+func (t *terminal) answer(cmd, opt byte) error {
+	if t.requested[opt] {
+		return nil
+	}
+	var reply byte
+	switch cmd {
+	case cmdWILL:
+		reply = cmdDONT
+	case cmdDO:
+		reply = cmdWONT
+	default:
+		return nil
+	}
+	_, err := t.conn.Write([]byte{cmdIAC, reply, opt})
+	return err
+}
+
+// subnegotiation reads an IAC SB ... IAC SE sequence.  Only the NAWS
+// option (window size) is interpreted, and only the first
+// maxSubnegotiation bytes are kept, so that a client cannot grow the
+// buffer without bound by never sending IAC SE.
+func (t *terminal) subnegotiation() error {
+	var data []byte
+	for {
+		b, err := t.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == cmdIAC {
+			next, err := t.reader.ReadByte()
+			if err != nil {
+				return err
+			}
+			if next == cmdSE {
+				break
+			}
+			b = next
+		}
+		if len(data) < maxSubnegotiation {
+			data = append(data, b)
+		}
+	}
+
+	if len(data) == 5 && data[0] == optNAWS {
+		if w := int(data[1])<<8 | int(data[2]); w > 0 {
+			t.width = w
+		}
+		if h := int(data[3])<<8 | int(data[4]); h > 0 {
+			t.height = h
+		}
+	}
+	return nil
+}
+
+// readLine displays prompt and reads a line from the client while
+// echoing and editing it locally.  Supported keys: backspace, Ctrl-U
+// (erase line), Ctrl-W (erase word), Ctrl-A/Ctrl-E (home/end),
+// left/right arrows, up/down arrows (history), and tab (completion).
+// Input is UTF-8: the bytes of a multibyte character are collected
+// before it is inserted, and the line is edited a character at a time.
+func (t *terminal) readLine(prompt string) (string, error) {
+	var (
+		line    []rune
+		pos     int
+		hist    = len(t.history)
+		pending []byte // incomplete UTF-8 sequence
+	)
+
+	redraw := func() {
+		fmt.Fprintf(t, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(t, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(s string) {
+		line = []rune(s)
+		pos = len(line)
+		redraw()
+	}
+	insert := func(r rune) {
+		line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+		pos++
+		redraw()
+	}
+
+	if _, err := io.WriteString(t, prompt); err != nil {
+		return "", err
+	}
+
+	for {
+		b, err := t.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		if b >= utf8.RuneSelf {
+			pending = append(pending, b)
+			if !utf8.FullRune(pending) {
+				continue
+			}
+			// invalid sequences are inserted as U+FFFD
+			r, _ := utf8.DecodeRune(pending)
+			pending = pending[:0]
+			insert(r)
+			continue
+		}
+		pending = pending[:0] // drop an incomplete sequence
+
+		switch b {
+		case keyCR, keyLF:
+			io.WriteString(t, "\n")
+			result := string(line)
+			if s := strings.TrimSpace(result); s != "" {
+				if n := len(t.history); n == 0 || t.history[n-1] != s {
+					t.history = append(t.history, s)
+				}
+			}
+			return result, nil
+
+		case keyCtrlC:
+			io.WriteString(t, "^C\n")
+			return "", errInterrupt
+
+		case keyCtrlD:
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+
+		case keyBackspace, keyDel:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+				redraw()
+			}
+
+		case keyCtrlU:
+			line, pos = line[:0], 0
+			redraw()
+
+		case keyCtrlW:
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+			redraw()
+
+		case keyCtrlA:
+			pos = 0
+			redraw()
+
+		case keyCtrlE:
+			pos = len(line)
+			redraw()
+
+		case keyTab:
+			if t.complete == nil || pos != len(line) {
+				continue
+			}
+			matches := t.complete(string(line))
+			switch len(matches) {
+			case 0:
+			case 1:
+				setLine(completeWord(string(line), matches[0]) + " ")
+			default:
+				// extend to the common prefix, then list the candidates
+				word := completeWord(string(line), commonPrefix(matches))
+				io.WriteString(t, "\n"+columns(matches, t.width)+"\n")
+				setLine(word)
+			}
+
+		case keyEsc:
+			seq, err := t.escape()
+			if err != nil {
+				return "", err
+			}
+			switch seq {
+			case 'A': // up
+				if hist > 0 {
+					hist--
+					setLine(t.history[hist])
+				}
+			case 'B': // down
+				if hist < len(t.history)-1 {
+					hist++
+					setLine(t.history[hist])
+				} else {
+					hist = len(t.history)
+					setLine("")
+				}
+			case 'C': // right
+				if pos < len(line) {
+					pos++
+					redraw()
+				}
+			case 'D': // left
+				if pos > 0 {
+					pos--
+					redraw()
+				}
+			}
+
+		default:
+			if b < 0x20 {
+				continue // ignore other control characters
+			}
+			insert(rune(b))
+		}
+	}
+}
+
+// escape reads the remainder of an ANSI escape sequence (ESC [ X or
+// ESC O X) and returns its final byte.
+func (t *terminal) escape() (byte, error) {
+	b, err := t.readByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != '[' && b != 'O' {
+		return 0, nil
+	}
+	for {
+		b, err = t.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if b >= 0x40 && b <= 0x7e {
+			return b, nil
+		}
+	}
+}
+
+// page writes lines to the client one screen at a time.  Between
+// screens a --More-- prompt lets the user continue with space (next
+// page), enter (next line), or stop with q.
+func (t *terminal) page(lines []string) error {
+	size := t.height - 1
+	if size < 1 {
+		size = 1
+	}
+
+	shown := 0
+	for i, l := range lines {
+		if _, err := io.WriteString(t, l+"\n"); err != nil {
+			return err
+		}
+		shown++
+		if shown < size || i == len(lines)-1 {
+			continue
+		}
+
+		io.WriteString(t, "--More--")
+		key, err := t.readKey()
+		if err != nil {
+			return err
+		}
+		io.WriteString(t, "\r\x1b[K")
+		switch key {
+		case 'q', 'Q', keyCtrlC:
+			return nil
+		case keyCR, keyLF:
+			shown = size - 1
+		default:
+			shown = 0
+		}
+	}
+	return nil
+}
+
+// completeWord replaces the last word of line with word
+func completeWord(line, word string) string {
+	i := strings.LastIndex(line, " ")
+	return line[:i+1] + word
+}
+
+// commonPrefix returns the longest prefix shared by all words
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// columns lays words out in rows that fit within width
+func columns(words []string, width int) string {
+	sort.Strings(words)
+	colWidth := 0
+	for _, w := range words {
+		if len(w) > colWidth {
+			colWidth = len(w)
+		}
+	}
+	colWidth += 2
+	perRow := width / colWidth
+	if perRow < 1 {
+		perRow = 1
+	}
+
+	var sb strings.Builder
+	for i, w := range words {
+		if i > 0 && i%perRow == 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(fmt.Sprintf("%-*s", colWidth, w))
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTerminal returns a terminal reading input, and drains what it
+// writes to the client
+func testTerminal(t *testing.T, input []byte) *terminal {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	go io.Copy(io.Discard, client)
+	return newTerminal(server, bufio.NewReader(bytes.NewReader(input)), nil)
+}
+
+func TestSubnegotiation(t *testing.T) {
+	var input []byte
+	// window size 100x40
+	input = append(input, cmdIAC, cmdSB, optNAWS, 0, 100, 0, 40, cmdIAC, cmdSE)
+	// a subnegotiation much larger than any option, with escaped IACs
+	input = append(input, cmdIAC, cmdSB, optNAWS)
+	for i := 0; i < 1<<16; i++ {
+		input = append(input, 1, cmdIAC, cmdIAC)
+	}
+	input = append(input, cmdIAC, cmdSE, 'x')
+
+	term := testTerminal(t, input)
+	b, err := term.readByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != 'x' {
+		t.Fatalf("got byte %#x, want 'x'", b)
+	}
+	if term.width != 100 || term.height != 40 {
+		t.Errorf("got window %dx%d, want 100x40", term.width, term.height)
+	}
+}
+
+func TestReadLineUTF8(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"EUR\r\n", "EUR"},
+		{"€uro\r\n", "€uro"},
+		// backspace erases a character, not a byte
+		{"ab€\x7f\x7fc\r\n", "ac"},
+		// left arrow moves over a character
+		{"日本\x1b[Dx\r\n", "日x本"},
+		// invalid bytes are replaced, 0xff would be IAC
+		{"a\xfeb\r\n", "a�b"},
+		// a sequence cut by an ASCII byte is dropped
+		{"a\xe2\x82b\r\n", "ab"},
+	}
+	for _, tt := range tests {
+		term := testTerminal(t, []byte(tt.input))
+		got, err := term.readLine("> ")
+		if err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 10; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	// enter sent as CR LF, then as CR NUL, a space, then q
+	input := []byte("\r\n\r\x00 q")
+
+	server, client := net.Pipe()
+	defer client.Close()
+	output := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(client)
+		output <- string(b)
+	}()
+	term := newTerminal(server, bufio.NewReader(bytes.NewReader(input)), nil)
+	term.height = 4
+	if err := term.page(lines); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	// lines shown between the --More-- prompts: a page, a line per
+	// enter, a page for space, none after q
+	var shown []int
+	for _, screen := range strings.Split(<-output, "--More--") {
+		shown = append(shown, strings.Count(screen, "line "))
+	}
+	if want := []int{3, 1, 1, 3, 0}; !reflect.DeepEqual(shown, want) {
+		t.Errorf("got lines %v between prompts, want %v", shown, want)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -11,6 +12,7 @@ import (
 	"net"
 
 	curr "github.com/vladimirvivien/go-networking/currency/lib"
+	"github.com/vladimirvivien/go-networking/currency/tlsdiag"
 )
 
 const prompt = "currency"
@@ -28,6 +30,19 @@ const prompt = "currency"
 // options:
 //  - e service endpoint or socket path, default localhost:4443
 //  - n network protocol name [tcp,unix], default tcp
+//  - servername name expected in the server certificate, required
+//    with -n unix since it cannot be inferred from the socket path
+//  - ca root CA used to verify the server certificate
+//  - v print the negotiated connection state and the server cert chain
+//  - sessions session ticket cache file, "" disables resumption, default
+//    go-networking/tls-sessions.json in the user cache directory
+//
+// Session tickets are saved between runs so that the next run resumes
+// the TLS session (abbreviated handshake).  The file persists in the
+// user cache directory (i.e. ~/.cache) and records which servers were
+// contacted, with tickets valid for up to 7 days: use -sessions "" on
+// shared machines.  For debugging with traffic captures, set
+// SSLKEYLOGFILE to a file that receives the session keys.
 //
 // Once started a prompt is provided to interact with service.
 func main() {
@@ -36,6 +51,11 @@ func main() {
 	flag.StringVar(&addr, "e", "localhost:4443", "service endpoint [ip addr or socket path]")
 	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
 	flag.StringVar(&ca, "ca", "../certs/ca-cert.pem", "CA certificate")
+	var verbose bool
+	var sessions, serverName string
+	flag.StringVar(&serverName, "servername", "", "server name to verify [required for unix sockets]")
+	flag.BoolVar(&verbose, "v", false, "print connection state and server certificates")
+	flag.StringVar(&sessions, "sessions", tlsdiag.DefaultSessionFile(), "session ticket cache file")
 	flag.Parse()
 
 	// Load our CA certificate
@@ -51,15 +71,38 @@ func main() {
 	tlsConf := &tls.Config{
 		InsecureSkipVerify: false,
 		RootCAs:            certPool,
+		ServerName:         serverName,
+	}
+	// resume previous sessions, and log session keys if requested
+	var cache *tlsdiag.FileSessionCache
+	if sessions != "" {
+		cache = tlsdiag.NewFileSessionCache(sessions)
+		tlsConf.ClientSessionCache = cache
+	}
+	keyLog, err := tlsdiag.KeyLogWriter()
+	if err != nil {
+		log.Fatal("failed to open key log:", err)
+	}
+	if keyLog != nil {
+		defer keyLog.Close()
+		tlsConf.KeyLogWriter = keyLog
 	}
 
 	// create a tls.Conn to connect to server
-	conn, err := tls.Dial(network, addr, tlsConf)
+	conn, timing, err := tlsdiag.Dial(context.Background(), network, addr, tlsConf)
 	if err != nil {
 		log.Fatal("failed to create socket:", err)
 	}
 	defer conn.Close()
 	fmt.Println("connected to currency service: ", addr)
+	fmt.Printf("%s (%s)\n", tlsdiag.Summary(conn.ConnectionState()), timing)
+	if cache != nil {
+		hits, misses := cache.Stats()
+		fmt.Printf("session cache: %d hit, %d miss\n", hits, misses)
+	}
+	if verbose {
+		fmt.Print(tlsdiag.Describe(conn.ConnectionState()))
+	}
 
 	var param string
 
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -9,8 +11,11 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"strings"
 
 	curr "github.com/vladimirvivien/go-networking/currency/lib"
+	"github.com/vladimirvivien/go-networking/currency/revoke"
+	"github.com/vladimirvivien/go-networking/currency/tlsdiag"
 )
 
 const prompt = "currency"
@@ -28,8 +33,28 @@ const prompt = "currency"
 // options:
 //  - e service endpoint or socket path, default localhost:4443
 //  - n network protocol name [tcp,unix], default tcp
+//  - servername name expected in the server certificate, required
+//    with -n unix since it cannot be inferred from the socket path
+//  - cert, key client certificate and private key
+//  - ca root CA used to verify the server certificate
+//  - v print the negotiated connection state and the server cert chain
+//  - sessions session ticket cache file, "" disables resumption, default
+//    go-networking/tls-sessions.json in the user cache directory
+//  - ocsp require the server to staple a valid OCSP response
 //
-// Once started a prompt is provided to interact with service.
+// Session tickets are saved between runs so that the next run resumes
+// the TLS session (abbreviated handshake).  The file persists in the
+// user cache directory (i.e. ~/.cache) and records which servers were
+// contacted, with tickets valid for up to 7 days: use -sessions "" on
+// shared machines.  For debugging with traffic captures, set
+// SSLKEYLOGFILE to a file that receives the session keys.
+//
+// A stapled OCSP response reporting the server certificate as revoked
+// always aborts the connection.
+//
+// Once started a prompt is provided to interact with service.  Input
+// starting with a colon runs a server command instead of a search, i.e.
+// :stats or :reload on tls-serv1, if the policy of the server allows it.
 func main() {
 	// setup flags
 	var addr, network, cert, key, ca string
@@ -38,6 +63,13 @@ func main() {
 	flag.StringVar(&cert, "cert", "../certs/client-cert.pem", "public cert")
 	flag.StringVar(&key, "key", "../certs/client-key.pem", "private key")
 	flag.StringVar(&ca, "ca", "../certs/ca-cert.pem", "root CA certificate")
+	var requireOCSP bool
+	flag.BoolVar(&requireOCSP, "ocsp", false, "require a valid stapled OCSP response")
+	var verbose bool
+	var sessions, serverName string
+	flag.StringVar(&serverName, "servername", "", "server name to verify [required for unix sockets]")
+	flag.BoolVar(&verbose, "v", false, "print connection state and server certificates")
+	flag.StringVar(&sessions, "sessions", tlsdiag.DefaultSessionFile(), "session ticket cache file")
 	flag.Parse()
 
 	cer, err := tls.LoadX509KeyPair(cert, key)
@@ -58,29 +90,57 @@ func main() {
 	tlsConf := &tls.Config{
 		RootCAs:      certPool,
 		Certificates: []tls.Certificate{cer},
+		ServerName:   serverName,
+		// check the revocation status stapled by the server
+		VerifyConnection: revoke.VerifyStaple(requireOCSP),
+	}
+	// resume previous sessions, and log session keys if requested
+	var cache *tlsdiag.FileSessionCache
+	if sessions != "" {
+		cache = tlsdiag.NewFileSessionCache(sessions)
+		tlsConf.ClientSessionCache = cache
+	}
+	keyLog, err := tlsdiag.KeyLogWriter()
+	if err != nil {
+		log.Fatal("failed to open key log:", err)
+	}
+	if keyLog != nil {
+		defer keyLog.Close()
+		tlsConf.KeyLogWriter = keyLog
 	}
 
 	// create a tls.Conn to connect to server
-	conn, err := tls.Dial(network, addr, tlsConf)
+	conn, timing, err := tlsdiag.Dial(context.Background(), network, addr, tlsConf)
 	if err != nil {
 		log.Fatal("failed to create socket:", err)
 	}
 	defer conn.Close()
 	fmt.Println("connected to currency service: ", addr)
+	fmt.Printf("%s (%s)\n", tlsdiag.Summary(conn.ConnectionState()), timing)
+	if cache != nil {
+		hits, misses := cache.Stats()
+		fmt.Printf("session cache: %d hit, %d miss\n", hits, misses)
+	}
+	if verbose {
+		fmt.Print(tlsdiag.Describe(conn.ConnectionState()))
+	}
 
 	var param string
 
 	// start REPL
 	for {
-		fmt.Println("Enter search string or *")
+		fmt.Println("Enter search string or *, or a server command (:stats, :reload)")
 		fmt.Print(prompt, "> ")
 		_, err = fmt.Scanf("%s", &param)
 		if err != nil {
-			fmt.Println("Usage: <search string or *>")
+			fmt.Println("Usage: <search string or *> | :<command>")
 			continue
 		}
 
 		req := curr.CurrencyRequest{Get: param}
+		if strings.HasPrefix(param, ":") {
+			req = curr.CurrencyRequest{Cmd: param[1:]}
+		}
 
 		// Send request:
 		// use json encoder to encode value of type curr.CurrencyRequest
@@ -96,9 +156,10 @@ func main() {
 			}
 		}
 
-		// Receive response
-		var currencies []curr.Currency
-		err = json.NewDecoder(conn).Decode(&currencies)
+		// Receive response: the currencies found, the reply of a
+		// command, or a curr.CurrencyError
+		var raw json.RawMessage
+		err = json.NewDecoder(conn).Decode(&raw)
 		if err != nil {
 			switch err := err.(type) {
 			case net.Error:
@@ -110,6 +171,23 @@ func main() {
 			}
 		}
 
+		var srvErr curr.CurrencyError
+		if json.Unmarshal(raw, &srvErr) == nil && srvErr.Error != "" {
+			fmt.Printf("server error: %s [%s]\n", srvErr.Error, srvErr.Code)
+			continue
+		}
+		if req.Cmd != "" {
+			var reply bytes.Buffer
+			json.Indent(&reply, raw, "", "  ")
+			fmt.Println(reply.String())
+			continue
+		}
+		var currencies []curr.Currency
+		if err := json.Unmarshal(raw, &currencies); err != nil {
+			fmt.Println("failed to decode response:", err)
+			continue
+		}
+
 		// print currencies
 		for i, c := range currencies {
 			fmt.Printf("%2d. %s[%s]\t%s, %s\n", i, c.Code, c.Number, c.Name, c.Country)
@@ -11,7 +11,12 @@ import (
 	"os"
 	"time"
 
+	"github.com/vladimirvivien/go-networking/backoff"
+	"github.com/vladimirvivien/go-networking/currency/certmgr"
 	curr "github.com/vladimirvivien/go-networking/currency/lib"
+	"github.com/vladimirvivien/go-networking/currency/tlsdiag"
+	"github.com/vladimirvivien/go-networking/currency/unixsock"
+	"github.com/vladimirvivien/go-networking/fdpass"
 )
 
 var (
@@ -46,13 +51,35 @@ var (
 // options:
 //   -e host endpoint, default ":4443"
 //   -n network protocol [tcp,unix], default "tcp"
+//   -cert, -key server certificate and private key
+//   -reload interval between checks for certificate changes, default 10s
+//   -tickets interval between session ticket key rotations, default 12h
+//   -sock-mode file mode of the unix socket, default 0660
+//   -sock-owner owner of the unix socket as user[:group]
+//   -peer-uid, -peer-gid comma-separated users or groups allowed to
+//                        connect to the unix socket, default any
+//
+// Each connection is logged with the negotiated TLS version, cipher
+// suite, and whether the client resumed a previous session.  Session
+// ticket keys are rotated, the last 3 keys remain valid for resumption.
+// For debugging with traffic captures, set SSLKEYLOGFILE to a file that
+// receives the session keys.
 func main() {
 	// setup flags
 	var addr, network, cert, key string
+	var reload time.Duration
 	flag.StringVar(&addr, "e", ":4443", "service endpoint [ip addr or socket path]")
 	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
 	flag.StringVar(&cert, "cert", "../certs/localhost-cert.pem", "public cert")
 	flag.StringVar(&key, "key", "../certs/localhost-key.pem", "private key")
+	flag.DurationVar(&reload, "reload", 10*time.Second, "interval between checks for certificate changes")
+	var tickets time.Duration
+	flag.DurationVar(&tickets, "tickets", 12*time.Hour, "interval between session ticket key rotations")
+	var sockMode, sockOwner, peerUIDs, peerGIDs string
+	flag.StringVar(&sockMode, "sock-mode", "0660", "unix socket file mode")
+	flag.StringVar(&sockOwner, "sock-owner", "", "unix socket owner [user[:group]]")
+	flag.StringVar(&peerUIDs, "peer-uid", "", "users allowed to connect to the unix socket")
+	flag.StringVar(&peerGIDs, "peer-gid", "", "groups allowed to connect to the unix socket")
 	flag.Parse()
 
 	// validate supported network protocols
@@ -63,61 +90,80 @@ func main() {
 		os.Exit(1)
 	}
 	// load server cert by providing the private key that generated it.
-	cer, err := tls.LoadX509KeyPair(cert, key)
+	// The manager watches the files and reloads them when they change
+	// so that the certificate can be renewed without a restart.
+	certs, err := certmgr.New(certmgr.Config{
+		CertFile: cert,
+		KeyFile:  key,
+		Interval: reload,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer certs.Close()
 
 	// configure tls with certs and other settings
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cer},
+	baseConfig := &tls.Config{}
+	stopRotation, err := tlsdiag.RotateTicketKeys(baseConfig, tickets, 3)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopRotation()
+	keyLog, err := tlsdiag.KeyLogWriter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keyLog != nil {
+		defer keyLog.Close()
+		baseConfig.KeyLogWriter = keyLog
+	}
+	tlsConfig := certs.ServerConfig(baseConfig)
+
+	// settings of the unix socket file and of its allowed peers
+	var sockOpts unixsock.Options
+	if sockOpts.Mode, err = unixsock.ParseMode(sockMode); err != nil {
+		log.Fatal(err)
+	}
+	sockOpts.Owner, sockOpts.Group = unixsock.ParseOwner(sockOwner)
+	if sockOpts.AllowUIDs, err = unixsock.ParseIDs(peerUIDs, true); err != nil {
+		log.Fatal(err)
+	}
+	if sockOpts.AllowGIDs, err = unixsock.ParseIDs(peerGIDs, false); err != nil {
+		log.Fatal(err)
 	}
 
-	// instead of net.Listen, we now use tls.Listen to start
-	// a listener on the secure port
-	ln, err := tls.Listen(network, addr, tlsConfig)
+	// TLS runs on top of the TCP or unix listener
+	rawLn, err := listen(network, addr, sockOpts)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
+	ln := tls.NewListener(rawLn, tlsConfig)
 	defer ln.Close()
 	log.Println("**** Global Currency Service (secure) ***")
 	log.Printf("Service started: (%s) %s; server cert %s\n", network, addr, cert)
 
-	// delay to sleep when accept fails with a temporary error
-	acceptDelay := time.Millisecond * 10
-	acceptCount := 0
-
 	// connection loop
 	for {
-		conn, err := ln.Accept()
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			switch e := err.(type) {
-			case net.Error:
-				// if temporary error, attempt to connect again
-				if e.Temporary() {
-					if acceptCount > 5 {
-						log.Fatalf("unable to connect after %d retries: %v", acceptCount, err)
-					}
-					acceptDelay *= 2
-					acceptCount++
-					time.Sleep(acceptDelay)
-					continue
-				}
-			default:
-				log.Println(err)
-				if err := conn.Close(); err != nil {
-					log.Fatal(err)
-				}
-				continue
-			}
-			acceptDelay = time.Millisecond * 10
-			acceptCount = 0
+			log.Println("failed to accept connection:", err)
+			return
 		}
-		log.Println("securely connected to remote client ", conn.RemoteAddr())
 		go handleConnection(conn)
 	}
 }
 
+// listen starts the listener of the service.  Unix sockets get the
+// permissions and peer checks of opts, and a stale socket file left by
+// a previous run is removed.  TCP sockets can be bound by a broker (see
+// unix/broker) to serve on port 443 without privileges.
+func listen(network, addr string, opts unixsock.Options) (net.Listener, error) {
+	if network == "unix" {
+		return unixsock.Listen(addr, opts)
+	}
+	return fdpass.Listen(network, addr)
+}
+
 // handle client connection
 func handleConnection(conn net.Conn) {
 	defer func() {
@@ -126,6 +172,19 @@ func handleConnection(conn net.Conn) {
 		}
 	}()
 
+	// complete the handshake to report what was negotiated
+	tlsConn := conn.(*tls.Conn)
+	if err := tlsConn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		log.Println("failed to set deadline:", err)
+		return
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		log.Println("handshake failed:", err)
+		return
+	}
+	log.Printf("securely connected to remote client %s (%s)", conn.RemoteAddr(),
+		tlsdiag.Summary(tlsConn.ConnectionState()))
+
 	// set initial deadline prior to entering
 	// the client request/response loop to 45 seconds.
 	// This means that the client has 45 seconds to send
@@ -2,24 +2,45 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/vladimirvivien/go-networking/backoff"
+	"github.com/vladimirvivien/go-networking/currency/authz"
+	"github.com/vladimirvivien/go-networking/currency/certmgr"
 	curr "github.com/vladimirvivien/go-networking/currency/lib"
+	"github.com/vladimirvivien/go-networking/currency/pki"
+	"github.com/vladimirvivien/go-networking/currency/revoke"
+	"github.com/vladimirvivien/go-networking/currency/tlsdiag"
+	"github.com/vladimirvivien/go-networking/currency/unixsock"
+	"github.com/vladimirvivien/go-networking/fdpass"
 )
 
 var (
 	currencies = curr.Load("../data.csv")
+
+	certs      *certmgr.Manager
+	policy     atomic.Value // *authz.Policy
+	policyFile string
+	stats      serverStats
 )
 
+// serverStats are the counters returned by the stats command
+type serverStats struct {
+	Started     time.Time `json:"started"`
+	Connections int64     `json:"connections"`
+	Requests    int64     `json:"requests"`
+	Denied      int64     `json:"denied"`
+}
+
 // This program implements a simple currency lookup service
 // over TCP or Unix Data Socket. It loads ISO currency
 // information using package curr (see above) and uses a simple
@@ -40,6 +61,23 @@ var (
 // values.  This ensures that a client cannot hold a connection hostage by
 // taking a long time to send or receive data.
 //
+// Requests are authorized using the identity of the verified client
+// certificate (subject CN and OU, SAN URI and DNS names) mapped to roles
+// by a policy file (see authz.json).  Roles decide which commands a
+// client can run:
+//   {"get":"USD"}     search currencies (command "get")
+//   {"cmd":"stats"}   return the server counters
+//   {"cmd":"reload"}  reload the certificates and the policy file
+// A denied request is answered with
+// {"currency_error":"...","code":"forbidden"}, a failed reload with code
+// "reload_failed".  Commands can be sent with currcli (stats, reload),
+// tls-client1 (:stats, :reload), or client.Command.
+//
+// Revocation: with -crl, client certificates listed in the CRL file
+// (signed by the CA, see certgen crl) are rejected; the file is reloaded
+// when it changes.  With -ocsp, the server staples the OCSP response for
+// its own certificate to every handshake (see ../ocspd).
+//
 // Testing:
 // Netcat can be used for rudimentary testing.  However, use clientjsonX
 // programs functional tests.
@@ -48,14 +86,44 @@ var (
 // options:
 //   -e host endpoint, default ":4443"
 //   -n network protocol [tcp,unix], default "tcp"
+//   -cert, -key server certificate and private key
+//   -ca root CA used to verify client certificates
+//   -reload interval between checks for certificate changes, default 10s
+//   -tickets interval between session ticket key rotations, default 12h
+//   -sock-mode file mode of the unix socket, default 0660
+//   -sock-owner owner of the unix socket as user[:group]
+//   -peer-uid, -peer-gid comma-separated users or groups allowed to
+//                        connect to the unix socket, default any
+//   -authz authorization policy file, default "authz.json"
+//   -crl certificate revocation list used to reject client certificates
+//   -ocsp OCSP responder URL used to staple the server certificate status,
+//         "cert" uses the responder listed in the certificate
+//
+// Each connection is logged with the negotiated TLS version, cipher
+// suite, and whether the client resumed a previous session.  Session
+// ticket keys are rotated, the last 3 keys remain valid for resumption.
+// For debugging with traffic captures, set SSLKEYLOGFILE to a file that
+// receives the session keys.
 func main() {
 	// setup flags
-	var addr, network, cert, key, ca string
+	var addr, network, cert, key, ca, crlFile, ocspURL string
+	var reload time.Duration
 	flag.StringVar(&addr, "e", ":4443", "service endpoint [ip addr or socket path]")
 	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
 	flag.StringVar(&cert, "cert", "../certs/localhost-cert.pem", "public cert")
 	flag.StringVar(&key, "key", "../certs/localhost-key.pem", "private key")
 	flag.StringVar(&ca, "ca", "../certs/ca-cert.pem", "root CA certificate")
+	flag.DurationVar(&reload, "reload", 10*time.Second, "interval between checks for certificate changes")
+	var tickets time.Duration
+	flag.DurationVar(&tickets, "tickets", 12*time.Hour, "interval between session ticket key rotations")
+	var sockMode, sockOwner, peerUIDs, peerGIDs string
+	flag.StringVar(&sockMode, "sock-mode", "0660", "unix socket file mode")
+	flag.StringVar(&sockOwner, "sock-owner", "", "unix socket owner [user[:group]]")
+	flag.StringVar(&peerUIDs, "peer-uid", "", "users allowed to connect to the unix socket")
+	flag.StringVar(&peerGIDs, "peer-gid", "", "groups allowed to connect to the unix socket")
+	flag.StringVar(&policyFile, "authz", "authz.json", "authorization policy file")
+	flag.StringVar(&crlFile, "crl", "", "certificate revocation list (PEM or DER)")
+	flag.StringVar(&ocspURL, "ocsp", "", "OCSP responder URL for stapling, or \"cert\"")
 	flag.Parse()
 
 	// validate supported network protocols
@@ -66,72 +134,120 @@ func main() {
 		os.Exit(1)
 	}
 
-	// load server cert by providing the private key that generated it.
-	cer, err := tls.LoadX509KeyPair(cert, key)
+	// the CA signs the certificates, the CRL, and the OCSP responses
+	caCerts, err := os.ReadFile(ca)
 	if err != nil {
 		log.Fatal(err)
 	}
+	issuers, err := pki.ParseCertificates(caCerts)
+	if err != nil {
+		log.Fatal(ca, ": ", err)
+	}
 
-	// load root CA
-	caCert, err := ioutil.ReadFile(ca)
+	// load server cert, its private key, and the root CA used to verify
+	// client certs.  The manager watches the files and reloads them when
+	// they change so that certificates can be renewed without a restart.
+	mgrConfig := certmgr.Config{
+		CertFile: cert,
+		KeyFile:  key,
+		CAFile:   ca,
+		Interval: reload,
+	}
+	if ocspURL != "" {
+		if ocspURL == "cert" {
+			ocspURL = ""
+		}
+		mgrConfig.Staple = revoke.NewStapler(issuers[0], ocspURL).Staple
+	}
+	certs, err = certmgr.New(mgrConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer certs.Close()
+
+	// load the policy mapping client identities to roles
+	p, err := authz.LoadPolicy(policyFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-	caPool := x509.NewCertPool()
-	caPool.AppendCertsFromPEM(caCert)
+	policy.Store(p)
+	stats.Started = time.Now()
 
 	// configure tls with certs and other settings
-	tlsConfig := &tls.Config{
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    caPool,
-		Certificates: []tls.Certificate{cer},
+	baseConfig := &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+
+	// reject revoked client certs after the chain is verified, on
+	// resumed sessions too
+	if crlFile != "" {
+		crl, err := revoke.LoadCRL(crlFile, issuers[0], reload)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer crl.Close()
+		baseConfig.VerifyConnection = crl.VerifyConnection
+	}
+	stopRotation, err := tlsdiag.RotateTicketKeys(baseConfig, tickets, 3)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopRotation()
+	keyLog, err := tlsdiag.KeyLogWriter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keyLog != nil {
+		defer keyLog.Close()
+		baseConfig.KeyLogWriter = keyLog
+	}
+	tlsConfig := certs.ServerConfig(baseConfig)
+
+	// settings of the unix socket file and of its allowed peers
+	var sockOpts unixsock.Options
+	if sockOpts.Mode, err = unixsock.ParseMode(sockMode); err != nil {
+		log.Fatal(err)
+	}
+	sockOpts.Owner, sockOpts.Group = unixsock.ParseOwner(sockOwner)
+	if sockOpts.AllowUIDs, err = unixsock.ParseIDs(peerUIDs, true); err != nil {
+		log.Fatal(err)
+	}
+	if sockOpts.AllowGIDs, err = unixsock.ParseIDs(peerGIDs, false); err != nil {
+		log.Fatal(err)
 	}
 
-	// instead of net.Listen, we now use tls.Listen to start
-	// a listener on the secure port
-	ln, err := tls.Listen(network, addr, tlsConfig)
+	// TLS runs on top of the TCP or unix listener
+	rawLn, err := listen(network, addr, sockOpts)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
+	ln := tls.NewListener(rawLn, tlsConfig)
 	defer ln.Close()
 	log.Println("**** Global Currency Service (secure) ***")
 	log.Printf("Service started: (%s) %s; server cert %s\n", network, addr, cert)
 
-	// delay to sleep when accept fails with a temporary error
-	acceptDelay := time.Millisecond * 10
-	acceptCount := 0
-
 	// connection loop
 	for {
-		conn, err := ln.Accept()
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			switch e := err.(type) {
-			case net.Error:
-				// if temporary error, attempt to connect again
-				if e.Temporary() {
-					if acceptCount > 5 {
-						log.Fatalf("unable to connect after %d retries: %v", acceptCount, err)
-					}
-					acceptDelay *= 2
-					acceptCount++
-					time.Sleep(acceptDelay)
-					continue
-				}
-			default:
-				log.Println(err)
-				if err := conn.Close(); err != nil {
-					log.Fatal(err)
-				}
-				continue
-			}
-			acceptDelay = time.Millisecond * 10
-			acceptCount = 0
+			log.Println("failed to accept connection:", err)
+			return
 		}
-		log.Println("securely connected to remote client ", conn.RemoteAddr())
 		go handleConnection(conn)
 	}
 }
 
+// listen starts the listener of the service.  Unix sockets get the
+// permissions and peer checks of opts, and a stale socket file left by
+// a previous run is removed.  TCP sockets can be bound by a broker (see
+// unix/broker) to serve on port 443 without privileges.
+func listen(network, addr string, opts unixsock.Options) (net.Listener, error) {
+	if network == "unix" {
+		return unixsock.Listen(addr, opts)
+	}
+	return fdpass.Listen(network, addr)
+}
+
 // handle client connection
 func handleConnection(conn net.Conn) {
 	defer func() {
@@ -140,6 +256,26 @@ func handleConnection(conn net.Conn) {
 		}
 	}()
 
+	// complete the handshake to obtain the verified client certificate
+	tlsConn := conn.(*tls.Conn)
+	if err := tlsConn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		log.Println("failed to set deadline:", err)
+		return
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		log.Println("handshake failed:", err)
+		return
+	}
+	state := tlsConn.ConnectionState()
+	id := authz.IdentityFromCert(state.PeerCertificates[0])
+	if cred, err := unixsock.PeerCred(conn); err == nil {
+		// over unix sockets, the client process identity is known too
+		id.UID, id.GID = strconv.Itoa(cred.UID), strconv.Itoa(cred.GID)
+	}
+	atomic.AddInt64(&stats.Connections, 1)
+	log.Printf("securely connected to remote client %s [%s] (%s)", conn.RemoteAddr(), id,
+		tlsdiag.Summary(state))
+
 	// set initial deadline prior to entering
 	// the client request/response loop to 45 seconds.
 	// This means that the client has 45 seconds to send
@@ -180,12 +316,13 @@ func handleConnection(conn net.Conn) {
 			}
 		}
 
-		// search currencies, result is []curr.Currency
-		result := curr.Find(currencies, req.Get)
+		// authorize and run the command, the result is []curr.Currency
+		// for get, or a curr.CurrencyError if the request is rejected
+		result := execute(id, req)
 
 		// send result
 		enc := json.NewEncoder(conn)
-		if err := enc.Encode(&result); err != nil {
+		if err := enc.Encode(result); err != nil {
 			switch err := err.(type) {
 			case net.Error:
 				log.Println("failed to send response:", err)
@@ -206,3 +343,46 @@ func handleConnection(conn net.Conn) {
 		}
 	}
 }
+
+// execute authorizes the request for the client identity and runs it
+func execute(id authz.Identity, req curr.CurrencyRequest) interface{} {
+	cmd := req.Cmd
+	if cmd == "" {
+		cmd = "get"
+	}
+	atomic.AddInt64(&stats.Requests, 1)
+
+	if err := policy.Load().(*authz.Policy).Authorize(id, cmd); err != nil {
+		atomic.AddInt64(&stats.Denied, 1)
+		log.Printf("[%s] %s %q: denied: %v", id, cmd, req.Get, err)
+		return &curr.CurrencyError{Error: err.Error(), Code: curr.ErrCodeForbidden}
+	}
+	log.Printf("[%s] %s %q", id, cmd, req.Get)
+
+	switch cmd {
+	case "get":
+		result := curr.Find(currencies, req.Get)
+		return &result
+	case "stats":
+		return &serverStats{
+			Started:     stats.Started,
+			Connections: atomic.LoadInt64(&stats.Connections),
+			Requests:    atomic.LoadInt64(&stats.Requests),
+			Denied:      atomic.LoadInt64(&stats.Denied),
+		}
+	case "reload":
+		if err := certs.Reload(); err != nil {
+			return &curr.CurrencyError{Error: "reload failed: " + err.Error(), Code: curr.ErrCodeReloadFailed}
+		}
+		p, err := authz.LoadPolicy(policyFile)
+		if err != nil {
+			return &curr.CurrencyError{Error: "reload failed: " + err.Error(), Code: curr.ErrCodeReloadFailed}
+		}
+		policy.Store(p)
+		return &struct {
+			Reloaded time.Time `json:"reloaded"`
+		}{time.Now()}
+	default:
+		return &curr.CurrencyError{Error: "unknown command: " + cmd, Code: curr.ErrCodeBadRequest}
+	}
+}
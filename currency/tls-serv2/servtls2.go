@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
+	"github.com/vladimirvivien/go-networking/currency/certmgr"
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+var (
+	currencies = curr.Load("../data.csv")
+)
+
+// This program implements a simple currency lookup service
+// over TCP or Unix Data Socket. It loads ISO currency
+// information using package curr (see above) and uses a simple
+// JSON-encode text-based protocol to exchange data with a client.
+//
+// Clients send currency search requests as JSON objects
+// as {"Get":"<currency name,code,or country"}. The request data is
+// then unmarshalled to Go type curr.CurrencyRequest using
+// the encoding/json package.
+//
+// The request is then used to search the list of
+// currencies. The search result, a []curr.Currency, is marshalled
+// as JSON array of objects and sent to the client.
+//
+// Focus:
+// This version of the server hosts the service under several names.
+// Certificates are loaded from a directory and indexed by their DNS
+// subject alternative names; each connection is served the certificate
+// of the name requested by the client (SNI), or a default one.  Each
+// name also has its own client authentication policy: public names do
+// not ask for a client certificate while internal names require and
+// verify one against their own CA pool.  Names, policies, and the
+// certificate directory are read from a JSON file (see tenants.json).
+//
+// Certificates:
+// The certificates of tenants.json are not checked in, they expire.
+// Generate them, with their own CA and a client certificate for the
+// internal names, with certgen (from the currency directory):
+//   go run ./certgen ca -dir certs/tenants -name ca -cn "Currency Tenants CA"
+//   go run ./certgen server -dir certs/tenants -name currency -dns currency.example.com,localhost -ip 127.0.0.1,::1
+//   go run ./certgen server -dir certs/tenants -name internal -dns internal.example.com,*.internal.example.com
+//   go run ./certgen client -dir certs/tenants -name client -cn client -ou admin
+//
+// Testing:
+// Netcat can be used for rudimentary testing.  However, use clientjsonX
+// programs functional tests.
+//
+// Usage: server [options]
+// options:
+//   -e host endpoint, default ":4443"
+//   -n network protocol [tcp,unix], default "tcp"
+//   -config JSON file with the certificate directory and per-hostname
+//           policies, default "tenants.json"
+//   -reload interval between checks for certificate changes, default 10s
+func main() {
+	// setup flags
+	var addr, network, config string
+	var reload time.Duration
+	flag.StringVar(&addr, "e", ":4443", "service endpoint [ip addr or socket path]")
+	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
+	flag.StringVar(&config, "config", "tenants.json", "certificate directory and per-hostname policies")
+	flag.DurationVar(&reload, "reload", 10*time.Second, "interval between checks for certificate changes")
+	flag.Parse()
+
+	// validate supported network protocols
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		fmt.Println("unsupported network protocol")
+		os.Exit(1)
+	}
+
+	// load the certificates of every hosted name and their client
+	// authentication policies.  The manager watches the files and
+	// reloads them when they change.
+	sniConfig, err := certmgr.LoadSNIConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sniConfig.Interval = reload
+	certs, err := certmgr.NewSNI(*sniConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer certs.Close()
+
+	// configure tls, the certificate and client auth settings
+	// are selected for each client from the requested server name
+	tlsConfig := certs.ServerConfig(&tls.Config{})
+
+	// instead of net.Listen, we now use tls.Listen to start
+	// a listener on the secure port
+	ln, err := tls.Listen(network, addr, tlsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ln.Close()
+	log.Println("**** Global Currency Service (secure) ***")
+	log.Printf("Service started: (%s) %s; certificates %s\n", network, addr, sniConfig.CertDir)
+
+	// connection loop
+	for {
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
+		if err != nil {
+			log.Println("failed to accept connection:", err)
+			return
+		}
+		go handleConnection(conn)
+	}
+}
+
+// handle client connection
+func handleConnection(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Println("error closing connection:", err)
+		}
+	}()
+
+	// complete the handshake to learn the server name requested by the client
+	tlsConn := conn.(*tls.Conn)
+	if err := tlsConn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		log.Println("failed to set deadline:", err)
+		return
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		log.Println("handshake failed:", err)
+		return
+	}
+	state := tlsConn.ConnectionState()
+	client := "anonymous"
+	if len(state.PeerCertificates) > 0 {
+		client = state.PeerCertificates[0].Subject.CommonName
+	}
+	log.Printf("securely connected to remote client %s (server name %q, client %s)",
+		conn.RemoteAddr(), state.ServerName, client)
+
+	// set initial deadline prior to entering
+	// the client request/response loop to 45 seconds.
+	// This means that the client has 45 seconds to send
+	// its initial request or loose the connection.
+	if err := conn.SetDeadline(time.Now().Add(time.Second * 45)); err != nil {
+		log.Println("failed to set deadline:", err)
+		return
+	}
+
+	// command-loop
+	for {
+		dec := json.NewDecoder(conn)
+		var req curr.CurrencyRequest
+		if err := dec.Decode(&req); err != nil {
+			switch err := err.(type) {
+			//network error: disconnect
+			case net.Error:
+				// is it a timeout error?
+				// A deadline policy maybe implemented here using a decreasing
+				// grace period that eventually causes an error if reached.
+				// Here we just reject the connection if timeout is reached.
+				if err.Timeout() {
+					log.Println("deadline reached, disconnecting...")
+				}
+				log.Println("network error:", err)
+				return
+			default:
+				if err == io.EOF {
+					log.Println("closing connection:", err)
+					return
+				}
+				enc := json.NewEncoder(conn)
+				if encerr := enc.Encode(&curr.CurrencyError{Error: err.Error()}); encerr != nil {
+					log.Println("failed error encoding:", encerr)
+					return
+				}
+				continue
+			}
+		}
+
+		// search currencies, result is []curr.Currency
+		result := curr.Find(currencies, req.Get)
+
+		// send result
+		enc := json.NewEncoder(conn)
+		if err := enc.Encode(&result); err != nil {
+			switch err := err.(type) {
+			case net.Error:
+				log.Println("failed to send response:", err)
+				return
+			default:
+				if encerr := enc.Encode(&curr.CurrencyError{Error: err.Error()}); encerr != nil {
+					log.Println("failed to send error:", encerr)
+					return
+				}
+				continue
+			}
+		}
+
+		// renew deadline for 45 secs later
+		if err := conn.SetDeadline(time.Now().Add(time.Second * 90)); err != nil {
+			log.Println("failed to set deadline:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+)
+
+// serveJSON implements the JSON protocol (currency-json/1):
+// {"get":"<search>"} requests answered with []curr.Currency
+func serveJSON(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Println("error closing connection:", err)
+		}
+	}()
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		// the client has 45 seconds to send its next request
+		if err := conn.SetDeadline(time.Now().Add(time.Second * 45)); err != nil {
+			log.Println("failed to set deadline:", err)
+			return
+		}
+
+		var req curr.CurrencyRequest
+		if err := dec.Decode(&req); err != nil {
+			switch err := err.(type) {
+			case net.Error:
+				log.Println("network error:", err)
+				return
+			default:
+				if err == io.EOF {
+					log.Println("closing connection:", err)
+					return
+				}
+				// the stream cannot be resynchronized after a syntax error
+				enc.Encode(&curr.CurrencyError{Error: err.Error(), Code: curr.ErrCodeBadRequest})
+				return
+			}
+		}
+
+		result := curr.Find(currencies, req.Get)
+		if err := enc.Encode(&result); err != nil {
+			log.Println("failed to send response:", err)
+			return
+		}
+	}
+}
+
+// serveText implements the text protocol (currency-txt/1):
+// GET <search> commands answered with one currency per line
+func serveText(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Println("error closing connection:", err)
+		}
+	}()
+
+	if _, err := fmt.Fprint(conn, "Connected...\nUsage: GET <currency, country, or code>\n"); err != nil {
+		log.Println("error writing:", err)
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		if err := conn.SetDeadline(time.Now().Add(time.Minute * 5)); err != nil {
+			log.Println("failed to set deadline:", err)
+			return
+		}
+		cmdLine, err := reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				log.Println("connection read error:", err)
+			}
+			return
+		}
+
+		cmd, param, _ := strings.Cut(strings.TrimSpace(cmdLine), " ")
+		if !strings.EqualFold(cmd, "GET") || strings.TrimSpace(param) == "" {
+			if _, err := fmt.Fprint(conn, "Invalid command\n"); err != nil {
+				log.Println("failed to write:", err)
+				return
+			}
+			continue
+		}
+
+		result := curr.Find(currencies, strings.TrimSpace(param))
+		if len(result) == 0 {
+			if _, err := fmt.Fprint(conn, "Nothing found\n"); err != nil {
+				log.Println("failed to write:", err)
+				return
+			}
+			continue
+		}
+		for _, cur := range result {
+			if _, err := fmt.Fprintf(conn, "%s %s %s %s\n", cur.Name, cur.Code, cur.Number, cur.Country); err != nil {
+				log.Println("failed to write response:", err)
+				return
+			}
+		}
+	}
+}
+
+// httpHandler implements the HTTP API:
+// GET /currency/<search> returns the JSON-encoded []curr.Currency
+func httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/currency/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result := curr.Find(currencies, strings.TrimPrefix(r.URL.Path, "/currency/"))
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&result); err != nil {
+			log.Println("failed to send response:", err)
+		}
+	})
+	return mux
+}
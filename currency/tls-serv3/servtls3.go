@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
+	"github.com/vladimirvivien/go-networking/currency/certmgr"
+	curr "github.com/vladimirvivien/go-networking/currency/lib"
+	"github.com/vladimirvivien/go-networking/currency/protomux"
+)
+
+var (
+	currencies = curr.Load("../data.csv")
+)
+
+// This program implements the currency lookup service for every type
+// of client on a single endpoint: the JSON protocol of the serverjsonX
+// programs, the text protocol of the servertxtX programs, and HTTP
+// (HTTP/1.1, and HTTP/2 over TLS), where GET /currency/<search> returns the
+// JSON-encoded result.
+//
+// Focus:
+// This version of the server uses ALPN (Application-Layer Protocol
+// Negotiation) to pick the protocol of each connection during the TLS
+// handshake.  The server advertises the protocol IDs currency-json/1,
+// currency-txt/1, h2, and http/1.1; the client offers the ones it
+// speaks, and the connection is routed to the handler of the
+// negotiated protocol (see package protomux).  Clients that do not use
+// ALPN, and clients of the optional plaintext endpoint, are routed by
+// sniffing their first bytes instead: '{' starts a JSON request, an
+// HTTP request line starts HTTP, and anything else (or silence, since
+// text clients wait for the greeting) is the text protocol.
+//
+// Testing:
+//   openssl s_client -connect localhost:4443 -alpn currency-txt/1
+//   openssl s_client -connect localhost:4443 -alpn currency-json/1
+//   curl -k https://localhost:4443/currency/usd
+//   curl http://localhost:4040/currency/usd (with -plain :4040)
+//
+// Usage: server [options]
+// options:
+//   -e host endpoint (TLS), default ":4443"
+//   -n network protocol [tcp,unix], default "tcp"
+//   -plain plaintext endpoint routed by sniffing, disabled by default
+//   -cert, -key server certificate and private key
+func main() {
+	// setup flags
+	var addr, network, plain, cert, key string
+	flag.StringVar(&addr, "e", ":4443", "service endpoint [ip addr or socket path]")
+	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
+	flag.StringVar(&plain, "plain", "", "plaintext service endpoint, routed by sniffing")
+	flag.StringVar(&cert, "cert", "../certs/localhost-cert.pem", "public cert")
+	flag.StringVar(&key, "key", "../certs/localhost-key.pem", "private key")
+	flag.Parse()
+
+	// validate supported network protocols
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		fmt.Println("unsupported network protocol")
+		os.Exit(1)
+	}
+
+	certs, err := certmgr.New(certmgr.Config{CertFile: cert, KeyFile: key})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer certs.Close()
+
+	// HTTP connections are handed over to an http.Server through
+	// a listener fed by the mux
+	httpConns := protomux.NewListener(&net.TCPAddr{})
+	httpServer := &http.Server{
+		Handler:           httpHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       90 * time.Second,
+	}
+	go func() {
+		if err := httpServer.Serve(httpConns); err != nil && err != http.ErrServerClosed {
+			log.Println("http server failed:", err)
+		}
+	}()
+
+	// register the handler of each protocol, in order of preference
+	mux := protomux.New()
+	mux.Handle(protomux.JSON, protomux.HandlerFunc(serveJSON))
+	mux.Handle(protomux.Text, protomux.HandlerFunc(serveText))
+	mux.Handle(protomux.HTTP2, httpConns)
+	mux.Handle(protomux.HTTP1, httpConns)
+
+	// advertise the protocols with ALPN
+	tlsConfig := certs.ServerConfig(&tls.Config{
+		NextProtos: mux.NextProtos(),
+	})
+
+	ln, err := tls.Listen(network, addr, tlsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ln.Close()
+	log.Println("**** Global Currency Service (secure, multi-protocol) ***")
+	log.Printf("Service started: (%s) %s; protocols %v\n", network, addr, mux.NextProtos())
+
+	if plain != "" {
+		plainLn, err := net.Listen(network, plain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer plainLn.Close()
+		log.Printf("Plaintext service started: (%s) %s\n", network, plain)
+		go serve(plainLn, mux)
+	}
+	serve(ln, mux)
+}
+
+// serve runs the connection loop of a listener
+func serve(ln net.Listener, mux *protomux.Mux) {
+	for {
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, log.Printf)
+		if err != nil {
+			log.Println("failed to accept connection:", err)
+			return
+		}
+		go mux.ServeConn(conn)
+	}
+}
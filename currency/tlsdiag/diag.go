@@ -0,0 +1,152 @@
+// Package tlsdiag helps understand and tune the TLS connections of the
+// currency service:
+//
+//   - Describe and Dial report what a handshake negotiated (version,
+//     cipher suite, ALPN, resumption), how long it took, and the peer
+//     certificate chain
+//   - KeyLogWriter writes the session secrets to $SSLKEYLOGFILE so that
+//     tools like Wireshark can decrypt traffic captures (development only)
+//   - FileSessionCache keeps client session tickets across runs, so that
+//     a client resumes its previous session instead of doing a full
+//     handshake
+//   - RotateTicketKeys rotates the keys a server uses to encrypt
+//     session tickets
+package tlsdiag
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Timing is the duration of the steps of a connection
+type Timing struct {
+	Connect   time.Duration
+	Handshake time.Duration
+}
+
+func (t Timing) String() string {
+	return fmt.Sprintf("connect %v, handshake %v", t.Connect.Round(time.Microsecond),
+		t.Handshake.Round(time.Microsecond))
+}
+
+// Dial connects to addr and completes the TLS handshake, timing
+// both steps.  ServerName is inferred from addr like tls.Dial does,
+// except for unix sockets where it must be set.
+func Dial(ctx context.Context, network, addr string, config *tls.Config) (*tls.Conn, Timing, error) {
+	var timing Timing
+	if err := CheckServerName(network, config); err != nil {
+		return nil, timing, err
+	}
+	start := time.Now()
+	var d net.Dialer
+	rawConn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, timing, err
+	}
+	timing.Connect = time.Since(start)
+
+	if config.ServerName == "" {
+		config = config.Clone()
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	conn := tls.Client(rawConn, config)
+	start = time.Now()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, timing, err
+	}
+	timing.Handshake = time.Since(start)
+	return conn, timing, nil
+}
+
+// CheckServerName returns an error when connecting over a unix socket
+// without a server name: the name cannot be inferred from the socket
+// path and certificate verification would fail
+func CheckServerName(network string, config *tls.Config) error {
+	if strings.HasPrefix(network, "unix") && config.ServerName == "" && !config.InsecureSkipVerify {
+		return errors.New("tls: server name required to verify the server over a unix socket")
+	}
+	return nil
+}
+
+// Summary returns a one line description of a connection state
+func Summary(cs tls.ConnectionState) string {
+	proto := cs.NegotiatedProtocol
+	if proto == "" {
+		proto = "none"
+	}
+	return fmt.Sprintf("%s %s, alpn %s, resumed %t", tls.VersionName(cs.Version),
+		tls.CipherSuiteName(cs.CipherSuite), proto, cs.DidResume)
+}
+
+// Describe returns a multi-line description of a connection state and
+// of the peer certificate chain
+func Describe(cs tls.ConnectionState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "version:      %s\n", tls.VersionName(cs.Version))
+	fmt.Fprintf(&b, "cipher suite: %s\n", tls.CipherSuiteName(cs.CipherSuite))
+	fmt.Fprintf(&b, "server name:  %s\n", cs.ServerName)
+	fmt.Fprintf(&b, "alpn:         %s\n", cs.NegotiatedProtocol)
+	fmt.Fprintf(&b, "resumed:      %t\n", cs.DidResume)
+	fmt.Fprintf(&b, "ocsp staple:  %t\n", len(cs.OCSPResponse) > 0)
+
+	chain := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		chain = cs.VerifiedChains[0]
+	}
+	for i, cert := range chain {
+		fmt.Fprintf(&b, "peer cert %d:\n%s", i, describeCert(cert))
+	}
+	return b.String()
+}
+
+func describeCert(cert *x509.Certificate) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  subject:    %s\n", cert.Subject)
+	fmt.Fprintf(&b, "  issuer:     %s\n", cert.Issuer)
+	fmt.Fprintf(&b, "  serial:     %x\n", cert.SerialNumber)
+	fmt.Fprintf(&b, "  validity:   %s - %s\n", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+	fmt.Fprintf(&b, "  key:        %s, signed with %s\n", cert.PublicKeyAlgorithm, cert.SignatureAlgorithm)
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	if len(sans) > 0 {
+		fmt.Fprintf(&b, "  names:      %s\n", strings.Join(sans, ", "))
+	}
+	return b.String()
+}
+
+// KeyLogWriter opens the file named by $SSLKEYLOGFILE for appending, to
+// be used as tls.Config.KeyLogWriter.  It returns nil when the variable
+// is not set.  Anyone with the file can decrypt the recorded sessions,
+// so it is meant for local debugging only and a warning is logged.
+func KeyLogWriter() (io.WriteCloser, error) {
+	file := os.Getenv("SSLKEYLOGFILE")
+	if file == "" {
+		return nil, nil
+	}
+	w, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("WARNING: writing TLS session secrets to %s (SSLKEYLOGFILE), do not use in production", file)
+	return w, nil
+}
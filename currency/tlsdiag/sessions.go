@@ -0,0 +1,128 @@
+package tlsdiag
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSessionCache is a tls.ClientSessionCache that saves the session
+// tickets received from servers to a file, so that the next run of
+// a client can resume the session.  The file contains session secrets
+// and is only readable by its owner.
+type FileSessionCache struct {
+	file string
+
+	mu       sync.Mutex
+	sessions map[string]savedSession
+	hits     int
+	misses   int
+}
+
+// savedSession is the serialized form of a tls.ClientSessionState
+type savedSession struct {
+	Ticket []byte    `json:"ticket"`
+	State  []byte    `json:"state"`
+	Saved  time.Time `json:"saved"`
+}
+
+// sessionLifetime bounds how long tickets are kept; servers do not
+// accept tickets older than 7 days (TLS 1.3)
+const sessionLifetime = 7 * 24 * time.Hour
+
+// DefaultSessionFile returns the default session cache file, in the
+// user cache directory
+func DefaultSessionFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "go-networking", "tls-sessions.json")
+}
+
+// NewFileSessionCache loads the sessions saved in file, if any
+func NewFileSessionCache(file string) *FileSessionCache {
+	c := &FileSessionCache{file: file, sessions: make(map[string]savedSession)}
+	if data, err := os.ReadFile(file); err == nil {
+		json.Unmarshal(data, &c.sessions)
+	}
+	for key, s := range c.sessions {
+		if time.Since(s.Saved) > sessionLifetime {
+			delete(c.sessions, key)
+		}
+	}
+	return c
+}
+
+// Get returns the session saved for sessionKey (usually the server name)
+func (c *FileSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	saved, ok := c.sessions[sessionKey]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	state, err := tls.ParseSessionState(saved.State)
+	if err != nil {
+		delete(c.sessions, sessionKey)
+		c.misses++
+		return nil, false
+	}
+	session, err := tls.NewResumptionState(saved.Ticket, state)
+	if err != nil {
+		delete(c.sessions, sessionKey)
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return session, true
+}
+
+// Put saves the session for sessionKey, a nil session removes it
+func (c *FileSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cs == nil {
+		delete(c.sessions, sessionKey)
+		c.save()
+		return
+	}
+	ticket, state, err := cs.ResumptionState()
+	if err != nil || state == nil {
+		return
+	}
+	stateBytes, err := state.Bytes()
+	if err != nil {
+		return
+	}
+	c.sessions[sessionKey] = savedSession{Ticket: ticket, State: stateBytes, Saved: time.Now()}
+	c.save()
+}
+
+// Stats returns how many lookups found, or did not find, a session
+func (c *FileSessionCache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// save writes the sessions to the file, errors are ignored since the
+// cache is only an optimization
+func (c *FileSessionCache) save() {
+	data, err := json.Marshal(c.sessions)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0700); err != nil {
+		return
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, c.file)
+}
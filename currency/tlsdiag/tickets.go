@@ -0,0 +1,56 @@
+package tlsdiag
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"log"
+	"time"
+)
+
+// RotateTicketKeys sets a new session ticket key on config every
+// interval.  The keys of the previous keep-1 periods remain valid to
+// resume sessions but are no longer used for new tickets, so a stolen
+// key exposes a bounded window of sessions.  Call the returned function
+// to stop rotating.
+//
+// Configs cloned from config after a rotation (i.e. by
+// GetConfigForClient) use the current keys.
+func RotateTicketKeys(config *tls.Config, interval time.Duration, keep int) (stop func(), err error) {
+	if keep < 1 {
+		keep = 1
+	}
+	var keys [][32]byte
+	rotate := func() error {
+		var key [32]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		// the first key encrypts new tickets, all keys decrypt
+		keys = append([][32]byte{key}, keys...)
+		if len(keys) > keep {
+			keys = keys[:keep]
+		}
+		config.SetSessionTicketKeys(keys)
+		return nil
+	}
+	if err := rotate(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := rotate(); err != nil {
+					log.Println("failed to rotate session ticket keys:", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }, nil
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package unixsock
+
+import (
+	"net"
+	"syscall"
+)
+
+// abstractSupported reports whether sockets can be named in the
+// abstract namespace
+const abstractSupported = true
+
+func peerCred(conn *net.UnixConn) (Cred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return Cred{}, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return Cred{}, err
+	}
+	if credErr != nil {
+		return Cred{}, credErr
+	}
+	return Cred{PID: int(ucred.Pid), UID: int(ucred.Uid), GID: int(ucred.Gid)}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package unixsock
+
+import "net"
+
+// abstractSupported reports whether sockets can be named in the
+// abstract namespace
+const abstractSupported = false
+
+func peerCred(*net.UnixConn) (Cred, error) {
+	return Cred{}, ErrUnsupported
+}
@@ -0,0 +1,328 @@
+// Package unixsock provides the pieces needed to serve TLS over unix
+// domain sockets: listening on a socket file with controlled permissions,
+// removing the stale file left by a previous run, and checking the
+// credentials (uid, gid, pid) of the peer process, which the kernel
+// reports for unix sockets (SO_PEERCRED).
+//
+// TLS runs on top of the unix listener as usual:
+//
+//	ln, err := unixsock.Listen("/run/currency.sock", unixsock.Options{Mode: 0660, AllowUIDs: []int{1000}})
+//	...
+//	tlsLn := tls.NewListener(ln, config)
+//
+// Clients cannot infer the server name from a socket path, they must
+// set tls.Config.ServerName to a name of the server certificate.
+//
+// On Linux, a path starting with "@" names a socket in the abstract
+// namespace: there is no file to clean up, and no file permissions,
+// anyone on the host (in the same network namespace) can connect, so
+// the peer checks are the only access control.
+//
+// Accepted connections are *Conn values that carry the credentials of
+// the peer, see PeerCred.
+package unixsock
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned by PeerCred on systems that do not report
+// peer credentials
+var ErrUnsupported = errors.New("unixsock: peer credentials not supported on this system")
+
+// Options configures the socket file and the accepted peers
+type Options struct {
+	// Mode is the permission of the socket file, i.e. 0660 to only let
+	// the owner and group connect.  Zero leaves the default (umask).
+	Mode os.FileMode
+
+	// Owner and Group change the owner of the socket file, as names or
+	// numeric ids.  Changing the owner requires privileges.
+	Owner, Group string
+
+	// AllowUIDs and AllowGIDs restrict the peers to processes running
+	// as one of the users, or one of the groups; empty allows all
+	AllowUIDs, AllowGIDs []int
+}
+
+// Cred is the identity of the process at the other end of a socket
+type Cred struct {
+	PID, UID, GID int
+}
+
+func (c Cred) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d", c.PID, c.UID, c.GID)
+}
+
+// Conn is an accepted unix socket connection and the credentials of
+// its peer, read once when the connection is accepted
+type Conn struct {
+	*net.UnixConn
+	Cred Cred
+}
+
+// IsAbstract reports whether path names a socket in the Linux abstract
+// namespace
+func IsAbstract(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// Listen listens on the unix socket path.  A stale socket file left by
+// a server that is no longer running is removed first; if a server still
+// answers on path, Listen fails.  The file is removed when the listener
+// is closed.  The file mode and owner of opts do not apply to abstract
+// sockets.
+//
+// The socket file is created in a private directory next to path, and
+// only linked to path once its mode and owner are set, so that no one
+// can connect in between.  The directory name adds about 20 bytes to
+// the path, which is limited to 104 to 108 bytes depending on the
+// system.
+func Listen(path string, opts Options) (net.Listener, error) {
+	if IsAbstract(path) {
+		if !abstractSupported {
+			return nil, fmt.Errorf("%s: abstract socket addresses are only supported on Linux", path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return &listener{Listener: ln, opts: opts}, nil
+	}
+
+	if err := removeStale(path); err != nil {
+		return nil, err
+	}
+	ln, err := listenPrivate(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{Listener: ln, opts: opts, path: path}, nil
+}
+
+// listenPrivate listens on a socket file created in a directory only
+// the owner can search, sets its mode and owner, and links it to path
+func listenPrivate(path string, opts Options) (*net.UnixListener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// the temporary name is gone, Close removes path instead
+	ln.SetUnlinkOnClose(false)
+	if err := setOwner(tmp, opts); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	// unlike a rename, a link fails if a server took path meanwhile
+	if err := os.Link(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStale removes path if it is a socket nobody listens on
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: another server is listening", path)
+	}
+	log.Printf("removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+// setOwner applies the file mode and owner of the socket file
+func setOwner(path string, opts Options) error {
+	if opts.Owner != "" || opts.Group != "" {
+		uid, gid := -1, -1
+		var err error
+		if opts.Owner != "" {
+			if uid, err = lookupID(opts.Owner, true); err != nil {
+				return err
+			}
+		}
+		if opts.Group != "" {
+			if gid, err = lookupID(opts.Group, false); err != nil {
+				return err
+			}
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	if opts.Mode != 0 {
+		return os.Chmod(path, opts.Mode)
+	}
+	return nil
+}
+
+// lookupID returns the numeric id of a user or group name (or id)
+func lookupID(name string, isUser bool) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	if isUser {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(u.Uid)
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// ParseMode parses an octal file mode such as 0660
+func ParseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ParseOwner splits "user:group", "user", or ":group"
+func ParseOwner(s string) (owner, group string) {
+	owner, group, _ = strings.Cut(s, ":")
+	return owner, group
+}
+
+// ParseIDs parses a comma-separated list of user or group names or ids
+func ParseIDs(s string, users bool) ([]int, error) {
+	var ids []int
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, err := lookupID(item, users)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Allowed reports whether cred matches the allowed users or groups
+func (o Options) Allowed(cred Cred) bool {
+	if len(o.AllowUIDs) == 0 && len(o.AllowGIDs) == 0 {
+		return true
+	}
+	for _, uid := range o.AllowUIDs {
+		if cred.UID == uid {
+			return true
+		}
+	}
+	for _, gid := range o.AllowGIDs {
+		if cred.GID == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// listener attaches the peer credentials to accepted connections, and
+// rejects peers that are not allowed by the options
+type listener struct {
+	net.Listener
+	opts Options
+	path string // socket file, empty for abstract sockets
+}
+
+// Addr returns the address of path, the socket file was created under
+// another name
+func (l *listener) Addr() net.Addr {
+	if l.path == "" {
+		return l.Listener.Addr()
+	}
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close closes the listener and removes the socket file
+func (l *listener) Close() error {
+	err := l.Listener.Close()
+	if l.path != "" && !errors.Is(err, net.ErrClosed) {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	restricted := len(l.opts.AllowUIDs) > 0 || len(l.opts.AllowGIDs) > 0
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uc := conn.(*net.UnixConn)
+		cred, err := peerCred(uc)
+		if err != nil {
+			if !restricted {
+				return conn, nil // nothing to check
+			}
+			log.Printf("rejecting unix socket peer: %v", err)
+			conn.Close()
+			continue
+		}
+		if !l.opts.Allowed(cred) {
+			log.Printf("rejecting unix socket peer %s: not an allowed user or group", cred)
+			conn.Close()
+			continue
+		}
+		return &Conn{UnixConn: uc, Cred: cred}, nil
+	}
+}
+
+// PeerCred returns the credentials of the process at the other end of
+// a unix socket connection.  TLS connections are unwrapped.
+func PeerCred(conn net.Conn) (Cred, error) {
+	if nc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = nc.NetConn()
+	}
+	switch c := conn.(type) {
+	case *Conn:
+		return c.Cred, nil
+	case *net.UnixConn:
+		return peerCred(c)
+	}
+	return Cred{}, fmt.Errorf("unixsock: %T is not a unix socket connection", conn)
+}
+
+// Peer describes the client of conn for logs: the credentials of the
+// peer process for unix sockets, the remote address otherwise
+func Peer(conn net.Conn) string {
+	if cred, err := PeerCred(conn); err == nil {
+		return cred.String()
+	}
+	return conn.RemoteAddr().String()
+}
@@ -0,0 +1,118 @@
+package unixsock
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestListenMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.sock")
+	ln, err := Listen(path, Options{Mode: 0660})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0660 {
+		t.Errorf("got mode %v, want a socket with 0660", info.Mode())
+	}
+	if got := ln.Addr().String(); got != path {
+		t.Errorf("got address %s, want %s", got, path)
+	}
+	// the private directory is gone
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want the socket only", len(entries))
+	}
+
+	done := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- conn
+	}()
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	conn := <-done
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	if runtime.GOOS == "linux" {
+		cred, err := PeerCred(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cred.UID != os.Getuid() || cred.PID != os.Getpid() {
+			t.Errorf("got peer %s, want this process", cred)
+		}
+	}
+}
+
+func TestListenInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := Listen(path, Options{Mode: 0600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path, Options{}); err == nil {
+		t.Fatal("second listener on the same path succeeded")
+	}
+
+	// closing removes the file, and only once
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file left after Close: %v", err)
+	}
+	ln2, err := Listen(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln2.Close()
+	ln.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Errorf("second Close removed the file of another listener: %v", err)
+	}
+}
+
+func TestListenStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	// a socket file nobody listens on
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path, Options{}); err == nil {
+		t.Fatal("Listen replaced a regular file")
+	}
+}
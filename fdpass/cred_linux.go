@@ -0,0 +1,37 @@
+package fdpass
+
+import (
+	"net"
+	"syscall"
+)
+
+// credSpace is the room for a credentials message
+var credSpace = syscall.CmsgSpace(syscall.SizeofUcred)
+
+// PassCred asks the kernel to attach the credentials of the sender to
+// each message received on conn (SO_PASSCRED)
+func PassCred(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func parseCred(scm *syscall.SocketControlMessage) (*Cred, bool) {
+	if scm.Header.Type != syscall.SCM_CREDENTIALS {
+		return nil, false
+	}
+	ucred, err := syscall.ParseUnixCredentials(scm)
+	if err != nil {
+		return nil, false
+	}
+	return &Cred{PID: int(ucred.Pid), UID: int(ucred.Uid), GID: int(ucred.Gid)}, true
+}
@@ -0,0 +1,20 @@
+//go:build unix && !linux
+
+package fdpass
+
+import (
+	"net"
+	"syscall"
+)
+
+// credSpace is the room for a credentials message
+var credSpace = 0
+
+// PassCred returns ErrUnsupported, only Linux passes credentials
+func PassCred(conn *net.UnixConn) error {
+	return ErrUnsupported
+}
+
+func parseCred(scm *syscall.SocketControlMessage) (*Cred, bool) {
+	return nil, false
+}
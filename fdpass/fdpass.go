@@ -0,0 +1,322 @@
+//go:build unix
+
+// Package fdpass sends open file descriptors, and the credentials of
+// the sending process, over unix domain sockets (SCM_RIGHTS and
+// SCM_CREDENTIALS ancillary messages, with WriteMsgUnix/ReadMsgUnix).
+//
+// A descriptor received by another process refers to the same open file
+// or socket as the sender's.  This lets a privileged "socket broker"
+// bind ports below 1024 and hand the sockets to an unprivileged worker
+// (see program unix/broker).  Workers get the sockets with Listen and
+// ListenPacket, which fall back to net.Listen and net.ListenPacket when
+// the program was not started by a broker:
+//
+//	ln, err := fdpass.Listen("tcp", ":443")
+//
+// The broker and the worker are connected with a socket pair (Pair)
+// passed to the worker as an inherited descriptor, named by the
+// environment variable FDPASS_FD.
+package fdpass
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ErrUnsupported is returned by PassCred on systems that do not pass
+// credentials
+var ErrUnsupported = errors.New("fdpass: credentials not supported on this system")
+
+// MaxFiles is the most descriptors sent or received in one message
+const MaxFiles = 16
+
+// EnvFD names the environment variable set by a broker to the
+// descriptor of its connection with the worker
+const EnvFD = "FDPASS_FD"
+
+// Cred is the identity of the sending process, checked by the kernel
+type Cred struct {
+	PID, UID, GID int
+}
+
+func (c Cred) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d", c.PID, c.UID, c.GID)
+}
+
+// Msg is a message received with Recv
+type Msg struct {
+	Data  []byte
+	Files []*os.File
+
+	// Cred is the identity of the sender, set when the receiving
+	// connection enabled credentials with PassCred
+	Cred *Cred
+}
+
+// Close closes the received files
+func (m *Msg) Close() {
+	for _, f := range m.Files {
+		f.Close()
+	}
+}
+
+// Send writes data and the descriptors of files in one message.  Data
+// must not be empty: on stream sockets, the descriptors travel with the
+// data bytes.  The files can be closed once Send returns.
+func Send(conn *net.UnixConn, data []byte, files ...*os.File) error {
+	if len(data) == 0 {
+		return errors.New("fdpass: empty message")
+	}
+	if len(files) > MaxFiles {
+		return fmt.Errorf("fdpass: more than %d files", MaxFiles)
+	}
+	var oob []byte
+	if len(files) > 0 {
+		fds := make([]int, len(files))
+		for i, f := range files {
+			fds[i] = int(f.Fd())
+		}
+		oob = syscall.UnixRights(fds...)
+	}
+	n, oobn, err := conn.WriteMsgUnix(data, oob, nil)
+	runtime.KeepAlive(files)
+	if err != nil {
+		return err
+	}
+	if n != len(data) || oobn != len(oob) {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+// Recv reads one message into buf, with the descriptors and credentials
+// sent along.  Received descriptors are closed on exec.
+func Recv(conn *net.UnixConn, buf []byte) (*Msg, error) {
+	oob := make([]byte, syscall.CmsgSpace(MaxFiles*4)+credSpace)
+	n, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 && oobn == 0 {
+		return nil, io.EOF
+	}
+	msg := &Msg{Data: buf[:n]}
+	if oobn > 0 {
+		scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, err
+		}
+		for i := range scms {
+			scm := &scms[i]
+			if scm.Header.Level != syscall.SOL_SOCKET {
+				continue
+			}
+			if scm.Header.Type == syscall.SCM_RIGHTS {
+				fds, err := syscall.ParseUnixRights(scm)
+				if err != nil {
+					msg.Close()
+					return nil, err
+				}
+				for _, fd := range fds {
+					syscall.CloseOnExec(fd)
+					msg.Files = append(msg.Files, os.NewFile(uintptr(fd), "fdpass"))
+				}
+			} else if cred, ok := parseCred(scm); ok {
+				msg.Cred = cred
+			}
+		}
+	}
+	if flags&syscall.MSG_CTRUNC != 0 {
+		msg.Close()
+		return nil, errors.New("fdpass: control message truncated, descriptors lost")
+	}
+	return msg, nil
+}
+
+// Pair returns the two ends of a connected pair of sequenced-packet
+// unix sockets, which keep message boundaries.  Hand one end to a child
+// process with (*net.UnixConn).File and exec.Cmd.ExtraFiles.
+func Pair() (*net.UnixConn, *net.UnixConn, error) {
+	// hold the fork lock so no child inherits the descriptors before
+	// they are marked close on exec
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+	a, err := fileConn(fds[0])
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	b, err := fileConn(fds[1])
+	if err != nil {
+		a.Close()
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// fileConn returns a connection using (a copy of) descriptor fd,
+// which is closed
+func fileConn(fd int) (*net.UnixConn, error) {
+	f := os.NewFile(uintptr(fd), "fdpass")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("fdpass: descriptor %d is not a unix socket", fd)
+	}
+	return uc, nil
+}
+
+// Filer is implemented by the listeners and connections of package net
+// that expose their descriptor
+type Filer interface {
+	File() (*os.File, error)
+}
+
+// SendFile sends the descriptor of a listener or connection, with a name
+// telling the receiver what it is
+func SendFile(conn *net.UnixConn, name string, c Filer) error {
+	f, err := c.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Send(conn, []byte(name), f)
+}
+
+// RecvFile receives a descriptor sent with SendFile
+func RecvFile(conn *net.UnixConn) (string, *os.File, error) {
+	msg, err := Recv(conn, make([]byte, 512))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(msg.Files) != 1 {
+		msg.Close()
+		return "", nil, fmt.Errorf("fdpass: received %d files, want 1", len(msg.Files))
+	}
+	return string(msg.Data), msg.Files[0], nil
+}
+
+// RecvListener receives a listener sent with SendFile
+func RecvListener(conn *net.UnixConn) (string, net.Listener, error) {
+	name, f, err := RecvFile(conn)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	return name, ln, err
+}
+
+// RecvPacketConn receives a packet connection (i.e. UDP) sent with
+// SendFile
+func RecvPacketConn(conn *net.UnixConn) (string, net.PacketConn, error) {
+	name, f, err := RecvFile(conn)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	pc, err := net.FilePacketConn(f)
+	return name, pc, err
+}
+
+// Name is the name of the socket bound on network and address, as sent
+// by the broker
+func Name(network, addr string) string {
+	return network + ":" + addr
+}
+
+var parent struct {
+	once sync.Once
+	conn *net.UnixConn
+	err  error
+}
+
+// Parent returns the connection with the broker that started the
+// program, nil if there is none
+func Parent() (*net.UnixConn, error) {
+	parent.once.Do(func() {
+		s := os.Getenv(EnvFD)
+		if s == "" {
+			return
+		}
+		os.Unsetenv(EnvFD) // not for the children of the worker
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			parent.err = fmt.Errorf("fdpass: %s: %v", EnvFD, err)
+			return
+		}
+		parent.conn, parent.err = fileConn(fd)
+	})
+	return parent.conn, parent.err
+}
+
+// Listen returns the next listener handed over by the broker, which
+// must be bound to network and addr, or listens on addr when the program
+// was not started by a broker
+func Listen(network, addr string) (net.Listener, error) {
+	conn, err := Parent()
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return net.Listen(network, addr)
+	}
+	name, ln, err := RecvListener(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := ack(conn, name, Name(network, addr)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// ListenPacket is Listen for packet connections
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	conn, err := Parent()
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return net.ListenPacket(network, addr)
+	}
+	name, pc, err := RecvPacketConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := ack(conn, name, Name(network, addr)); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// ack checks that the broker sent the expected socket and tells it
+// the socket is in use
+func ack(conn *net.UnixConn, name, want string) error {
+	if name != want {
+		return fmt.Errorf("fdpass: broker sent %s, want %s", name, want)
+	}
+	return Send(conn, []byte("ok "+name))
+}
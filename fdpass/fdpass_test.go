@@ -0,0 +1,237 @@
+//go:build unix
+
+package fdpass
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// pair returns a socket pair closed at the end of the test
+func pair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	a, b, err := Pair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestSendFile(t *testing.T) {
+	a, b := pair(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the write end of the pipe is closed once sent, the received
+	// descriptor still refers to it
+	if err := Send(a, []byte("pipe"), w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	msg, err := Recv(b, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "pipe" || len(msg.Files) != 1 {
+		t.Fatalf("received %q with %d files", msg.Data, len(msg.Files))
+	}
+	if _, err := msg.Files[0].WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	msg.Close()
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+}
+
+func TestSendMaxFiles(t *testing.T) {
+	a, b := pair(t)
+	files := make([]*os.File, MaxFiles+1)
+	for i := range files {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		files[i] = r
+	}
+	if err := Send(a, []byte("too many"), files...); err == nil {
+		t.Fatalf("sent %d files", len(files))
+	}
+	if err := Send(a, []byte("max"), files[:MaxFiles]...); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := Recv(b, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer msg.Close()
+	if len(msg.Files) != MaxFiles {
+		t.Fatalf("received %d files, want %d", len(msg.Files), MaxFiles)
+	}
+}
+
+func TestRecvTruncated(t *testing.T) {
+	a, b := pair(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// more descriptors than Recv has room for, sent around Send
+	fds := make([]int, 2*MaxFiles)
+	for i := range fds {
+		fds[i] = int(r.Fd())
+	}
+	before := openFiles(t)
+	if _, _, err := a.WriteMsgUnix([]byte("flood"), syscall.UnixRights(fds...), nil); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := Recv(b, make([]byte, 64))
+	if err == nil {
+		msg.Close()
+		t.Fatal("truncated message received")
+	}
+	if !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("got %v, want a truncation error", err)
+	}
+
+	// the descriptors that fit were closed
+	if after := openFiles(t); after > before {
+		t.Errorf("%d descriptors open after Recv, %d before", after, before)
+	}
+}
+
+// openFiles returns the number of open descriptors of the process
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd to count descriptors")
+	}
+	return len(entries)
+}
+
+// setParent makes conn the connection with the broker, as if the
+// program had been started by one
+func setParent(t *testing.T, conn *net.UnixConn) {
+	t.Helper()
+	var fd int
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Control(func(s uintptr) {
+		fd, err = syscall.Dup(int(s))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent.once, parent.conn, parent.err = sync.Once{}, nil, nil
+	t.Setenv(EnvFD, strconv.Itoa(fd))
+	t.Cleanup(func() {
+		if parent.conn != nil {
+			parent.conn.Close()
+		}
+		parent.once, parent.conn, parent.err = sync.Once{}, nil, nil
+	})
+}
+
+func TestListenFromBroker(t *testing.T) {
+	broker, worker := pair(t)
+	setParent(t, worker)
+
+	// the broker binds the socket and sends it to the worker
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := SendFile(broker, Name("tcp", "127.0.0.1:0"), ln.(*net.TCPListener)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Close()
+	if os.Getenv(EnvFD) != "" {
+		t.Errorf("%s left in the environment", EnvFD)
+	}
+	if got.Addr().String() != ln.Addr().String() {
+		t.Errorf("listening on %s, want %s", got.Addr(), ln.Addr())
+	}
+	msg, err := Recv(broker, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ok tcp:127.0.0.1:0"; string(msg.Data) != want {
+		t.Errorf("broker got %q, want %q", msg.Data, want)
+	}
+
+	// the received listener accepts the connections
+	go func() {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := got.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestListenWrongSocket(t *testing.T) {
+	broker, worker := pair(t)
+	setParent(t, worker)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := SendFile(broker, Name("tcp", ":80"), ln.(*net.TCPListener)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Listen("tcp", ":443"); err == nil {
+		got.Close()
+		t.Fatal("listening on the socket of another address")
+	}
+}
+
+func TestAck(t *testing.T) {
+	a, b := pair(t)
+	if err := ack(a, "tcp::80", "tcp::443"); err == nil {
+		t.Fatal("mismatched name acknowledged")
+	}
+	if err := ack(a, "tcp::443", "tcp::443"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the match was acknowledged
+	msg, err := Recv(b, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "ok tcp::443" {
+		t.Errorf("got %q", msg.Data)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package fdpass
+
+import "net"
+
+// Listen listens on addr, there are no brokers on this system
+func Listen(network, addr string) (net.Listener, error) {
+	return net.Listen(network, addr)
+}
+
+// ListenPacket listens on addr, there are no brokers on this system
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	return net.ListenPacket(network, addr)
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"net"
 	"os"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
 )
 
 // This program implements a simple echo server over TCP.
@@ -39,11 +43,11 @@ func main() {
 	for {
 		// use TCPListener to block and wait for TCP
 		// connection request using AcceptTCP which creates a TCPConn
-		conn, err := l.AcceptTCP()
+		// Errors that leave the listener usable are retried.
+		conn, err := backoff.Accept(l.AcceptTCP, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			fmt.Println("failed to accept conn:", err)
-			conn.Close()
-			continue
+			fmt.Println("failed to accept connection:", err)
+			return
 		}
 		fmt.Println("connected to: ", conn.RemoteAddr())
 
@@ -3,8 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"net"
 	"os"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
 )
 
 // This program implements a simple echo server over
@@ -40,11 +44,11 @@ func main() {
 		// use UnixListener to block and wait for UDS
 		// connection request using AcceptUnix which then
 		// creates a UnixConn
-		conn, err := l.AcceptUnix()
+		// Errors that leave the listener usable are retried.
+		conn, err := backoff.Accept(l.AcceptUnix, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			fmt.Println("failed to accept conn:", err)
-			conn.Close()
-			continue
+			fmt.Println("failed to accept connection:", err)
+			return
 		}
 		fmt.Println("connected to: ", conn.RemoteAddr())
 
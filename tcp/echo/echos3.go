@@ -3,23 +3,37 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"net"
 	"os"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
+	"github.com/vladimirvivien/go-networking/currency/unixsock"
 )
 
 // This program implements a simple echo server over that is able
 // to use TCP or Unix Domain Socket (streaming).
 // When the server receives a request, it returns its content immediately.
+// Over Unix Domain Sockets, the server reads the credentials of the
+// client process (uid, gid, pid) and only serves the allowed users or
+// groups.  On Linux, a path starting with "@" is an abstract socket
+// that needs no file cleanup.
 //
 // Usage:
 // echos2
 //   -e <endpoint: ip addr or path>
 //   - n <protoco [tcp,unix]>
+//   -peer-uid <users allowed over unix sockets, comma-separated>
+//   -peer-gid <groups allowed over unix sockets, comma-separated>
 func main() {
 	var addr string
 	var network string
+	var peerUIDs, peerGIDs string
 	flag.StringVar(&addr, "e", ":4040", "service endpoint [ip addr or socket path]")
 	flag.StringVar(&network, "n", "tcp", "network protocol [tcp,unix]")
+	flag.StringVar(&peerUIDs, "peer-uid", "", "users allowed to connect to the unix socket")
+	flag.StringVar(&peerGIDs, "peer-gid", "", "groups allowed to connect to the unix socket")
 	flag.Parse()
 
 	// validate network
@@ -30,9 +44,28 @@ func main() {
 		os.Exit(1)
 	}
 
+	// users and groups allowed to connect over unix sockets
+	var opts unixsock.Options
+	var err error
+	if opts.AllowUIDs, err = unixsock.ParseIDs(peerUIDs, true); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if opts.AllowGIDs, err = unixsock.ParseIDs(peerGIDs, false); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	// announce service using the Listen function
 	// which creates a generic Listen listener.
-	l, err := net.Listen(network, addr)
+	// Unix sockets are created with package unixsock
+	// which checks the credentials of the clients.
+	var l net.Listener
+	if network == "unix" {
+		l, err = unixsock.Listen(addr, opts)
+	} else {
+		l, err = net.Listen(network, addr)
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -45,13 +78,13 @@ func main() {
 		// use Listener to block and wait for connection
 		// request using function Accept() which then
 		// creates a generic Conn value.
-		conn, err := l.Accept()
+		// Errors that leave the listener usable are retried.
+		conn, err := backoff.Accept(l.Accept, backoff.Policy{Max: time.Second}, log.Printf)
 		if err != nil {
-			fmt.Println("failed to accept conn:", err)
-			conn.Close()
-			continue
+			fmt.Println("failed to accept connection:", err)
+			return
 		}
-		fmt.Println("connected to: ", conn.RemoteAddr())
+		fmt.Println("connected to: ", unixsock.Peer(conn))
 
 		go handleConnection(conn)
 	}
@@ -72,6 +105,7 @@ func handleConnection(conn net.Conn) {
 	}
 
 	// echo buffer
+	fmt.Printf("%s: echo %d bytes\n", unixsock.Peer(conn), n)
 	w, err := conn.Write(buf[:n])
 	if err != nil {
 		fmt.Println("failed to write to client:", err)
@@ -0,0 +1,347 @@
+package ntp
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Symmetric-key authentication (RFC 5905, RFC 8573).  A message
+// authentication code (MAC) follows the 48 bytes of the packet: the
+// 32-bit ID of a key shared by the client and the server, then the
+// digest of the packet with that key.
+//
+//    0                   1                   2                   3
+//    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                 NTP packet (48 bytes, see Packet)             |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                            Key ID                             |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                    Digest (16 or 20 bytes)                    |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// A MAC made of a zero key ID alone is a crypto-NAK: the answer of a
+// server that could not authenticate the request.
+
+// Key types, named as in the keys file of ntpd
+const (
+	// KeySHA1 digests the key followed by the packet with SHA-1
+	KeySHA1 = "SHA1"
+
+	// KeyAES128CMAC is the AES-CMAC of the packet (RFC 4493) with a
+	// 128-bit key, recommended by RFC 8573
+	KeyAES128CMAC = "AES128CMAC"
+)
+
+// MaxKeyID is the largest key ID of a keys file
+const MaxKeyID = 65535
+
+var (
+	// ErrNoMAC is returned when verifying a packet without a MAC
+	ErrNoMAC = errors.New("ntp: packet not authenticated")
+
+	// ErrCryptoNAK is returned when verifying a crypto-NAK
+	ErrCryptoNAK = errors.New("ntp: crypto-NAK, the server could not authenticate the request")
+
+	// ErrBadMAC is returned when the digest of a packet does not match
+	ErrBadMAC = errors.New("ntp: bad MAC")
+)
+
+// UnknownKeyError is returned when verifying a packet authenticated
+// with a key that is not known
+type UnknownKeyError struct {
+	ID uint32
+}
+
+func (e *UnknownKeyError) Error() string {
+	return fmt.Sprintf("ntp: unknown key %d", e.ID)
+}
+
+// Authenticator authenticates the requests of a client and verifies
+// the responses of the server, with a Key or with NTS (see package nts)
+type Authenticator interface {
+	// AuthenticateRequest returns the data of a request followed by
+	// its authentication
+	AuthenticateRequest(data []byte) ([]byte, error)
+
+	// VerifyResponse checks a response to the last request.  A
+	// negative acknowledgment (NAK) of the request, which is a
+	// kiss-o'-death reported by CheckResponse, is not an error.  A NAK
+	// is not authenticated, so it must not change the state of the
+	// authenticator: it may be spoofed.
+	VerifyResponse(data []byte) error
+}
+
+// Key is a symmetric key shared by a client and a server
+type Key struct {
+	ID     uint32
+	Type   string
+	Secret []byte
+}
+
+// NewKey returns a key after checking its type and length
+func NewKey(id uint32, typ string, secret []byte) (*Key, error) {
+	if id == 0 {
+		return nil, errors.New("ntp: key ID 0 is reserved")
+	}
+	switch typ = strings.ToUpper(typ); typ {
+	case KeySHA1:
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("ntp: key %d: empty key", id)
+		}
+	case KeyAES128CMAC:
+		if len(secret) != 16 {
+			return nil, fmt.Errorf("ntp: key %d: %s needs a 16-byte key, got %d", id, typ, len(secret))
+		}
+	case "M", "MD5":
+		return nil, fmt.Errorf("ntp: key %d: MD5 is not supported (RFC 8573), use %s", id, KeyAES128CMAC)
+	default:
+		return nil, fmt.Errorf("ntp: key %d: unsupported type %s", id, typ)
+	}
+	return &Key{ID: id, Type: typ, Secret: secret}, nil
+}
+
+// Digest returns the digest of data with the key
+func (k *Key) Digest(data []byte) []byte {
+	if k.Type == KeyAES128CMAC {
+		return cmac(k.Secret, data)
+	}
+	h := sha1.New()
+	h.Write(k.Secret)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// Sign returns the packet data followed by its MAC
+func (k *Key) Sign(data []byte) []byte {
+	signed := make([]byte, len(data), len(data)+4+sha1.Size)
+	copy(signed, data)
+	signed = binary.BigEndian.AppendUint32(signed, k.ID)
+	return append(signed, k.Digest(data)...)
+}
+
+// Verify checks that data, a packet followed by a MAC, is authenticated
+// with the key
+func (k *Key) Verify(data []byte) error {
+	pkt, id, digest, err := splitMAC(data)
+	if err != nil {
+		return err
+	}
+	if id != k.ID {
+		return &UnknownKeyError{ID: id}
+	}
+	if subtle.ConstantTimeCompare(digest, k.Digest(pkt)) != 1 {
+		return ErrBadMAC
+	}
+	return nil
+}
+
+// AuthenticateRequest signs a request, see Authenticator
+func (k *Key) AuthenticateRequest(data []byte) ([]byte, error) {
+	return k.Sign(data), nil
+}
+
+// VerifyResponse verifies the MAC of a response, a crypto-NAK passes,
+// see Authenticator.  A response with the time never passes without a
+// valid MAC.
+func (k *Key) VerifyResponse(data []byte) error {
+	if IsCryptoNAK(data) {
+		return nil
+	}
+	return k.Verify(data)
+}
+
+func (k *Key) String() string {
+	return fmt.Sprintf("%d (%s)", k.ID, k.Type)
+}
+
+// Keys are the keys of a keys file, by ID
+type Keys map[uint32]*Key
+
+// Verify checks that data, a packet followed by a MAC, is authenticated
+// with one of the keys, and returns that key
+func (ks Keys) Verify(data []byte) (*Key, error) {
+	_, id, _, err := splitMAC(data)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := ks[id]
+	if !ok {
+		return nil, &UnknownKeyError{ID: id}
+	}
+	return k, k.Verify(data)
+}
+
+// splitMAC splits data into the packet, the key ID and the digest of
+// its MAC.  Extension fields are not supported: the MAC must follow
+// the 48 bytes of the packet.
+func splitMAC(data []byte) (pkt []byte, id uint32, digest []byte, err error) {
+	if len(data) < PacketSize {
+		return nil, 0, nil, ErrShortPacket
+	}
+	pkt, mac := data[:PacketSize], data[PacketSize:]
+	switch len(mac) {
+	case 0:
+		return nil, 0, nil, ErrNoMAC
+	case 4:
+		if binary.BigEndian.Uint32(mac) == 0 {
+			return nil, 0, nil, ErrCryptoNAK
+		}
+	case 4 + 16, 4 + sha1.Size:
+		return pkt, binary.BigEndian.Uint32(mac), mac[4:], nil
+	}
+	return nil, 0, nil, fmt.Errorf("ntp: invalid MAC of %d bytes", len(mac))
+}
+
+// IsCryptoNAK reports whether data is a crypto-NAK: a kiss-o'-death
+// CRYP followed by a zero key ID, see CryptoNAK
+func IsCryptoNAK(data []byte) bool {
+	if _, _, _, err := splitMAC(data); err != ErrCryptoNAK {
+		return false
+	}
+	var p Packet
+	return p.UnmarshalBinary(data) == nil && p.Stratum == 0 && p.ReferenceID == RefID("CRYP")
+}
+
+// CryptoNAK returns the answer to a request that failed authentication:
+// a kiss-o'-death CRYP, so that it cannot be taken for the time, with
+// a crypto-NAK
+func CryptoNAK(req *Packet) ([]byte, error) {
+	data, err := Kiss(req, "CRYP").MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(data, 0, 0, 0, 0), nil
+}
+
+// LoadKeys reads a keys file, see ParseKeys
+func LoadKeys(path string) (Keys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys, err := ParseKeys(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// LoadKey returns the key id of the keys file path
+func LoadKey(path string, id uint32) (*Key, error) {
+	keys, err := LoadKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, &UnknownKeyError{ID: id})
+	}
+	return key, nil
+}
+
+// ParseKeys reads keys in the format of the ntp.keys file of ntpd, one
+// key per line, # starting a comment:
+//
+//	# id  type        key
+//	1     SHA1        0f5e0b9c4b2ce4bdb8a3b52e9f7d1c3a40a2f6d1
+//	2     AES128CMAC  8c0e3ad96e2d3f7bb4c2ac3f0d9b1e55
+//	3     SHA1        passphrase
+//
+// A key longer than 20 characters is in hexadecimal, a shorter one is
+// the ASCII key itself.  The addresses that may follow the key in ntpd
+// files are ignored.
+func ParseKeys(r io.Reader) (Keys, error) {
+	keys := make(Keys)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: want key ID, type and key", line)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || id > MaxKeyID {
+			return nil, fmt.Errorf("line %d: invalid key ID %q", line, fields[0])
+		}
+		secret := []byte(fields[2])
+		if len(secret) > 20 {
+			if secret, err = hex.DecodeString(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid hexadecimal key", line)
+			}
+		}
+		key, err := NewKey(uint32(id), fields[1], secret)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, dup := keys[key.ID]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %d", line, key.ID)
+		}
+		keys[key.ID] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// cmac returns the AES-CMAC of msg (RFC 4493)
+func cmac(key, msg []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // NewKey checks the key length
+	}
+
+	// subkeys k1 and k2 derive from the encrypted zero block
+	var k1, k2, x [aes.BlockSize]byte
+	block.Encrypt(k1[:], k1[:])
+	double(&k1)
+	k2 = k1
+	double(&k2)
+
+	// all blocks but the last are chained as in CBC, the last block is
+	// masked with k1 when complete, or padded and masked with k2
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x[:], x[:], msg[i*aes.BlockSize:])
+		block.Encrypt(x[:], x[:])
+	}
+	var last [aes.BlockSize]byte
+	rest := msg[(n-1)*aes.BlockSize:]
+	copy(last[:], rest)
+	if len(rest) == aes.BlockSize {
+		subtle.XORBytes(last[:], last[:], k1[:])
+	} else {
+		last[len(rest)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	block.Encrypt(x[:], x[:])
+	return x[:]
+}
+
+// double multiplies b by x in GF(2^128), for the CMAC subkeys
+func double(b *[aes.BlockSize]byte) {
+	carry := b[0] >> 7
+	for i := 0; i < aes.BlockSize-1; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[aes.BlockSize-1] = b[aes.BlockSize-1]<<1 ^ carry*0x87
+}
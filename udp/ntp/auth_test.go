@@ -0,0 +1,216 @@
+package ntp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 4493, section 4
+func TestCMAC(t *testing.T) {
+	key := unhex(t, "2b7e1516 28aed2a6 abf71588 09cf4f3c")
+	msg := unhex(t, "6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51"+
+		"30c81c46 a35ce411 e5fbc119 1a0a52ef f69f2445 df4f9b17 ad2b417b e66c3710")
+
+	var k1 [aes.BlockSize]byte
+	block, _ := aes.NewCipher(key)
+	block.Encrypt(k1[:], k1[:])
+	double(&k1)
+	k2 := k1
+	double(&k2)
+	if want := unhex(t, "fbeed618 35713366 7c85e08f 7236a8de"); !bytes.Equal(k1[:], want) {
+		t.Errorf("K1 %x, want %x", k1, want)
+	}
+	if want := unhex(t, "f7ddac30 6ae266cc f90bc11e e46d513b"); !bytes.Equal(k2[:], want) {
+		t.Errorf("K2 %x, want %x", k2, want)
+	}
+
+	tests := []struct {
+		len int
+		mac string
+	}{
+		{0, "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{16, "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{40, "dfa66747 de9ae630 30ca3261 1497c827"},
+		{64, "51f0bebf 7e3b9d92 fc497417 79363cfe"},
+	}
+	for _, tt := range tests {
+		if got, want := cmac(key, msg[:tt.len]), unhex(t, tt.mac); !bytes.Equal(got, want) {
+			t.Errorf("message of %d bytes: got %x, want %x", tt.len, got, want)
+		}
+	}
+}
+
+func TestDigestSHA1(t *testing.T) {
+	// the digest is SHA-1 of the key followed by the data (FIPS 180 "abc")
+	key, err := NewKey(1, KeySHA1, []byte("ab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := key.Digest([]byte("c")), unhex(t, "a9993e36 4706816a ba3e2571 7850c26c 9cd0d89d"); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader(`
+# id  type        key
+1     SHA1        0f5e0b9c4b2ce4bdb8a3b52e9f7d1c3a40a2f6d1
+2     AES128CMAC  8c0e3ad96e2d3f7bb4c2ac3f0d9b1e55   # hex, 32 digits
+3     sha1        passphrase  192.0.2.1
+4     AES128CMAC  0123456789abcdef
+5     SHA1        01234567890123456789
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint32]struct {
+		typ    string
+		secret string
+	}{
+		1: {KeySHA1, "\x0f\x5e\x0b\x9c\x4b\x2c\xe4\xbd\xb8\xa3\xb5\x2e\x9f\x7d\x1c\x3a\x40\xa2\xf6\xd1"},
+		2: {KeyAES128CMAC, "\x8c\x0e\x3a\xd9\x6e\x2d\x3f\x7b\xb4\xc2\xac\x3f\x0d\x9b\x1e\x55"},
+		3: {KeySHA1, "passphrase"},
+		4: {KeyAES128CMAC, "0123456789abcdef"}, // 16 characters, ASCII
+		5: {KeySHA1, "01234567890123456789"},   // 20 characters, ASCII
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(want))
+	}
+	for id, w := range want {
+		k := keys[id]
+		if k == nil || k.ID != id || k.Type != w.typ || string(k.Secret) != w.secret {
+			t.Errorf("key %d: got %+v, want %s %x", id, k, w.typ, w.secret)
+		}
+	}
+
+	bad := []struct {
+		name, keys string
+	}{
+		{"missing key", "1 SHA1"},
+		{"key ID 0", "0 SHA1 secret"},
+		{"key ID too large", "65536 SHA1 secret"},
+		{"key ID not a number", "one SHA1 secret"},
+		{"MD5", "1 MD5 secret"},
+		{"MD5 abbreviated", "1 M secret"},
+		{"unknown type", "1 SHA256 secret"},
+		{"bad hexadecimal", "1 SHA1 0f5e0b9c4b2ce4bdb8a3b52e9f7d1c3a40a2f6zz"},
+		{"AES key too short", "1 AES128CMAC secret"},
+		{"duplicate", "1 SHA1 secret\n1 SHA1 other"},
+	}
+	for _, tt := range bad {
+		if _, err := ParseKeys(strings.NewReader(tt.keys)); err == nil {
+			t.Errorf("%s: keys accepted", tt.name)
+		}
+	}
+}
+
+func TestSplitMAC(t *testing.T) {
+	pkt, _ := NewRequest(time.Now()).MarshalBinary()
+	with := func(mac ...byte) []byte {
+		return append(append([]byte(nil), pkt...), mac...)
+	}
+	invalid := errors.New("invalid MAC")
+	tests := []struct {
+		name   string
+		data   []byte
+		id     uint32
+		digest int
+		err    error
+	}{
+		{"short packet", pkt[:PacketSize-1], 0, 0, ErrShortPacket},
+		{"no MAC", pkt, 0, 0, ErrNoMAC},
+		{"crypto-NAK", with(0, 0, 0, 0), 0, 0, ErrCryptoNAK},
+		{"key ID alone", with(0, 0, 0, 1), 0, 0, invalid},
+		{"AES-CMAC", with(append([]byte{0, 0, 0, 2}, make([]byte, 16)...)...), 2, 16, nil},
+		{"SHA-1", with(append([]byte{0, 0, 1, 0}, make([]byte, 20)...)...), 256, 20, nil},
+		{"truncated digest", with(append([]byte{0, 0, 0, 2}, make([]byte, 15)...)...), 0, 0, invalid},
+	}
+	for _, tt := range tests {
+		got, id, digest, err := splitMAC(tt.data)
+		switch {
+		case tt.err == invalid:
+			if err == nil || err == ErrCryptoNAK || err == ErrNoMAC {
+				t.Errorf("%s: got %v, want an invalid MAC", tt.name, err)
+			}
+			continue
+		case tt.err != nil:
+			if err != tt.err {
+				t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, pkt) || id != tt.id || len(digest) != tt.digest {
+			t.Errorf("%s: got key %d, %d-byte digest, %v", tt.name, id, len(digest), err)
+		}
+	}
+}
+
+func TestCryptoNAK(t *testing.T) {
+	req := NewRequest(time.Now())
+	nak, err := CryptoNAK(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsCryptoNAK(nak) {
+		t.Fatal("crypto-NAK not recognized")
+	}
+	key, _ := NewKey(1, KeyAES128CMAC, []byte("0123456789abcdef"))
+	if err := key.VerifyResponse(nak); err != nil {
+		t.Errorf("VerifyResponse: %v", err)
+	}
+	if err := key.Verify(nak); !errors.Is(err, ErrCryptoNAK) {
+		t.Errorf("Verify: got %v, want %v", err, ErrCryptoNAK)
+	}
+
+	// a zero key ID after the time, or after another kiss code, is not a
+	// crypto-NAK
+	rsp, _ := LocalClock(time.Now()).Respond(req, time.Now()).MarshalBinary()
+	deny, _ := Kiss(req, "DENY").MarshalBinary()
+	for _, data := range [][]byte{append(rsp, 0, 0, 0, 0), append(deny, 0, 0, 0, 0)} {
+		if IsCryptoNAK(data) {
+			t.Errorf("%x taken for a crypto-NAK", data)
+		}
+		if err := key.VerifyResponse(data); err == nil {
+			t.Errorf("%x passed without MAC", data)
+		}
+	}
+}
+
+func TestKeysVerify(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader("1 AES128CMAC 0123456789abcdef\n2 SHA1 secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, _ := NewRequest(time.Now()).MarshalBinary()
+	signed := keys[2].Sign(pkt)
+	if k, err := keys.Verify(signed); err != nil || k != keys[2] {
+		t.Fatalf("got key %v, %v, want key 2", k, err)
+	}
+
+	signed[len(signed)-1] ^= 1
+	if _, err := keys.Verify(signed); !errors.Is(err, ErrBadMAC) {
+		t.Errorf("corrupted digest: got %v, want %v", err, ErrBadMAC)
+	}
+	other, _ := NewKey(3, KeySHA1, []byte("secret"))
+	var unknown *UnknownKeyError
+	if _, err := keys.Verify(other.Sign(pkt)); !errors.As(err, &unknown) || unknown.ID != 3 {
+		t.Errorf("unknown key: got %v", err)
+	}
+	if err := keys[1].Verify(keys[2].Sign(pkt)); !errors.As(err, &unknown) {
+		t.Errorf("other key: got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package ntp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Broadcast mode (RFC 5905): a server periodically sends its time to an
+// IPv4 broadcast address or an IP multicast group, such as 224.0.1.1
+// (ntp.mcast.net) or ff02::101, and clients listen without sending
+// requests.  A broadcast carries only the transmit time of the server
+// (T3), so a client measures the delay δ once in client/server mode
+// (calibration), then computes its offset from each broadcast received
+// at time T4 as T3 + δ/2 - T4.
+
+// Broadcast returns the broadcast packet of the clock, sent every 2^poll
+// seconds.  Like Respond, it is stamped with the current time, so the
+// caller should send it right away.
+func (c *Clock) Broadcast(poll int8) *Packet {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	return &Packet{
+		Leap:           c.Leap,
+		Version:        Version,
+		Mode:           ModeBroadcast,
+		Stratum:        c.Stratum,
+		Poll:           poll,
+		Precision:      c.Precision,
+		RootDelay:      c.RootDelay,
+		RootDispersion: c.RootDispersion,
+		ReferenceID:    c.ReferenceID,
+		ReferenceTime:  TimestampOf(c.ReferenceTime),
+		TransmitTime:   TimestampOf(now().Add(c.Offset)),
+	}
+}
+
+// CheckBroadcast validates a broadcast packet received by a client
+func (p *Packet) CheckBroadcast() error {
+	if p.Mode != ModeBroadcast {
+		return fmt.Errorf("ntp: unexpected %s packet", p.Mode)
+	}
+	if p.Stratum == 0 || p.Stratum > MaxStratum {
+		return fmt.Errorf("ntp: server not synchronized (stratum %d)", p.Stratum)
+	}
+	if p.Leap == LeapNotInSync {
+		return errors.New("ntp: server clock not synchronized")
+	}
+	if p.TransmitTime.IsZero() {
+		return errors.New("ntp: broadcast without transmit time")
+	}
+	return nil
+}
+
+// BroadcastSample returns the sample of a broadcast received at time
+// dst (T4), from a server at the round trip delay measured by the
+// calibration
+func BroadcastSample(p *Packet, dst time.Time, delay time.Duration) Sample {
+	return Sample{
+		Offset:   p.TransmitTime.Time().Sub(dst) + delay/2,
+		Delay:    delay,
+		Response: p,
+		Received: dst,
+	}
+}
+
+// ListenBroadcast returns a connection receiving the broadcasts sent to
+// group (host:port): it joins a multicast group on interface ifi (the
+// system default if nil), or listens on the port for IPv4 broadcasts.
+func ListenBroadcast(group string, ifi *net.Interface) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IP.IsMulticast() {
+		return net.ListenMulticastUDP("udp", ifi, addr)
+	}
+	return net.ListenUDP("udp4", &net.UDPAddr{Port: addr.Port})
+}
+
+// maxBroadcastServers bounds the servers a BroadcastReceiver tracks
+const maxBroadcastServers = 64
+
+// BroadcastReceiver reads the broadcasts received on Conn.  Like RFC
+// 5905 does for duplicate packets, it skips the broadcasts whose
+// transmit time does not advance past the last one of their server, so
+// that a broadcast captured on the network, even authenticated, cannot
+// be replayed to set back the clock of the client.
+type BroadcastReceiver struct {
+	Conn net.PacketConn
+
+	// Key, if not nil, authenticates the broadcasts: the others are
+	// skipped like stray packets (a broadcast cannot be a crypto-NAK)
+	Key *Key
+
+	// Now returns the current time, default time.Now
+	Now func() time.Time
+
+	last map[string]Timestamp // transmit time by server IP address
+}
+
+// Read waits until timeout (none if 0) for a valid broadcast, and
+// returns its sample for the calibrated delay and the address of its
+// server
+func (r *BroadcastReceiver) Read(delay, timeout time.Duration) (Sample, net.Addr, error) {
+	now := r.Now
+	if now == nil {
+		now = time.Now
+	}
+	if r.last == nil {
+		r.last = make(map[string]Timestamp)
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := r.Conn.SetReadDeadline(deadline); err != nil {
+		return Sample{}, nil, err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := r.Conn.ReadFrom(buf)
+		if err != nil {
+			return Sample{}, nil, err
+		}
+		dst := now()
+		var p Packet
+		if err := p.UnmarshalBinary(buf[:n]); err != nil {
+			continue
+		}
+		if p.CheckBroadcast() != nil {
+			continue
+		}
+		if r.Key != nil && r.Key.Verify(buf[:n]) != nil {
+			continue
+		}
+		server := addr.String()
+		if udpAddr, ok := addr.(*net.UDPAddr); ok {
+			server = udpAddr.IP.String() // servers may send from any port
+		}
+		last, known := r.last[server]
+		if known && p.TransmitTime <= last {
+			continue // duplicate or replay
+		}
+		if !known && len(r.last) >= maxBroadcastServers {
+			continue
+		}
+		r.last[server] = p.TransmitTime
+		return BroadcastSample(&p, dst, delay), addr, nil
+	}
+}
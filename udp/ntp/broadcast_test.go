@@ -0,0 +1,187 @@
+package ntp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// loopbackGroup listens to a link-local multicast group on the loopback
+// interface, and returns a connection sending to it, or skips the test
+// when the host has no multicast route on loopback
+func loopbackGroup(t *testing.T) (recv *net.UDPConn, send net.PacketConn, group *net.UDPAddr) {
+	t.Helper()
+	ifi, err := loopback()
+	if err != nil {
+		t.Skip("no loopback interface:", err)
+	}
+	recv, err = ListenBroadcast("224.0.0.187:0", ifi)
+	if err != nil {
+		t.Skip("cannot join a multicast group on loopback:", err)
+	}
+	t.Cleanup(func() { recv.Close() })
+	group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 187), Port: recv.LocalAddr().(*net.UDPAddr).Port}
+
+	send, err = net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { send.Close() })
+	if err := SetMulticastInterface(send, ifi, group); err != nil {
+		t.Skip("cannot send to a multicast group on loopback:", err)
+	}
+	return recv, send, group
+}
+
+// loopback returns the loopback interface
+func loopback() (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, net.UnknownNetworkError("loopback")
+}
+
+// broadcast sends the data to group, or skips the test when the host
+// cannot route it
+func broadcast(t *testing.T, send net.PacketConn, group *net.UDPAddr, data []byte) {
+	t.Helper()
+	if _, err := send.WriteTo(data, group); err != nil {
+		t.Skip("no multicast route on loopback:", err)
+	}
+}
+
+func TestBroadcastLoopback(t *testing.T) {
+	recv, send, group := loopbackGroup(t)
+	clock := LocalClock(time.Now())
+	clock.Offset = 20 * time.Millisecond
+	data, err := clock.Broadcast(4).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcast(t, send, group, data)
+
+	delay := 2 * time.Millisecond
+	sample, from, err := (&BroadcastReceiver{Conn: recv}).Read(delay, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != send.LocalAddr().String() {
+		t.Errorf("broadcast from %s, want %s", from, send.LocalAddr())
+	}
+	if sample.Response.Mode != ModeBroadcast || sample.Response.Poll != 4 || sample.Delay != delay {
+		t.Errorf("sample %+v of packet %v", sample, sample.Response)
+	}
+
+	// the offset is the server clock plus half the delay, the time on
+	// the way over loopback is negligible
+	want := clock.Offset + delay/2
+	if d := sample.Offset - want; d < -5*time.Millisecond || d > time.Millisecond {
+		t.Errorf("offset %v, want about %v", sample.Offset, want)
+	}
+}
+
+func TestBroadcastAuthenticated(t *testing.T) {
+	recv, send, group := loopbackGroup(t)
+	key, err := NewKey(1, KeyAES128CMAC, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := LocalClock(time.Now())
+	data, err := clock.Broadcast(6).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// requests, unsigned broadcasts and forged crypto-NAKs are skipped
+	req, _ := NewRequest(time.Now()).MarshalBinary()
+	broadcast(t, send, group, req)
+	broadcast(t, send, group, data)
+	broadcast(t, send, group, append(append([]byte(nil), data...), 0, 0, 0, 0))
+	broadcast(t, send, group, key.Sign(data))
+
+	receiver := &BroadcastReceiver{Conn: recv, Key: key}
+	sample, _, err := receiver.Read(0, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.Response.Poll != 6 {
+		t.Errorf("got %v", sample.Response)
+	}
+
+	// nothing else is accepted
+	if _, _, err := receiver.Read(0, 100*time.Millisecond); err == nil {
+		t.Error("accepted a second broadcast")
+	}
+}
+
+func TestBroadcastReplay(t *testing.T) {
+	recv, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback:", err)
+	}
+	defer recv.Close()
+	send, err := net.Dial("udp4", recv.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer send.Close()
+	key, err := NewKey(1, KeyAES128CMAC, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := LocalClock(time.Now())
+	signed := func(at time.Time) []byte {
+		clock.Now = func() time.Time { return at }
+		data, _ := clock.Broadcast(6).MarshalBinary()
+		return key.Sign(data)
+	}
+	start := time.Now()
+	first, second := signed(start), signed(start.Add(time.Second))
+
+	receiver := &BroadcastReceiver{Conn: recv, Key: key}
+	send.Write(first)
+	if _, _, err := receiver.Read(0, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same broadcast again, then an older one, are skipped
+	send.Write(first)
+	send.Write(signed(start.Add(-time.Second)))
+	send.Write(second)
+	sample, _, err := receiver.Read(0, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := TimestampOf(start.Add(time.Second)); sample.Response.TransmitTime != want {
+		t.Errorf("got the broadcast sent at %v, want %v", sample.Response.TransmitTime.Time(), want.Time())
+	}
+	send.Write(second)
+	if _, _, err := receiver.Read(0, 100*time.Millisecond); err == nil {
+		t.Error("replayed broadcast accepted")
+	}
+}
+
+func TestCheckBroadcast(t *testing.T) {
+	ok := LocalClock(time.Now()).Broadcast(6)
+	if err := ok.CheckBroadcast(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Packet{
+		{Version: Version, Mode: ModeServer, Stratum: 2, TransmitTime: 1},
+		{Version: Version, Mode: ModeBroadcast, Stratum: 0, TransmitTime: 1},
+		{Version: Version, Mode: ModeBroadcast, Stratum: 16, TransmitTime: 1},
+		{Version: Version, Mode: ModeBroadcast, Stratum: 2, Leap: LeapNotInSync, TransmitTime: 1},
+		{Version: Version, Mode: ModeBroadcast, Stratum: 2},
+	} {
+		if err := p.CheckBroadcast(); err == nil {
+			t.Errorf("%v: accepted", &p)
+		}
+	}
+}
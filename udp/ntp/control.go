@@ -0,0 +1,209 @@
+package ntp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
+)
+
+// Stats counts the packets received by a server, the responses and the
+// dropped packets by cause.  The responses sent include the
+// kiss-o'-death: RATE, and the NAKs of requests that failed
+// authentication, CRYP and NTSN.
+type Stats struct {
+	Received, Sent, Broadcasts                            atomic.Int64
+	KissRate, KissCryp, KissNTSN                          atomic.Int64
+	RateLimited, QueueFull, Invalid, Oversize, SendErrors atomic.Int64
+}
+
+// dropReason names a counter of dropped packets, for the metrics
+type dropReason struct {
+	name    string
+	counter *atomic.Int64
+}
+
+func (s *Stats) dropReasons() []dropReason {
+	return []dropReason{
+		{"rate_limited", &s.RateLimited},
+		{"queue_full", &s.QueueFull},
+		{"invalid", &s.Invalid},
+		{"oversize", &s.Oversize},
+		{"send_error", &s.SendErrors},
+	}
+}
+
+// Dropped returns the number of packets dropped
+func (s *Stats) Dropped() int64 {
+	var n int64
+	for _, r := range s.dropReasons() {
+		n += r.counter.Load()
+	}
+	return n
+}
+
+// Control serves the counters and the clients of a server: to
+// Prometheus over HTTP, and to ntpq (see udp/ntpq) over a control
+// socket, one command per connection: stats, mrulist or metrics.
+type Control struct {
+	Stats   *Stats
+	Monitor *Monitor
+	Limiter *Limiter
+
+	// QueueLen returns the number of requests waiting for a worker,
+	// nil for none
+	QueueLen func() int
+
+	// Started is the start time of the server, and Now returns the
+	// current time, time.Now if nil
+	Started time.Time
+	Now     func() time.Time
+
+	// Logger receives the errors of the control socket, default
+	// log.Printf
+	Logger *log.Logger
+}
+
+func (c *Control) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *Control) queueLen() int {
+	if c.QueueLen != nil {
+		return c.QueueLen()
+	}
+	return 0
+}
+
+func (c *Control) logf(format string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// ServeHTTP serves the metrics, i.e. at /metrics
+func (c *Control) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WriteMetrics(w)
+}
+
+// WriteMetrics writes the counters in the Prometheus text format
+func (c *Control) WriteMetrics(w io.Writer) {
+	s := c.Stats
+	family := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	family("ntps_requests_total", "counter", "Packets received.")
+	fmt.Fprintf(w, "ntps_requests_total %d\n", s.Received.Load())
+	family("ntps_responses_total", "counter", "Responses sent, kiss-o'-death included.")
+	fmt.Fprintf(w, "ntps_responses_total %d\n", s.Sent.Load())
+	family("ntps_kisses_total", "counter", "Kiss-o'-death responses sent, by code.")
+	fmt.Fprintf(w, "ntps_kisses_total{code=\"RATE\"} %d\n", s.KissRate.Load())
+	fmt.Fprintf(w, "ntps_kisses_total{code=\"CRYP\"} %d\n", s.KissCryp.Load())
+	fmt.Fprintf(w, "ntps_kisses_total{code=\"NTSN\"} %d\n", s.KissNTSN.Load())
+	family("ntps_dropped_total", "counter", "Packets dropped, by reason.")
+	for _, r := range s.dropReasons() {
+		fmt.Fprintf(w, "ntps_dropped_total{reason=%q} %d\n", r.name, r.counter.Load())
+	}
+	family("ntps_broadcasts_total", "counter", "Broadcast packets sent.")
+	fmt.Fprintf(w, "ntps_broadcasts_total %d\n", s.Broadcasts.Load())
+	family("ntps_clients", "gauge", "Clients in the MRU list.")
+	fmt.Fprintf(w, "ntps_clients %d\n", c.Monitor.Len())
+	family("ntps_rate_limited_clients", "gauge", "Clients tracked by the rate limiter.")
+	fmt.Fprintf(w, "ntps_rate_limited_clients %d\n", c.Limiter.Clients())
+	family("ntps_queue_length", "gauge", "Requests waiting for a worker.")
+	fmt.Fprintf(w, "ntps_queue_length %d\n", c.queueLen())
+	family("ntps_start_time_seconds", "gauge", "Start time of the server since the Unix epoch.")
+	fmt.Fprintf(w, "ntps_start_time_seconds %d\n", c.Started.Unix())
+}
+
+// WriteStats writes the counters like ntpq -c sysstats
+func (c *Control) WriteStats(w io.Writer) {
+	s := c.Stats
+	fmt.Fprintf(w, "uptime:                 %d\n", int64(c.now().Sub(c.Started).Seconds()))
+	fmt.Fprintf(w, "received packets:       %d\n", s.Received.Load())
+	fmt.Fprintf(w, "responses sent:         %d\n", s.Sent.Load())
+	fmt.Fprintf(w, "kiss-o'-death RATE:     %d\n", s.KissRate.Load())
+	fmt.Fprintf(w, "kiss-o'-death CRYP:     %d\n", s.KissCryp.Load())
+	fmt.Fprintf(w, "kiss-o'-death NTSN:     %d\n", s.KissNTSN.Load())
+	fmt.Fprintf(w, "broadcasts sent:        %d\n", s.Broadcasts.Load())
+	fmt.Fprintf(w, "dropped packets:        %d\n", s.Dropped())
+	for _, r := range s.dropReasons() {
+		fmt.Fprintf(w, "  %-21s %d\n", strings.ReplaceAll(r.name, "_", " ")+":", r.counter.Load())
+	}
+	fmt.Fprintf(w, "clients listed:         %d\n", c.Monitor.Len())
+	fmt.Fprintf(w, "queue length:           %d\n", c.queueLen())
+}
+
+// WriteMRU writes the clients like ntpq -c mrulist, the most recent
+// first: seconds since the last packet and average interval, mode,
+// version, packets received, dropped and kissed, port and address
+func (c *Control) WriteMRU(w io.Writer) {
+	now := c.now()
+	fmt.Fprintln(w, "lstint avgint m v    count  dropped   kissed rport remote address")
+	fmt.Fprintln(w, "=================================================================")
+	for _, cl := range c.Monitor.Clients() {
+		fmt.Fprintf(w, "%6d %6d %d %d %8d %8d %8d %5d %s\n",
+			int64(now.Sub(cl.Last).Seconds()), int64(cl.Interval().Seconds()),
+			cl.Mode, cl.Version, cl.Count, cl.Dropped, cl.Kisses, cl.Port, cl.Addr)
+	}
+}
+
+// Serve answers the control commands of the connections accepted on ln,
+// until ln is closed.  Other accept errors, such as running out of file
+// descriptors, are retried with a backoff.
+func (c *Control) Serve(ln net.Listener) error {
+	retry := backoff.New(backoff.Policy{Initial: 10 * time.Millisecond, Max: time.Second})
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			delay, _ := retry.Next()
+			c.logf("control socket: accept failed, retrying in %v: %v", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		retry.Reset()
+		go c.Handle(conn)
+	}
+}
+
+// Handle reads a command line from conn, writes its answer and closes
+// conn
+func (c *Control) Handle(conn net.Conn) {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	w := bufio.NewWriter(conn)
+	defer w.Flush()
+	switch cmd := strings.ToLower(strings.TrimSpace(line)); cmd {
+	case "stats", "sysstats":
+		c.WriteStats(w)
+	case "mrulist", "mru":
+		c.WriteMRU(w)
+	case "metrics":
+		c.WriteMetrics(w)
+	default:
+		fmt.Fprintf(w, "unknown command %q, commands: stats, mrulist, metrics\n", cmd)
+	}
+}
@@ -0,0 +1,187 @@
+package ntp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testControl returns the control of a server that received packets
+// from two clients
+func testControl(t *testing.T) *Control {
+	t.Helper()
+	now := time.Unix(1700000000, 0)
+	c := &Control{
+		Stats:    &Stats{},
+		Monitor:  NewMonitor(10),
+		Limiter:  NewLimiter(1, 1),
+		QueueLen: func() int { return 3 },
+		Started:  now.Add(-time.Hour),
+		Now:      func() time.Time { return now },
+	}
+	req, _ := NewRequest(now).MarshalBinary()
+	a := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1123}
+	b := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 123}
+	for i := 0; i < 3; i++ {
+		at := now.Add(time.Duration(i-3) * 10 * time.Second)
+		c.Monitor.Record(a, req, at)
+		c.Limiter.Allow(a, at)
+		c.Stats.Received.Add(1)
+	}
+	c.Monitor.Record(b, req, now.Add(-5*time.Second))
+	c.Limiter.Allow(b, now.Add(-5*time.Second))
+	c.Stats.Received.Add(1)
+
+	c.Stats.Sent.Add(3)
+	c.Stats.KissRate.Add(1)
+	c.Stats.KissCryp.Add(2)
+	c.Stats.Broadcasts.Add(5)
+	c.Stats.RateLimited.Add(1)
+	c.Stats.Invalid.Add(2)
+	c.Monitor.Drop(a)
+	c.Monitor.Kiss(b)
+	return c
+}
+
+const metricsGolden = `# HELP ntps_requests_total Packets received.
+# TYPE ntps_requests_total counter
+ntps_requests_total 4
+# HELP ntps_responses_total Responses sent, kiss-o'-death included.
+# TYPE ntps_responses_total counter
+ntps_responses_total 3
+# HELP ntps_kisses_total Kiss-o'-death responses sent, by code.
+# TYPE ntps_kisses_total counter
+ntps_kisses_total{code="RATE"} 1
+ntps_kisses_total{code="CRYP"} 2
+ntps_kisses_total{code="NTSN"} 0
+# HELP ntps_dropped_total Packets dropped, by reason.
+# TYPE ntps_dropped_total counter
+ntps_dropped_total{reason="rate_limited"} 1
+ntps_dropped_total{reason="queue_full"} 0
+ntps_dropped_total{reason="invalid"} 2
+ntps_dropped_total{reason="oversize"} 0
+ntps_dropped_total{reason="send_error"} 0
+# HELP ntps_broadcasts_total Broadcast packets sent.
+# TYPE ntps_broadcasts_total counter
+ntps_broadcasts_total 5
+# HELP ntps_clients Clients in the MRU list.
+# TYPE ntps_clients gauge
+ntps_clients 2
+# HELP ntps_rate_limited_clients Clients tracked by the rate limiter.
+# TYPE ntps_rate_limited_clients gauge
+ntps_rate_limited_clients 2
+# HELP ntps_queue_length Requests waiting for a worker.
+# TYPE ntps_queue_length gauge
+ntps_queue_length 3
+# HELP ntps_start_time_seconds Start time of the server since the Unix epoch.
+# TYPE ntps_start_time_seconds gauge
+ntps_start_time_seconds 1699996400
+`
+
+func TestWriteMetrics(t *testing.T) {
+	c := testControl(t)
+	var b bytes.Buffer
+	c.WriteMetrics(&b)
+	if b.String() != metricsGolden {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), metricsGolden)
+	}
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("got content type %q", ct)
+	}
+	if rec.Body.String() != metricsGolden {
+		t.Errorf("got\n%s", rec.Body.String())
+	}
+}
+
+// command sends cmd to c over a pipe, and returns the answer
+func command(t *testing.T, c *Control, cmd string) string {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	go c.Handle(server)
+	if _, err := io.WriteString(client, cmd+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(answer)
+}
+
+func TestHandle(t *testing.T) {
+	c := testControl(t)
+
+	if got := command(t, c, "METRICS "); got != metricsGolden {
+		t.Errorf("metrics: got\n%s", got)
+	}
+
+	stats := command(t, c, "stats")
+	for _, line := range []string{
+		"uptime:                 3600\n",
+		"received packets:       4\n",
+		"kiss-o'-death CRYP:     2\n",
+		"dropped packets:        3\n",
+		"  rate limited:         1\n",
+		"  send error:           0\n",
+		"clients listed:         2\n",
+		"queue length:           3\n",
+	} {
+		if !strings.Contains(stats, line) {
+			t.Errorf("stats: missing %q in\n%s", line, stats)
+		}
+	}
+
+	want := `lstint avgint m v    count  dropped   kissed rport remote address
+=================================================================
+     5      0 3 4        1        0        1   123 2001:db8::1
+    10     10 3 4        3        1        0  1123 192.0.2.1
+`
+	if got := command(t, c, "mrulist"); got != want {
+		t.Errorf("mrulist: got\n%s\nwant\n%s", got, want)
+	}
+
+	if got := command(t, c, "peers"); !strings.HasPrefix(got, `unknown command "peers"`) {
+		t.Errorf("peers: got %q", got)
+	}
+}
+
+func TestServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	c := testControl(t)
+	done := make(chan error)
+	go func() { done <- c.Serve(ln) }()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "metrics\n")
+		answer, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || string(answer) != metricsGolden {
+			t.Errorf("connection %d: got %q, %v", i, answer, err)
+		}
+	}
+
+	ln.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Serve returned nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
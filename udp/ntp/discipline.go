@@ -0,0 +1,221 @@
+package ntp
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Clock discipline parameters, after RFC 5905 and ntpd
+const (
+	// StepThreshold is the offset above which the clock is stepped
+	// instead of slewed
+	StepThreshold = 128 * time.Millisecond
+
+	// MaxFreq bounds the frequency correction, 500 ppm
+	MaxFreq = 500e-6
+
+	// MinPoll and MaxPoll bound the poll exponent, the poll interval
+	// is 2^poll seconds (16s to 36h)
+	MinPoll = 4
+	MaxPoll = 17
+
+	pllGain   = 64  // PLL time constant, in poll intervals (4 * 16)
+	freqWatch = 8   // poll intervals measuring the frequency error
+	maxWatch  = 900 // seconds measuring the frequency error at most
+	fllDamp   = 4   // FLL averaging
+	allanPoll = 11  // poll exponent from which the FLL is used (2048s)
+	pollGate  = 4   // offsets within pollGate * jitter are good
+	pollLimit = 30  // hysteresis of the poll exponent changes
+	avgJitter = 4   // jitter averaging
+)
+
+// DisciplineState is the state of the clock discipline
+type DisciplineState int
+
+const (
+	// StateUnset: no offset measured yet
+	StateUnset DisciplineState = iota
+	// StateFreq: the clock was stepped, the offsets that follow measure the
+	// frequency error
+	StateFreq
+	// StateSync: the clock is disciplined by the PLL/FLL
+	StateSync
+)
+
+func (s DisciplineState) String() string {
+	return [...]string{"unset", "freq", "sync"}[s]
+}
+
+// Discipline steers a VirtualClock to the offsets measured against
+// time servers, with the hybrid phase/frequency-locked loop of NTP:
+//
+//   - the first offset steps the clock, the offset accumulated over the
+//     next few poll intervals measures the frequency error directly
+//   - afterwards, each offset is slewed out and trims the frequency:
+//     the PLL (phase-locked loop) at short poll intervals, and the
+//     FLL (frequency-locked loop) at long poll intervals, where the
+//     oscillator wander dominates
+//   - offsets above StepThreshold step the clock and the frequency is
+//     measured again
+//
+// The poll exponent adapts: it increases while offsets stay within the
+// jitter, and decreases when they do not.
+type Discipline struct {
+	Clock            *VirtualClock
+	MinPoll, MaxPoll int
+
+	mu     sync.Mutex
+	state  DisciplineState
+	poll   int
+	count  int
+	last   time.Time // base time of the last update
+	offset time.Duration
+	jitter time.Duration
+}
+
+// NewDiscipline returns a discipline of clock polling between 2^minPoll
+// and 2^maxPoll seconds
+func NewDiscipline(clock *VirtualClock, minPoll, maxPoll int) *Discipline {
+	if maxPoll < minPoll {
+		maxPoll = minPoll
+	}
+	return &Discipline{Clock: clock, MinPoll: minPoll, MaxPoll: maxPoll, poll: minPoll}
+}
+
+// Poll returns the poll exponent
+func (d *Discipline) Poll() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.poll
+}
+
+// Interval returns the poll interval, 2^poll seconds
+func (d *Discipline) Interval() time.Duration {
+	return time.Duration(int64(1)<<uint(d.Poll())) * time.Second
+}
+
+// State returns the state of the discipline
+func (d *Discipline) State() DisciplineState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// Jitter returns the clock jitter, the average difference between
+// successive offsets
+func (d *Discipline) Jitter() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.jitter
+}
+
+// Update steers the clock to offset, measured against the virtual clock
+// with the given jitter (i.e. from Select).  It returns what was done:
+// "set", "step", "wait" (measuring the frequency), "freq", "pll" or
+// "fll".
+func (d *Discipline) Update(offset, jitter time.Duration) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.Clock.Base()
+	mu := now.Sub(d.last)
+	action := ""
+
+	switch {
+	case d.state == StateUnset || abs(offset) > StepThreshold:
+		// the first or a large offset: the frequency may be wrong too
+		action = "step"
+		if d.state == StateUnset {
+			action = "set"
+		}
+		d.Clock.Step(offset)
+		d.state = StateFreq
+		d.poll, d.count = d.MinPoll, 0
+		d.offset, d.last = 0, now
+		return action
+
+	case d.state == StateFreq:
+		// the offset accumulated since the clock was stepped is the
+		// frequency error, once measured long enough to beat the jitter
+		if mu < d.watch() {
+			return "wait"
+		}
+		d.setFreq(d.Clock.Freq() + offset.Seconds()/mu.Seconds())
+		d.Clock.Slew(offset)
+		d.state = StateSync
+		action = "freq"
+
+	default:
+		if mu <= 0 {
+			action = "pll"
+		} else if d.poll >= allanPoll {
+			d.setFreq(d.Clock.Freq() + offset.Seconds()/mu.Seconds()/fllDamp)
+			action = "fll"
+		} else {
+			tc := float64(pllGain * (int64(1) << uint(d.poll)))
+			d.setFreq(d.Clock.Freq() + offset.Seconds()*mu.Seconds()/(tc*tc))
+			action = "pll"
+		}
+		d.Clock.Slew(offset)
+	}
+
+	// clock jitter, exponential average of the offset differences
+	diff := (offset - d.offset).Seconds()
+	j := d.jitter.Seconds()
+	j = math.Sqrt(j*j + (diff*diff-j*j)/avgJitter)
+	d.jitter = time.Duration(j * float64(time.Second))
+	d.offset, d.last = offset, now
+
+	if d.state == StateSync {
+		d.adjustPoll(offset, jitter)
+	}
+	return action
+}
+
+// watch returns how long the frequency error is measured after a step
+func (d *Discipline) watch() time.Duration {
+	watch := freqWatch * time.Duration(int64(1)<<uint(d.MinPoll)) * time.Second
+	if watch > maxWatch*time.Second {
+		watch = maxWatch * time.Second
+	}
+	return watch
+}
+
+// setFreq sets the frequency correction, within MaxFreq
+func (d *Discipline) setFreq(freq float64) {
+	d.Clock.SetFreq(math.Max(-MaxFreq, math.Min(MaxFreq, freq)))
+}
+
+// adjustPoll lengthens the poll interval while the offsets stay within
+// the jitter, and shortens it otherwise
+func (d *Discipline) adjustPoll(offset, jitter time.Duration) {
+	if jitter < d.jitter {
+		jitter = d.jitter
+	}
+	if abs(offset) < pollGate*jitter {
+		d.count += d.poll
+		if d.count > pollLimit {
+			d.count = pollLimit
+			if d.poll < d.MaxPoll {
+				d.count = 0
+				d.poll++
+			}
+		}
+		return
+	}
+	d.count -= 2 * d.poll
+	if d.count < -pollLimit {
+		d.count = -pollLimit
+		if d.poll > d.MinPoll {
+			d.count = 0
+			d.poll--
+		}
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
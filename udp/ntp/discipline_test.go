@@ -0,0 +1,133 @@
+package ntp
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// simulation is a virtual clock disciplined against the true time,
+// with a base clock that is wrong and drifts
+type simulation struct {
+	now    time.Time // true time
+	clock  *VirtualClock
+	disc   *Discipline
+	rand   *rand.Rand
+	noise  time.Duration // jitter of the measured offsets
+	action map[string]int
+}
+
+func newSimulation(offset time.Duration, ppm float64, minPoll, maxPoll int) *simulation {
+	s := &simulation{
+		now:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		rand:   rand.New(rand.NewSource(1)),
+		action: make(map[string]int),
+	}
+	base := DriftingClock(func() time.Time { return s.now }, offset, ppm)
+	s.clock = NewVirtualClock(base)
+	s.disc = NewDiscipline(s.clock, minPoll, maxPoll)
+	return s
+}
+
+// error returns the offset of the true time relative to the clock
+func (s *simulation) error() time.Duration {
+	return s.now.Sub(s.clock.Now())
+}
+
+// poll waits a poll interval, then measures the offset and updates the
+// discipline
+func (s *simulation) poll() string {
+	s.now = s.now.Add(s.disc.Interval())
+	noise := time.Duration((s.rand.Float64()*2 - 1) * float64(s.noise))
+	action := s.disc.Update(s.error()+noise, s.noise)
+	s.action[action]++
+	return action
+}
+
+func TestDisciplineConverges(t *testing.T) {
+	const ppm = 200
+	s := newSimulation(50*time.Millisecond, ppm, MinPoll, 10)
+	s.noise = 20 * time.Microsecond
+	if action := s.poll(); action != "set" {
+		t.Fatalf("first update %q, want set", action)
+	}
+	if s.disc.State() != StateFreq {
+		t.Fatalf("state %s after the first update, want freq", s.disc.State())
+	}
+	if e := s.error(); e > time.Millisecond || e < -time.Millisecond {
+		t.Fatalf("offset %v after the clock was set", e)
+	}
+
+	for i := 0; i < 200; i++ {
+		s.poll()
+	}
+	if s.disc.State() != StateSync {
+		t.Fatalf("state %s, want sync", s.disc.State())
+	}
+	if s.action["wait"] == 0 || s.action["freq"] != 1 || s.action["step"] != 0 {
+		t.Errorf("actions %v: want a frequency measurement and no step", s.action)
+	}
+
+	// the frequency correction cancels the drift of the base clock
+	freq := s.clock.Freq() * 1e6
+	if math.Abs(freq+ppm) > 5 {
+		t.Errorf("frequency %.1f ppm, want %d ppm within 5", freq, -ppm)
+	}
+	if e := s.error(); e > 500*time.Microsecond || e < -500*time.Microsecond {
+		t.Errorf("residual offset %v", e)
+	}
+	if s.disc.Poll() <= MinPoll {
+		t.Errorf("poll exponent %d, want it to grow once stable", s.disc.Poll())
+	}
+}
+
+func TestDisciplineStep(t *testing.T) {
+	s := newSimulation(0, 10, MinPoll, 6)
+	for i := 0; i < 30; i++ {
+		s.poll()
+	}
+	if s.disc.State() != StateSync {
+		t.Fatalf("state %s, want sync", s.disc.State())
+	}
+
+	// offsets below the threshold are slewed, above it stepped
+	if action := s.disc.Update(StepThreshold/2, 0); action == "step" {
+		t.Errorf("offset %v stepped", StepThreshold/2)
+	}
+	before := s.clock.Correction()
+	if action := s.disc.Update(2*StepThreshold, 0); action != "step" {
+		t.Fatalf("offset %v: %q, want step", 2*StepThreshold, action)
+	}
+	if d := s.clock.Correction() - before; d != 2*StepThreshold {
+		t.Errorf("stepped by %v, want %v", d, 2*StepThreshold)
+	}
+	if s.disc.State() != StateFreq || s.disc.Poll() != MinPoll {
+		t.Errorf("state %s, poll %d after a step: want freq, %d", s.disc.State(), s.disc.Poll(), MinPoll)
+	}
+	if action := s.disc.Update(-2*StepThreshold, 0); action != "step" {
+		t.Errorf("offset %v: %q, want step", -2*StepThreshold, action)
+	}
+}
+
+func TestDisciplinePLLFLL(t *testing.T) {
+	tests := []struct {
+		minPoll, maxPoll int
+		want             string
+	}{
+		{MinPoll, allanPoll - 1, "pll"},
+		{allanPoll, allanPoll + 1, "fll"},
+	}
+	for _, test := range tests {
+		s := newSimulation(10*time.Millisecond, 50, test.minPoll, test.maxPoll)
+		for i := 0; i < 40; i++ {
+			s.poll()
+		}
+		if s.action[test.want] == 0 || s.action["pll"]+s.action["fll"] != s.action[test.want] {
+			t.Errorf("polls %d-%d: actions %v, want %s", test.minPoll, test.maxPoll, s.action, test.want)
+		}
+		if freq := s.clock.Freq() * 1e6; math.Abs(freq+50) > 5 {
+			t.Errorf("polls %d-%d: frequency %.1f ppm, want -50", test.minPoll, test.maxPoll, freq)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Extension field types of NTS (RFC 8915)
+const (
+	ExtUniqueID         = 0x0104
+	ExtNTSCookie        = 0x0204
+	ExtNTSCookieHolder  = 0x0304
+	ExtNTSAuthenticator = 0x0404
+)
+
+const (
+	extHeaderSize = 4      // type and length
+	extMinSize    = 16     // fields are at least 16 bytes
+	extAlign      = 4      // and padded to 4 bytes
+	maxMACSize    = 4 + 20 // key ID and SHA-1 digest
+)
+
+// Extension is an NTPv4 extension field (RFC 7822), between the 48
+// bytes of the packet and the MAC if any:
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|          Field Type           |            Length             |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	.                             Value                             .
+//	.                     (padded to 4 bytes)                       .
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// The length counts the whole field, at least 16 bytes.
+type Extension struct {
+	Type  uint16
+	Value []byte
+
+	// Offset is the position of the field in the packet it was
+	// parsed from
+	Offset int
+}
+
+// AppendExtension appends the extension field of type typ and value
+// to b, padded with zeros
+func AppendExtension(b []byte, typ uint16, value []byte) []byte {
+	size := extHeaderSize + len(value)
+	size += (extAlign - size%extAlign) % extAlign
+	if size < extMinSize {
+		size = extMinSize
+	}
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(size))
+	b = append(b, value...)
+	return append(b, make([]byte, size-extHeaderSize-len(value))...)
+}
+
+// ParseExtensions returns the extension fields of a packet, and the MAC
+// that may follow them.  Like RFC 7822, what is left after the fields
+// is a MAC when at most 24 bytes long, the fields being longer.  The
+// values of the fields include their padding.
+func ParseExtensions(data []byte) (exts []Extension, mac []byte, err error) {
+	if len(data) < PacketSize {
+		return nil, nil, ErrShortPacket
+	}
+	off := PacketSize
+	for len(data)-off > maxMACSize {
+		e, err := parseExtension(data, off)
+		if err != nil {
+			return nil, nil, err
+		}
+		exts = append(exts, e)
+		off += extHeaderSize + len(e.Value)
+	}
+	return exts, data[off:], nil
+}
+
+// ParseExtensionFields returns the extension fields that make up b,
+// such as the fields encrypted in an NTS authenticator
+func ParseExtensionFields(b []byte) ([]Extension, error) {
+	var exts []Extension
+	for off := 0; off < len(b); {
+		e, err := parseExtension(b, off)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, e)
+		off += extHeaderSize + len(e.Value)
+	}
+	return exts, nil
+}
+
+// parseExtension returns the extension field at offset off of data
+func parseExtension(data []byte, off int) (Extension, error) {
+	if len(data)-off < extHeaderSize {
+		return Extension{}, errors.New("ntp: truncated extension field")
+	}
+	typ := binary.BigEndian.Uint16(data[off:])
+	size := int(binary.BigEndian.Uint16(data[off+2:]))
+	if size < extMinSize || size%extAlign != 0 || off+size > len(data) {
+		return Extension{}, fmt.Errorf("ntp: invalid extension field %#04x of %d bytes", typ, size)
+	}
+	return Extension{
+		Type:   typ,
+		Value:  data[off+extHeaderSize : off+size],
+		Offset: off,
+	}, nil
+}
+
+// ErrNoExtension is returned when a packet lacks an extension field
+var ErrNoExtension = errors.New("ntp: missing extension field")
+
+// FindExtension returns the first field of type typ
+func FindExtension(exts []Extension, typ uint16) (Extension, error) {
+	for _, e := range exts {
+		if e.Type == typ {
+			return e, nil
+		}
+	}
+	return Extension{}, fmt.Errorf("%w %#04x", ErrNoExtension, typ)
+}
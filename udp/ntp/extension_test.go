@@ -0,0 +1,103 @@
+package ntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseExtensions(t *testing.T) {
+	pkt, _ := NewRequest(time.Now()).MarshalBinary()
+	with := func(parts ...[]byte) []byte {
+		return append(append([]byte(nil), pkt...), bytes.Join(parts, nil)...)
+	}
+	field := func(typ uint16, size int) []byte {
+		b := binary.BigEndian.AppendUint16(nil, typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(size))
+		return append(b, make([]byte, size-extHeaderSize)...)
+	}
+	unique := AppendExtension(nil, ExtUniqueID, bytes.Repeat([]byte{1}, 32))
+	cookie := AppendExtension(nil, ExtNTSCookie, []byte("cookie"))
+	sha1MAC := append([]byte{0, 0, 0, 1}, make([]byte, 20)...)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		types []uint16
+		mac   int
+		fails bool
+	}{
+		{"no field", pkt, nil, 0, false},
+		{"MAC only", with(sha1MAC), nil, 24, false},
+		{"crypto-NAK", with([]byte{0, 0, 0, 0}), nil, 4, false},
+		{"fields", with(cookie, unique), []uint16{ExtNTSCookie, ExtUniqueID}, 0, false},
+		{"fields and MAC", with(unique, sha1MAC), []uint16{ExtUniqueID}, 24, false},
+		// more than 24 bytes left must be a field: 28 bytes of MAC are not
+		{"MAC too long", with(sha1MAC, []byte{0, 0, 0, 0}), nil, 0, true},
+		{"field of 16 bytes", with(field(0x1234, 16), field(0x5678, 28)), []uint16{0x1234, 0x5678}, 0, false},
+		// without a MAC, the last field must be longer than 24 bytes
+		// (RFC 7822, section 7.5), a shorter one is taken for the MAC
+		{"last field of 16 bytes", with(unique, cookie), []uint16{ExtUniqueID}, 16, false},
+		{"field of 12 bytes", with(field(0x1234, 12), make([]byte, 16)), nil, 0, true},
+		{"misaligned field", with(field(0x1234, 18), make([]byte, 10)), nil, 0, true},
+		{"field past the end", with(field(0x1234, 32)[:30]), nil, 0, true},
+		{"field larger than the packet", with([]byte{0x12, 0x34, 0xff, 0xfc}, make([]byte, 28)), nil, 0, true},
+		{"short packet", pkt[:PacketSize-1], nil, 0, true},
+	}
+	for _, tt := range tests {
+		exts, mac, err := ParseExtensions(tt.data)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: parsed %d fields and a MAC of %d bytes", tt.name, len(exts), len(mac))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var types []uint16
+		for _, e := range exts {
+			types = append(types, e.Type)
+			if got := binary.BigEndian.Uint16(tt.data[e.Offset:]); got != e.Type {
+				t.Errorf("%s: field %#04x at offset %d of type %#04x", tt.name, e.Type, e.Offset, got)
+			}
+		}
+		if !reflect.DeepEqual(types, tt.types) {
+			t.Errorf("%s: got fields %#04x, want %#04x", tt.name, types, tt.types)
+		}
+		if len(mac) != tt.mac {
+			t.Errorf("%s: got a MAC of %d bytes, want %d", tt.name, len(mac), tt.mac)
+		}
+	}
+}
+
+func TestAppendExtension(t *testing.T) {
+	tests := []struct {
+		value, size int
+	}{
+		{0, 16},
+		{12, 16},
+		{13, 20},
+		{32, 36},
+	}
+	for _, tt := range tests {
+		b := AppendExtension(nil, ExtNTSCookie, bytes.Repeat([]byte{1}, tt.value))
+		if len(b) != tt.size || int(binary.BigEndian.Uint16(b[2:])) != tt.size {
+			t.Errorf("value of %d bytes: got a field of %d bytes, length %d, want %d",
+				tt.value, len(b), binary.BigEndian.Uint16(b[2:]), tt.size)
+		}
+		exts, err := ParseExtensionFields(b)
+		if err != nil || len(exts) != 1 || !bytes.HasPrefix(exts[0].Value, bytes.Repeat([]byte{1}, tt.value)) {
+			t.Errorf("value of %d bytes: got %v, %v", tt.value, exts, err)
+		}
+	}
+
+	_, err := FindExtension(nil, ExtNTSCookie)
+	if !errors.Is(err, ErrNoExtension) {
+		t.Errorf("got %v, want %v", err, ErrNoExtension)
+	}
+}
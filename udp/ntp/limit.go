@@ -0,0 +1,158 @@
+package ntp
+
+import (
+	"container/list"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// MaxClients bounds the clients a Limiter keeps track of
+const MaxClients = 100000
+
+// Limiter limits the rate of the requests of each client address with a
+// token bucket: a client may send Burst requests at once, then Rate
+// requests per second.  A client over its rate should be answered with
+// a kiss-o'-death RATE, but at most once per token interval, so that the
+// kisses cannot be used to flood a spoofed address either.
+//
+// Clients are identified by IP address, IPv6 clients by /64 prefix,
+// since a host has many addresses of its prefix.  Addresses that are not
+// IP addresses, such as unix sockets, are not limited.  At most
+// MaxClients are tracked: when full, the least recently seen client is
+// forgotten, like in a Monitor, so that a flood of spoofed addresses
+// neither exhausts the memory of the server nor lifts the limit of the
+// other clients.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	mu        sync.Mutex
+	lru       *list.List // of *bucket, the most recent first
+	clients   map[netip.Addr]*list.Element
+	lastSweep time.Time
+}
+
+// bucket is the token bucket of a client
+type bucket struct {
+	ip     netip.Addr
+	tokens float64
+	last   time.Time // time tokens was computed
+	kiss   time.Time // time of the last kiss-o'-death
+}
+
+// NewLimiter returns a limiter of rate requests per second after a
+// burst of burst requests
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{Rate: rate, Burst: burst, lru: list.New(), clients: make(map[netip.Addr]*list.Element)}
+}
+
+// Allow reports whether the request of the client at addr received at
+// time now is allowed, and if not, whether to answer it with a RATE
+// kiss-o'-death
+func (l *Limiter) Allow(addr net.Addr, now time.Time) (ok, kiss bool) {
+	ip, tracked := clientIP(addr)
+	if !tracked || l.Rate <= 0 {
+		return true, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+	var b *bucket
+	if e := l.clients[ip]; e != nil {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		if l.lru.Len() >= MaxClients {
+			l.evict(now)
+		}
+		b = &bucket{ip: ip, tokens: float64(l.Burst), last: now}
+		l.clients[ip] = l.lru.PushFront(b)
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.Rate
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, false
+	}
+	if now.Sub(b.kiss).Seconds()*l.Rate >= 1 {
+		b.kiss = now
+		return false, true
+	}
+	return false, false
+}
+
+// Clients returns the number of clients tracked
+func (l *Limiter) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
+
+// evict makes room for a new client: it forgets the least recently
+// seen clients as long as their bucket is full again, then, if still
+// full, the least recently seen one.  Unlike sweep, it only looks at the
+// clients it forgets, so that each of a flood of new addresses costs
+// the same.
+func (l *Limiter) evict(now time.Time) {
+	for e := l.lru.Back(); e != nil; e = l.lru.Back() {
+		b := e.Value.(*bucket)
+		refilled := l.refilled(b, now)
+		if !refilled && l.lru.Len() < MaxClients {
+			return
+		}
+		delete(l.clients, b.ip)
+		l.lru.Remove(e)
+		if !refilled {
+			return
+		}
+	}
+}
+
+// sweep forgets the clients whose bucket is full again, they are like
+// new clients
+func (l *Limiter) sweep(now time.Time) {
+	for e := l.lru.Front(); e != nil; {
+		next := e.Next()
+		if b := e.Value.(*bucket); l.refilled(b, now) {
+			delete(l.clients, b.ip)
+			l.lru.Remove(e)
+		}
+		e = next
+	}
+	l.lastSweep = now
+}
+
+// refilled reports whether the bucket b is full again at time now
+func (l *Limiter) refilled(b *bucket, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst)
+}
+
+// clientIP returns the address identifying the client at addr
+func clientIP(addr net.Addr) (netip.Addr, bool) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	ip, ok := netip.AddrFromSlice(udpAddr.IP)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	ip = ip.Unmap()
+	if ip.Is6() {
+		prefix, _ := ip.Prefix(64)
+		ip = prefix.Addr()
+	}
+	return ip, true
+}
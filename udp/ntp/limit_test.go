@@ -0,0 +1,136 @@
+package ntp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiterRate(t *testing.T) {
+	l := NewLimiter(1, 2)
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 123}
+	now := time.Unix(1e9, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(addr, now); !ok {
+			t.Fatalf("request %d of the burst denied", i+1)
+		}
+	}
+	if ok, kiss := l.Allow(addr, now); ok || !kiss {
+		t.Fatalf("over the burst: got ok %v, kiss %v, want a kiss", ok, kiss)
+	}
+	// at most one kiss per token interval
+	if ok, kiss := l.Allow(addr, now.Add(time.Second/2)); ok || kiss {
+		t.Fatalf("second kiss: got ok %v, kiss %v, want a silent drop", ok, kiss)
+	}
+	if ok, _ := l.Allow(addr, now.Add(2*time.Second)); !ok {
+		t.Fatal("request after a token interval denied")
+	}
+
+	// IPv6 clients are limited by /64 prefix
+	for i, ip := range []string{"2001:db8::1", "2001:db8::2"} {
+		addr := &net.UDPAddr{IP: net.ParseIP(ip), Port: 123}
+		if ok, _ := l.Allow(addr, now); !ok {
+			t.Fatalf("request %d from the prefix denied", i+1)
+		}
+	}
+	if ok, _ := l.Allow(&net.UDPAddr{IP: net.ParseIP("2001:db8::3")}, now); ok {
+		t.Fatal("third request from the prefix allowed")
+	}
+
+	// unix sockets are not limited
+	unix := &net.UnixAddr{Name: "/run/ntp.sock", Net: "unixgram"}
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow(unix, now); !ok {
+			t.Fatal("unix socket limited")
+		}
+	}
+}
+
+func TestLimiterFull(t *testing.T) {
+	l := NewLimiter(1, 1)
+	now := time.Unix(1e9, 0)
+	client := func(i int) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 123}
+	}
+
+	// the abuser is over its rate, then a flood of spoofed addresses
+	// fills the limiter; none of them gets a token back before the
+	// abuser is seen again
+	abuser := client(0)
+	l.Allow(abuser, now)
+	if ok, _ := l.Allow(abuser, now); ok {
+		t.Fatal("abuser allowed over its rate")
+	}
+	for i := 1; i < MaxClients; i++ {
+		l.Allow(client(i), now)
+	}
+	if n := l.Clients(); n != MaxClients {
+		t.Fatalf("got %d clients, want %d", n, MaxClients)
+	}
+	if ok, _ := l.Allow(abuser, now); ok {
+		t.Fatal("abuser allowed with the limiter full")
+	}
+
+	// a new client evicts the least recently seen one, not the abuser
+	if ok, _ := l.Allow(client(MaxClients), now); !ok {
+		t.Fatal("new client denied with the limiter full")
+	}
+	if n := l.Clients(); n != MaxClients {
+		t.Fatalf("got %d clients, want %d", n, MaxClients)
+	}
+	if ok, _ := l.Allow(client(MaxClients), now); ok {
+		t.Fatal("new client not limited with the limiter full")
+	}
+	if ok, _ := l.Allow(abuser, now); ok {
+		t.Fatal("abuser allowed after an eviction")
+	}
+	if ok, _ := l.Allow(client(1), now); !ok {
+		t.Fatal("evicted client not forgotten")
+	}
+}
+
+func TestLimiterEvictRefilled(t *testing.T) {
+	l := NewLimiter(1, 1)
+	now := time.Unix(1e9, 0)
+	client := func(i int) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 123}
+	}
+
+	// the first half of the clients get their token back
+	for i := 0; i < MaxClients; i++ {
+		if i == MaxClients/2 {
+			now = now.Add(time.Second)
+		}
+		l.Allow(client(i), now)
+	}
+	now = now.Add(time.Second / 2)
+
+	// a new client forgets them all, and only them
+	l.Allow(client(MaxClients), now)
+	if n, want := l.Clients(), MaxClients/2+1; n != want {
+		t.Fatalf("got %d clients, want %d", n, want)
+	}
+	if ok, _ := l.Allow(client(MaxClients/2), now); ok {
+		t.Fatal("client over its rate forgotten")
+	}
+}
+
+// BenchmarkLimiterFull measures the requests of new clients with the
+// limiter full, as in a flood of spoofed addresses
+func BenchmarkLimiterFull(b *testing.B) {
+	l := NewLimiter(1, 1)
+	now := time.Unix(1e9, 0)
+	addr := &net.UDPAddr{Port: 123}
+	client := func(i int) *net.UDPAddr {
+		addr.IP = net.IPv4(10+byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+		return addr
+	}
+	for i := 0; i < MaxClients; i++ {
+		l.Allow(client(i), now)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Allow(client(MaxClients+i), now)
+	}
+}
@@ -0,0 +1,152 @@
+package ntp
+
+import (
+	"container/list"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Client is an entry of the list of clients of a server, like an entry
+// of the MRU list of ntpd (ntpq -c mrulist)
+type Client struct {
+	// Addr is the IP address of the client, or its socket path for
+	// unix sockets, and Port the port of its last packet
+	Addr string
+	Port int
+
+	// Mode and Version are those of the last packet
+	Mode    Mode
+	Version uint8
+
+	// Count is the number of packets received, Dropped the number of
+	// those that were not answered, and Kisses those answered with a
+	// kiss-o'-death
+	Count, Dropped, Kisses int64
+
+	// First and Last are the times the first and last packets were
+	// received
+	First, Last time.Time
+}
+
+// Interval returns the average interval between the packets of the
+// client, 0 for a single packet
+func (c *Client) Interval() time.Duration {
+	if c.Count < 2 {
+		return 0
+	}
+	return c.Last.Sub(c.First) / time.Duration(c.Count-1)
+}
+
+// Monitor counts the packets of the clients of a server, in a list of
+// the most recently used (MRU) clients: when the list is full, the
+// least recently seen client is forgotten, so that a flood of spoofed
+// addresses cannot exhaust the memory of the server.
+type Monitor struct {
+	Size int
+
+	mu      sync.Mutex
+	lru     *list.List // of *Client, the most recent first
+	clients map[string]*list.Element
+}
+
+// NewMonitor returns a monitor of size clients at most
+func NewMonitor(size int) *Monitor {
+	if size < 1 {
+		size = 1
+	}
+	return &Monitor{Size: size, lru: list.New(), clients: make(map[string]*list.Element)}
+}
+
+// Record counts the packet data received from addr at time now, and
+// makes its client the most recent
+func (m *Monitor) Record(addr net.Addr, data []byte, now time.Time) {
+	key, port := clientKey(addr)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.clients[key]
+	if e == nil {
+		if m.lru.Len() >= m.Size {
+			oldest := m.lru.Back()
+			delete(m.clients, oldest.Value.(*Client).Addr)
+			m.lru.Remove(oldest)
+		}
+		e = m.lru.PushFront(&Client{Addr: key, First: now})
+		m.clients[key] = e
+	} else {
+		m.lru.MoveToFront(e)
+	}
+	c := e.Value.(*Client)
+	c.Port = port
+	c.Count++
+	c.Last = now
+	if len(data) > 0 {
+		c.Mode = Mode(data[0] & 0x7)
+		c.Version = (data[0] >> 3) & 0x7
+	}
+}
+
+// Drop counts a packet of the client at addr that was not answered
+func (m *Monitor) Drop(addr net.Addr) {
+	m.update(addr, func(c *Client) { c.Dropped++ })
+}
+
+// Kiss counts a packet of the client at addr answered with a
+// kiss-o'-death
+func (m *Monitor) Kiss(addr net.Addr) {
+	m.update(addr, func(c *Client) { c.Kisses++ })
+}
+
+// update updates the entry of the client at addr, if still listed
+func (m *Monitor) update(addr net.Addr, f func(c *Client)) {
+	key, _ := clientKey(addr)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.clients[key]; e != nil {
+		f(e.Value.(*Client))
+	}
+}
+
+// Clients returns a copy of the clients, the most recent first
+func (m *Monitor) Clients() []Client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clients := make([]Client, 0, m.lru.Len())
+	for e := m.lru.Front(); e != nil; e = e.Next() {
+		clients = append(clients, *e.Value.(*Client))
+	}
+	return clients
+}
+
+// Len returns the number of clients listed
+func (m *Monitor) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// clientKey returns the key of the client at addr, its IP address or
+// its socket path, and its port
+func clientKey(addr net.Addr) (string, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip := a.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		key := ip.String()
+		if a.Zone != "" {
+			key += "%" + a.Zone
+		}
+		return key, a.Port
+	case nil:
+		return "", 0
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String(), 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
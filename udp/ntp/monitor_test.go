@@ -0,0 +1,114 @@
+package ntp
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMonitorEviction(t *testing.T) {
+	m := NewMonitor(3)
+	start := time.Now()
+	addr := func(i int) net.Addr {
+		return &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 1000 + i}
+	}
+	listed := func() []string {
+		var addrs []string
+		for _, c := range m.Clients() {
+			addrs = append(addrs, c.Addr)
+		}
+		return addrs
+	}
+
+	for i := 1; i <= 3; i++ {
+		m.Record(addr(i), nil, start.Add(time.Duration(i)*time.Second))
+	}
+	// a packet makes its client the most recent, so that the least
+	// recently seen client is evicted, not the oldest one
+	m.Record(addr(1), nil, start.Add(4*time.Second))
+	if want := []string{"192.0.2.1", "192.0.2.3", "192.0.2.2"}; !reflect.DeepEqual(listed(), want) {
+		t.Fatalf("got %v, want %v", listed(), want)
+	}
+	m.Record(addr(4), nil, start.Add(5*time.Second))
+	if want := []string{"192.0.2.4", "192.0.2.1", "192.0.2.3"}; !reflect.DeepEqual(listed(), want) {
+		t.Fatalf("got %v, want %v", listed(), want)
+	}
+	if m.Len() != 3 {
+		t.Errorf("got %d clients, want 3", m.Len())
+	}
+
+	// an evicted client is not counted, nor listed again, by Drop and
+	// Kiss, and comes back with new counts
+	m.Drop(addr(2))
+	m.Kiss(addr(2))
+	if want := []string{"192.0.2.4", "192.0.2.1", "192.0.2.3"}; !reflect.DeepEqual(listed(), want) {
+		t.Fatalf("got %v after Drop and Kiss, want %v", listed(), want)
+	}
+	m.Record(addr(2), nil, start.Add(6*time.Second))
+	c := m.Clients()[0]
+	if c.Addr != "192.0.2.2" || c.Count != 1 || c.Dropped != 0 || c.Kisses != 0 || !c.First.Equal(start.Add(6*time.Second)) {
+		t.Errorf("got %+v, want a new entry", c)
+	}
+}
+
+func TestMonitorCounts(t *testing.T) {
+	m := NewMonitor(10)
+	start := time.Now()
+	req, _ := NewRequest(start).MarshalBinary()
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 123}
+	for i := 0; i < 5; i++ {
+		m.Record(&net.UDPAddr{IP: addr.IP, Port: 1000 + i}, req, start.Add(time.Duration(i)*2*time.Second))
+	}
+	m.Drop(addr)
+	m.Kiss(addr)
+	m.Kiss(addr)
+
+	clients := m.Clients()
+	if len(clients) != 1 {
+		t.Fatalf("got %d clients, want 1", len(clients))
+	}
+	c := clients[0]
+	if c.Count != 5 || c.Dropped != 1 || c.Kisses != 2 || c.Port != 1004 {
+		t.Errorf("got %+v", c)
+	}
+	if c.Mode != ModeClient || c.Version != Version {
+		t.Errorf("got mode %v version %d, want %v %d", c.Mode, c.Version, ModeClient, Version)
+	}
+	if c.Interval() != 2*time.Second {
+		t.Errorf("got interval %v, want 2s", c.Interval())
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		key  string
+		port int
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 123}, "192.0.2.1", 123},
+		// an IPv4-mapped address is the IPv4 client
+		{&net.UDPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 123}, "192.0.2.1", 123},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 123}, "2001:db8::1", 123},
+		// link-local addresses of different interfaces are different
+		// clients
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth0", Port: 123}, "fe80::1%eth0", 123},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth1", Port: 123}, "fe80::1%eth1", 123},
+		{&net.UnixAddr{Name: "/tmp/ntpc.sock", Net: "unixgram"}, "/tmp/ntpc.sock", 0},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Zone: "eth0", Port: 80}, "2001:db8::1%eth0", 80},
+		{nil, "", 0},
+	}
+	for _, tt := range tests {
+		key, port := clientKey(tt.addr)
+		if key != tt.key || port != tt.port {
+			t.Errorf("%v: got %q port %d, want %q port %d", tt.addr, key, port, tt.key, tt.port)
+		}
+	}
+
+	m := NewMonitor(10)
+	m.Record(tests[3].addr, nil, time.Now())
+	m.Record(tests[4].addr, nil, time.Now())
+	if m.Len() != 2 {
+		t.Errorf("got %d clients for two zones, want 2", m.Len())
+	}
+}
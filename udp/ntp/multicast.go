@@ -0,0 +1,65 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ntp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// SetMulticastInterface makes conn send its packets to the multicast
+// group on interface ifi, instead of the interface of the route to the
+// group.  IPv6 groups may name their interface with a zone instead
+// (i.e. ff02::101%eth0); broadcasts leave by the interface of their
+// address (i.e. 192.0.2.255).
+func SetMulticastInterface(conn net.PacketConn, ifi *net.Interface, group *net.UDPAddr) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errors.New("ntp: multicast interface of a non-socket connection")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// IPv4 sockets name the interface by address, IPv6 by index
+	var set func(fd int) error
+	if group.IP.To4() != nil {
+		addr, err := interfaceAddr4(ifi)
+		if err != nil {
+			return err
+		}
+		set = func(fd int) error {
+			return syscall.SetsockoptInet4Addr(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+		}
+	} else {
+		set = func(fd int) error {
+			return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifi.Index)
+		}
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = set(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// interfaceAddr4 returns the IPv4 address of an interface
+func interfaceAddr4(ifi *net.Interface) ([4]byte, error) {
+	var addr [4]byte
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return addr, err
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			copy(addr[:], ipNet.IP.To4())
+			return addr, nil
+		}
+	}
+	return addr, errors.New("ntp: no IPv4 address on interface " + ifi.Name)
+}
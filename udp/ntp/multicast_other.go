@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package ntp
+
+import (
+	"errors"
+	"net"
+)
+
+// SetMulticastInterface is not supported on this system, the packets
+// sent to a group leave by the interface of its route
+func SetMulticastInterface(conn net.PacketConn, ifi *net.Interface, group *net.UDPAddr) error {
+	return errors.New("ntp: choosing the multicast interface is not supported on this system")
+}
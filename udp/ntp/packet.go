@@ -0,0 +1,289 @@
+// Package ntp encodes and decodes NTP version 4 packets (RFC 5905), as
+// used by the ntpc clients and ntps servers.  An NTP packet is at least
+// 48 bytes long, in network byte order:
+//
+//	 0                   1                   2                   3
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|LI | VN  |Mode |    Stratum    |     Poll      |   Precision   |
+//	|                          Root Delay                           |
+//	|                        Root Dispersion                        |
+//	|                         Reference ID                          |
+//	|                     Reference Timestamp (64)                  |
+//	|                      Origin Timestamp (64)                    |
+//	|                      Receive Timestamp (64)                   |
+//	|                      Transmit Timestamp (64)                  |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// A client sends its clock in the transmit timestamp; the server copies
+// it to the origin timestamp of its response, with the times at which it
+// received the request and sent the response.  From the four times, the
+// client computes its clock offset and the round trip delay.
+package ntp
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// PacketSize is the size of an NTP packet without extension fields
+const PacketSize = 48
+
+// Version is the NTP version of the packets created by this package
+const Version = 4
+
+// MaxStratum is the highest valid stratum, 16 means unsynchronized
+const MaxStratum = 15
+
+// RefIDLocal is the reference ID (127.127.1.1) of servers that serve
+// their local clock without a reference, usually at stratum 10
+const RefIDLocal = 0x7f7f0101
+
+// LeapIndicator warns of a leap second in the last minute of the day
+type LeapIndicator uint8
+
+const (
+	LeapNone      LeapIndicator = 0 // no warning
+	LeapAddSecond LeapIndicator = 1 // last minute has 61 seconds
+	LeapDelSecond LeapIndicator = 2 // last minute has 59 seconds
+	LeapNotInSync LeapIndicator = 3 // clock not synchronized
+)
+
+// Mode is the role of the sender of a packet
+type Mode uint8
+
+const (
+	ModeReserved         Mode = 0
+	ModeSymmetricActive  Mode = 1
+	ModeSymmetricPassive Mode = 2
+	ModeClient           Mode = 3
+	ModeServer           Mode = 4
+	ModeBroadcast        Mode = 5
+	ModeControl          Mode = 6
+	ModePrivate          Mode = 7
+)
+
+var modeNames = [...]string{"reserved", "symmetric active", "symmetric passive",
+	"client", "server", "broadcast", "control", "private"}
+
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("mode(%d)", uint8(m))
+}
+
+var (
+	// ErrShortPacket is returned when decoding less than PacketSize bytes
+	ErrShortPacket = errors.New("ntp: packet too short")
+
+	// ErrVersion is returned when decoding a packet of an unknown version
+	ErrVersion = errors.New("ntp: unsupported version")
+)
+
+// KissError is the response of a server that refuses to serve the
+// client (stratum 0, "kiss-o'-death"), with a code such as "RATE"
+// (slow down) or "DENY" (access denied)
+type KissError struct {
+	Code string
+}
+
+func (e *KissError) Error() string {
+	return fmt.Sprintf("ntp: kiss-o'-death %s", e.Code)
+}
+
+// Packet is an NTP packet.  Extension fields and authentication codes
+// that may follow the 48 bytes are ignored, see ParseExtensions and
+// Key.Verify.
+type Packet struct {
+	Leap      LeapIndicator
+	Version   uint8
+	Mode      Mode
+	Stratum   uint8
+	Poll      int8 // log2 of the poll interval in seconds
+	Precision int8 // log2 of the clock precision in seconds
+
+	RootDelay      Short
+	RootDispersion Short
+
+	// ReferenceID identifies the reference clock of the server: four
+	// ASCII characters for stratum 1 (i.e. "GPS"), the IPv4 address of
+	// the upstream server otherwise, or a kiss code for stratum 0
+	ReferenceID uint32
+
+	ReferenceTime Timestamp // last time the clock was set
+	OriginTime    Timestamp // client transmit time, in a response
+	ReceiveTime   Timestamp // server receive time
+	TransmitTime  Timestamp // sender transmit time
+}
+
+// NewRequest returns a client request sent at time now
+func NewRequest(now time.Time) *Packet {
+	return &Packet{
+		Leap:         LeapNotInSync,
+		Version:      Version,
+		Mode:         ModeClient,
+		TransmitTime: TimestampOf(now),
+	}
+}
+
+// MarshalBinary encodes the packet in its 48 bytes wire format
+func (p *Packet) MarshalBinary() ([]byte, error) {
+	if p.Leap > 3 || p.Version > 7 || p.Mode > 7 {
+		return nil, fmt.Errorf("ntp: invalid leap %d, version %d or mode %d", p.Leap, p.Version, p.Mode)
+	}
+	b := make([]byte, PacketSize)
+	b[0] = uint8(p.Leap)<<6 | p.Version<<3 | uint8(p.Mode)
+	b[1] = p.Stratum
+	b[2] = uint8(p.Poll)
+	b[3] = uint8(p.Precision)
+	binary.BigEndian.PutUint32(b[4:], uint32(p.RootDelay))
+	binary.BigEndian.PutUint32(b[8:], uint32(p.RootDispersion))
+	binary.BigEndian.PutUint32(b[12:], p.ReferenceID)
+	binary.BigEndian.PutUint64(b[16:], uint64(p.ReferenceTime))
+	binary.BigEndian.PutUint64(b[24:], uint64(p.OriginTime))
+	binary.BigEndian.PutUint64(b[32:], uint64(p.ReceiveTime))
+	binary.BigEndian.PutUint64(b[40:], uint64(p.TransmitTime))
+	return b, nil
+}
+
+// UnmarshalBinary decodes a packet of versions 1 to 4, extra bytes
+// (extension fields) are ignored
+func (p *Packet) UnmarshalBinary(b []byte) error {
+	if len(b) < PacketSize {
+		return ErrShortPacket
+	}
+	version := (b[0] >> 3) & 0x7
+	if version < 1 || version > Version {
+		return fmt.Errorf("%w %d", ErrVersion, version)
+	}
+	*p = Packet{
+		Leap:           LeapIndicator(b[0] >> 6),
+		Version:        version,
+		Mode:           Mode(b[0] & 0x7),
+		Stratum:        b[1],
+		Poll:           int8(b[2]),
+		Precision:      int8(b[3]),
+		RootDelay:      Short(binary.BigEndian.Uint32(b[4:])),
+		RootDispersion: Short(binary.BigEndian.Uint32(b[8:])),
+		ReferenceID:    binary.BigEndian.Uint32(b[12:]),
+		ReferenceTime:  Timestamp(binary.BigEndian.Uint64(b[16:])),
+		OriginTime:     Timestamp(binary.BigEndian.Uint64(b[24:])),
+		ReceiveTime:    Timestamp(binary.BigEndian.Uint64(b[32:])),
+		TransmitTime:   Timestamp(binary.BigEndian.Uint64(b[40:])),
+	}
+	return nil
+}
+
+// CheckRequest validates a packet received by a server
+func (p *Packet) CheckRequest() error {
+	if p.Mode != ModeClient {
+		return fmt.Errorf("ntp: unexpected %s packet", p.Mode)
+	}
+	return nil
+}
+
+// CheckResponse validates the response of a server to request req.
+// The origin time must echo the transmit time of the request, so that
+// stray or spoofed responses are rejected.
+func (p *Packet) CheckResponse(req *Packet) error {
+	if p.Mode != ModeServer {
+		return fmt.Errorf("ntp: unexpected %s packet", p.Mode)
+	}
+	if p.Stratum == 0 {
+		return &KissError{Code: p.refASCII()}
+	}
+	if p.Stratum > MaxStratum {
+		return fmt.Errorf("ntp: server not synchronized (stratum %d)", p.Stratum)
+	}
+	if p.Leap == LeapNotInSync {
+		return errors.New("ntp: server clock not synchronized")
+	}
+	if p.OriginTime != req.TransmitTime {
+		return errors.New("ntp: response does not match the request")
+	}
+	if p.ReceiveTime.IsZero() || p.TransmitTime.IsZero() {
+		return errors.New("ntp: response without server times")
+	}
+	if p.TransmitTime.Time().Before(p.ReceiveTime.Time()) {
+		return errors.New("ntp: server transmit time before receive time")
+	}
+	return nil
+}
+
+// Offset returns the offset of the server clock relative to the local
+// clock, for a response received at time dst
+func (p *Packet) Offset(dst time.Time) time.Duration {
+	t1, t2, t3 := p.OriginTime.Time(), p.ReceiveTime.Time(), p.TransmitTime.Time()
+	return (t2.Sub(t1) + t3.Sub(dst)) / 2
+}
+
+// Delay returns the round trip delay of a response received at time
+// dst, without the time spent by the server
+func (p *Packet) Delay(dst time.Time) time.Duration {
+	t1, t2, t3 := p.OriginTime.Time(), p.ReceiveTime.Time(), p.TransmitTime.Time()
+	d := dst.Sub(t1) - t3.Sub(t2)
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// RefID returns the reference ID of a clock name such as "GPS" or
+// "LOCL" (local clock), truncated to four characters
+func RefID(name string) uint32 {
+	var b [4]byte
+	copy(b[:], name)
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// RefIDOf returns the reference ID of a server at stratum 2 or more
+// that synchronizes to the server at addr: its IPv4 address, or the
+// first four bytes of the MD5 hash of its IPv6 address
+func RefIDOf(addr net.Addr) uint32 {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return 0
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return binary.BigEndian.Uint32(ip4)
+	}
+	sum := md5.Sum(ip.To16())
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Reference returns the reference ID as text: a clock name for stratum
+// 0 and 1, an IPv4 address otherwise
+func (p *Packet) Reference() string {
+	if p.Stratum <= 1 {
+		return p.refASCII()
+	}
+	var ip [4]byte
+	binary.BigEndian.PutUint32(ip[:], p.ReferenceID)
+	return net.IP(ip[:]).String()
+}
+
+// refASCII returns the reference ID as four ASCII characters
+func (p *Packet) refASCII() string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], p.ReferenceID)
+	n := 0
+	for n < 4 && b[n] >= 0x20 && b[n] < 0x7f {
+		n++
+	}
+	return string(b[:n])
+}
+
+func (p *Packet) String() string {
+	return fmt.Sprintf("v%d %s stratum %d ref %s leap %d poll %d precision %d delay %s dispersion %s transmit %s",
+		p.Version, p.Mode, p.Stratum, p.Reference(), p.Leap, p.Poll, p.Precision,
+		p.RootDelay, p.RootDispersion, p.TransmitTime)
+}
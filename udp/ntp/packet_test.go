@@ -0,0 +1,147 @@
+package ntp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalShortPacket(t *testing.T) {
+	for _, n := range []int{0, 1, 47} {
+		var p Packet
+		if err := p.UnmarshalBinary(make([]byte, n)); !errors.Is(err, ErrShortPacket) {
+			t.Errorf("%d bytes: got %v, want ErrShortPacket", n, err)
+		}
+	}
+}
+
+func TestUnmarshalVersion(t *testing.T) {
+	tests := []struct {
+		version uint8
+		ok      bool
+	}{
+		{0, false}, {1, true}, {3, true}, {4, true}, {5, false}, {7, false},
+	}
+	for _, test := range tests {
+		b := make([]byte, PacketSize)
+		b[0] = test.version<<3 | uint8(ModeClient)
+		var p Packet
+		err := p.UnmarshalBinary(b)
+		if test.ok && err != nil {
+			t.Errorf("version %d: %v", test.version, err)
+		}
+		if !test.ok && !errors.Is(err, ErrVersion) {
+			t.Errorf("version %d: got %v, want ErrVersion", test.version, err)
+		}
+	}
+}
+
+func TestCheckMode(t *testing.T) {
+	for mode := ModeReserved; mode <= ModePrivate; mode++ {
+		p := &Packet{Version: Version, Mode: mode, Stratum: 2, TransmitTime: 1}
+		if err := p.CheckRequest(); (err == nil) != (mode == ModeClient) {
+			t.Errorf("CheckRequest of a %s packet: %v", mode, err)
+		}
+		req := &Packet{TransmitTime: 1}
+		p.OriginTime, p.ReceiveTime = req.TransmitTime, 1
+		if err := p.CheckResponse(req); (err == nil) != (mode == ModeServer) {
+			t.Errorf("CheckResponse of a %s packet: %v", mode, err)
+		}
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	for _, p := range []Packet{
+		{Leap: 4, Version: Version, Mode: ModeClient},
+		{Version: 8, Mode: ModeClient},
+		{Version: Version, Mode: 8},
+	} {
+		if _, err := p.MarshalBinary(); err == nil {
+			t.Errorf("%+v: marshaled", p)
+		}
+	}
+}
+
+func TestTimestampEra(t *testing.T) {
+	rollover := time.Date(2036, 2, 7, 6, 28, 16, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		secs uint32
+	}{
+		{time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), unixOffset},
+		{rollover.Add(-time.Second), 0xffffffff},
+		{rollover.Add(time.Second), 1},
+		{time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), 0x7830d580},
+	}
+	for _, test := range tests {
+		ts := TimestampOf(test.t)
+		if ts.Seconds() != test.secs {
+			t.Errorf("%v: seconds %#x, want %#x", test.t, ts.Seconds(), test.secs)
+		}
+		if !ts.Time().Equal(test.t) {
+			t.Errorf("%v: round trip gives %v", test.t, ts.Time())
+		}
+	}
+
+	// the rollover instant is not stamped zero, which means unknown
+	ts := TimestampOf(rollover)
+	if ts != 1 {
+		t.Errorf("rollover: %#x, want 1", uint64(ts))
+	}
+	if d := ts.Time().Sub(rollover); d < 0 || d > time.Nanosecond {
+		t.Errorf("rollover: round trip gives %v", ts.Time())
+	}
+
+	// era 1 times keep their fraction
+	ts = TimestampOf(rollover.Add(500 * time.Millisecond))
+	if ts.IsZero() || ts.Seconds() != 0 || ts.Fraction() != 1<<31 {
+		t.Errorf("rollover + 0.5s: %#x", uint64(ts))
+	}
+	if !ts.Time().Equal(rollover.Add(500 * time.Millisecond)) {
+		t.Errorf("rollover + 0.5s: round trip gives %v", ts.Time())
+	}
+}
+
+func TestTimestampPrecision(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	if d := TimestampOf(now).Time().Sub(now); d < -time.Nanosecond || d > time.Nanosecond {
+		t.Errorf("round trip error %v", d)
+	}
+	if !TimestampOf(time.Time{}).IsZero() || !Timestamp(0).Time().IsZero() {
+		t.Error("zero time is not the zero timestamp")
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	req, _ := NewRequest(time.Now()).MarshalBinary()
+	rsp, _ := LocalClock(time.Now()).Respond(&Packet{Version: Version, TransmitTime: 1}, time.Now()).MarshalBinary()
+	f.Add(req)
+	f.Add(rsp)
+	f.Add(append(rsp, 0, 0, 0, 1))
+	f.Add([]byte{0x23})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var p Packet
+		if err := p.UnmarshalBinary(data); err != nil {
+			return
+		}
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("decoded packet does not encode: %v", err)
+		}
+		if !bytes.Equal(b, data[:PacketSize]) {
+			t.Fatalf("round trip:\n got %x\nwant %x", b, data[:PacketSize])
+		}
+		var q Packet
+		if err := q.UnmarshalBinary(b); err != nil || q != p {
+			t.Fatalf("second decode: %v, %+v != %+v", err, q, p)
+		}
+
+		// the checks and conversions must not panic either
+		p.CheckRequest()
+		p.CheckResponse(&p)
+		p.CheckBroadcast()
+		_ = p.String()
+		_ = p.TransmitTime.Time()
+	})
+}
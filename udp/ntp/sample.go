@@ -0,0 +1,230 @@
+package ntp
+
+import (
+	"errors"
+	"math"
+	"net"
+	"time"
+)
+
+// Sample is the result of one request/response exchange with a server
+type Sample struct {
+	// Offset is the offset θ of the server clock relative to the local
+	// clock: ((T2 - T1) + (T3 - T4)) / 2
+	Offset time.Duration
+
+	// Delay is the round trip delay δ: (T4 - T1) - (T3 - T2)
+	Delay time.Duration
+
+	// Response is the packet of the server, received at time Received
+	Response *Packet
+	Received time.Time
+}
+
+// NewSample returns the sample of a response received at time dst (T4)
+func NewSample(rsp *Packet, dst time.Time) Sample {
+	return Sample{
+		Offset:   rsp.Offset(dst),
+		Delay:    rsp.Delay(dst),
+		Response: rsp,
+		Received: dst,
+	}
+}
+
+// Query sends a request on conn and waits for the response until
+// timeout.  Packets that do not answer this request, such as late
+// responses to an earlier request, are skipped.
+func Query(conn net.Conn, timeout time.Duration) (Sample, error) {
+	return QueryWith(conn, timeout, time.Now, nil)
+}
+
+// QueryWith is Query measuring the offset of the clock now, such as
+// a VirtualClock, instead of the system clock.  With an authenticator
+// (a Key, or NTS), the request is authenticated and responses are only
+// accepted once verified; responses that fail are skipped like spoofed
+// packets, the error is returned if no valid response arrives.  A
+// negative acknowledgment, such as a crypto-NAK, is not authenticated
+// either: QueryWith waits for an authenticated response until timeout,
+// then returns the NAK as a KissError (CRYP, NTSN).
+func QueryWith(conn net.Conn, timeout time.Duration, now func() time.Time, auth Authenticator) (Sample, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return Sample{}, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	req := NewRequest(now())
+	data, err := req.MarshalBinary()
+	if err != nil {
+		return Sample{}, err
+	}
+	if auth != nil {
+		if data, err = auth.AuthenticateRequest(data); err != nil {
+			return Sample{}, &requestError{err}
+		}
+	}
+	if _, err := conn.Write(data); err != nil {
+		return Sample{}, err
+	}
+
+	buf := make([]byte, 2048)
+	var authErr, nakErr error
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if nakErr != nil {
+				return Sample{}, nakErr
+			}
+			if authErr != nil {
+				return Sample{}, authErr
+			}
+			return Sample{}, err
+		}
+		dst := now()
+		var rsp Packet
+		if err := rsp.UnmarshalBinary(buf[:n]); err != nil {
+			continue
+		}
+		if rsp.Mode == ModeServer && rsp.OriginTime != req.TransmitTime {
+			continue // not for this request
+		}
+		if auth != nil {
+			if err := auth.VerifyResponse(buf[:n]); err != nil {
+				authErr = err
+				continue
+			}
+		}
+		if err := rsp.CheckResponse(req); err != nil {
+			if auth != nil && IsNAK(err) {
+				nakErr = err
+				continue
+			}
+			return Sample{}, err
+		}
+		return NewSample(&rsp, dst), nil
+	}
+}
+
+// IsNAK reports whether err is a negative acknowledgment of an
+// authenticated request: a kiss-o'-death CRYP (crypto-NAK) or NTSN (NTS
+// NAK)
+func IsNAK(err error) bool {
+	var kiss *KissError
+	return errors.As(err, &kiss) && (kiss.Code == "CRYP" || kiss.Code == "NTSN")
+}
+
+// maxNAKs is the number of NAKs in a row that stops a Sampler
+const maxNAKs = 3
+
+// requestError is the error of a request that could not be
+// authenticated, such as an NTS request without cookies
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+// Sampler takes samples from a server, like ntpdate -q: Count requests
+// Interval apart, each waiting Timeout for its response.  Times are
+// read from Now, time.Now if nil, and requests are authenticated with
+// Auth, if not nil (see QueryWith).
+type Sampler struct {
+	Count             int
+	Interval, Timeout time.Duration
+	Now               func() time.Time
+	Auth              Authenticator
+
+	// Sampled, if not nil, is called after each request with its
+	// number, from 1, and its sample or error
+	Sampled func(n int, sample Sample, err error)
+}
+
+// Sample sends the requests on conn and returns the samples of the
+// responses.  Lost responses and RATE kisses are skipped, and so are
+// NAKs, which may be spoofed, unless maxNAKs come in a row.  Other
+// kiss-o'-death codes, and requests that cannot be authenticated, stop
+// the sampling with their error.  Without any sample, the error of the
+// last request is returned.
+func (s *Sampler) Sample(conn net.Conn) ([]Sample, error) {
+	now := s.Now
+	if now == nil {
+		now = time.Now
+	}
+	var samples []Sample
+	err := errors.New("ntp: no request sent")
+	naks := 0
+	for i := 0; i < s.Count; i++ {
+		if i > 0 {
+			time.Sleep(s.Interval)
+		}
+		var sample Sample
+		sample, err = QueryWith(conn, s.Timeout, now, s.Auth)
+		if s.Sampled != nil {
+			s.Sampled(i+1, sample, err)
+		}
+		if err != nil {
+			var kiss *KissError
+			var reqErr *requestError
+			switch {
+			case IsNAK(err):
+				if naks++; naks >= maxNAKs {
+					return nil, err
+				}
+			case errors.As(err, &kiss) && kiss.Code != "RATE", errors.As(err, &reqErr):
+				return nil, err
+			}
+			continue
+		}
+		naks = 0
+		samples = append(samples, sample)
+	}
+	if len(samples) == 0 {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// Measure takes count samples from the server at host, interval apart,
+// and returns the one with the smallest delay as a selection candidate.
+// Times are read from now, time.Now if nil.  Requests are authenticated
+// with auth, if not nil (see Sampler).
+func Measure(host string, count int, interval, timeout time.Duration, now func() time.Time, auth Authenticator) (*Candidate, error) {
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sampler := &Sampler{Count: count, Interval: interval, Timeout: timeout, Now: now, Auth: auth}
+	samples, err := sampler.Sample(conn)
+	if err != nil {
+		return nil, err
+	}
+	best, jitter := Filter(samples)
+	return &Candidate{Name: host, Addr: conn.RemoteAddr(), Sample: best, Jitter: jitter}, nil
+}
+
+// Filter returns the sample with the minimum delay, the least affected
+// by network queuing, and the jitter of the samples: the root mean
+// square of the differences between their offsets and the best offset.
+func Filter(samples []Sample) (best Sample, jitter time.Duration) {
+	if len(samples) == 0 {
+		return Sample{}, 0
+	}
+	best = samples[0]
+	for _, s := range samples[1:] {
+		if s.Delay < best.Delay {
+			best = s
+		}
+	}
+	if len(samples) == 1 {
+		return best, 0
+	}
+	var sum float64
+	for _, s := range samples {
+		d := (s.Offset - best.Offset).Seconds()
+		sum += d * d
+	}
+	rms := math.Sqrt(sum / float64(len(samples)-1))
+	return best, time.Duration(rms * float64(time.Second))
+}
@@ -0,0 +1,168 @@
+package ntp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// script answers the requests received on conn in turn: with a
+// response for "", signed with key if not nil, a kiss-o'-death for a
+// kiss code, nothing for "drop", a crypto-NAK for "NAK", a crypto-NAK
+// then the response for "NAK+", and a response passed off as a
+// crypto-NAK for "forged"
+func script(conn net.PacketConn, key *Key, answers ...string) {
+	clock := LocalClock(time.Now())
+	buf := make([]byte, 2048)
+	for _, answer := range answers {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var req Packet
+		if req.UnmarshalBinary(buf[:n]) != nil {
+			return
+		}
+		rsp, _ := clock.Respond(&req, time.Now()).MarshalBinary()
+		if key != nil {
+			rsp = key.Sign(rsp)
+		}
+		switch answer {
+		case "drop":
+			continue
+		case "":
+		case "NAK", "NAK+":
+			nak, _ := CryptoNAK(&req)
+			conn.WriteTo(nak, addr)
+			if answer == "NAK" {
+				continue
+			}
+		case "forged":
+			rsp = append(rsp[:PacketSize], 0, 0, 0, 0)
+		default:
+			rsp, _ = Kiss(&req, answer).MarshalBinary()
+		}
+		conn.WriteTo(rsp, addr)
+	}
+}
+
+// sample runs sampler against a server answering with answers, and
+// returns the samples, the errors reported per request, and the error
+func sample(t *testing.T, sampler *Sampler, answers ...string) ([]Sample, []error, error) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback:", err)
+	}
+	defer conn.Close()
+	key, _ := sampler.Auth.(*Key)
+	go script(conn, key, answers...)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reported []error
+	sampler.Sampled = func(n int, _ Sample, err error) {
+		if n != len(reported)+1 {
+			t.Errorf("sample %d reported after %d", n, len(reported))
+		}
+		reported = append(reported, err)
+	}
+	samples, err := sampler.Sample(client)
+	return samples, reported, err
+}
+
+func TestSamplerSkips(t *testing.T) {
+	sampler := &Sampler{Count: 4, Timeout: 100 * time.Millisecond}
+	samples, reported, err := sample(t, sampler, "drop", "RATE", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Errorf("got %d samples, want 2", len(samples))
+	}
+	if len(reported) != 4 || reported[0] == nil || reported[1] == nil || reported[2] != nil || reported[3] != nil {
+		t.Errorf("got reports %v, want 2 errors then 2 samples", reported)
+	}
+}
+
+func TestSamplerKiss(t *testing.T) {
+	sampler := &Sampler{Count: 3, Timeout: 100 * time.Millisecond}
+	_, reported, err := sample(t, sampler, "", "DENY", "")
+	var kiss *KissError
+	if !errors.As(err, &kiss) || kiss.Code != "DENY" {
+		t.Fatalf("got %v, want DENY", err)
+	}
+	if len(reported) != 2 {
+		t.Errorf("got %d requests, want to stop after 2", len(reported))
+	}
+}
+
+func TestSamplerNoResponse(t *testing.T) {
+	sampler := &Sampler{Count: 2, Timeout: 50 * time.Millisecond}
+	_, _, err := sample(t, sampler, "drop", "drop")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
+
+// failingAuth cannot authenticate requests
+type failingAuth struct{}
+
+var errNoKey = errors.New("no key")
+
+func (failingAuth) AuthenticateRequest([]byte) ([]byte, error) { return nil, errNoKey }
+func (failingAuth) VerifyResponse([]byte) error                { return nil }
+
+func TestSamplerAuthFailure(t *testing.T) {
+	sampler := &Sampler{Count: 3, Timeout: 50 * time.Millisecond, Auth: failingAuth{}}
+	_, reported, err := sample(t, sampler)
+	if !errors.Is(err, errNoKey) {
+		t.Fatalf("got %v, want %v", err, errNoKey)
+	}
+	if len(reported) != 1 {
+		t.Errorf("got %d requests, want to stop after 1", len(reported))
+	}
+}
+
+func TestSamplerNAK(t *testing.T) {
+	key, err := NewKey(1, KeyAES128CMAC, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a NAK, which may be spoofed, does not hide the authenticated
+	// response that follows it, nor stop the sampling
+	sampler := &Sampler{Count: 3, Timeout: 100 * time.Millisecond, Auth: key}
+	samples, reported, err := sample(t, sampler, "NAK+", "NAK", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Errorf("got %d samples, want 2", len(samples))
+	}
+	if !IsNAK(reported[1]) {
+		t.Errorf("got %v for a NAK alone, want a NAK", reported[1])
+	}
+
+	// NAKs in a row stop the sampling
+	sampler = &Sampler{Count: 5, Timeout: 50 * time.Millisecond, Auth: key}
+	_, reported, err = sample(t, sampler, "NAK", "NAK", "NAK", "", "")
+	if !IsNAK(err) {
+		t.Fatalf("got %v, want a NAK", err)
+	}
+	if len(reported) != maxNAKs {
+		t.Errorf("got %d requests, want to stop after %d", len(reported), maxNAKs)
+	}
+
+	// a response without MAC is not a crypto-NAK
+	sampler = &Sampler{Count: 1, Timeout: 50 * time.Millisecond, Auth: key}
+	if _, _, err := sample(t, sampler, "forged"); !errors.Is(err, ErrCryptoNAK) {
+		t.Fatalf("got %v, want %v", err, ErrCryptoNAK)
+	}
+}
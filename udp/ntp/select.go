@@ -0,0 +1,241 @@
+package ntp
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+// MinDispersion is the smallest root distance given to a server, so
+// that servers on a fast network still have a usable interval
+const MinDispersion = 10 * time.Millisecond
+
+// minSurvivors is the number of servers the clustering keeps at least
+const minSurvivors = 3
+
+// Status is the result of the selection for a server, shown as the
+// tally code of ntpq
+type Status int
+
+const (
+	Rejected    Status = iota // not selected yet, or failed
+	Falseticker               // offset outside the intersection interval
+	Outlier                   // discarded by the clustering
+	Survivor                  // combined into the system offset
+	SystemPeer                // best survivor
+)
+
+func (s Status) String() string {
+	return [...]string{" ", "x", "-", "+", "*"}[s]
+}
+
+// Candidate is a server taking part in the clock selection, with its
+// best sample and the jitter of its samples (see Filter)
+type Candidate struct {
+	Name   string
+	Addr   net.Addr
+	Sample Sample
+	Jitter time.Duration
+
+	// Status is set by Select
+	Status Status
+}
+
+// RootDistance returns the maximum error of the candidate offset: half
+// the round trip delay to the primary reference, plus the dispersions
+// and jitter (λ in RFC 5905)
+func (c *Candidate) RootDistance() time.Duration {
+	rsp := c.Sample.Response
+	precision := time.Duration(math.Exp2(float64(rsp.Precision)) * float64(time.Second))
+	d := (c.Sample.Delay+rsp.RootDelay.Duration())/2 + rsp.RootDispersion.Duration() +
+		precision + c.Jitter
+	if d < MinDispersion {
+		d = MinDispersion
+	}
+	return d
+}
+
+// Interval returns the correctness interval of the candidate, which
+// contains the true offset if the server is correct
+func (c *Candidate) Interval() (low, high time.Duration) {
+	d := c.RootDistance()
+	return c.Sample.Offset - d, c.Sample.Offset + d
+}
+
+// Selection is the result of the clock selection
+type Selection struct {
+	// Offset is the combined offset of the survivors, weighted by
+	// their root distance, and Jitter its uncertainty
+	Offset time.Duration
+	Jitter time.Duration
+
+	// Low and High bound the intersection interval: if a majority of
+	// the servers is correct, the true offset is in [Low, High]
+	Low, High time.Duration
+
+	// System is the best survivor
+	System *Candidate
+
+	// Truechimers is the number of servers whose offset is in the
+	// intersection interval
+	Truechimers int
+}
+
+// ErrNoMajority is returned by Select when no majority of the servers
+// agrees on the time
+var ErrNoMajority = errors.New("ntp: no majority of servers agrees")
+
+// Select runs the clock selection of RFC 5905 on the candidates and
+// sets their Status:
+//
+//   - the intersection algorithm (Marzullo) finds the smallest interval
+//     that contains the offsets of a majority of servers; the servers
+//     outside are falsetickers
+//   - the clustering discards the truechimers that contribute most to
+//     the dispersion of the offsets, while more than 3 remain
+//   - the offsets of the survivors are combined, weighted by the
+//     inverse of their root distance
+func Select(cands []*Candidate) (*Selection, error) {
+	n := len(cands)
+	if n == 0 {
+		return nil, ErrNoMajority
+	}
+	for _, c := range cands {
+		c.Status = Rejected
+	}
+
+	// endpoints of the correctness intervals, low points count -1,
+	// offsets 0 and high points +1
+	type endpoint struct {
+		edge time.Duration
+		kind int
+	}
+	points := make([]endpoint, 0, 3*n)
+	for _, c := range cands {
+		low, high := c.Interval()
+		points = append(points,
+			endpoint{low, -1}, endpoint{c.Sample.Offset, 0}, endpoint{high, +1})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].edge != points[j].edge {
+			return points[i].edge < points[j].edge
+		}
+		return points[i].kind < points[j].kind
+	})
+
+	// find the interval shared by n-allow servers, allowing for more
+	// falsetickers until a majority agrees
+	var low, high time.Duration
+	ok := false
+	for allow := 0; 2*allow < n; allow++ {
+		found, chime := 0, 0
+		for _, p := range points {
+			chime -= p.kind
+			if chime >= n-allow {
+				low = p.edge
+				break
+			}
+			if p.kind == 0 {
+				found++
+			}
+		}
+		chime = 0
+		for i := len(points) - 1; i >= 0; i-- {
+			p := points[i]
+			chime += p.kind
+			if chime >= n-allow {
+				high = p.edge
+				break
+			}
+			if p.kind == 0 {
+				found++
+			}
+		}
+		if found > allow {
+			continue
+		}
+		if high > low {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return nil, ErrNoMajority
+	}
+
+	// truechimers have their offset in the intersection interval
+	var survivors []*Candidate
+	for _, c := range cands {
+		if c.Sample.Offset < low || c.Sample.Offset > high {
+			c.Status = Falseticker
+			continue
+		}
+		c.Status = Survivor
+		survivors = append(survivors, c)
+	}
+	sel := &Selection{Low: low, High: high, Truechimers: len(survivors)}
+	if len(survivors) == 0 {
+		return nil, ErrNoMajority
+	}
+
+	// best first: lowest stratum, then shortest root distance
+	sort.SliceStable(survivors, func(i, j int) bool {
+		si, sj := survivors[i].Sample.Response.Stratum, survivors[j].Sample.Response.Stratum
+		if si != sj {
+			return si < sj
+		}
+		return survivors[i].RootDistance() < survivors[j].RootDistance()
+	})
+
+	// clustering: drop the survivor with the largest selection jitter
+	// while it exceeds the smallest peer jitter
+	for len(survivors) > minSurvivors {
+		worst, maxJitter := 0, time.Duration(0)
+		minJitter := time.Duration(math.MaxInt64)
+		for i, c := range survivors {
+			if j := selectionJitter(c, survivors); j > maxJitter {
+				worst, maxJitter = i, j
+			}
+			if c.Jitter < minJitter {
+				minJitter = c.Jitter
+			}
+		}
+		if maxJitter <= minJitter {
+			break
+		}
+		survivors[worst].Status = Outlier
+		survivors = append(survivors[:worst], survivors[worst+1:]...)
+	}
+
+	// combine the survivors, weighted by 1/root distance
+	sel.System = survivors[0]
+	sel.System.Status = SystemPeer
+	var weights, offset, spread float64
+	for _, c := range survivors {
+		w := 1 / c.RootDistance().Seconds()
+		weights += w
+		offset += w * c.Sample.Offset.Seconds()
+		d := (c.Sample.Offset - sel.System.Sample.Offset).Seconds()
+		spread += w * d * d
+	}
+	sel.Offset = time.Duration(offset / weights * float64(time.Second))
+	peer := sel.System.Jitter.Seconds()
+	sel.Jitter = time.Duration(math.Sqrt(spread/weights+peer*peer) * float64(time.Second))
+	return sel, nil
+}
+
+// selectionJitter returns the root mean square of the differences
+// between the offset of c and the offsets of the other candidates
+func selectionJitter(c *Candidate, cands []*Candidate) time.Duration {
+	if len(cands) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, o := range cands {
+		d := (o.Sample.Offset - c.Sample.Offset).Seconds()
+		sum += d * d
+	}
+	return time.Duration(math.Sqrt(sum/float64(len(cands)-1)) * float64(time.Second))
+}
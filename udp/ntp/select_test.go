@@ -0,0 +1,189 @@
+package ntp
+
+import (
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// candidate returns a stratum 2 candidate with its best sample
+func candidate(name string, offset, delay, jitter time.Duration) *Candidate {
+	return &Candidate{
+		Name: name,
+		Sample: Sample{
+			Offset:   offset,
+			Delay:    delay,
+			Response: &Packet{Stratum: 2, Precision: -20},
+		},
+		Jitter: jitter,
+	}
+}
+
+func TestSelectFalseticker(t *testing.T) {
+	ms := time.Millisecond
+	cands := []*Candidate{
+		candidate("a", 0, 10*ms, 0),
+		candidate("b", 1*ms, 10*ms, 0),
+		candidate("c", 2*ms, 10*ms, 0),
+		candidate("wrong", 2*time.Second, 10*ms, 0),
+	}
+	sel, err := Select(cands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cands[3].Status != Falseticker {
+		t.Errorf("wrong server is %q, want a falseticker", cands[3].Status)
+	}
+	for _, c := range cands[:3] {
+		if c.Status < Survivor {
+			t.Errorf("server %s is %q, want a survivor", c.Name, c.Status)
+		}
+	}
+	if sel.Truechimers != 3 {
+		t.Errorf("%d truechimers, want 3", sel.Truechimers)
+	}
+	if sel.Offset < 0 || sel.Offset > 2*ms {
+		t.Errorf("offset %v outside the offsets of the truechimers", sel.Offset)
+	}
+	if sel.Low > 0 || sel.High < 2*ms {
+		t.Errorf("intersection [%v, %v] misses the truechimers", sel.Low, sel.High)
+	}
+}
+
+func TestSelectNoMajority(t *testing.T) {
+	cands := []*Candidate{
+		candidate("a", 0, 10*time.Millisecond, 0),
+		candidate("b", time.Millisecond, 10*time.Millisecond, 0),
+		candidate("c", time.Second, 10*time.Millisecond, 0),
+		candidate("d", time.Second+time.Millisecond, 10*time.Millisecond, 0),
+	}
+	if _, err := Select(cands); !errors.Is(err, ErrNoMajority) {
+		t.Fatalf("got %v, want ErrNoMajority", err)
+	}
+	if _, err := Select(nil); !errors.Is(err, ErrNoMajority) {
+		t.Fatalf("no candidates: got %v, want ErrNoMajority", err)
+	}
+}
+
+func TestSelectClustering(t *testing.T) {
+	ms := time.Millisecond
+	var cands []*Candidate
+	for i, offset := range []time.Duration{0, 1 * ms, 2 * ms, 3 * ms, 12 * ms, 14 * ms} {
+		cands = append(cands, candidate(string(rune('a'+i)), offset, 40*ms, 100*time.Microsecond))
+	}
+	sel, err := Select(cands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.Truechimers != len(cands) {
+		t.Fatalf("%d truechimers, want %d", sel.Truechimers, len(cands))
+	}
+	count := map[Status]int{}
+	for _, c := range cands {
+		count[c.Status]++
+	}
+	if survivors := count[Survivor] + count[SystemPeer]; survivors != minSurvivors {
+		t.Errorf("%d survivors, want %d", survivors, minSurvivors)
+	}
+	if count[SystemPeer] != 1 || count[Outlier] != len(cands)-minSurvivors {
+		t.Errorf("statuses %v", count)
+	}
+
+	// the outliers are the servers far from the others
+	for _, c := range cands[4:] {
+		if c.Status != Outlier {
+			t.Errorf("server %s (%v) is %q, want an outlier", c.Name, c.Sample.Offset, c.Status)
+		}
+	}
+}
+
+func TestSelectWeightedOffset(t *testing.T) {
+	near := candidate("near", 0, 20*time.Millisecond, 0)
+	far := candidate("far", 6*time.Millisecond, 60*time.Millisecond, 0)
+	sel, err := Select([]*Candidate{far, near})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.System != near {
+		t.Errorf("system peer %s, want the shortest root distance", sel.System.Name)
+	}
+	wn, wf := 1/near.RootDistance().Seconds(), 1/far.RootDistance().Seconds()
+	want := time.Duration((wf * far.Sample.Offset.Seconds()) / (wn + wf) * float64(time.Second))
+	if d := sel.Offset - want; d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("offset %v, want %v", sel.Offset, want)
+	}
+	if sel.Offset >= 3*time.Millisecond {
+		t.Errorf("offset %v not weighted towards the nearest server", sel.Offset)
+	}
+
+	// a lower stratum makes the system peer, whatever its distance
+	far.Sample.Response.Stratum = 1
+	if sel, err = Select([]*Candidate{far, near}); err != nil || sel.System != far {
+		t.Errorf("system peer %v, %v: want the stratum 1 server", sel, err)
+	}
+}
+
+// serve answers the requests of conn with the clock, like ntps3 with
+// -offset and -delay: the delay is added to the round trip, half on
+// each way
+func serve(conn net.PacketConn, clock *Clock, delay time.Duration) {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var req Packet
+		if req.UnmarshalBinary(buf[:n]) != nil || req.CheckRequest() != nil {
+			continue
+		}
+		time.Sleep(delay / 2)
+		rsp, _ := clock.Respond(&req, time.Now()).MarshalBinary()
+		time.Sleep(delay / 2)
+		conn.WriteTo(rsp, addr)
+	}
+}
+
+func TestSelectLoopback(t *testing.T) {
+	servers := []struct {
+		offset, delay time.Duration
+	}{
+		{0, 0},
+		{2 * time.Millisecond, 0},
+		{0, 5 * time.Millisecond},
+		{3 * time.Second, 0}, // falseticker
+	}
+	var cands []*Candidate
+	for _, s := range servers {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Skip("no loopback:", err)
+		}
+		defer conn.Close()
+		clock := LocalClock(time.Now())
+		clock.Offset = s.offset
+		go serve(conn, clock, s.delay)
+
+		cand, err := Measure(conn.LocalAddr().String(), 3, 10*time.Millisecond, time.Second, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cands = append(cands, cand)
+	}
+
+	if cands[2].Sample.Delay < 5*time.Millisecond {
+		t.Errorf("delay %v, want at least 5ms", cands[2].Sample.Delay)
+	}
+	sel, err := Select(cands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cands[3].Status != Falseticker {
+		t.Errorf("server with a 3s offset is %q, want a falseticker", cands[3].Status)
+	}
+	if math.Abs(sel.Offset.Seconds()) > 0.01 {
+		t.Errorf("offset %v, want near 0", sel.Offset)
+	}
+}
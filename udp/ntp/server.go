@@ -0,0 +1,113 @@
+package ntp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Clock describes the clock served by a server, the fields of its
+// responses that do not depend on the request
+type Clock struct {
+	Leap           LeapIndicator
+	Stratum        uint8
+	ReferenceID    uint32
+	Precision      int8
+	RootDelay      Short
+	RootDispersion Short
+
+	// ReferenceTime is when the clock was last set or corrected
+	ReferenceTime time.Time
+
+	// Offset is added to the times served, to simulate a server
+	// with a wrong clock when testing clients
+	Offset time.Duration
+
+	// Now returns the time served, time.Now if nil
+	Now func() time.Time
+}
+
+// LocalClock returns the clock of a server that serves its system
+// clock without a reference: stratum 10, reference ID RefIDLocal.  The
+// clock is assumed set at time set.
+func LocalClock(set time.Time) *Clock {
+	return &Clock{
+		Stratum:       10,
+		ReferenceID:   RefIDLocal,
+		Precision:     Precision(time.Microsecond),
+		ReferenceTime: set,
+	}
+}
+
+// Respond returns the response to request req received at time recv
+// (T2), read from the same clock as Now.  The response echoes the
+// transmit time of the client as its origin time, and is stamped with
+// the current time (T3) as late as possible, so the caller should send
+// it right away.
+func (c *Clock) Respond(req *Packet, recv time.Time) *Packet {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	return &Packet{
+		Leap:           c.Leap,
+		Version:        req.Version,
+		Mode:           ModeServer,
+		Stratum:        c.Stratum,
+		Poll:           req.Poll,
+		Precision:      c.Precision,
+		RootDelay:      c.RootDelay,
+		RootDispersion: c.RootDispersion,
+		ReferenceID:    c.ReferenceID,
+		ReferenceTime:  TimestampOf(c.ReferenceTime),
+		OriginTime:     req.TransmitTime,
+		ReceiveTime:    TimestampOf(recv.Add(c.Offset)),
+		TransmitTime:   TimestampOf(now().Add(c.Offset)),
+	}
+}
+
+// Kiss returns the kiss-o'-death response to req with code, such as
+// "RATE" or "DENY": stratum 0 and the code as reference ID, without
+// times
+func Kiss(req *Packet, code string) *Packet {
+	return &Packet{
+		Leap:        LeapNotInSync,
+		Version:     req.Version,
+		Mode:        ModeServer,
+		Poll:        req.Poll,
+		ReferenceID: RefID(code),
+		OriginTime:  req.TransmitTime,
+	}
+}
+
+// ParseRefID parses the reference ID advertised at a stratum: an IPv4
+// address, or for stratum 1 a clock name of up to four characters
+// (i.e. "GPS", "PPS")
+func ParseRefID(s string, stratum uint8) (uint32, error) {
+	if ip := net.ParseIP(s).To4(); ip != nil {
+		return RefID(string(ip)), nil
+	}
+	if stratum > 1 {
+		return 0, fmt.Errorf("ntp: reference %q must be an IPv4 address for stratum %d", s, stratum)
+	}
+	if s == "" || len(s) > 4 {
+		return 0, fmt.Errorf("ntp: invalid reference clock name %q", s)
+	}
+	return RefID(s), nil
+}
+
+// ParseLeap parses a leap indicator: none, add, del, or unsync
+func ParseLeap(s string) (LeapIndicator, error) {
+	switch strings.ToLower(s) {
+	case "none", "":
+		return LeapNone, nil
+	case "add":
+		return LeapAddSecond, nil
+	case "del":
+		return LeapDelSecond, nil
+	case "unsync":
+		return LeapNotInSync, nil
+	}
+	return 0, fmt.Errorf("ntp: invalid leap indicator %q", s)
+}
@@ -0,0 +1,121 @@
+package ntp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// SIVKeySize is the key size of AEAD_AES_SIV_CMAC_256: a CMAC key and
+// a CTR key of 128 bits each
+const SIVKeySize = 32
+
+var errOpen = errors.New("ntp: message authentication failed")
+
+// siv implements AEAD_AES_SIV_CMAC_256 (RFC 5297), the AEAD algorithm
+// of NTS: the synthetic IV is the CMAC-based PRF (S2V) of the
+// associated data, the nonce and the plaintext, and also the tag; the
+// plaintext is encrypted in CTR mode from that IV.
+type siv struct {
+	macKey []byte
+	ctr    cipher.Block
+}
+
+// NewAESSIV returns AEAD_AES_SIV_CMAC_256 with a SIVKeySize key.  The
+// nonce may have any length, including none.
+func NewAESSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != SIVKeySize {
+		return nil, fmt.Errorf("ntp: AES-SIV needs a %d-byte key, got %d", SIVKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key[16:])
+	if err != nil {
+		return nil, err
+	}
+	return &siv{macKey: key[:16], ctr: block}, nil
+}
+
+func (s *siv) NonceSize() int { return aes.BlockSize }
+func (s *siv) Overhead() int  { return aes.BlockSize }
+
+// Seal appends the IV (tag) and the encrypted plaintext to dst
+func (s *siv) Seal(dst, nonce, plaintext, ad []byte) []byte {
+	iv := s.s2v(ad, nonce, plaintext)
+	ret, out := sliceForAppend(dst, len(iv)+len(plaintext))
+	copy(out, iv)
+	s.xorKeyStream(out[len(iv):], plaintext, iv)
+	return ret
+}
+
+// Open decrypts and authenticates ciphertext, and appends the plaintext
+// to dst
+func (s *siv) Open(dst, nonce, ciphertext, ad []byte) ([]byte, error) {
+	if len(ciphertext) < aes.BlockSize {
+		return nil, errOpen
+	}
+	iv, ciphertext := ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:]
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	s.xorKeyStream(out, ciphertext, iv)
+	if subtle.ConstantTimeCompare(iv, s.s2v(ad, nonce, out)) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// s2v returns the synthetic IV of the associated data, the nonce (if
+// any) and the plaintext
+func (s *siv) s2v(ad, nonce, plaintext []byte) []byte {
+	var d [aes.BlockSize]byte
+	copy(d[:], cmac(s.macKey, d[:]))
+	components := [][]byte{ad}
+	if len(nonce) > 0 {
+		components = append(components, nonce)
+	}
+	for _, c := range components {
+		double(&d)
+		subtle.XORBytes(d[:], d[:], cmac(s.macKey, c))
+	}
+
+	// the plaintext is xored into the end of d when long enough,
+	// otherwise padded and xored with d doubled
+	var t []byte
+	if len(plaintext) >= aes.BlockSize {
+		t = append([]byte(nil), plaintext...)
+		end := t[len(t)-aes.BlockSize:]
+		subtle.XORBytes(end, end, d[:])
+	} else {
+		double(&d)
+		var pad [aes.BlockSize]byte
+		copy(pad[:], plaintext)
+		pad[len(plaintext)] = 0x80
+		subtle.XORBytes(d[:], d[:], pad[:])
+		t = d[:]
+	}
+	return cmac(s.macKey, t)
+}
+
+// xorKeyStream encrypts or decrypts src in CTR mode from the IV, with
+// the bits 31 and 63 cleared
+func (s *siv) xorKeyStream(dst, src, iv []byte) {
+	var ctr [aes.BlockSize]byte
+	copy(ctr[:], iv)
+	ctr[8] &= 0x7f
+	ctr[12] &= 0x7f
+	cipher.NewCTR(s.ctr, ctr[:]).XORKeyStream(dst, src)
+}
+
+// sliceForAppend extends in by n bytes, and returns the extended slice
+// and its last n bytes
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	return head, head[len(in):]
+}
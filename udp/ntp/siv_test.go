@@ -0,0 +1,73 @@
+package ntp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// RFC 5297, appendix A.1.  The vector of A.2 takes two associated data
+// components, while NTS, and so the cipher.AEAD here, has a single one.
+func TestSIV(t *testing.T) {
+	key := unhex(t, "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff")
+	ad := unhex(t, "10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627")
+	plaintext := unhex(t, "11223344 55667788 99aabbcc ddee")
+	want := unhex(t, "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c")
+
+	aead, err := NewAESSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefix")
+	sealed := aead.Seal(append([]byte(nil), prefix...), nil, plaintext, ad)
+	if !bytes.HasPrefix(sealed, prefix) || !bytes.Equal(sealed[len(prefix):], want) {
+		t.Fatalf("got %x, want %x", sealed[len(prefix):], want)
+	}
+	got, err := aead.Open(nil, nil, want, ad)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("got %x, %v, want %x", got, err, plaintext)
+	}
+
+	// any change to the tag, the ciphertext, the associated data or the
+	// nonce fails
+	for i := range want {
+		tampered := append([]byte(nil), want...)
+		tampered[i] ^= 1
+		if _, err := aead.Open(nil, nil, tampered, ad); err == nil {
+			t.Errorf("byte %d changed: opened", i)
+		}
+	}
+	if _, err := aead.Open(nil, nil, want, ad[1:]); err == nil {
+		t.Error("other associated data: opened")
+	}
+	if _, err := aead.Open(nil, []byte("nonce"), want, ad); err == nil {
+		t.Error("other nonce: opened")
+	}
+	if _, err := aead.Open(nil, nil, want[:15], ad); err == nil {
+		t.Error("short ciphertext: opened")
+	}
+
+	if _, err := NewAESSIV(key[:16]); err == nil {
+		t.Error("16-byte key accepted")
+	}
+}
+
+// TestSIVNonce seals with a nonce, and with plaintexts shorter and
+// longer than a block, which take different paths in S2V
+func TestSIVNonce(t *testing.T) {
+	aead, err := NewAESSIV(bytes.Repeat([]byte{7}, SIVKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := bytes.Repeat([]byte{1}, aead.NonceSize())
+	for _, n := range []int{0, 1, 15, 16, 17, 100} {
+		plaintext := bytes.Repeat([]byte{'p'}, n)
+		sealed := aead.Seal(nil, nonce, plaintext, []byte("ad"))
+		if len(sealed) != n+aead.Overhead() {
+			t.Errorf("%d bytes: sealed into %d bytes", n, len(sealed))
+		}
+		got, err := aead.Open(nil, nonce, sealed, []byte("ad"))
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes: got %q, %v", n, got, err)
+		}
+	}
+}
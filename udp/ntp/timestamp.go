@@ -0,0 +1,104 @@
+package ntp
+
+import (
+	"math"
+	"time"
+)
+
+// unixOffset is the number of seconds between the NTP epoch
+// (1900-01-01) and the Unix epoch (1970-01-01)
+const unixOffset = 2208988800
+
+// Timestamp is an NTP timestamp: seconds since 1900 in the upper 32
+// bits, and a fraction of a second in the lower 32 bits (32.32 fixed
+// point).  The seconds wrap every 136 years, the first time on
+// 2036-02-07 (era 1).
+type Timestamp uint64
+
+// TimestampOf returns the timestamp of t, zero for the zero time.  The
+// rollover instant of an era, whose timestamp would be zero (unknown),
+// is stamped 2^-32 s later.
+func TimestampOf(t time.Time) Timestamp {
+	if t.IsZero() {
+		return 0
+	}
+	// the conversion to uint32 drops the era
+	secs := uint32(t.Unix() + unixOffset)
+	frac := (uint64(t.Nanosecond())<<32 + uint64(time.Second)/2) / uint64(time.Second)
+	if ts := Timestamp(uint64(secs)<<32 + frac); ts != 0 {
+		return ts
+	}
+	return 1
+}
+
+// Seconds returns the integer part of the timestamp
+func (ts Timestamp) Seconds() uint32 {
+	return uint32(ts >> 32)
+}
+
+// Fraction returns the fractional part of the timestamp, in 1/2^32 s
+func (ts Timestamp) Fraction() uint32 {
+	return uint32(ts)
+}
+
+// IsZero reports whether the timestamp is zero, which means unknown
+func (ts Timestamp) IsZero() bool {
+	return ts == 0
+}
+
+// Time returns the time of the timestamp, zero for a zero timestamp.
+// The era is chosen as in RFC 4330: timestamps with the most
+// significant bit set are between 1968 and 2036 (era 0), the others
+// are after 2036 (era 1).  This works until 2104.
+func (ts Timestamp) Time() time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	secs := int64(ts.Seconds()) - unixOffset
+	if ts.Seconds()&0x80000000 == 0 {
+		secs += 1 << 32
+	}
+	nsec := (int64(ts.Fraction())*int64(time.Second) + 1<<31) >> 32
+	return time.Unix(secs, nsec).UTC()
+}
+
+func (ts Timestamp) String() string {
+	if ts == 0 {
+		return "0"
+	}
+	return ts.Time().Format(time.RFC3339Nano)
+}
+
+// Short is the short NTP format used for the root delay and dispersion:
+// seconds in the upper 16 bits, fraction in the lower 16 bits (16.16)
+type Short uint32
+
+// ShortOf returns d in short format, saturated to [0, 65536s)
+func ShortOf(d time.Duration) Short {
+	if d <= 0 {
+		return 0
+	}
+	v := (uint64(d) << 16) / uint64(time.Second)
+	if v > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return Short(v)
+}
+
+// Duration returns the value as a duration
+func (s Short) Duration() time.Duration {
+	return time.Duration((uint64(s) * uint64(time.Second)) >> 16)
+}
+
+func (s Short) String() string {
+	return s.Duration().String()
+}
+
+// Precision returns the precision field of a clock with resolution d,
+// the exponent of its resolution in seconds (log2), i.e. -20 for 1µs
+func Precision(d time.Duration) int8 {
+	if d <= 0 {
+		d = 1
+	}
+	return int8(math.Floor(math.Log2(d.Seconds())))
+}
@@ -0,0 +1,141 @@
+package ntp
+
+import (
+	"sync"
+	"time"
+)
+
+// MaxSlew is the rate at which a VirtualClock amortizes a phase
+// correction, 500µs per second like the kernel adjtime
+const MaxSlew = 500e-6
+
+// VirtualClock is a clock derived from a base clock, normally the system
+// clock, by a phase correction and a frequency correction.  A time
+// daemon disciplines the virtual clock without changing the system
+// clock, which needs privileges and affects every program.
+type VirtualClock struct {
+	base func() time.Time
+
+	mu    sync.Mutex
+	ref   time.Time     // base time of the last adjustment
+	phase time.Duration // correction at ref
+	freq  float64       // frequency correction, in s/s
+	slew  time.Duration // phase correction left to amortize from ref
+}
+
+// NewVirtualClock returns a virtual clock following base, time.Now if
+// nil, without corrections
+func NewVirtualClock(base func() time.Time) *VirtualClock {
+	if base == nil {
+		base = time.Now
+	}
+	return &VirtualClock{base: base, ref: base()}
+}
+
+// Now returns the corrected time
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.base()
+	return now.Add(c.correction(now))
+}
+
+// Base returns the time of the base clock
+func (c *VirtualClock) Base() time.Time {
+	return c.base()
+}
+
+// Correction returns the difference between the virtual clock and the
+// base clock
+func (c *VirtualClock) Correction() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.correction(c.base())
+}
+
+// correction returns the correction at base time now, the phase plus
+// the frequency correction and the slew applied since ref
+func (c *VirtualClock) correction(now time.Time) time.Duration {
+	elapsed := now.Sub(c.ref)
+	return c.phase + time.Duration(c.freq*float64(elapsed)) + c.slewed(elapsed)
+}
+
+// slewed returns the part of the slew amortized after elapsed
+func (c *VirtualClock) slewed(elapsed time.Duration) time.Duration {
+	max := time.Duration(MaxSlew * float64(elapsed))
+	switch {
+	case c.slew > max:
+		return max
+	case c.slew < -max:
+		return -max
+	}
+	return c.slew
+}
+
+// rebase folds the corrections applied so far into the phase
+func (c *VirtualClock) rebase() {
+	now := c.base()
+	elapsed := now.Sub(c.ref)
+	done := c.slewed(elapsed)
+	c.phase += time.Duration(c.freq*float64(elapsed)) + done
+	c.slew -= done
+	c.ref = now
+}
+
+// Step corrects the clock by offset at once
+func (c *VirtualClock) Step(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebase()
+	c.phase += offset
+	c.slew = 0
+}
+
+// Slew corrects the clock by offset gradually, at MaxSlew.  It replaces
+// the correction still in progress, since offset is measured against
+// the clock as it is now.
+func (c *VirtualClock) Slew(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebase()
+	c.slew = offset
+}
+
+// Freq returns the frequency correction, in s/s (1e-6 is 1 ppm)
+func (c *VirtualClock) Freq() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.freq
+}
+
+// SetFreq sets the frequency correction, in s/s
+func (c *VirtualClock) SetFreq(freq float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebase()
+	c.freq = freq
+}
+
+// Reset removes the phase corrections, applied or not, and returns
+// them: the caller moves them to the base clock (i.e. adjusts the
+// system clock).  The frequency correction is kept.
+func (c *VirtualClock) Reset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebase()
+	corr := c.phase + c.slew
+	c.phase, c.slew = 0, 0
+	return corr
+}
+
+// DriftingClock returns a clock that starts offset away from base and
+// drifts away from it by ppm parts per million, to simulate a bad
+// oscillator when testing a clock discipline
+func DriftingClock(base func() time.Time, offset time.Duration, ppm float64) func() time.Time {
+	start := base()
+	return func() time.Time {
+		now := base()
+		drift := time.Duration(float64(now.Sub(start)) * ppm * 1e-6)
+		return now.Add(offset + drift)
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
 )
 
 // This program implements an NTP client that is capable of
@@ -16,23 +17,39 @@ import (
 //
 // The program uses -host to specify the remote address
 // (or socket path) and -n for the network protocl ("udp" or "datagram").
+//
+// Like ntpdate -q, the client takes several samples (-c, spaced by
+// -i) and reports the offset of the local clock, the round trip delay
+// and the jitter of the sample with the smallest delay.
+//
+// Requests are authenticated with -key, the ID of a key of the keys
+// file -keys (see ntp.ParseKeys), and only responses authenticated with
+// the same key are accepted.
 func main() {
 	var host string
 	var network string
+	var count int
+	var keysFile string
+	var keyID uint
+	var interval, timeout time.Duration
 	flag.StringVar(&host, "e", "us.pool.ntp.org:123", "NTP host")
 	flag.StringVar(&network, "n", "udp", "network protocol to use")
+	flag.IntVar(&count, "c", 4, "number of samples")
+	flag.DurationVar(&interval, "i", 2*time.Second, "interval between samples")
+	flag.DurationVar(&timeout, "t", 2*time.Second, "response timeout")
+	flag.StringVar(&keysFile, "keys", "/etc/ntp.keys", "keys file")
+	flag.UintVar(&keyID, "key", 0, "ID of the key authenticating requests, 0 for none")
 	flag.Parse()
 
-	// req data packet is a 48-byte long value
-	// that is used for sending time request.
-	req := make([]byte, 48)
-
-	// req is initialized with 0x1B or 0001 1011 which is
-	// a request setting for time server.
-	req[0] = 0x1B
-
-	// rsp byte slice used to receive server response
-	rsp := make([]byte, 48)
+	var auth ntp.Authenticator
+	if keyID != 0 {
+		key, err := ntp.LoadKey(keysFile, uint32(keyID))
+		if err != nil {
+			fmt.Println("failed to load key:", err)
+			os.Exit(1)
+		}
+		auth = key
+	}
 
 	// Create a Dialer which allows us to specify dialing options.
 	// We will need this a bit later to configure the local address
@@ -63,39 +80,37 @@ func main() {
 
 	fmt.Printf("time from (%s) (%s)\n", network, conn.RemoteAddr())
 
-	// send time request
-	if _, err = conn.Write(req); err != nil {
-		fmt.Printf("failed to send request: %v\n", err)
-		os.Exit(1)
+	// take several samples and keep the one with the smallest
+	// round trip delay, the least disturbed by the network
+	// (like ntpdate -q).  Each request carries the local time
+	// (T1), the response holds the times the server received
+	// it (T2) and answered (T3), and the client records when
+	// the response arrived (T4).  See package ntp.
+	sampler := &ntp.Sampler{
+		Count:    count,
+		Interval: interval,
+		Timeout:  timeout,
+		Auth:     auth,
+		Sampled: func(n int, sample ntp.Sample, err error) {
+			if err != nil {
+				fmt.Printf("sample %d: %v\n", n, err)
+				return
+			}
+			fmt.Printf("sample %d: offset %+.6f, delay %.6f\n", n,
+				sample.Offset.Seconds(), sample.Delay.Seconds())
+		},
 	}
-
-	// block to receive server response
-	read, err := conn.Read(rsp)
+	samples, err := sampler.Sample(conn)
 	if err != nil {
-		fmt.Printf("failed to receive response: %v\n", err)
-		os.Exit(1)
-	}
-	//ensure we read 48 bytes back (NTP protocol spec)
-	if read != 48 {
-		fmt.Println("did not get all expected bytes from server")
+		fmt.Println("no sample from server:", err)
 		os.Exit(1)
 	}
 
-	// NTP data comes in as big-endian (LSB [0...47] MSB)
-	// with a 64-bit value containing the server time in seconds
-	// where the first 32-bits are seconds and last 32-bit are fractional.
-	// The following extracts the seconds from [0...[40:43]...47]
-	// it is the number of secs since 1900 (NTP epoch)
-	secs := binary.BigEndian.Uint32(rsp[40:])
-	frac := binary.BigEndian.Uint32(rsp[44:])
+	best, jitter := ntp.Filter(samples)
+	fmt.Printf("server %s, stratum %d, offset %+.6f, delay %.6f, jitter %.6f\n",
+		conn.RemoteAddr(), best.Response.Stratum, best.Offset.Seconds(),
+		best.Delay.Seconds(), jitter.Seconds())
 
-	// Many OSs use Unix time epoch which is num of secs since 1970,
-	// while NTP's ephoch starts on Jan 1, 1900.  Therefore,
-	// to get the correct time, we must adjust the epocs properly
-	// by removing 70 yrs of seconds (1970-1900) offset.
-	ntpEpoch := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	unixEpoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
-	offset := unixEpoch.Sub(ntpEpoch).Seconds()
-	now := float64(secs) - offset
-	fmt.Printf("%v\n", time.Unix(int64(now), int64(frac)))
+	// the server time is the local time corrected by the offset
+	fmt.Printf("%v\n", time.Now().Add(best.Offset).Round(0))
 }
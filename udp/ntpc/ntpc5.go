@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// This program implements an NTP client that polls several servers
+// concurrently, and combines their answers with the clock selection
+// algorithm of NTP (see ntp.Select): the intersection algorithm finds
+// the interval that a majority of servers agrees on and rejects the
+// others (falsetickers), the clustering discards the outliers, and the
+// offsets of the survivors are combined.
+//
+// The servers are listed like ntpq does, with a tally code:
+//   * system peer, + survivor, - outlier, x falseticker
+//
+// Usage: ntpc5 [options]
+// options:
+//   -e comma-separated NTP servers, default 0-3.us.pool.ntp.org:123
+//   -c samples per server, default 4
+//   -i interval between samples, default 2s
+//   -t response timeout, default 2s
+//   -keys keys file, default /etc/ntp.keys (see ntp.ParseKeys)
+//   -key ID of the key authenticating the requests, default 0 (none)
+//
+// Testing:
+// Several local ntps3 servers with wrong clocks (-offset) and slow
+// networks (-delay) exercise the selection without a network:
+//   ntps3 -e :1231 & ntps3 -e :1232 -delay 20ms &
+//   ntps3 -e :1233 -offset 5ms & ntps3 -e :1234 -offset 2s &
+//   ntpc5 -e localhost:1231,localhost:1232,localhost:1233,localhost:1234
+func main() {
+	var servers string
+	var count int
+	var interval, timeout time.Duration
+	var keysFile string
+	var keyID uint
+	flag.StringVar(&servers, "e",
+		"0.us.pool.ntp.org:123,1.us.pool.ntp.org:123,2.us.pool.ntp.org:123,3.us.pool.ntp.org:123",
+		"NTP servers, comma-separated")
+	flag.IntVar(&count, "c", 4, "number of samples per server")
+	flag.DurationVar(&interval, "i", 2*time.Second, "interval between samples")
+	flag.DurationVar(&timeout, "t", 2*time.Second, "response timeout")
+	flag.StringVar(&keysFile, "keys", "/etc/ntp.keys", "keys file")
+	flag.UintVar(&keyID, "key", 0, "ID of the key authenticating requests, 0 for none")
+	flag.Parse()
+
+	var auth ntp.Authenticator
+	if keyID != 0 {
+		key, err := ntp.LoadKey(keysFile, uint32(keyID))
+		if err != nil {
+			fmt.Println("failed to load key:", err)
+			os.Exit(1)
+		}
+		auth = key
+	}
+
+	// poll the servers concurrently
+	hosts := strings.Split(servers, ",")
+	cands := make([]*ntp.Candidate, len(hosts))
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			cands[i], errs[i] = ntp.Measure(strings.TrimSpace(host), count, interval, timeout, nil, auth)
+		}(i, host)
+	}
+	wg.Wait()
+
+	// only the servers that answered take part in the selection
+	var answered []*ntp.Candidate
+	for i, c := range cands {
+		if errs[i] != nil {
+			fmt.Printf("  %-30s %v\n", hosts[i], errs[i])
+			continue
+		}
+		answered = append(answered, c)
+	}
+	sel, err := ntp.Select(answered)
+
+	fmt.Printf("  %-30s %7s %12s %10s %10s %10s\n", "server", "stratum", "offset", "delay", "jitter", "distance")
+	for _, c := range answered {
+		fmt.Printf("%s %-30s %7d %+12.6f %10.6f %10.6f %10.6f\n", c.Status, c.Name,
+			c.Sample.Response.Stratum, c.Sample.Offset.Seconds(), c.Sample.Delay.Seconds(),
+			c.Jitter.Seconds(), c.RootDistance().Seconds())
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("offset %+.6f, jitter %.6f, system peer %s\n",
+		sel.Offset.Seconds(), sel.Jitter.Seconds(), sel.System.Name)
+	fmt.Printf("%d of %d servers agree, true offset within [%+.6f, %+.6f]\n",
+		sel.Truechimers, len(hosts), sel.Low.Seconds(), sel.High.Seconds())
+
+	// the server time is the local time corrected by the offset
+	fmt.Printf("%v\n", time.Now().Add(sel.Offset).Round(0))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/client"
+	"github.com/vladimirvivien/go-networking/currency/tlsdiag"
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+	"github.com/vladimirvivien/go-networking/udp/nts"
+)
+
+// This program implements an NTP client secured with Network Time
+// Security (RFC 8915, see package nts).  It first runs the NTS key
+// establishment over TLS 1.3 with the server given by -e, which
+// returns the keys and cookies of the session and the NTP server to
+// query; then each NTP request carries a cookie and is authenticated,
+// and only authenticated responses are accepted.
+//
+// The TLS configuration is loaded like the currency TLS clients: the
+// server certificate is verified with the CA of -ca, i.e. the local CA
+// of the repository when testing with ntps3 on localhost:
+//   ntps3 -nts-ke :4460 &
+//   ntpc6 -e localhost:4460
+//
+// Usage: ntpc6 [options]
+// options:
+//   -e NTS-KE server, default localhost:4460
+//   -ca CA certificate, default ../../currency/certs/ca-cert.pem
+//   -servername server name to verify, default the host of -e
+//   -c samples, default 4
+//   -i interval between samples, default 2s
+//   -t timeout of the key exchange and of the responses, default 2s
+func main() {
+	var addr, ca, serverName string
+	var count int
+	var interval, timeout time.Duration
+	flag.StringVar(&addr, "e", "localhost:4460", "NTS-KE server")
+	flag.StringVar(&ca, "ca", "../../currency/certs/ca-cert.pem", "CA certificate")
+	flag.StringVar(&serverName, "servername", "", "server name to verify")
+	flag.IntVar(&count, "c", 4, "number of samples")
+	flag.DurationVar(&interval, "i", 2*time.Second, "interval between samples")
+	flag.DurationVar(&timeout, "t", 2*time.Second, "response timeout")
+	flag.Parse()
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(nts.DefaultKEPort))
+	}
+
+	// NTS key establishment
+	tlsConf, err := client.LoadTLSConfig(ca, "", "")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tlsConf.ServerName = serverName
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	tlsConn, timing, err := tlsdiag.Dial(ctx, "tcp", addr, nts.ClientConfig(tlsConf))
+	if err != nil {
+		fmt.Println("key exchange failed:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("NTS-KE %s: %s (%s)\n", addr, tlsdiag.Summary(tlsConn.ConnectionState()), timing)
+	session, err := nts.Exchange(tlsConn)
+	if err != nil {
+		fmt.Println("key exchange failed:", err)
+		os.Exit(1)
+	}
+	ntpAddr := session.Addr(addr)
+	fmt.Printf("NTP server %s, %d cookies\n", ntpAddr, len(session.Cookies))
+
+	conn, err := net.Dial("udp", ntpAddr)
+	if err != nil {
+		fmt.Printf("failed to connect: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	// each sample uses a cookie, the response brings a new one
+	sampler := &ntp.Sampler{
+		Count:    count,
+		Interval: interval,
+		Timeout:  timeout,
+		Auth:     session,
+		Sampled: func(n int, sample ntp.Sample, err error) {
+			if err != nil {
+				fmt.Printf("sample %d: %v\n", n, err)
+				return
+			}
+			fmt.Printf("sample %d: offset %+.6f, delay %.6f, %d cookies\n", n,
+				sample.Offset.Seconds(), sample.Delay.Seconds(), len(session.Cookies))
+		},
+	}
+	samples, err := sampler.Sample(conn)
+	if err != nil {
+		fmt.Println("no authenticated sample from server:", err)
+		os.Exit(1)
+	}
+
+	best, jitter := ntp.Filter(samples)
+	fmt.Printf("server %s, stratum %d, offset %+.6f, delay %.6f, jitter %.6f\n",
+		conn.RemoteAddr(), best.Response.Stratum, best.Offset.Seconds(),
+		best.Delay.Seconds(), jitter.Seconds())
+
+	// the server time is the local time corrected by the offset
+	fmt.Printf("%v\n", time.Now().Add(best.Offset).Round(0))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// This program implements an NTP broadcast client (RFC 5905): instead
+// of polling a server, it listens to the broadcasts a server sends to an
+// IPv4 broadcast address or a multicast group of the LAN (see ntps3.go,
+// -broadcast).  A broadcast only carries the time of the server when it
+// was sent, so the client first calibrates the round trip delay with a
+// few client/server exchanges with the server of the first broadcast,
+// then computes its offset from each broadcast, assuming half the delay
+// on the way (see package ntp).
+//
+// Broadcasts signed with the key -key of the keys file -keys are the
+// only ones accepted, and the calibration requests are authenticated
+// with the same key.  Broadcasts that do not advance the transmit time
+// of their server, such as replays of captured broadcasts, are skipped.
+//
+// Usage: ntpc7 [options]
+// options:
+//   -g broadcast address or multicast group to listen to,
+//      default 224.0.1.1:123
+//   -if interface joining the multicast group, default the system choice
+//   -c calibration samples, default 4
+//   -i interval between calibration samples, default 2s
+//   -t response timeout, default 2s
+//   -b broadcasts to receive, default 0 (until interrupted)
+//   -w time to wait for a broadcast, default 0 (forever)
+//   -keys keys file, default /etc/ntp.keys (see ntp.ParseKeys)
+//   -key ID of the key of the server, default 0 (none)
+//
+// Testing:
+// Multicast also works on a single host, the kernel loops the groups
+// joined back to the local sockets.  The group needs its own port, the
+// client listens to it on all addresses:
+//   ntps3 -broadcast 224.0.1.1:1124 -broadcast-if lo -broadcast-poll 1 &
+//   ntpc7 -g 224.0.1.1:1124 -if lo -b 5
+func main() {
+	var group, ifName string
+	var count, broadcasts int
+	var interval, timeout, wait time.Duration
+	var keysFile string
+	var keyID uint
+	flag.StringVar(&group, "g", "224.0.1.1:123", "broadcast address or multicast group")
+	flag.StringVar(&ifName, "if", "", "interface joining the multicast group")
+	flag.IntVar(&count, "c", 4, "number of calibration samples")
+	flag.DurationVar(&interval, "i", 2*time.Second, "interval between calibration samples")
+	flag.DurationVar(&timeout, "t", 2*time.Second, "response timeout")
+	flag.IntVar(&broadcasts, "b", 0, "broadcasts to receive, 0 until interrupted")
+	flag.DurationVar(&wait, "w", 0, "time to wait for a broadcast, 0 forever")
+	flag.StringVar(&keysFile, "keys", "/etc/ntp.keys", "keys file")
+	flag.UintVar(&keyID, "key", 0, "ID of the key of the server, 0 for none")
+	flag.Parse()
+
+	var key *ntp.Key
+	var auth ntp.Authenticator
+	if keyID != 0 {
+		var err error
+		if key, err = ntp.LoadKey(keysFile, uint32(keyID)); err != nil {
+			fmt.Println("failed to load key:", err)
+			os.Exit(1)
+		}
+		auth = key
+	}
+
+	var ifi *net.Interface
+	if ifName != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(ifName); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	conn, err := ntp.ListenBroadcast(group, ifi)
+	if err != nil {
+		fmt.Println("failed to listen:", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	fmt.Printf("listening for broadcasts to %s\n", group)
+
+	// the first broadcast names the server
+	receiver := &ntp.BroadcastReceiver{Conn: conn, Key: key}
+	first, server, err := receiver.Read(0, wait)
+	if err != nil {
+		fmt.Println("no broadcast:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("broadcast from %s, stratum %d, poll %gs\n", server,
+		first.Response.Stratum, math.Ldexp(1, int(first.Response.Poll)))
+
+	// calibrate the delay in client/server mode, with the address the
+	// broadcasts come from
+	delay, err := calibrate(server.String(), count, interval, timeout, auth)
+	if err != nil {
+		fmt.Println("calibration failed:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("calibrated delay %.6f\n", delay.Seconds())
+
+	// track the time from the broadcasts of the server, the others
+	// are ignored
+	var last ntp.Sample
+	for n := 0; broadcasts == 0 || n < broadcasts; {
+		sample, from, err := receiver.Read(delay, wait)
+		if err != nil {
+			fmt.Println("no broadcast:", err)
+			os.Exit(1)
+		}
+		if from.String() != server.String() {
+			continue
+		}
+		n++
+		fmt.Printf("broadcast %d: offset %+.6f\n", n, sample.Offset.Seconds())
+		last = sample
+	}
+
+	fmt.Printf("server %s, stratum %d, offset %+.6f, delay %.6f\n",
+		server, last.Response.Stratum, last.Offset.Seconds(), delay.Seconds())
+
+	// the server time is the local time corrected by the offset
+	fmt.Printf("%v\n", time.Now().Add(last.Offset).Round(0))
+}
+
+// calibrate returns the round trip delay to the server at addr, the
+// smallest of count samples interval apart
+func calibrate(addr string, count int, interval, timeout time.Duration, auth ntp.Authenticator) (time.Duration, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	sampler := &ntp.Sampler{
+		Count:    count,
+		Interval: interval,
+		Timeout:  timeout,
+		Auth:     auth,
+		Sampled: func(n int, sample ntp.Sample, err error) {
+			if err != nil {
+				fmt.Printf("sample %d: %v\n", n, err)
+				return
+			}
+			fmt.Printf("sample %d: offset %+.6f, delay %.6f\n", n,
+				sample.Offset.Seconds(), sample.Delay.Seconds())
+		},
+	}
+	samples, err := sampler.Sample(conn)
+	if err != nil {
+		return 0, err
+	}
+	best, _ := ntp.Filter(samples)
+	return best.Delay, nil
+}
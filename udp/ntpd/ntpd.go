@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// This program is a long-running time synchronization daemon.  It polls
+// a set of NTP servers (see ntpc5.go), disciplines a virtual clock with
+// the offsets they report, and serves the corrected time on a local
+// unix datagram socket with the protocol of ntps, so that ntpc2 or
+// ntpc4 (-n unixgram) can query it.
+//
+// Focus:
+// The virtual clock is the system clock plus a phase and a frequency
+// correction (see ntp.VirtualClock).  The clock discipline (see
+// ntp.Discipline) first sets the clock, then measures its frequency
+// error over a few polls, then keeps it locked with a PLL/FLL loop.  The poll interval,
+// 2^poll seconds, grows while the clock is stable and shrinks when it
+// is not.  The system clock is left alone unless -sync-system is given.
+//
+// Testing:
+// -sim-offset and -sim-drift replace the system clock with a clock that
+// is wrong and drifts, to watch the discipline converge, i.e. against
+// local ntps3 servers with short polls:
+//   ntps3 -e :1231 & ntps3 -e :1232 & ntps3 -e :1233 &
+//   ntpd -e localhost:1231,localhost:1232,localhost:1233 -minpoll 1 -maxpoll 3 \
+//     -c 1 -sim-offset 50ms -sim-drift 200
+//
+// Usage: ntpd [options]
+// options:
+//   -e comma-separated NTP servers, default 0-3.us.pool.ntp.org:123
+//   -l unix datagram socket serving the time, default "/tmp/time.sock"
+//   -minpoll, -maxpoll bounds of the poll exponent, default 6 (64s), 10 (1024s)
+//   -c samples per server and poll, default 4
+//   -i interval between samples, default 2s
+//   -t response timeout, default 2s
+//   -keys keys file, default /etc/ntp.keys (see ntp.ParseKeys)
+//   -key ID of the key authenticating the requests, default 0 (none)
+//   -sync-system moves the corrections of the virtual clock to the system
+//                clock after each poll (needs privileges): offsets up to
+//                ntp.StepThreshold (128ms) are slewed with adjtime, larger
+//                ones step it, default false
+//   -sim-offset, -sim-drift offset and drift (ppm) of a simulated clock
+func main() {
+	var servers, sock, keysFile string
+	var minPoll, maxPoll, count int
+	var keyID uint
+	var interval, timeout, simOffset time.Duration
+	var simDrift float64
+	var syncSystem bool
+	flag.StringVar(&servers, "e",
+		"0.us.pool.ntp.org:123,1.us.pool.ntp.org:123,2.us.pool.ntp.org:123,3.us.pool.ntp.org:123",
+		"NTP servers, comma-separated")
+	flag.StringVar(&sock, "l", "/tmp/time.sock", "unix datagram socket serving the time")
+	flag.IntVar(&minPoll, "minpoll", 6, "minimum poll exponent [poll interval 2^minpoll s]")
+	flag.IntVar(&maxPoll, "maxpoll", 10, "maximum poll exponent")
+	flag.IntVar(&count, "c", 4, "number of samples per server and poll")
+	flag.DurationVar(&interval, "i", 2*time.Second, "interval between samples")
+	flag.DurationVar(&timeout, "t", 2*time.Second, "response timeout")
+	flag.StringVar(&keysFile, "keys", "/etc/ntp.keys", "keys file")
+	flag.UintVar(&keyID, "key", 0, "ID of the key authenticating requests, 0 for none")
+	flag.BoolVar(&syncSystem, "sync-system", false, "slew or step the system clock to the virtual clock")
+	flag.DurationVar(&simOffset, "sim-offset", 0, "offset of a simulated system clock [testing]")
+	flag.Float64Var(&simDrift, "sim-drift", 0, "drift of a simulated system clock, in ppm [testing]")
+	flag.Parse()
+
+	if minPoll < 0 || maxPoll > ntp.MaxPoll || minPoll > maxPoll {
+		fmt.Printf("invalid poll range %d-%d\n", minPoll, maxPoll)
+		os.Exit(1)
+	}
+	var auth ntp.Authenticator
+	if keyID != 0 {
+		key, err := ntp.LoadKey(keysFile, uint32(keyID))
+		if err != nil {
+			fmt.Println("failed to load key:", err)
+			os.Exit(1)
+		}
+		auth = key
+	}
+
+	base := time.Now
+	if simOffset != 0 || simDrift != 0 {
+		if syncSystem {
+			fmt.Println("-sync-system cannot be used with a simulated clock")
+			os.Exit(1)
+		}
+		base = ntp.DriftingClock(time.Now, simOffset, simDrift)
+		log.Printf("simulated clock: offset %v, drift %+.3f ppm", simOffset, simDrift)
+	}
+	vclock := ntp.NewVirtualClock(base)
+	disc := ntp.NewDiscipline(vclock, minPoll, maxPoll)
+
+	// the clock served locally is unsynchronized until the first update
+	var served atomic.Pointer[ntp.Clock]
+	served.Store(&ntp.Clock{
+		Leap:      ntp.LeapNotInSync,
+		Stratum:   ntp.MaxStratum + 1,
+		Precision: ntp.Precision(time.Microsecond),
+		Now:       vclock.Now,
+	})
+	go serve(sock, vclock, &served)
+
+	hosts := strings.Split(servers, ",")
+	for {
+		sel, err := ntp.Select(measure(hosts, count, interval, timeout, vclock.Now, auth))
+		if err != nil {
+			log.Println("no time source:", err)
+		} else {
+			action := disc.Update(sel.Offset, sel.Jitter)
+			log.Printf("%-4s offset %+.6f freq %+8.3f ppm jitter %.6f poll %d peer %s",
+				action, sel.Offset.Seconds(), vclock.Freq()*1e6, disc.Jitter().Seconds(),
+				disc.Poll(), sel.System.Name)
+			served.Store(synchronized(sel, vclock))
+
+			if syncSystem {
+				if err := adjustSystem(vclock.Reset()); err != nil {
+					log.Println("failed to adjust the system clock:", err)
+				}
+			}
+		}
+		time.Sleep(disc.Interval())
+	}
+}
+
+// measure polls the servers concurrently and returns those that answered
+func measure(hosts []string, count int, interval, timeout time.Duration, now func() time.Time, auth ntp.Authenticator) []*ntp.Candidate {
+	cands := make([]*ntp.Candidate, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			c, err := ntp.Measure(host, count, interval, timeout, now, auth)
+			if err != nil {
+				log.Printf("%s: %v", host, err)
+				return
+			}
+			cands[i] = c
+		}(i, strings.TrimSpace(host))
+	}
+	wg.Wait()
+
+	var answered []*ntp.Candidate
+	for _, c := range cands {
+		if c != nil {
+			answered = append(answered, c)
+		}
+	}
+	return answered
+}
+
+// synchronized returns the clock served once synchronized to the
+// system peer of sel: one stratum below it, with the accumulated root
+// delay and dispersion
+func synchronized(sel *ntp.Selection, vclock *ntp.VirtualClock) *ntp.Clock {
+	peer := sel.System.Sample
+	rsp := peer.Response
+	return &ntp.Clock{
+		Leap:           rsp.Leap,
+		Stratum:        rsp.Stratum + 1,
+		ReferenceID:    ntp.RefIDOf(sel.System.Addr),
+		Precision:      ntp.Precision(time.Microsecond),
+		RootDelay:      ntp.ShortOf(rsp.RootDelay.Duration() + peer.Delay),
+		RootDispersion: ntp.ShortOf(rsp.RootDispersion.Duration() + sel.Jitter),
+		ReferenceTime:  vclock.Now(),
+		Now:            vclock.Now,
+	}
+}
+
+// serve answers the time requests received on the unix datagram
+// socket path with the clock served
+func serve(path string, vclock *ntp.VirtualClock, served *atomic.Pointer[ntp.Clock]) {
+	// remove the socket left by a previous run
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	log.Printf("serving time on (unixgram) %s", path)
+
+	buf := make([]byte, 1024)
+	for {
+		n, raddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Println("error getting request:", err)
+			return
+		}
+		recv := vclock.Now()
+		if raddr == nil {
+			continue // the client must bind a socket to get the answer
+		}
+		var req ntp.Packet
+		if err := req.UnmarshalBinary(buf[:n]); err != nil {
+			continue
+		}
+		if err := req.CheckRequest(); err != nil {
+			continue
+		}
+		rsp, err := served.Load().Respond(&req, recv).MarshalBinary()
+		if err != nil {
+			continue
+		}
+		if _, err := conn.WriteTo(rsp, raddr); err != nil {
+			log.Println("err sending data:", err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// adjustSystem moves the correction corr to the system clock, which
+// needs privileges.  Like ntpd, a correction above ntp.StepThreshold
+// steps the clock, a smaller one is slewed by the kernel, so that the
+// time seen by the other programs never jumps, nor goes backwards.
+func adjustSystem(corr time.Duration) error {
+	switch {
+	case corr == 0:
+		return nil
+	case corr > ntp.StepThreshold || corr < -ntp.StepThreshold:
+		tv := syscall.NsecToTimeval(time.Now().Add(corr).UnixNano())
+		return syscall.Settimeofday(&tv)
+	}
+	return slewSystem(corr)
+}
@@ -0,0 +1,15 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// slewSystem slews the system clock by corr with adjtime, which
+// replaces the slew in progress
+func slewSystem(corr time.Duration) error {
+	delta := syscall.NsecToTimeval(corr.Nanoseconds())
+	return syscall.Adjtime(&delta, nil)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// adjOffsetSingleshot is ADJ_OFFSET_SINGLESHOT, the adjtime mode of
+// adjtimex: the offset, in µs, is slewed at 500µs per second and
+// replaces the slew in progress
+const adjOffsetSingleshot = 0x8001
+
+// slewSystem slews the system clock by corr with adjtimex
+func slewSystem(corr time.Duration) error {
+	tx := syscall.Timex{Modes: adjOffsetSingleshot}
+	setOffset(&tx.Offset, corr.Microseconds())
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
+
+// setOffset sets the offset of a Timex, an int32 or an int64 depending
+// on the architecture
+func setOffset[T int32 | int64](offset *T, us int64) {
+	*offset = T(us)
+}
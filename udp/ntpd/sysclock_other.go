@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// adjustSystem is not supported on this system
+func adjustSystem(corr time.Duration) error {
+	return errors.New("setting the system clock is not supported on this system")
+}
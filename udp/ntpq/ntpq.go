@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// This program queries the live statistics of a running ntps3 server
+// on its control socket (ntps3 -control), like ntpq does for ntpd.  It
+// sends one command and prints the answer:
+//   stats    counters of the packets received, answered and dropped
+//   mrulist  most recent clients and their packet counts
+//   metrics  the counters in the Prometheus text format (also served
+//            over HTTP with ntps3 -metrics)
+//
+// Usage: ntpq [options] [command]
+// options:
+//   -s control socket of the server, default /tmp/ntps.sock
+//   -t timeout, default 5s
+//
+// Example:
+//   ntps3 -control /tmp/ntps.sock &
+//   ntpq mrulist
+func main() {
+	var sock string
+	var timeout time.Duration
+	flag.StringVar(&sock, "s", "/tmp/ntps.sock", "control socket of the server")
+	flag.DurationVar(&timeout, "t", 5*time.Second, "timeout")
+	flag.Parse()
+	cmd := "stats"
+	if flag.NArg() > 0 {
+		cmd = strings.Join(flag.Args(), " ")
+	}
+
+	if err := query(os.Stdout, sock, cmd, timeout); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// query sends cmd to the control socket sock, and copies the answer to
+// w.  The server answers one command, then closes the connection.
+func query(w io.Writer, sock, cmd string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("unix", sock, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+	if _, err := io.Copy(w, conn); err != nil {
+		return fmt.Errorf("failed to read answer: %w", err)
+	}
+	return nil
+}
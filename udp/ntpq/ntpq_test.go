@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+func TestQuery(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "ntps.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	control := &ntp.Control{
+		Stats:   &ntp.Stats{},
+		Monitor: ntp.NewMonitor(10),
+		Limiter: ntp.NewLimiter(1, 1),
+		Started: time.Now(),
+	}
+	control.Stats.Received.Add(7)
+	go control.Serve(ln)
+
+	tests := []struct {
+		cmd, want string
+	}{
+		{"stats", "received packets:       7\n"},
+		{"mrulist", "remote address\n"},
+		{"metrics", "ntps_requests_total 7\n"},
+		{"peers", `unknown command "peers"`},
+	}
+	for _, tt := range tests {
+		var answer strings.Builder
+		if err := query(&answer, sock, tt.cmd, 5*time.Second); err != nil {
+			t.Fatalf("%s: %v", tt.cmd, err)
+		}
+		if !strings.Contains(answer.String(), tt.want) {
+			t.Errorf("%s: got %q, want %q in it", tt.cmd, answer.String(), tt.want)
+		}
+	}
+
+	if err := query(&strings.Builder{}, filepath.Join(t.TempDir(), "none.sock"), "stats", time.Second); err == nil {
+		t.Error("query to a missing socket succeeded")
+	}
+}
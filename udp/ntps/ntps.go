@@ -1,18 +1,19 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
 )
 
 // This program is a simple Network Time Protocol server over UDP.
 // The implementation uses a UDPConn and ListenUDP to manage requests.
-// The server returns the number of seconds since 1900 up to the
-// current time.
+// The server decodes the NTP request and returns the current time
+// in an NTP packet (see package ntp).
 
 // Again this is a simple server, it dies after sending the response.
 
@@ -48,27 +49,36 @@ func main() {
 	// potentially go to a different client.  Therefore, the ReadFromXXX
 	// operation returns the remote address (saved in raddr)
 	// where to send the response.
-	_, raddr, err := conn.ReadFromUDP(make([]byte, 48))
+	buf := make([]byte, 1024)
+	n, raddr, err := conn.ReadFromUDP(buf)
 	if err != nil {
 		fmt.Println("error getting request:", err)
 		os.Exit(1)
 	}
+	recv := time.Now()
 	// ensure laddr is set
 	if raddr == nil {
 		fmt.Println("request missing remote addr")
 		os.Exit(1)
 	}
 
-	// get seconds and fractional secs since 1900
-	secs, fracs := getNTPSeconds(time.Now())
+	// decode and validate the request packet
+	var req ntp.Packet
+	if err := req.UnmarshalBinary(buf[:n]); err != nil {
+		fmt.Println("invalid request:", err)
+		os.Exit(1)
+	}
+	if err := req.CheckRequest(); err != nil {
+		fmt.Println("invalid request:", err)
+		os.Exit(1)
+	}
 
-	// response packet is filled with the seconds and
-	// fractional sec values using Big-Endian
-	rsp := make([]byte, 48)
-	// write seconds (as uint32) in buffer at [40:43]
-	binary.BigEndian.PutUint32(rsp[40:], uint32(secs))
-	// write seconds (as uint32) in buffer at [44:47]
-	binary.BigEndian.PutUint32(rsp[44:], uint32(fracs))
+	// encode the response packet
+	rsp, err := clock.Respond(&req, recv).MarshalBinary()
+	if err != nil {
+		fmt.Println("failed to encode response:", err)
+		os.Exit(1)
+	}
 
 	// send data
 	if _, err := conn.WriteToUDP(rsp, raddr); err != nil {
@@ -77,20 +87,6 @@ func main() {
 	}
 }
 
-// getNTPSecs decompose current time as NTP seconds
-func getNTPSeconds(t time.Time) (int64, int64) {
-	// convert time to total # of secs since 1970
-	// add NTP epoch offets as total #secs between 1900-1970
-	secs := t.Unix() + int64(getNTPOffset())
-	fracs := t.Nanosecond()
-	return secs, int64(fracs)
-}
-
-// getNTPOffset returns the 70yrs between Unix epoch
-// and NTP epoch (1970-1900) in seconds
-func getNTPOffset() float64 {
-	ntpEpoch := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	unixEpoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
-	offset := unixEpoch.Sub(ntpEpoch).Seconds()
-	return offset
-}
+// clock is the system clock served to clients, not synchronized to
+// a reference (stratum 10), and assumed set when the server started
+var clock = ntp.LocalClock(time.Now())
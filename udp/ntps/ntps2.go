@@ -1,19 +1,20 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"time"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
 )
 
 // This program is a simple Network Time Protocol server over
 // Unix Domain Socket instead of UDP. The implementation uses
 // ListenUnixgram and UnixConn to manage requests.
-// The server returns the number of seconds since 1900 up to the
-// current time.
+// The server decodes the NTP request and returns the current time
+// in an NTP packet (see package ntp).
 
 // Usage:
 // ntps -e <host address endpoint>
@@ -45,56 +46,52 @@ func main() {
 		// potentially go to a different client.  Therefore, the ReadFromXXX
 		// operation returns the remote address (saved in raddr)
 		// where to send the response.
-		_, raddr, err := conn.ReadFromUnix(make([]byte, 48))
+		buf := make([]byte, 1024)
+		n, raddr, err := conn.ReadFromUnix(buf)
 		if err != nil {
 			fmt.Println("error getting request:", err)
 			os.Exit(1)
 		}
+		recv := time.Now()
 		// ensure raddr is set
 		if raddr == nil {
 			fmt.Println("warning: request missing remote addr")
 			continue
 		}
 		// go handle request
-		go handleRequest(conn, raddr)
+		go handleRequest(conn, raddr, buf[:n], recv)
 	}
 }
 
-// handle incoming requests
-func handleRequest(conn *net.UnixConn, addr *net.UnixAddr) {
-	// get seconds and fractional secs since 1900
-	secs, fracs := getNTPSeconds(time.Now())
+// handle incoming requests, data is the request
+// received at time recv
+func handleRequest(conn *net.UnixConn, addr *net.UnixAddr, data []byte, recv time.Time) {
+	// decode and validate the request packet
+	var req ntp.Packet
+	if err := req.UnmarshalBinary(data); err != nil {
+		fmt.Println("invalid request:", err)
+		return
+	}
+	if err := req.CheckRequest(); err != nil {
+		fmt.Println("invalid request:", err)
+		return
+	}
 
-	// response packet is filled with the seconds and
-	// fractional sec values using Big-Endian
-	rsp := make([]byte, 48)
-	// write seconds (as uint32) in buffer at [40:43]
-	binary.BigEndian.PutUint32(rsp[40:], uint32(secs))
-	// write seconds (as uint32) in buffer at [44:47]
-	binary.BigEndian.PutUint32(rsp[44:], uint32(fracs))
+	// encode the response packet
+	rsp, err := clock.Respond(&req, recv).MarshalBinary()
+	if err != nil {
+		fmt.Println("failed to encode response:", err)
+		return
+	}
 
 	// send response to client
-	fmt.Printf("writing response %v to %v\n", rsp, addr)
+	fmt.Printf("writing response to %v\n", addr)
 	if _, err := conn.WriteToUnix(rsp, addr); err != nil {
 		fmt.Println("err sending data:", err)
 		os.Exit(1)
 	}
 }
 
-// getNTPSecs decompose current time as NTP seconds
-func getNTPSeconds(t time.Time) (int64, int64) {
-	// convert time to total # of secs since 1970
-	// add NTP epoch offets as total #secs between 1900-1970
-	secs := t.Unix() + int64(getNTPOffset())
-	fracs := t.Nanosecond()
-	return secs, int64(fracs)
-}
-
-// getNTPOffset returns the 70yrs between Unix epoch
-// and NTP epoch (1970-1900) in seconds
-func getNTPOffset() float64 {
-	ntpEpoch := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	unixEpoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
-	offset := unixEpoch.Sub(ntpEpoch).Seconds()
-	return offset
-}
+// clock is the system clock served to clients, not synchronized to
+// a reference (stratum 10), and assumed set when the server started
+var clock = ntp.LocalClock(time.Now())
@@ -1,31 +1,226 @@
 package main
 
 import (
-	"encoding/binary"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/certmgr"
+	"github.com/vladimirvivien/go-networking/currency/unixsock"
+	"github.com/vladimirvivien/go-networking/fdpass"
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+	"github.com/vladimirvivien/go-networking/udp/nts"
 )
 
 var (
 	host    string
 	network string
+
+	// clock is the clock served to clients, see flags below
+	clock *ntp.Clock
+
+	// delay simulates network latency, half on each way
+	delay time.Duration
+
+	// keys authenticate the requests that carry a MAC
+	keys ntp.Keys
+
+	// cookies opens the NTS requests, nil without NTS
+	cookies *nts.CookieKey
+
+	// limiter limits the requests of each client
+	limiter *ntp.Limiter
+
+	// verbose logs every dropped packet
+	verbose bool
+
+	// queue holds the requests waiting for a worker
+	queue chan request
+
+	// monitor lists the most recent clients
+	monitor *ntp.Monitor
+
+	// stats counts the packets received, answered and dropped
+	stats ntp.Stats
+
+	// started is the start time of the server
+	started = time.Now()
 )
 
 // This program is a simple Network Time Protocol server that can use
 // either UDP or the Unix Domain Socket Datagram protocol.  The program
 // uses the ListenPacket to create a PacketConn generic connection.
 //
-// The server returns the number of seconds since 1900 up to the
-// current time. It uses command-line flag -e to specify server
-// addr:port and -n to specify network protocol ["udp","unixgram"]
+// The server decodes the NTP request and returns the current time
+// in an NTP packet (see package ntp). It uses command-line flag -e
+// to specify server addr:port and -n to specify network protocol
+// ["udp","unixgram"]
+//
+// The socket can be bound by a broker (see unix/broker), which lets the
+// server use the privileged NTP port 123 without running as root.
+//
+// The server answers like an SNTPv4 server (RFC 4330), so that clients
+// such as chrony or ntpdate accept its responses: the response echoes
+// the client transmit time as its origin time, carries the times the
+// request was received and answered, and advertises the stratum,
+// reference, precision and leap indicator given by the flags:
+//   -stratum stratum of the server clock, default 10 (local clock)
+//   -ref reference ID, an IPv4 address or for stratum 1 a clock name
+//        such as GPS, default 127.127.1.1 (local clock)
+//   -precision resolution of the clock, default 1µs
+//   -leap leap indicator [none,add,del,unsync], default none
+// Packets that are malformed or not client requests are dropped.
+//
+// Requests that carry a MAC (symmetric-key authentication, RFC 8573)
+// are verified with the keys of an ntp.keys file (see ntp.ParseKeys):
+//   -keys keys file, default none
+// The response to an authenticated request is authenticated with the
+// same key; a request with an unknown key or a bad MAC is answered with
+// a crypto-NAK.  Requests without a MAC are answered without one.
+//
+// With -nts-ke, the server also serves Network Time Security (RFC 8915):
+// an NTS key establishment server over TLS 1.3 hands out the keys and
+// cookies that NTS requests carry (see package nts and ntpc6.go).  The
+// certificate is loaded like the currency TLS servers (certmgr):
+//   -nts-ke address of the NTS-KE server, i.e. ":4460", default none
+//   -nts-cert, -nts-key certificate and private key of the NTS-KE server,
+//                 default ../../currency/certs/localhost-*.pem
+// An NTS request that fails is answered with an NTS NAK.
+//
+// The server protects itself and others from abuse: requests are
+// handled by a bounded pool of workers, and dropped when the queue is
+// full; each client (IP address, or /64 IPv6 prefix) may send a burst of
+// requests, then a steady rate, and is told to slow down with a
+// kiss-o'-death RATE, itself rate limited; responses are never larger
+// than requests, so that spoofed requests cannot amplify traffic.
+// Dropped packets are counted and the counters logged periodically:
+//   -workers number of workers, default 8
+//   -queue requests waiting for a worker, default 1024
+//   -rate requests per second of a client, 0 for no limit, default 1
+//   -burst requests a client may send at once, default 8
+//   -stats interval between logs of the counters, default 1m
+//   -v logs every dropped packet
+//
+// The server can also broadcast its time to the clients of a LAN that
+// only listen (broadcast mode, see ntpc7.go): every 2^poll seconds, it
+// sends a broadcast packet to an IPv4 broadcast address or to an IPv4 or
+// IPv6 multicast group, from its socket, so that clients calibrate
+// their delay with requests to the address the broadcasts come from:
+//   -broadcast address or group, i.e. 192.0.2.255:123, 224.0.1.1:123 or
+//              [ff02::101%eth0]:123, default none
+//   -broadcast-if interface sending to a multicast group, default the
+//                 interface of the route to the group
+//   -broadcast-poll log2 of the interval in seconds, default 6 (64s)
+//   -broadcast-key ID of the key of -keys signing the broadcasts,
+//                  default 0 (none)
+//
+// The server can be monitored: it counts the packets received, the
+// responses and kiss-o'-death sent and the packets dropped, and lists
+// its most recent clients with their packet counts (like the MRU list
+// of ntpd, see ntp.Monitor).  The counters are served over HTTP in the
+// Prometheus text format, at /metrics; a control socket answers the
+// commands of ntpq (see udp/ntpq and ntp.Control): stats, mrulist,
+// metrics:
+//   -mru clients listed, default 1000
+//   -metrics address of the HTTP server, i.e. ":9123", default none
+//   -control unix socket of the control commands, i.e. /tmp/ntps.sock,
+//            default none
+//
+// To test clients without a network, the server can simulate a wrong
+// clock and a slow network:
+//   -offset added to the time served, default 0
+//   -delay added to the round trip of each request, default 0
 func main() {
+	var stratum uint
+	var ref, leap, keysFile string
+	var ntsKE, ntsCert, ntsKey string
+	var workers, queueSize, burst int
+	var rate float64
+	var statsInterval time.Duration
+	var broadcastAddr, broadcastIf string
+	var broadcastPoll int
+	var broadcastKey uint
+	var mruSize int
+	var metricsAddr, controlPath string
+	var precision time.Duration
+	var offset time.Duration
 	flag.StringVar(&host, "e", ":1123", "server address")
 	flag.StringVar(&network, "n", "udp", "the network protocol [udp,unixgram]")
+	flag.UintVar(&stratum, "stratum", 10, "stratum of the server clock [1-15]")
+	flag.StringVar(&ref, "ref", "127.127.1.1", "reference ID [IPv4 address, or clock name for stratum 1]")
+	flag.DurationVar(&precision, "precision", time.Microsecond, "resolution of the server clock")
+	flag.StringVar(&leap, "leap", "none", "leap indicator [none,add,del,unsync]")
+	flag.StringVar(&keysFile, "keys", "", "keys file authenticating requests")
+	flag.StringVar(&ntsKE, "nts-ke", "", "NTS-KE server address, i.e. :4460")
+	flag.StringVar(&ntsCert, "nts-cert", "../../currency/certs/localhost-cert.pem", "NTS-KE server certificate")
+	flag.StringVar(&ntsKey, "nts-key", "../../currency/certs/localhost-key.pem", "NTS-KE server private key")
+	flag.IntVar(&workers, "workers", 8, "number of workers handling requests")
+	flag.IntVar(&queueSize, "queue", 1024, "requests waiting for a worker")
+	flag.Float64Var(&rate, "rate", 1, "requests per second of a client, 0 for no limit")
+	flag.IntVar(&burst, "burst", 8, "requests a client may send at once")
+	flag.DurationVar(&statsInterval, "stats", time.Minute, "interval between logs of the counters")
+	flag.BoolVar(&verbose, "v", false, "log every dropped packet")
+	flag.StringVar(&broadcastAddr, "broadcast", "", "broadcast address or multicast group, i.e. 224.0.1.1:123")
+	flag.StringVar(&broadcastIf, "broadcast-if", "", "interface sending to the multicast group")
+	flag.IntVar(&broadcastPoll, "broadcast-poll", 6, "log2 of the broadcast interval in seconds")
+	flag.UintVar(&broadcastKey, "broadcast-key", 0, "ID of the key signing broadcasts, 0 for none")
+	flag.IntVar(&mruSize, "mru", 1000, "clients listed by the monitor")
+	flag.StringVar(&metricsAddr, "metrics", "", "HTTP server of the metrics, i.e. :9123")
+	flag.StringVar(&controlPath, "control", "", "unix socket of the control commands, i.e. /tmp/ntps.sock")
+	flag.DurationVar(&offset, "offset", 0, "offset added to the time served [testing]")
+	flag.DurationVar(&delay, "delay", 0, "delay added to each round trip [testing]")
 	flag.Parse()
 
+	// validate the clock settings
+	if stratum < 1 || stratum > ntp.MaxStratum {
+		fmt.Println("invalid stratum:", stratum)
+		os.Exit(1)
+	}
+	clock = ntp.LocalClock(time.Now())
+	clock.Stratum = uint8(stratum)
+	clock.Precision = ntp.Precision(precision)
+	clock.Offset = offset
+	var err error
+	if clock.ReferenceID, err = ntp.ParseRefID(ref, clock.Stratum); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if clock.Leap, err = ntp.ParseLeap(leap); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if workers < 1 || queueSize < 0 {
+		fmt.Println("invalid workers or queue size")
+		os.Exit(1)
+	}
+	limiter = ntp.NewLimiter(rate, burst)
+	monitor = ntp.NewMonitor(mruSize)
+
+	if keysFile != "" {
+		if keys, err = ntp.LoadKeys(keysFile); err != nil {
+			fmt.Println("failed to load keys:", err)
+			os.Exit(1)
+		}
+	}
+
+	var bcastKey *ntp.Key
+	if broadcastKey != 0 {
+		if bcastKey = keys[uint32(broadcastKey)]; bcastKey == nil {
+			fmt.Println("unknown broadcast key:", broadcastKey)
+			os.Exit(1)
+		}
+	}
+	if broadcastPoll < 0 || broadcastPoll > ntp.MaxPoll {
+		fmt.Println("invalid broadcast poll:", broadcastPoll)
+		os.Exit(1)
+	}
+
 	// validate network protocols
 	switch network {
 	case "udp", "udp4", "udp6", "unixgram":
@@ -36,8 +231,9 @@ func main() {
 
 	// create a generic packet connection, PacketConn, with
 	// ListenPacket. PacketConn implements common ReadFrom and
-	// WriteTo that are protocol agnostic.
-	conn, err := net.ListenPacket(network, host)
+	// WriteTo that are protocol agnostic.  fdpass.ListenPacket
+	// uses the socket of the broker, if any.
+	conn, err := fdpass.ListenPacket(network, host)
 	if err != nil {
 		fmt.Println("failed to create socket:", err)
 		os.Exit(1)
@@ -45,6 +241,120 @@ func main() {
 	defer conn.Close()
 	fmt.Printf("listening on (%s)%s\n", network, conn.LocalAddr())
 
+	// the NTS-KE server advertises the port of the NTP server
+	if ntsKE != "" {
+		udpAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			fmt.Println("NTS requires the udp network")
+			os.Exit(1)
+		}
+		if cookies, err = nts.NewCookieKey(); err != nil {
+			fmt.Println("failed to create the cookie key:", err)
+			os.Exit(1)
+		}
+		certs, err := certmgr.New(certmgr.Config{CertFile: ntsCert, KeyFile: ntsKey})
+		if err != nil {
+			fmt.Println("failed to load the NTS-KE certificate:", err)
+			os.Exit(1)
+		}
+		defer certs.Close()
+		ln, err := tls.Listen("tcp", ntsKE, certs.ServerConfig(nts.ServerConfig(&tls.Config{})))
+		if err != nil {
+			fmt.Println("failed to create NTS-KE socket:", err)
+			os.Exit(1)
+		}
+		defer ln.Close()
+		fmt.Printf("NTS-KE listening on (tcp)%s\n", ln.Addr())
+		ke := &nts.KEServer{Cookies: cookies, Port: uint16(udpAddr.Port)}
+		go func() {
+			// without NTS-KE, clients get NTSN kisses only
+			err := ke.Serve(ln)
+			fmt.Println("NTS-KE server failed:", err)
+			os.Exit(1)
+		}()
+	}
+
+	// broadcasts are sent from the socket of the server, the
+	// address clients calibrate their delay with
+	if broadcastAddr != "" {
+		group, err := net.ResolveUDPAddr("udp", broadcastAddr)
+		if err != nil {
+			fmt.Println("invalid broadcast address:", err)
+			os.Exit(1)
+		}
+		if _, ok := conn.LocalAddr().(*net.UDPAddr); !ok {
+			fmt.Println("broadcasting requires the udp network")
+			os.Exit(1)
+		}
+		if broadcastIf != "" {
+			ifi, err := net.InterfaceByName(broadcastIf)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if !group.IP.IsMulticast() {
+				fmt.Println("-broadcast-if requires a multicast group")
+				os.Exit(1)
+			}
+			if err := ntp.SetMulticastInterface(conn, ifi, group); err != nil {
+				fmt.Println("failed to set the multicast interface:", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("broadcasting to %s every %ds\n", group, 1<<broadcastPoll)
+		go broadcast(conn, group, int8(broadcastPoll), bcastKey)
+	}
+
+	// the counters are served over HTTP and on the control socket
+	control := &ntp.Control{
+		Stats:    &stats,
+		Monitor:  monitor,
+		Limiter:  limiter,
+		QueueLen: func() int { return len(queue) },
+		Started:  started,
+	}
+	if metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", control)
+		ln, err := net.Listen("tcp", metricsAddr)
+		if err != nil {
+			fmt.Println("failed to create metrics socket:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("metrics on http://%s/metrics\n", ln.Addr())
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := srv.Serve(ln); err != nil {
+				fmt.Println("metrics server failed:", err)
+			}
+		}()
+	}
+	if controlPath != "" {
+		ln, err := unixsock.Listen(controlPath, unixsock.Options{Mode: 0600})
+		if err != nil {
+			fmt.Println("failed to create control socket:", err)
+			os.Exit(1)
+		}
+		defer ln.Close()
+		fmt.Printf("control on (unix)%s\n", controlPath)
+		go func() {
+			err := control.Serve(ln)
+			fmt.Println("control socket closed:", err)
+		}()
+	}
+
+	// requests are queued to a bounded pool of workers, a flood
+	// fills the queue and the excess is dropped
+	queue = make(chan request, queueSize)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for req := range queue {
+				handleRequest(conn, req)
+			}
+		}()
+	}
+	go logStats(statsInterval)
+
 	// request/response loop
 	for {
 		// block to read incoming requests
@@ -53,11 +363,13 @@ func main() {
 		// operation returns the remote address (saved in laddr)
 		// where to send the response.
 		// NOTE: use of generic ReadFrom instead of ReadFromXXX
-		_, raddr, err := conn.ReadFrom(make([]byte, 48))
+		buf := make([]byte, 2048)
+		n, raddr, err := conn.ReadFrom(buf)
 		if err != nil {
 			fmt.Println("error getting request:", err)
 			os.Exit(1)
 		}
+		recv := time.Now()
 
 		// ensure raddr is set
 		if raddr == nil {
@@ -65,49 +377,202 @@ func main() {
 			continue
 		}
 
+		stats.Received.Add(1)
+		monitor.Record(raddr, buf[:n], recv)
+
+		// clients over their rate get a kiss-o'-death RATE, now
+		// and then, the other requests are dropped
+		ok, kiss := limiter.Allow(raddr, recv)
+		if !ok && !kiss {
+			drop(&stats.RateLimited, raddr, "rate limited")
+			continue
+		}
+
 		// handle request
-		go handleRequest(conn, raddr)
+		select {
+		case queue <- request{addr: raddr, data: buf[:n], recv: recv, kiss: kiss}:
+		default:
+			drop(&stats.QueueFull, raddr, "queue full")
+		}
 	}
 }
 
-// handleRequest handles incoming request and sends current
-// time.  If network=udp, the passed address is used.
-// If network=unixgram, then the global host address path is
-// used for both read and write.
-func handleRequest(conn net.PacketConn, addr net.Addr) {
-	// get seconds and fractional secs since 1900
-	secs, fracs := getNTPSeconds(time.Now())
-
-	// response packet is filled with the seconds and
-	// fractional sec values using Big-Endian
-	rsp := make([]byte, 48)
-	// write seconds (as uint32) in buffer at [40:43]
-	binary.BigEndian.PutUint32(rsp[40:], uint32(secs))
-	// write seconds (as uint32) in buffer at [44:47]
-	binary.BigEndian.PutUint32(rsp[44:], uint32(fracs))
-
-	// send data
+// request is a datagram received at time recv from addr, kiss is set
+// when it is answered with a kiss-o'-death RATE
+type request struct {
+	addr net.Addr
+	data []byte
+	recv time.Time
+	kiss bool
+}
+
+// handleRequest handles incoming request data received
+// at time recv, and sends the current time.  If network=udp,
+// the passed address is used.  If network=unixgram, then the
+// global host address path is used for both read and write.
+func handleRequest(conn net.PacketConn, r request) {
+	addr, data, recv := r.addr, r.data, r.recv
+
+	// simulated latency of the request
+	if delay > 0 {
+		time.Sleep(delay / 2)
+		recv = time.Now()
+	}
+	// decode and validate the request packet
+	var req ntp.Packet
+	if err := req.UnmarshalBinary(data); err != nil {
+		drop(&stats.Invalid, addr, err.Error())
+		return
+	}
+	if err := req.CheckRequest(); err != nil {
+		drop(&stats.Invalid, addr, err.Error())
+		return
+	}
+	if r.kiss {
+		kiss, err := ntp.Kiss(&req, "RATE").MarshalBinary()
+		if err != nil {
+			fmt.Println("failed to encode response:", err)
+			return
+		}
+		if send(conn, addr, data, kiss) {
+			stats.KissRate.Add(1)
+			monitor.Kiss(addr)
+		}
+		return
+	}
+
+	// open NTS requests, a failure is answered with an NTS NAK
+	var ntsReq *nts.Request
+	if cookies != nil && nts.IsRequest(data) {
+		var err error
+		if ntsReq, err = cookies.OpenRequest(data); err != nil {
+			logf("NTS request failed from %s: %v", addr, err)
+			nak, err := nts.NAK(&req, data)
+			if err != nil {
+				drop(&stats.Invalid, addr, err.Error())
+				return
+			}
+			if send(conn, addr, data, nak) {
+				stats.KissNTSN.Add(1)
+				monitor.Kiss(addr)
+			}
+			return
+		}
+	}
+
+	// verify the MAC of authenticated requests, a failure is
+	// answered with a crypto-NAK
+	var key *ntp.Key
+	if ntsReq == nil && len(data) > ntp.PacketSize {
+		var err error
+		if key, err = keys.Verify(data); err != nil {
+			logf("authentication failed from %s: %v", addr, err)
+			nak, err := ntp.CryptoNAK(&req)
+			if err != nil {
+				fmt.Println("failed to encode response:", err)
+				return
+			}
+			if send(conn, addr, data, nak) {
+				stats.KissCryp.Add(1)
+				monitor.Kiss(addr)
+			}
+			return
+		}
+	}
+
+	// encode the response packet, authenticated with the key of
+	// the request or with NTS
+	rsp, err := clock.Respond(&req, recv).MarshalBinary()
+	if err != nil {
+		fmt.Println("failed to encode response:", err)
+		return
+	}
+	switch {
+	case key != nil:
+		rsp = key.Sign(rsp)
+	case ntsReq != nil:
+		if rsp, err = ntsReq.Seal(rsp); err != nil {
+			fmt.Println("failed to encode response:", err)
+			return
+		}
+	}
+
+	// simulated latency of the response, after the transmit
+	// time is stamped
+	time.Sleep(delay / 2)
+
+	send(conn, addr, data, rsp)
+}
+
+// broadcast sends the time to group every 2^poll seconds, signed with
+// key if not nil.  Send errors are logged, the server keeps serving.
+func broadcast(conn net.PacketConn, group *net.UDPAddr, poll int8, key *ntp.Key) {
+	tick := time.NewTicker(time.Duration(1<<poll) * time.Second)
+	defer tick.Stop()
+	for ; ; <-tick.C {
+		data, err := clock.Broadcast(poll).MarshalBinary()
+		if err != nil {
+			fmt.Println("failed to encode broadcast:", err)
+			continue
+		}
+		if key != nil {
+			data = key.Sign(data)
+		}
+		if _, err := conn.WriteTo(data, group); err != nil {
+			fmt.Println("failed to send broadcast:", err)
+			continue
+		}
+		stats.Broadcasts.Add(1)
+	}
+}
+
+// send sends the response rsp to the request data from addr, and
+// reports whether it was sent.  A response larger than the request is
+// dropped: the server must not amplify the traffic of spoofed requests.
+// Send errors concern a single client, they are counted like drops.
+func send(conn net.PacketConn, addr net.Addr, data, rsp []byte) bool {
+	if len(rsp) > len(data) {
+		drop(&stats.Oversize, addr, fmt.Sprintf("response of %d bytes to a request of %d", len(rsp), len(data)))
+		return false
+	}
 	if _, err := conn.WriteTo(rsp, addr); err != nil {
-		fmt.Println("err sending data:", err)
-		os.Exit(1)
+		drop(&stats.SendErrors, addr, err.Error())
+		return false
 	}
+	stats.Sent.Add(1)
+	return true
+}
 
+// drop counts a dropped packet from addr, and logs it with -v
+func drop(counter *atomic.Int64, addr net.Addr, reason string) {
+	counter.Add(1)
+	monitor.Drop(addr)
+	logf("dropped packet from %s: %s", addr, reason)
 }
 
-// getNTPSecs decompose current time as NTP seconds
-func getNTPSeconds(t time.Time) (int64, int64) {
-	// convert time to total # of secs since 1970
-	// add NTP epoch offets as total #secs between 1900-1970
-	secs := t.Unix() + int64(getNTPOffset())
-	fracs := t.Nanosecond()
-	return secs, int64(fracs)
+// logf logs the event of a single packet with -v, the others are only
+// counted, so that a flood does not flood the log too
+func logf(format string, args ...interface{}) {
+	if verbose {
+		fmt.Printf(format+"\n", args...)
+	}
 }
 
-// getNTPOffset returns the 70yrs between Unix epoch
-// and NTP epoch (1970-1900) in seconds
-func getNTPOffset() float64 {
-	ntpEpoch := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	unixEpoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
-	offset := unixEpoch.Sub(ntpEpoch).Seconds()
-	return offset
+// logStats prints the counters every interval when packets were dropped
+func logStats(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	var last int64
+	for range time.Tick(interval) {
+		total := stats.Dropped()
+		if total == last {
+			continue
+		}
+		last = total
+		fmt.Printf("%s sent %d (%d RATE kisses), %d broadcasts, dropped %d: rate limited %d, queue full %d, invalid %d, oversize %d, send errors %d, %d clients\n",
+			time.Now().Format(time.DateTime), stats.Sent.Load(), stats.KissRate.Load(), stats.Broadcasts.Load(), total,
+			stats.RateLimited.Load(), stats.QueueFull.Load(), stats.Invalid.Load(),
+			stats.Oversize.Load(), stats.SendErrors.Load(), limiter.Clients())
+	}
 }
@@ -0,0 +1,76 @@
+package nts
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// cookieNonceSize is the size of the nonce of a cookie
+const cookieNonceSize = 16
+
+var errCookie = errors.New("nts: invalid cookie")
+
+// CookieKey is the key an NTP server encrypts its cookies with.  The
+// NTS-KE server and the NTP server share it; a key made by NewCookieKey
+// lives as long as the process, clients run a new key exchange once the
+// server restarts.
+//
+// A cookie is the key ID, a nonce, and the AEAD algorithm and the C2S
+// and S2C keys encrypted with AES-SIV:
+//
+//	key ID (4) | nonce (16) | tag (16) | AEAD ID (2), 0 (2), C2S, S2C
+type CookieKey struct {
+	id   uint32
+	aead cipher.AEAD
+}
+
+// NewCookieKey returns a random cookie key
+func NewCookieKey() (*CookieKey, error) {
+	key := make([]byte, ntp.SIVKeySize+4)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	aead, err := ntp.NewAESSIV(key[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &CookieKey{id: binary.BigEndian.Uint32(key), aead: aead}, nil
+}
+
+// seal returns a cookie holding the keys of algorithm aead
+func (k *CookieKey) seal(aead uint16, c2s, s2c []byte) ([]byte, error) {
+	cookie := binary.BigEndian.AppendUint32(nil, k.id)
+	nonce := make([]byte, cookieNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	cookie = append(cookie, nonce...)
+
+	// the padding keeps cookies a multiple of 4 bytes, the extension
+	// fields carrying them need no padding
+	plaintext := append(uint16s(aead, 0), c2s...)
+	plaintext = append(plaintext, s2c...)
+	return k.aead.Seal(cookie, nonce, plaintext, cookie[:4]), nil
+}
+
+// open returns the algorithm and the keys held by a cookie
+func (k *CookieKey) open(cookie []byte) (aead uint16, c2s, s2c []byte, err error) {
+	if len(cookie) < 4+cookieNonceSize || binary.BigEndian.Uint32(cookie) != k.id {
+		return 0, nil, nil, errCookie
+	}
+	nonce := cookie[4 : 4+cookieNonceSize]
+	plaintext, err := k.aead.Open(nil, nonce, cookie[4+cookieNonceSize:], cookie[:4])
+	if err != nil || len(plaintext) != 4+2*ntp.SIVKeySize {
+		return 0, nil, nil, errCookie
+	}
+	aead = binary.BigEndian.Uint16(plaintext)
+	if aead != AEADAESSIVCMAC256 {
+		return 0, nil, nil, errCookie
+	}
+	keys := plaintext[4:]
+	return aead, keys[:ntp.SIVKeySize], keys[ntp.SIVKeySize:], nil
+}
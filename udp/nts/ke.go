@@ -0,0 +1,253 @@
+package nts
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/backoff"
+)
+
+// ClientConfig returns a copy of base for NTS-KE clients: TLS 1.3 and
+// the ntske/1 protocol
+func ClientConfig(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	cfg.MinVersion = tls.VersionTLS13
+	cfg.NextProtos = []string{ALPN}
+	return cfg
+}
+
+// ServerConfig returns a copy of base for NTS-KE servers, see
+// ClientConfig
+func ServerConfig(base *tls.Config) *tls.Config {
+	return ClientConfig(base)
+}
+
+// checkState checks that a TLS connection negotiated NTS-KE
+func checkState(cs tls.ConnectionState) error {
+	if cs.Version != tls.VersionTLS13 {
+		return fmt.Errorf("nts: %s negotiated, TLS 1.3 required", tls.VersionName(cs.Version))
+	}
+	if cs.NegotiatedProtocol != ALPN {
+		return fmt.Errorf("nts: protocol %q negotiated, %s required", cs.NegotiatedProtocol, ALPN)
+	}
+	return nil
+}
+
+// Exchange runs the NTS-KE client protocol over conn, a TLS connection
+// configured with ClientConfig, and returns the session: the keys, the
+// cookies, and the NTP server to use.  The connection is closed.
+func Exchange(conn *tls.Conn) (*Session, error) {
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	cs := conn.ConnectionState()
+	if err := checkState(cs); err != nil {
+		return nil, err
+	}
+
+	// offer NTPv4 with AES-SIV
+	var req []byte
+	req = appendRecord(req, true, recNextProto, uint16s(ProtocolNTPv4))
+	req = appendRecord(req, false, recAEAD, uint16s(AEADAESSIVCMAC256))
+	req = appendRecord(req, true, recEnd, nil)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	recs, err := readMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("nts: reading response: %w", err)
+	}
+	s := &Session{Port: DefaultNTPPort}
+	var proto, aead bool
+	for _, rec := range recs {
+		switch rec.typ {
+		case recError:
+			if len(rec.body) != 2 {
+				return nil, errors.New("nts: invalid error record")
+			}
+			return nil, &KEError{Code: binary.BigEndian.Uint16(rec.body)}
+		case recNextProto:
+			proto = hasUint16(rec.body, ProtocolNTPv4)
+		case recAEAD:
+			aead = hasUint16(rec.body, AEADAESSIVCMAC256)
+		case recCookie:
+			s.Cookies = append(s.Cookies, rec.body)
+		case recServer:
+			s.Server = string(rec.body)
+		case recPort:
+			if len(rec.body) != 2 {
+				return nil, errors.New("nts: invalid port record")
+			}
+			s.Port = binary.BigEndian.Uint16(rec.body)
+		case recWarning:
+		default:
+			if rec.critical {
+				return nil, fmt.Errorf("nts: unrecognized critical record %d", rec.typ)
+			}
+		}
+	}
+	switch {
+	case !proto:
+		return nil, errors.New("nts: server does not support NTPv4")
+	case !aead:
+		return nil, errors.New("nts: server does not support AEAD_AES_SIV_CMAC_256")
+	case len(s.Cookies) == 0:
+		return nil, errors.New("nts: server sent no cookies")
+	}
+
+	c2s, s2c, err := exportKeys(cs.ExportKeyingMaterial, AEADAESSIVCMAC256)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setKeys(c2s, s2c); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// KEServer is an NTS-KE server handing out the cookies of an NTP server
+type KEServer struct {
+	// Cookies is the cookie key of the NTP server
+	Cookies *CookieKey
+
+	// Server and Port are the NTP server advertised to clients, by
+	// default the NTS-KE host and port 123
+	Server string
+	Port   uint16
+
+	// Timeout bounds the exchange with a client, default 10s
+	Timeout time.Duration
+
+	// Logger receives the errors of the exchanges, default log.Printf
+	Logger *log.Logger
+}
+
+// Serve accepts NTS-KE connections on ln, a TLS listener configured with
+// ServerConfig, until ln is closed.  Temporary errors, such as running
+// out of file descriptors, are retried with a backoff; Serve returns the
+// others.
+func (s *KEServer) Serve(ln net.Listener) error {
+	for {
+		conn, err := backoff.Accept(ln.Accept, backoff.Policy{Max: time.Second}, s.logf)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(conn); err != nil {
+				s.logf("nts-ke %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn runs the NTS-KE server protocol over conn, a TLS connection,
+// and closes it
+func (s *KEServer) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return errors.New("nts: not a TLS connection")
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	cs := tlsConn.ConnectionState()
+	if err := checkState(cs); err != nil {
+		return err
+	}
+
+	recs, err := readMessage(conn)
+	if err != nil {
+		return fmt.Errorf("reading request: %w", err)
+	}
+	rsp, err := s.respond(recs, cs)
+	if err != nil {
+		s.logf("nts-ke %s: %v", conn.RemoteAddr(), err)
+	}
+	if _, err := conn.Write(rsp); err != nil {
+		return err
+	}
+	return tlsConn.CloseWrite()
+}
+
+// respond returns the response to the records of a request.  On error,
+// the response is an error record.
+func (s *KEServer) respond(recs []record, cs tls.ConnectionState) ([]byte, error) {
+	var protos, aeads []byte
+	var gotProto, gotAEAD bool
+	for _, rec := range recs {
+		switch rec.typ {
+		case recNextProto:
+			protos, gotProto = rec.body, true
+		case recAEAD:
+			aeads, gotAEAD = rec.body, true
+		case recError, recWarning, recCookie, recServer, recPort:
+		default:
+			if rec.critical {
+				return errorRecord(ErrCodeUnrecognizedCritical), fmt.Errorf("unrecognized critical record %d", rec.typ)
+			}
+		}
+	}
+	if !gotProto || (hasUint16(protos, ProtocolNTPv4) && !gotAEAD) {
+		return errorRecord(ErrCodeBadRequest), errors.New("bad request")
+	}
+
+	// without a protocol or algorithm in common, the response has
+	// empty records and no cookies
+	var rsp []byte
+	if !hasUint16(protos, ProtocolNTPv4) {
+		rsp = appendRecord(rsp, true, recNextProto, nil)
+		return appendRecord(rsp, true, recEnd, nil), nil
+	}
+	rsp = appendRecord(rsp, true, recNextProto, uint16s(ProtocolNTPv4))
+	if !hasUint16(aeads, AEADAESSIVCMAC256) {
+		rsp = appendRecord(rsp, true, recAEAD, nil)
+		return appendRecord(rsp, true, recEnd, nil), nil
+	}
+	rsp = appendRecord(rsp, true, recAEAD, uint16s(AEADAESSIVCMAC256))
+	if s.Server != "" {
+		rsp = appendRecord(rsp, true, recServer, []byte(s.Server))
+	}
+	if s.Port != 0 && s.Port != DefaultNTPPort {
+		rsp = appendRecord(rsp, true, recPort, uint16s(s.Port))
+	}
+
+	c2s, s2c, err := exportKeys(cs.ExportKeyingMaterial, AEADAESSIVCMAC256)
+	if err != nil {
+		return errorRecord(ErrCodeInternal), err
+	}
+	for i := 0; i < MaxCookies; i++ {
+		cookie, err := s.Cookies.seal(AEADAESSIVCMAC256, c2s, s2c)
+		if err != nil {
+			return errorRecord(ErrCodeInternal), err
+		}
+		rsp = appendRecord(rsp, false, recCookie, cookie)
+	}
+	return appendRecord(rsp, true, recEnd, nil), nil
+}
+
+// errorRecord returns a response made of an error record
+func errorRecord(code uint16) []byte {
+	rsp := appendRecord(nil, true, recError, uint16s(code))
+	return appendRecord(rsp, true, recEnd, nil)
+}
+
+func (s *KEServer) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
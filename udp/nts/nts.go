@@ -0,0 +1,188 @@
+// Package nts implements Network Time Security for NTPv4 (RFC 8915).
+//
+// NTS has two parts.  The key establishment (NTS-KE) runs over TLS 1.3
+// (ALPN "ntske/1", port 4460): the client and the server negotiate the
+// protocol (NTPv4) and the AEAD algorithm (AEAD_AES_SIV_CMAC_256),
+// derive the client-to-server (C2S) and server-to-client (S2C) keys
+// from the TLS session, and the server hands out cookies.  A cookie is
+// opaque to the client: it holds the keys, encrypted with a key only
+// the server knows, so that the NTP server keeps no state per client.
+//
+// Then each NTP request carries extension fields: a unique identifier,
+// a cookie, placeholders asking for more cookies, and an authenticator,
+// the AEAD tag of the packet under the C2S key.  The server recovers
+// the keys from the cookie, verifies the request, and answers with the
+// unique identifier and an authenticator under the S2C key, which also
+// encrypts fresh cookies.  Cookies are used once, so that requests
+// cannot be linked to the same client.
+//
+// Session is the client side (KeyExchange, then ntp.QueryWith),
+// KEServer and CookieKey the server side.
+package nts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+const (
+	// ALPN is the TLS application protocol of NTS-KE
+	ALPN = "ntske/1"
+
+	// DefaultKEPort is the port of NTS-KE servers
+	DefaultKEPort = 4460
+
+	// DefaultNTPPort is the NTP port used unless NTS-KE negotiates one
+	DefaultNTPPort = 123
+
+	// ProtocolNTPv4 is the NTS next protocol ID of NTPv4
+	ProtocolNTPv4 = 0
+
+	// AEADAESSIVCMAC256 is the ID of AEAD_AES_SIV_CMAC_256 (RFC 5297),
+	// the AEAD algorithm all implementations support
+	AEADAESSIVCMAC256 = 15
+
+	// MaxCookies is the number of cookies a client keeps
+	MaxCookies = 8
+
+	// exporterLabel is the label of the TLS keying material exporter
+	exporterLabel = "EXPORTER-network-time-security"
+)
+
+// NTS-KE record types
+const (
+	recEnd       = 0
+	recNextProto = 1
+	recError     = 2
+	recWarning   = 3
+	recAEAD      = 4
+	recCookie    = 5
+	recServer    = 6
+	recPort      = 7
+	recCritical  = 0x8000
+)
+
+// NTS-KE error codes
+const (
+	ErrCodeUnrecognizedCritical = 0
+	ErrCodeBadRequest           = 1
+	ErrCodeInternal             = 2
+)
+
+// KEError is an error record sent by an NTS-KE server
+type KEError struct {
+	Code uint16
+}
+
+func (e *KEError) Error() string {
+	switch e.Code {
+	case ErrCodeUnrecognizedCritical:
+		return "nts: server error: unrecognized critical record"
+	case ErrCodeBadRequest:
+		return "nts: server error: bad request"
+	case ErrCodeInternal:
+		return "nts: server error: internal server error"
+	}
+	return fmt.Sprintf("nts: server error %d", e.Code)
+}
+
+// ErrNoCookies is returned when a session used up its cookies, a new
+// key exchange is needed
+var ErrNoCookies = errors.New("nts: no cookies left, a new key exchange is needed")
+
+// record is an NTS-KE record:
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|C|         Record Type         |          Body Length          |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	.                          Record Body                          .
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// A record the receiver does not know is ignored, unless its critical
+// bit C is set.
+type record struct {
+	critical bool
+	typ      uint16
+	body     []byte
+}
+
+// appendRecord appends the record of type typ to b
+func appendRecord(b []byte, critical bool, typ uint16, body []byte) []byte {
+	if critical {
+		typ |= recCritical
+	}
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+// readRecord reads a record from r
+func readRecord(r io.Reader) (record, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return record{}, err
+	}
+	typ := binary.BigEndian.Uint16(hdr[:])
+	body := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return record{}, err
+	}
+	return record{critical: typ&recCritical != 0, typ: typ &^ recCritical, body: body}, nil
+}
+
+// readMessage reads records up to the end of message record
+func readMessage(r io.Reader) ([]record, error) {
+	var recs []record
+	for {
+		rec, err := readRecord(r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if rec.typ == recEnd {
+			return recs, nil
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// uint16s encodes a list of 16-bit values, such as protocol IDs
+func uint16s(vals ...uint16) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+// hasUint16 reports whether the list of 16-bit values b holds v
+func hasUint16(b []byte, v uint16) bool {
+	for i := 0; i+1 < len(b); i += 2 {
+		if binary.BigEndian.Uint16(b[i:]) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// exportKeys derives the C2S and S2C keys of NTPv4 with the AEAD
+// algorithm from a TLS session (ExportKeyingMaterial)
+func exportKeys(export func(label string, context []byte, length int) ([]byte, error), aead uint16) (c2s, s2c []byte, err error) {
+	context := append(uint16s(ProtocolNTPv4, aead), 0)
+	if c2s, err = export(exporterLabel, context, ntp.SIVKeySize); err != nil {
+		return nil, nil, err
+	}
+	context[4] = 1
+	if s2c, err = export(exporterLabel, context, ntp.SIVKeySize); err != nil {
+		return nil, nil, err
+	}
+	return c2s, s2c, nil
+}
@@ -0,0 +1,205 @@
+package nts
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vladimirvivien/go-networking/currency/pki"
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// keServer starts an NTS-KE server on 127.0.0.1 with a throwaway CA,
+// for the NTP server at port, and returns its address and the client
+// configuration trusting the CA
+func keServer(t *testing.T, cookies *CookieKey, port int) (string, *tls.Config) {
+	t.Helper()
+	ca, err := pki.NewCA(pki.Options{CommonName: "NTS test CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := ca.Issue(pki.Options{
+		CommonName:  "localhost",
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		Usage:       pki.ServerUsage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := srv.TLSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", ServerConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ke := &KEServer{Cookies: cookies, Port: uint16(port)}
+	done := make(chan error, 1)
+	go func() { done <- ke.Serve(ln) }()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-done; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return ln.Addr().String(), ClientConfig(&tls.Config{RootCAs: ca.Pool()})
+}
+
+// ntpServer serves NTP on 127.0.0.1 like the handler of ntps3: NTS
+// requests are opened with cookies and their responses sealed, the
+// requests that cannot be opened are answered with an NTS NAK
+func ntpServer(t *testing.T, cookies *CookieKey) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	clock := ntp.LocalClock(time.Now())
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			recv := time.Now()
+			data := buf[:n]
+			var req ntp.Packet
+			if req.UnmarshalBinary(data) != nil || req.CheckRequest() != nil || !IsRequest(data) {
+				continue
+			}
+			var rsp []byte
+			if r, err := cookies.OpenRequest(data); err != nil {
+				rsp, err = NAK(&req, data)
+				if err != nil {
+					continue
+				}
+			} else {
+				rsp, _ = clock.Respond(&req, recv).MarshalBinary()
+				if rsp, err = r.Seal(rsp); err != nil {
+					t.Errorf("Seal: %v", err)
+					continue
+				}
+			}
+			conn.WriteTo(rsp, addr)
+		}
+	}()
+	return conn
+}
+
+// tamperer corrupts the authenticator of the requests of a session
+type tamperer struct {
+	*Session
+}
+
+func (t tamperer) AuthenticateRequest(data []byte) ([]byte, error) {
+	req, err := t.Session.AuthenticateRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	req[len(req)-1] ^= 1 // the last byte of the ciphertext
+	return req, nil
+}
+
+// exchange runs the client side of NTS-KE with the server at addr
+func exchange(t *testing.T, addr string, cfg *tls.Config) *Session {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Exchange(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRoundTrip(t *testing.T) {
+	cookies, err := NewCookieKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpConn := ntpServer(t, cookies)
+	port := udpConn.LocalAddr().(*net.UDPAddr).Port
+	keAddr, cfg := keServer(t, cookies, port)
+
+	s := exchange(t, keAddr, cfg)
+	if len(s.Cookies) != MaxCookies {
+		t.Fatalf("got %d cookies, want %d", len(s.Cookies), MaxCookies)
+	}
+	if s.Port != uint16(port) {
+		t.Fatalf("got port %d, want %d", s.Port, port)
+	}
+	conn, err := net.Dial("udp", s.Addr(keAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// each response replaces the cookie of its request
+	for i := 0; i < 2*MaxCookies; i++ {
+		sample, err := ntp.QueryWith(conn, 2*time.Second, time.Now, s)
+		if err != nil {
+			t.Fatalf("query %d: %v", i+1, err)
+		}
+		if sample.Response.Stratum != 10 {
+			t.Fatalf("query %d: stratum %d", i+1, sample.Response.Stratum)
+		}
+		if len(s.Cookies) != MaxCookies {
+			t.Fatalf("query %d: %d cookies left", i+1, len(s.Cookies))
+		}
+	}
+
+	// a tampered request is answered with an NTS NAK, reported once no
+	// authenticated response arrived; the NAK is not authenticated, it
+	// costs the cookie of the request only
+	_, err = ntp.QueryWith(conn, 200*time.Millisecond, time.Now, tamperer{s})
+	var kiss *ntp.KissError
+	if !errors.As(err, &kiss) || kiss.Code != "NTSN" {
+		t.Fatalf("tampered request: got %v, want NTSN", err)
+	}
+	if len(s.Cookies) != MaxCookies-1 {
+		t.Errorf("%d cookies left after NTSN, want %d", len(s.Cookies), MaxCookies-1)
+	}
+	if _, err := ntp.QueryWith(conn, 2*time.Second, time.Now, s); err != nil {
+		t.Fatalf("query after NTSN: %v", err)
+	}
+
+	// the cookies run out without responses
+	s.Cookies = nil
+	if _, err := ntp.QueryWith(conn, 2*time.Second, time.Now, s); !errors.Is(err, ErrNoCookies) {
+		t.Errorf("query without cookies: got %v, want %v", err, ErrNoCookies)
+	}
+
+	// a new key exchange starts over
+	s = exchange(t, keAddr, cfg)
+	if _, err := ntp.QueryWith(conn, 2*time.Second, time.Now, s); err != nil {
+		t.Fatalf("query after new exchange: %v", err)
+	}
+}
+
+func TestKEWrongProtocol(t *testing.T) {
+	cookies, err := NewCookieKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keAddr, cfg := keServer(t, cookies, DefaultNTPPort)
+
+	// without ALPN ntske/1, the handshake fails
+	cfg = cfg.Clone()
+	cfg.NextProtos = []string{"h2"}
+	conn, err := tls.Dial("tcp", keAddr, cfg)
+	if err == nil {
+		_, err = Exchange(conn)
+	}
+	if err == nil {
+		t.Fatal("exchange without ntske/1 succeeded")
+	}
+}
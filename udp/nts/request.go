@@ -0,0 +1,146 @@
+package nts
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// Request is an NTS request opened by an NTP server
+type Request struct {
+	uid     []byte
+	s2c     cipher.AEAD
+	cookies int // cookies asked for, one per cookie and placeholder
+	key     *CookieKey
+	c2sKey  []byte
+	s2cKey  []byte
+}
+
+// IsRequest reports whether data is a request carrying NTS extension
+// fields
+func IsRequest(data []byte) bool {
+	exts, _, err := ntp.ParseExtensions(data)
+	if err != nil {
+		return false
+	}
+	_, err = ntp.FindExtension(exts, ntp.ExtNTSCookie)
+	return err == nil
+}
+
+// OpenRequest recovers the keys of a request from its cookie and
+// verifies its authenticator.  A request that fails is answered with
+// NAK.
+func (k *CookieKey) OpenRequest(data []byte) (*Request, error) {
+	exts, _, err := ntp.ParseExtensions(data)
+	if err != nil {
+		return nil, err
+	}
+	r := &Request{key: k}
+	var cookie []byte
+	var auth *ntp.Extension
+	var holders []int
+	for i, e := range exts {
+		switch e.Type {
+		case ntp.ExtUniqueID:
+			if r.uid != nil {
+				return nil, errors.New("nts: several unique identifiers")
+			}
+			r.uid = e.Value
+		case ntp.ExtNTSCookie:
+			if cookie != nil {
+				return nil, errors.New("nts: several cookies")
+			}
+			cookie = e.Value
+			r.cookies++
+		case ntp.ExtNTSCookieHolder:
+			holders = append(holders, len(e.Value))
+		case ntp.ExtNTSAuthenticator:
+			auth = &exts[i]
+		}
+		if auth != nil {
+			break // the fields after the authenticator are not authenticated
+		}
+	}
+	switch {
+	case len(r.uid) < uniqueIDSize:
+		return nil, errors.New("nts: missing unique identifier")
+	case cookie == nil:
+		return nil, errors.New("nts: missing cookie")
+	case auth == nil:
+		return nil, errors.New("nts: missing authenticator")
+	}
+
+	_, c2sKey, s2cKey, err := k.open(cookie)
+	if err != nil {
+		return nil, err
+	}
+	c2s, err := ntp.NewAESSIV(c2sKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := openAuthenticator(data, *auth, c2s); err != nil {
+		return nil, err
+	}
+	if r.s2c, err = ntp.NewAESSIV(s2cKey); err != nil {
+		return nil, err
+	}
+	r.c2sKey, r.s2cKey = c2sKey, s2cKey
+
+	// a placeholder smaller than a cookie does not pay for the cookie
+	// it asks for, the response would be larger than the request
+	for _, size := range holders {
+		if size >= len(cookie) && r.cookies < MaxCookies {
+			r.cookies++
+		}
+	}
+	return r, nil
+}
+
+// Seal appends to rsp, the encoded response packet, the unique
+// identifier of the request and an authenticator encrypting new
+// cookies.  The response is no larger than the request.
+func (r *Request) Seal(rsp []byte) ([]byte, error) {
+	if len(rsp) != ntp.PacketSize {
+		return nil, fmt.Errorf("nts: response of %d bytes", len(rsp))
+	}
+	var cookies []byte
+	for i := 0; i < r.cookies; i++ {
+		cookie, err := r.key.seal(AEADAESSIVCMAC256, r.c2sKey, r.s2cKey)
+		if err != nil {
+			return nil, err
+		}
+		cookies = ntp.AppendExtension(cookies, ntp.ExtNTSCookie, cookie)
+	}
+	data := ntp.AppendExtension(append([]byte(nil), rsp...), ntp.ExtUniqueID, r.uid)
+	return appendAuthenticator(data, r.s2c, cookies)
+}
+
+// NAK returns the NTS NAK answering a request that could not be opened:
+// a kiss-o'-death NTSN with the unique identifier of the request, not
+// authenticated.  Without a unique identifier, there is no answer.
+func NAK(req *ntp.Packet, data []byte) ([]byte, error) {
+	exts, _, err := ntp.ParseExtensions(data)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := ntp.FindExtension(exts, ntp.ExtUniqueID)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := ntp.Kiss(req, "NTSN").MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return ntp.AppendExtension(rsp, ntp.ExtUniqueID, uid.Value), nil
+}
+
+// isNAK reports whether the response data is an NTS NAK
+func isNAK(data []byte) bool {
+	var rsp ntp.Packet
+	if err := rsp.UnmarshalBinary(data); err != nil {
+		return false
+	}
+	return rsp.Stratum == 0 && rsp.ReferenceID == ntp.RefID("NTSN")
+}
@@ -0,0 +1,171 @@
+package nts
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/vladimirvivien/go-networking/udp/ntp"
+)
+
+// uniqueIDSize is the size of the unique identifier of requests
+const uniqueIDSize = 32
+
+// authNonceSize is the size of the nonce of authenticators
+const authNonceSize = 16
+
+// Session is an NTS session of a client established by Exchange.  It
+// authenticates requests and verifies responses for ntp.QueryWith, one
+// request at a time.
+type Session struct {
+	// Server and Port are the NTP server, the NTS-KE host if Server
+	// is empty
+	Server string
+	Port   uint16
+
+	// Cookies are the cookies left, each request uses one and the
+	// response replaces it
+	Cookies [][]byte
+
+	c2s, s2c cipher.AEAD
+	uid      []byte // unique identifier of the last request
+}
+
+// setKeys sets the C2S and S2C keys of the session
+func (s *Session) setKeys(c2s, s2c []byte) error {
+	var err error
+	if s.c2s, err = ntp.NewAESSIV(c2s); err != nil {
+		return err
+	}
+	s.s2c, err = ntp.NewAESSIV(s2c)
+	return err
+}
+
+// Addr returns the address of the NTP server of a session established
+// with the NTS-KE server at keAddr (host:port)
+func (s *Session) Addr(keAddr string) string {
+	host := s.Server
+	if host == "" {
+		host, _, _ = net.SplitHostPort(keAddr)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(s.Port)))
+}
+
+// AuthenticateRequest appends the NTS extension fields to a request: a
+// new unique identifier, a cookie, placeholders for the cookies missing
+// and the authenticator.  See ntp.Authenticator.
+func (s *Session) AuthenticateRequest(data []byte) ([]byte, error) {
+	if len(s.Cookies) == 0 {
+		return nil, ErrNoCookies
+	}
+	cookie := s.Cookies[0]
+	s.Cookies = s.Cookies[1:]
+
+	s.uid = make([]byte, uniqueIDSize)
+	if _, err := rand.Read(s.uid); err != nil {
+		return nil, err
+	}
+	req := append([]byte(nil), data...)
+	req = ntp.AppendExtension(req, ntp.ExtUniqueID, s.uid)
+	req = ntp.AppendExtension(req, ntp.ExtNTSCookie, cookie)
+	for i := len(s.Cookies) + 1; i < MaxCookies; i++ {
+		req = ntp.AppendExtension(req, ntp.ExtNTSCookieHolder, make([]byte, len(cookie)))
+	}
+	return appendAuthenticator(req, s.c2s, nil)
+}
+
+// VerifyResponse checks that a response echoes the unique identifier
+// of the last request and is authenticated with the S2C key, and keeps
+// the cookies it carries.  An NTS NAK (kiss-o'-death NTSN) passes, but
+// since it is not authenticated, the session is left as is: a valid
+// response to the request may still arrive (RFC 8915, section 5.7).  See
+// ntp.Authenticator.
+func (s *Session) VerifyResponse(data []byte) error {
+	exts, _, err := ntp.ParseExtensions(data)
+	if err != nil {
+		return err
+	}
+	uid, err := ntp.FindExtension(exts, ntp.ExtUniqueID)
+	if err != nil {
+		return err
+	}
+	if s.uid == nil || !bytes.Equal(uid.Value, s.uid) {
+		return errors.New("nts: response does not match the request")
+	}
+	if isNAK(data) {
+		return nil
+	}
+
+	auth, err := ntp.FindExtension(exts, ntp.ExtNTSAuthenticator)
+	if err != nil {
+		return err
+	}
+	if auth.Offset < uid.Offset {
+		return errors.New("nts: unique identifier not authenticated")
+	}
+	plaintext, err := openAuthenticator(data, auth, s.s2c)
+	if err != nil {
+		return err
+	}
+	s.uid = nil
+
+	// the new cookies are encrypted, cookies in the clear are ignored
+	fields, err := ntp.ParseExtensionFields(plaintext)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.Type == ntp.ExtNTSCookie && len(s.Cookies) < MaxCookies {
+			s.Cookies = append(s.Cookies, f.Value)
+		}
+	}
+	return nil
+}
+
+// appendAuthenticator appends to the packet data the authenticator of
+// data, which also encrypts plaintext:
+//
+//	nonce length (2) | ciphertext length (2) | nonce | ciphertext
+func appendAuthenticator(data []byte, aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, authNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, data)
+	body := uint16s(authNonceSize, uint16(len(ciphertext)))
+	body = append(body, nonce...)
+	body = append(body, ciphertext...)
+	return ntp.AppendExtension(data, ntp.ExtNTSAuthenticator, body), nil
+}
+
+// openAuthenticator verifies the authenticator auth of the packet data
+// and returns the plaintext it encrypts
+func openAuthenticator(data []byte, auth ntp.Extension, aead cipher.AEAD) ([]byte, error) {
+	body := auth.Value
+	if len(body) < 4 {
+		return nil, errors.New("nts: invalid authenticator")
+	}
+	nonceLen := int(binary.BigEndian.Uint16(body))
+	ciphertextLen := int(binary.BigEndian.Uint16(body[2:]))
+	nonceEnd := 4 + pad4(nonceLen)
+	if nonceLen == 0 || nonceEnd+ciphertextLen > len(body) {
+		return nil, errors.New("nts: invalid authenticator")
+	}
+	nonce := body[4 : 4+nonceLen]
+	ciphertext := body[nonceEnd : nonceEnd+ciphertextLen]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, data[:auth.Offset])
+	if err != nil {
+		return nil, fmt.Errorf("nts: %w", err)
+	}
+	return plaintext, nil
+}
+
+// pad4 returns n rounded up to a multiple of 4
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
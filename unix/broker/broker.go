@@ -0,0 +1,199 @@
+//go:build unix
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vladimirvivien/go-networking/fdpass"
+)
+
+// listenFlags collects the repeated -l flags
+type listenFlags []string
+
+func (l *listenFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenFlags) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// This program is a socket broker: it binds sockets that need
+// privileges, such as port 123 for ntps or 443 for tls-serv, then starts
+// a worker program as an unprivileged user and hands it the sockets
+// over a unix socket pair, as file descriptors (SCM_RIGHTS).  The worker
+// never runs with privileges, yet serves on the privileged ports.
+//
+// Focus:
+// The broker and the worker are connected with a pair of unix sockets
+// (no socket file).  The worker inherits its end as descriptor 3, named
+// by variable FDPASS_FD, and gets the sockets with fdpass.Listen or
+// fdpass.ListenPacket, in the order of the -l flags.  The worker then
+// acknowledges each socket; on Linux the kernel attaches the credentials
+// of the worker to its messages (SCM_CREDENTIALS), so the broker can
+// check that the worker dropped its privileges.
+//
+// Usage: broker [options] -- worker [worker args]
+// options:
+//   -l network:address to bind and hand over, repeatable, i.e. "udp::123"
+//   -user user to run the worker as when started by root, default "nobody"
+//
+// Example:
+//   sudo broker -l udp::123 -- go run ../../udp/ntps/ntps3.go -e :123
+//   sudo broker -l tcp::443 -- ./tls-serv1 -e :443
+func main() {
+	var listens listenFlags
+	var userName string
+	flag.Var(&listens, "l", "network:address to bind and hand over [repeatable]")
+	flag.StringVar(&userName, "user", "nobody", "user to run the worker as")
+	flag.Parse()
+	if len(listens) == 0 || flag.NArg() == 0 {
+		fmt.Println("usage: broker -l network:address [-l ...] -- worker [args]")
+		os.Exit(1)
+	}
+
+	// bind the sockets while privileged
+	var sockets []fdpass.Filer
+	for _, name := range listens {
+		sock, err := bind(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("bound %s", name)
+		sockets = append(sockets, sock)
+	}
+
+	broker, worker, err := fdpass.Pair()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer broker.Close()
+	if err := fdpass.PassCred(broker); err != nil {
+		log.Println("worker credentials not available:", err)
+	}
+
+	// the worker inherits its end of the pair as descriptor 3
+	workerFile, err := worker.File()
+	if err != nil {
+		log.Fatal(err)
+	}
+	worker.Close()
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{workerFile}
+	cmd.Env = append(os.Environ(), fdpass.EnvFD+"=3")
+	if os.Geteuid() == 0 {
+		cred, err := lookupUser(userName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+		log.Printf("running worker as %s (uid=%d gid=%d)", userName, cred.Uid, cred.Gid)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	workerFile.Close()
+
+	// hand over the sockets, the broker keeps no copy
+	for i, sock := range sockets {
+		if err := fdpass.SendFile(broker, listens[i], sock); err != nil {
+			log.Printf("failed to send %s: %v", listens[i], err)
+		}
+		sock.(io.Closer).Close()
+	}
+	go acks(broker)
+
+	// pass termination signals on to the worker
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		log.Println("worker:", err)
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		os.Exit(1)
+	}
+}
+
+// bind creates the socket named network:address
+func bind(name string) (fdpass.Filer, error) {
+	network, addr, ok := strings.Cut(name, ":")
+	if !ok {
+		return nil, fmt.Errorf("%s: want network:address", name)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if uln, ok := ln.(*net.UnixListener); ok {
+			uln.SetUnlinkOnClose(false) // the worker uses the socket file
+		}
+		return ln.(fdpass.Filer), nil
+	case "udp", "udp4", "udp6", "unixgram":
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return pc.(fdpass.Filer), nil
+	}
+	return nil, fmt.Errorf("%s: unsupported network %s", name, network)
+}
+
+// acks logs the acknowledgments of the worker and its credentials
+func acks(conn *net.UnixConn) {
+	buf := make([]byte, 512)
+	for {
+		msg, err := fdpass.Recv(conn, buf)
+		if err != nil {
+			return
+		}
+		msg.Close()
+		if msg.Cred != nil {
+			log.Printf("worker (%s): %s", msg.Cred, msg.Data)
+			if msg.Cred.UID == 0 {
+				log.Println("WARNING: worker runs as root")
+			}
+			continue
+		}
+		log.Printf("worker: %s", msg.Data)
+	}
+}
+
+// lookupUser returns the credentials of the user name
+func lookupUser(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}